@@ -0,0 +1,443 @@
+// Copyright 2024 Joy <joyssss94@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+
+	"github.com/JoyZF/zoom/pkg/rosedb"
+	"github.com/JoyZF/zoom/utils"
+)
+
+type testNode struct {
+	*Node
+	store  *Store
+	server *grpc.Server
+}
+
+func (n *testNode) stop() {
+	_ = n.Close()
+	n.server.Stop()
+}
+
+// startNode starts a cluster node serving on the listener, with its db and raft data in dir.
+func startNode(t *testing.T, dir, id string, listener net.Listener, bootstrap bool, peers []Peer) *testNode {
+	return startNodeWithOptions(t, dir, listener, Options{
+		NodeID:       id,
+		DataDir:      filepath.Join(dir, id, "raft"),
+		Bootstrap:    bootstrap,
+		Peers:        peers,
+		ApplyTimeout: 5 * time.Second,
+	})
+}
+
+// startNodeWithOptions starts a cluster node of the options, with its db next to the raft data.
+func startNodeWithOptions(t *testing.T, dir string, listener net.Listener, options Options) *testNode {
+	dbOptions := rosedb.DefaultOptions
+	dbOptions.DirPath = filepath.Join(dir, options.NodeID, "db")
+	// the index snapshot is written when the db is closed, so a restarted node does not replay the db.
+	dbOptions.IndexSnapshotInterval = time.Minute
+	db, err := rosedb.Open(dbOptions)
+	assert.Nil(t, err)
+
+	mux := NewMux(listener)
+	server := grpc.NewServer()
+	n, err := NewNode(db, dbOptions, mux, server, options)
+	assert.Nil(t, err)
+	go func() {
+		_ = server.Serve(mux.GRPCListener())
+	}()
+	tn := &testNode{Node: n, store: NewStore(n), server: server}
+	t.Cleanup(tn.stop)
+	return tn
+}
+
+func listen(t *testing.T) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	return listener
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	deadline := time.Now().Add(15 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met before the deadline")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// waitLeader waits for one of the nodes to become the leader, and returns it and the other nodes.
+func waitLeader(t *testing.T, nodes []*testNode) (*testNode, []*testNode) {
+	var leader *testNode
+	waitFor(t, func() bool {
+		for _, n := range nodes {
+			if n.IsLeader() {
+				leader = n
+				return true
+			}
+		}
+		return false
+	})
+	var followers []*testNode
+	for _, n := range nodes {
+		if n != leader {
+			followers = append(followers, n)
+		}
+	}
+	return leader, followers
+}
+
+func TestCluster(t *testing.T) {
+	dir := t.TempDir()
+	listeners := []net.Listener{listen(t), listen(t), listen(t)}
+	var peers []Peer
+	for i, listener := range listeners {
+		peers = append(peers, Peer{ID: "node" + strconv.Itoa(i), Address: listener.Addr().String()})
+	}
+	var nodes []*testNode
+	for i, listener := range listeners {
+		nodes = append(nodes, startNode(t, dir, peers[i].ID, listener, true, peers))
+	}
+	leader, followers := waitLeader(t, nodes)
+
+	// the writes to a follower are forwarded to the leader,
+	// and the reads on every node see them once the write returns.
+	assert.Nil(t, followers[0].store.Put([]byte("k1"), []byte("v1")))
+	assert.Nil(t, leader.store.PutWithTTL([]byte("k2"), []byte("v2"), time.Hour))
+	for _, n := range nodes {
+		value, err := n.store.Get([]byte("k1"))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("v1"), value)
+		ttl, err := n.store.TTL([]byte("k2"))
+		assert.Nil(t, err)
+		assert.True(t, ttl > 59*time.Minute)
+	}
+	assert.Nil(t, followers[1].store.Delete([]byte("k1")))
+	_, err := followers[0].store.Get([]byte("k1"))
+	assert.Equal(t, rosedb.ErrKeyNotFound, err)
+	assert.Equal(t, rosedb.ErrKeyNotFound, followers[0].store.Expire([]byte("k1"), time.Minute))
+	assert.Nil(t, followers[0].store.Expire([]byte("k2"), -time.Second))
+	exist, err := followers[1].store.Exist([]byte("k2"))
+	assert.Nil(t, err)
+	assert.False(t, exist)
+
+	// the read-modify-write operations are applied through the log,
+	// their results and precondition failures are returned by the forwarded requests.
+	counter := []byte("counter")
+	incremented, err := followers[0].store.IncrBy(counter, 5)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(5), incremented)
+	incremented, err = leader.store.Incr(counter)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(6), incremented)
+	float, err := followers[1].store.IncrByFloat(counter, 0.5)
+	assert.Nil(t, err)
+	assert.Equal(t, 6.5, float)
+	assert.Equal(t, rosedb.ErrPreconditionFailed, followers[0].store.PutIfAbsent(counter, []byte("1")))
+	assert.Equal(t, rosedb.ErrPreconditionFailed, followers[0].store.CompareAndSwap(counter, []byte("6"), []byte("1")))
+	assert.Nil(t, followers[0].store.CompareAndSwap(counter, []byte("6.5"), []byte("1")))
+	former, err := followers[1].store.GetAndSet(counter, []byte("2"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("1"), former)
+	assert.Equal(t, rosedb.ErrPreconditionFailed, followers[1].store.PutIfExists([]byte("k4"), []byte("v4")))
+	assert.Nil(t, followers[1].store.Delete(counter))
+
+	// the multi-key writes are applied in a single command
+	multiKeys := [][]byte{[]byte("m1"), []byte("m2"), []byte("m3")}
+	assert.Nil(t, followers[0].store.MultiPut(multiKeys, [][]byte{[]byte("v1"), []byte("v2"), []byte("v3")}))
+	values, err := followers[1].store.MultiGet(append(multiKeys, []byte("k1")))
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("v1"), []byte("v2"), []byte("v3"), nil}, values)
+	assert.Equal(t, rosedb.ErrKeyIsEmpty, followers[0].store.MultiDelete([][]byte{[]byte("m1"), nil}))
+	assert.Nil(t, followers[0].store.MultiDelete(multiKeys))
+	values, err = leader.store.MultiGet(multiKeys)
+	assert.Nil(t, err)
+	assert.Equal(t, make([][]byte, len(multiKeys)), values)
+
+	// a dump is imported through the log as well
+	var dump bytes.Buffer
+	export := startNode(t, dir, "export", listen(t), true, nil)
+	waitLeader(t, []*testNode{export})
+	for i := 0; i < 50; i++ {
+		assert.Nil(t, export.store.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	count, err := export.store.Export(&dump, rosedb.DefaultExportOptions)
+	assert.Nil(t, err)
+	assert.Equal(t, 50, count)
+	options := rosedb.DefaultImportOptions
+	options.BatchSize = 16
+	count, err = followers[0].store.Import(&dump, options)
+	assert.Nil(t, err)
+	assert.Equal(t, 50, count)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, n := range nodes {
+		// the stat is local, it is up to date after a read barrier
+		assert.Nil(t, n.ReadBarrier(ctx))
+		assert.Equal(t, 50, n.store.Stat().(*rosedb.Stat).KeysNum)
+	}
+
+	// the leader dies, the other nodes elect a new leader and keep serving
+	stopped := leader.options.NodeID
+	leader.stop()
+	leader, followers = waitLeader(t, followers)
+	assert.Nil(t, followers[0].store.Put([]byte("k3"), []byte("v3")))
+	value, err := leader.store.Get([]byte("k3"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v3"), value)
+
+	// a new node joins through a follower, and catches up with the log
+	listener := listen(t)
+	joined := startNode(t, dir, "node3", listener, false, nil)
+	assert.Nil(t, followers[0].Join(ctx, "node3", listener.Addr().String()))
+	value, err = joined.store.Get([]byte("k3"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v3"), value)
+	assert.Equal(t, 51, joined.store.Stat().(*rosedb.Stat).KeysNum)
+	status, err := joined.Status()
+	assert.Nil(t, err)
+	assert.Equal(t, leader.options.NodeID, status.LeaderID)
+	assert.Len(t, status.Servers, 4)
+
+	// the stopped node leaves the cluster
+	assert.Nil(t, joined.Leave(ctx, stopped))
+	status, err = leader.Status()
+	assert.Nil(t, err)
+	assert.Len(t, status.Servers, 3)
+}
+
+func TestCluster_InstallSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	listeners := []net.Listener{listen(t), listen(t), listen(t)}
+	var peers []Peer
+	for i, listener := range listeners {
+		peers = append(peers, Peer{ID: "node" + strconv.Itoa(i), Address: listener.Addr().String()})
+	}
+	options := func(i int) Options {
+		return Options{
+			NodeID:    peers[i].ID,
+			DataDir:   filepath.Join(dir, peers[i].ID, "raft"),
+			Bootstrap: true,
+			Peers:     peers,
+			// the snapshots are taken by the test, and drop all entries but the last few.
+			SnapshotThreshold: 1 << 20,
+			TrailingLogs:      4,
+			ApplyTimeout:      5 * time.Second,
+		}
+	}
+	var nodes []*testNode
+	for i, listener := range listeners {
+		nodes = append(nodes, startNodeWithOptions(t, dir, listener, options(i)))
+	}
+	leader, followers := waitLeader(t, nodes)
+	assert.Nil(t, leader.store.Put([]byte("before"), []byte("value")))
+
+	// a follower stops, and the leader writes and compacts its log past the follower.
+	lagging := followers[0]
+	var laggingIndex int
+	for i, n := range nodes {
+		if n == lagging {
+			laggingIndex = i
+		}
+	}
+	lagging.stop()
+	values := make(map[string][]byte)
+	for i := 0; i < 100; i++ {
+		key, value := utils.GetTestKey(i), utils.RandomValue(64)
+		values[string(key)] = value
+		assert.Nil(t, leader.store.Put(key, value))
+	}
+	assert.Nil(t, leader.store.Delete([]byte("before")))
+	assert.Nil(t, leader.raft.Snapshot().Error())
+	first, err := leader.logStore.FirstIndex()
+	assert.Nil(t, err)
+	assert.True(t, first > leader.fsm.appliedIndex()-10, "the log is not compacted")
+
+	// the follower restarts behind the first entry of the log, so it is sent the snapshot,
+	// which replaces its db with the checkpoint of the leader.
+	listener, err := net.Listen("tcp", peers[laggingIndex].Address)
+	assert.Nil(t, err)
+	restarted := startNodeWithOptions(t, dir, listener, options(laggingIndex))
+	waitFor(t, func() bool {
+		return restarted.fsm.appliedIndex() >= leader.fsm.appliedIndex()
+	})
+	assert.NotEqual(t, "0", restarted.raft.Stats()["last_snapshot_index"])
+	assert.Nil(t, restarted.View(func(db *rosedb.DB) error {
+		assert.Equal(t, len(values), db.Stat().KeysNum)
+		for key, value := range values {
+			val, err := db.Get([]byte(key))
+			assert.Nil(t, err)
+			assert.Equal(t, value, val)
+		}
+		_, err := db.Get([]byte("before"))
+		assert.Equal(t, rosedb.ErrKeyNotFound, err)
+		return nil
+	}))
+
+	// the restored node applies the entries written after the snapshot
+	assert.Nil(t, leader.store.Put([]byte("after"), []byte("value")))
+	waitFor(t, func() bool {
+		return restarted.fsm.appliedIndex() >= leader.fsm.appliedIndex()
+	})
+	value, err := restarted.store.Get([]byte("after"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), value)
+}
+
+func TestCluster_Restart(t *testing.T) {
+	dir := t.TempDir()
+	listener := listen(t)
+	peers := []Peer{{ID: "node0", Address: listener.Addr().String()}}
+	n := startNode(t, dir, "node0", listener, true, peers)
+	waitLeader(t, []*testNode{n})
+
+	counter := []byte("counter")
+	for i := 0; i < 10; i++ {
+		_, err := n.store.IncrBy(counter, 2)
+		assert.Nil(t, err)
+	}
+	// the failed swap is not applied again after the restart, when the value matches.
+	assert.Equal(t, rosedb.ErrPreconditionFailed, n.store.CompareAndSwap([]byte("swapped"), []byte("20"), []byte("x")))
+	assert.Nil(t, n.store.Put([]byte("swapped"), []byte("20")))
+	applied := n.fsm.appliedIndex()
+
+	// the node restarts with the whole log, the entries already in the db are skipped.
+	n.stop()
+	listener, err := net.Listen("tcp", peers[0].Address)
+	assert.Nil(t, err)
+	n = startNode(t, dir, "node0", listener, true, peers)
+	assert.Equal(t, applied, n.fsm.appliedIndex())
+	// the applied index in raftNamespace is in the index snapshot as well.
+	assert.Equal(t, 0, n.store.Stat().(*rosedb.Stat).Recovery.Records)
+	waitLeader(t, []*testNode{n})
+	value, err := n.store.Get(counter)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("20"), value)
+	value, err = n.store.Get([]byte("swapped"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("20"), value)
+
+	incremented, err := n.store.Incr(counter)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(21), incremented)
+	assert.Equal(t, 2, n.store.Stat().(*rosedb.Stat).KeysNum)
+}
+
+func TestApplyCommand_Time(t *testing.T) {
+	options := rosedb.DefaultOptions
+	options.DirPath = t.TempDir()
+	db, err := rosedb.Open(options)
+	assert.Nil(t, err)
+	defer func() {
+		_ = db.Close()
+	}()
+	meta, err := db.Namespace(raftNamespace)
+	assert.Nil(t, err)
+
+	// the command is applied at the time of the leader, an hour ago,
+	// the expiration times are the ones of the command, whatever the clock of the node.
+	leader := time.Now().Add(-time.Hour)
+	cmd := &Command{Time: leader.UnixNano(), Ops: []*Op{
+		{Type: OpPut, Key: []byte("k1"), Value: []byte("v1"), Expire: leader.Add(2 * time.Hour).UnixNano()},
+		{Type: OpPut, Key: []byte("k2"), Value: []byte("v2"), Expire: leader.Add(30 * time.Minute).UnixNano()},
+	}}
+	_, err = applyCommand(db, meta, cmd, 1)
+	assert.Nil(t, err)
+	ttl, err := db.TTL([]byte("k1"))
+	assert.Nil(t, err)
+	assert.True(t, ttl > 59*time.Minute && ttl <= time.Hour)
+	_, err = db.Get([]byte("k2"))
+	assert.Equal(t, rosedb.ErrKeyNotFound, err)
+
+	// k1 is expired at the time of the leader, though not on the clock of the node.
+	cmd = &Command{Time: leader.Add(150 * time.Minute).UnixNano(), Ops: []*Op{
+		{Type: OpExpire, Key: []byte("k1"), Expire: leader.Add(3 * time.Hour).UnixNano()},
+	}}
+	_, err = applyCommand(db, meta, cmd, 2)
+	assert.Equal(t, rosedb.ErrKeyNotFound, err)
+}
+
+func TestFSM_RestoreFailure(t *testing.T) {
+	dir := t.TempDir()
+	options := rosedb.DefaultOptions
+	options.DirPath = filepath.Join(dir, "db")
+	db, err := rosedb.Open(options)
+	assert.Nil(t, err)
+	f := newFSM(db, options, dir)
+	defer func() {
+		_ = f.close()
+	}()
+	assert.Nil(t, f.loadApplied(raft.NewInmemSnapshotStore()))
+	_, err = applyCommand(db, f.meta, &Command{Time: time.Now().UnixNano(),
+		Ops: []*Op{{Type: OpPut, Key: []byte("k"), Value: []byte("v")}}}, 1)
+	assert.Nil(t, err)
+	f.setApplied(1)
+
+	// the checkpoint of an encrypted db can not be opened without its key.
+	encrypted := rosedb.DefaultOptions
+	encrypted.DirPath = filepath.Join(dir, "encrypted")
+	encrypted.KeyProvider, err = rosedb.NewStaticKeyProvider(1, map[uint32][]byte{1: bytes.Repeat([]byte{1}, 32)})
+	assert.Nil(t, err)
+	other, err := rosedb.Open(encrypted)
+	assert.Nil(t, err)
+	assert.Nil(t, other.Put([]byte("k"), []byte("other")))
+	checkpoint := filepath.Join(dir, "checkpoint")
+	_, err = other.Checkpoint(checkpoint)
+	assert.Nil(t, err)
+	assert.Nil(t, other.Close())
+	var archive bytes.Buffer
+	assert.Nil(t, writeTar(&archive, checkpoint, 2))
+
+	err = f.Restore(io.NopCloser(&archive))
+	assert.Equal(t, rosedb.ErrEncryptionKeyRequired, err)
+
+	// the former db is opened again.
+	assert.Equal(t, uint64(1), f.appliedIndex())
+	assert.Nil(t, f.view(func(db *rosedb.DB) error {
+		value, err := db.Get([]byte("k"))
+		assert.Equal(t, []byte("v"), value)
+		return err
+	}))
+	_, err = os.Stat(options.DirPath + ".previous")
+	assert.True(t, os.IsNotExist(err))
+	assert.Nil(t, f.saveApplied(2, nil))
+}
+
+func TestSnapshotArchive(t *testing.T) {
+	dir := t.TempDir()
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "000000001.SEG"), []byte("segment"), 0644))
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "BACKUP"), []byte("{}"), 0644))
+	assert.Nil(t, os.Mkdir(filepath.Join(dir, "sub"), os.ModePerm))
+
+	var archive bytes.Buffer
+	assert.Nil(t, writeTar(&archive, dir, 42))
+	restored := filepath.Join(t.TempDir(), "restored")
+	applied, err := untar(bytes.NewReader(archive.Bytes()), restored)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(42), applied)
+	data, err := os.ReadFile(filepath.Join(restored, "000000001.SEG"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("segment"), data)
+	entries, err := os.ReadDir(restored)
+	assert.Nil(t, err)
+	assert.Len(t, entries, 2)
+
+	_, err = untar(bytes.NewReader(nil), t.TempDir())
+	assert.NotNil(t, err)
+}
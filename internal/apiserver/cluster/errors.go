@@ -0,0 +1,14 @@
+// Copyright 2024 Joy <joyssss94@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package cluster
+
+import "errors"
+
+var (
+	ErrUnknownOp       = errors.New("the operation of the command is unknown")
+	ErrInvalidSnapshot = errors.New("the snapshot is invalid")
+	ErrNoLeader        = errors.New("the cluster has no leader")
+	ErrTooManyHops     = errors.New("the request is forwarded too many times")
+)
@@ -0,0 +1,487 @@
+// Copyright 2024 Joy <joyssss94@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package cluster
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/raft"
+
+	"github.com/JoyZF/zoom/pkg/rosedb"
+)
+
+// OpType is the type of a write in a Command.
+type OpType string
+
+const (
+	OpPut    OpType = "put"
+	OpDelete OpType = "delete"
+	OpExpire OpType = "expire"
+	// the read-modify-write operations, see rosedb.Batch.IncrBy and the others.
+	// They read the db when they are applied, at Command.Time, and the nodes apply the same
+	// commands in the same order, so the result is the same on every node.
+	OpIncrBy         OpType = "incr_by"
+	OpIncrByFloat    OpType = "incr_by_float"
+	OpCompareAndSwap OpType = "compare_and_swap"
+	OpPutIfAbsent    OpType = "put_if_absent"
+	OpPutIfExists    OpType = "put_if_exists"
+	OpGetAndSet      OpType = "get_and_set"
+)
+
+// Op is a write in a Command.
+type Op struct {
+	Type  OpType `json:"type"`
+	Key   []byte `json:"key"`
+	Value []byte `json:"value,omitempty"`
+	// Expire is the absolute expiration time in unix nanoseconds, 0 means no ttl.
+	// It is compared with Command.Time, so the command has the same effect on every node.
+	Expire int64 `json:"expire,omitempty"`
+	// Expected is the value compared by OpCompareAndSwap.
+	Expected []byte `json:"expected,omitempty"`
+	// Delta is added by OpIncrBy, and FloatDelta by OpIncrByFloat.
+	Delta      int64   `json:"delta,omitempty"`
+	FloatDelta float64 `json:"float_delta,omitempty"`
+}
+
+// Command is an entry of the raft log, its writes are committed to rosedb in a single batch.
+type Command struct {
+	Ops []*Op `json:"ops"`
+	// Time is the time of the leader in unix nanoseconds when it appended the command to the log.
+	// The command is applied at this time instead of the clock of each node, see rosedb.BatchOptions.Now.
+	Time int64 `json:"time,omitempty"`
+}
+
+// appliedFileName is the file in the snapshot holding the applied index of the fsm.
+const appliedFileName = "APPLIED"
+
+// raftNamespace is the namespace of the db holding the state of the fsm, apart from the keys of the clients.
+// It is saved in the index snapshot of the db like the other namespaces,
+// so it does not make the db replay its WAL when it is opened.
+const raftNamespace = "raft"
+
+// appliedKey is the key of the applied index in raftNamespace.
+var appliedKey = []byte("applied")
+
+// fsm is the raft state machine on top of rosedb.
+//
+// The db is persistent, so the snapshot is not restored when the node starts,
+// and raft applies the log entries after the last snapshot again. The applied index is
+// written to the db in the same batch as the writes of each command, so the entries which
+// are already in the db are skipped, and the read-modify-write operations are not applied twice.
+// The commands are applied at the time the leader stamped on them, see Command.Time, so every node
+// writes the same expiration times and sees the same keys expired.
+// The snapshot is a checkpoint of the db, it is only restored when the leader sends it
+// to a node which is too far behind.
+type fsm struct {
+	mu      sync.RWMutex // protects db and meta, which are replaced by Restore
+	db      *rosedb.DB
+	meta    *rosedb.Namespace // raftNamespace of db
+	options rosedb.Options
+	// tempDir is where the checkpoints are created, it must be on the file system of the db.
+	tempDir string
+	// applied is the index of the last command applied to the db. The raft applied index
+	// is updated before the fsm applies the entries, and covers the entries which never
+	// reach the fsm, so the reads wait for this one instead.
+	applied atomic.Uint64
+
+	notifyMu sync.Mutex
+	notify   chan struct{} // closed when the applied index changes
+}
+
+func newFSM(db *rosedb.DB, options rosedb.Options, tempDir string) *fsm {
+	return &fsm{db: db, options: options, tempDir: tempDir, notify: make(chan struct{})}
+}
+
+// appliedIndex returns the index of the last command applied to the db.
+func (f *fsm) appliedIndex() uint64 {
+	return f.applied.Load()
+}
+
+func (f *fsm) setApplied(index uint64) {
+	f.applied.Store(index)
+	f.notifyMu.Lock()
+	close(f.notify)
+	f.notify = make(chan struct{})
+	f.notifyMu.Unlock()
+}
+
+// waitApplied waits until the command at the index is applied to the db.
+func (f *fsm) waitApplied(ctx context.Context, index uint64) error {
+	for {
+		f.notifyMu.Lock()
+		notify := f.notify
+		f.notifyMu.Unlock()
+		if f.applied.Load() >= index {
+			return nil
+		}
+		select {
+		case <-notify:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// loadApplied sets the applied index from the db, or from the latest snapshot in the store
+// if the db has none. It is called before raft starts, as the snapshot is not restored on start,
+// and the commands up to the applied index are not applied again.
+func (f *fsm) loadApplied(snapshots raft.SnapshotStore) error {
+	meta, err := f.db.Namespace(raftNamespace)
+	if err != nil {
+		return err
+	}
+	f.meta = meta
+	value, err := meta.Get(appliedKey)
+	if err == nil {
+		applied, err := strconv.ParseUint(string(value), 10, 64)
+		if err != nil {
+			return err
+		}
+		f.applied.Store(applied)
+		return nil
+	}
+	if !errors.Is(err, rosedb.ErrKeyNotFound) {
+		return err
+	}
+
+	metas, err := snapshots.List()
+	if err != nil || len(metas) == 0 {
+		return err
+	}
+	_, source, err := snapshots.Open(metas[0].ID)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = source.Close()
+	}()
+	applied, err := readApplied(tar.NewReader(source))
+	if err != nil {
+		return err
+	}
+	f.applied.Store(applied)
+	return nil
+}
+
+// view calls fn with the current db.
+func (f *fsm) view(fn func(db *rosedb.DB) error) error {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return fn(f.db)
+}
+
+// Apply commits the writes of the command, and returns the error of the commit,
+// or the result of its read-modify-write operation, see applyCommand.
+func (f *fsm) Apply(log *raft.Log) any {
+	if log.Type != raft.LogCommand {
+		return nil
+	}
+	// the command is in the db already, it was applied before the node restarted.
+	if log.Index <= f.applied.Load() {
+		return nil
+	}
+	// a command which fails is applied as well, it fails the same way on every node.
+	defer f.setApplied(log.Index)
+	cmd := &Command{}
+	if err := json.Unmarshal(log.Data, cmd); err != nil {
+		return f.saveApplied(log.Index, err)
+	}
+	var result []byte
+	err := f.view(func(db *rosedb.DB) error {
+		var err error
+		result, err = applyCommand(db, f.meta, cmd, log.Index)
+		return err
+	})
+	if err != nil {
+		return f.saveApplied(log.Index, err)
+	}
+	return result
+}
+
+// saveApplied writes the index of a command which failed, so it is not applied again
+// after a restart, when its read-modify-write operations may not fail anymore.
+// It returns the error of the command, or the error of the write.
+func (f *fsm) saveApplied(index uint64, cmdErr error) error {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if err := f.meta.Put(appliedKey, strconv.AppendUint(nil, index, 10)); err != nil {
+		return err
+	}
+	return cmdErr
+}
+
+// applyCommand commits the writes of the command in a batch along with the index of the command
+// in meta, and returns the result of its last read-modify-write operation:
+// the new value of a counter, or the former value of OpGetAndSet.
+func applyCommand(db *rosedb.DB, meta *rosedb.Namespace, cmd *Command, index uint64) ([]byte, error) {
+	batch := db.NewBatch(rosedb.BatchOptions{Sync: false, Now: time.Unix(0, cmd.Time)})
+	var result []byte
+	for _, op := range cmd.Ops {
+		var err error
+		switch {
+		case op.Type == OpDelete, op.Type == OpPut && op.Expire > 0 && op.Expire <= cmd.Time:
+			err = batch.Delete(op.Key)
+		case op.Type == OpPut && op.Expire > 0:
+			err = batch.PutWithTTL(op.Key, op.Value, time.Duration(op.Expire-cmd.Time))
+		case op.Type == OpPut:
+			err = batch.Put(op.Key, op.Value)
+		case op.Type == OpExpire:
+			// an expiration time in the past expires the key at once.
+			err = batch.Expire(op.Key, time.Duration(op.Expire-cmd.Time))
+		case op.Type == OpIncrBy:
+			var value int64
+			value, err = batch.IncrBy(op.Key, op.Delta)
+			result = strconv.AppendInt(nil, value, 10)
+		case op.Type == OpIncrByFloat:
+			var value float64
+			value, err = batch.IncrByFloat(op.Key, op.FloatDelta)
+			result = strconv.AppendFloat(nil, value, 'f', -1, 64)
+		case op.Type == OpCompareAndSwap:
+			err = batch.CompareAndSwap(op.Key, op.Expected, op.Value)
+		case op.Type == OpPutIfAbsent:
+			err = batch.PutIfAbsent(op.Key, op.Value)
+		case op.Type == OpPutIfExists:
+			err = batch.PutIfExists(op.Key, op.Value)
+		case op.Type == OpGetAndSet:
+			result, err = batch.GetAndSet(op.Key, op.Value)
+		default:
+			err = ErrUnknownOp
+		}
+		if err != nil {
+			_ = batch.Rollback()
+			return nil, err
+		}
+	}
+	if err := batch.Namespace(meta).Put(appliedKey, strconv.AppendUint(nil, index, 10)); err != nil {
+		_ = batch.Rollback()
+		return nil, err
+	}
+	return result, batch.Commit()
+}
+
+// Snapshot creates a checkpoint of the db, which is written to the snapshot by Persist.
+// The commands are not applied while it runs, so the checkpoint is consistent with the log.
+func (f *fsm) Snapshot() (raft.FSMSnapshot, error) {
+	applied := f.applied.Load()
+	dir, err := os.MkdirTemp(f.tempDir, "checkpoint-")
+	if err != nil {
+		return nil, err
+	}
+	err = f.view(func(db *rosedb.DB) error {
+		_, err := db.Checkpoint(dir)
+		return err
+	})
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return nil, err
+	}
+	return &fsmSnapshot{dir: dir, applied: applied}, nil
+}
+
+// Restore replaces the db with the checkpoint in the snapshot.
+// The former db is opened again if the checkpoint can not be opened.
+func (f *fsm) Restore(snapshot io.ReadCloser) error {
+	defer func() {
+		_ = snapshot.Close()
+	}()
+	dir, err := os.MkdirTemp(f.tempDir, "restore-")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	applied, err := untar(snapshot, filepath.Join(dir, "checkpoint"))
+	if err != nil {
+		return err
+	}
+	// the checkpoint is verified and copied next to the db before the db is replaced.
+	restored := f.options.DirPath + ".restore"
+	_ = os.RemoveAll(restored)
+	if _, err = rosedb.RestoreBackup(filepath.Join(dir, "checkpoint"), restored); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err = f.db.Close(); err != nil {
+		return err
+	}
+	// the former db is kept until the restored one is opened, and is opened again if it is not.
+	previous := f.options.DirPath + ".previous"
+	_ = os.RemoveAll(previous)
+	if err = os.Rename(f.options.DirPath, previous); err != nil {
+		return f.rollback(err, "")
+	}
+	if err = os.Rename(restored, f.options.DirPath); err != nil {
+		return f.rollback(err, previous)
+	}
+	if err = f.open(); err != nil {
+		return f.rollback(err, previous)
+	}
+	f.setApplied(applied)
+	return os.RemoveAll(previous)
+}
+
+// open opens the db in the directory of the options. f.mu must be held.
+func (f *fsm) open() error {
+	db, err := rosedb.Open(f.options)
+	if err != nil {
+		return err
+	}
+	meta, err := db.Namespace(raftNamespace)
+	if err != nil {
+		_ = db.Close()
+		return err
+	}
+	f.db, f.meta = db, meta
+	return nil
+}
+
+// rollback opens the former db again after Restore failed with err, moving it back
+// from previous first if it is not empty, and returns err. f.mu must be held.
+func (f *fsm) rollback(err error, previous string) error {
+	if previous != "" {
+		_ = os.RemoveAll(f.options.DirPath)
+		if renameErr := os.Rename(previous, f.options.DirPath); renameErr != nil {
+			return fmt.Errorf("%w, and the former db is not restored: %v", err, renameErr)
+		}
+	}
+	if openErr := f.open(); openErr != nil {
+		return fmt.Errorf("%w, and the former db is not opened: %v", err, openErr)
+	}
+	return err
+}
+
+func (f *fsm) close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.db.Close()
+}
+
+// fsmSnapshot is a checkpoint of the db, written to the snapshot as a tar archive.
+// The applied index is the first file of the archive, so it is read without the checkpoint.
+type fsmSnapshot struct {
+	dir     string
+	applied uint64
+}
+
+func (s *fsmSnapshot) Persist(sink raft.SnapshotSink) error {
+	if err := writeTar(sink, s.dir, s.applied); err != nil {
+		_ = sink.Cancel()
+		return err
+	}
+	return sink.Close()
+}
+
+func (s *fsmSnapshot) Release() {
+	_ = os.RemoveAll(s.dir)
+}
+
+// writeTar writes the applied index and the files in dir to w.
+func writeTar(w io.Writer, dir string, applied uint64) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(w)
+	data := []byte(strconv.FormatUint(applied, 10))
+	header := &tar.Header{Name: appliedFileName, Mode: 0644, Size: int64(len(data))}
+	if err = tw.WriteHeader(header); err != nil {
+		return err
+	}
+	if _, err = tw.Write(data); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		if err = tw.WriteHeader(header); err != nil {
+			return err
+		}
+		file, err := os.Open(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(tw, file)
+		_ = file.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// readApplied reads the applied index, which is the first file of the archive.
+func readApplied(tr *tar.Reader) (uint64, error) {
+	header, err := tr.Next()
+	if err != nil {
+		return 0, err
+	}
+	if header.Name != appliedFileName {
+		return 0, ErrInvalidSnapshot
+	}
+	data, err := io.ReadAll(tr)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(string(data), 10, 64)
+}
+
+// untar writes the files of the checkpoint in the archive to dir, and returns the applied index.
+func untar(r io.Reader, dir string) (uint64, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return 0, err
+	}
+	tr := tar.NewReader(r)
+	applied, err := readApplied(tr)
+	if err != nil {
+		return 0, err
+	}
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return applied, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		// the archive only holds the files of a checkpoint, anything else is ignored.
+		if header.Typeflag != tar.TypeReg || filepath.Base(header.Name) != header.Name {
+			continue
+		}
+		file, err := os.Create(filepath.Join(dir, header.Name))
+		if err != nil {
+			return 0, err
+		}
+		_, err = io.Copy(file, tr)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+}
@@ -0,0 +1,454 @@
+// Copyright 2024 Joy <joyssss94@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb/v2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"github.com/JoyZF/zoom/internal/pkg/codec"
+	"github.com/JoyZF/zoom/pkg/rosedb"
+)
+
+const (
+	raftLogFileName = "raft.db"
+	// retainSnapshots is the number of snapshots kept in the data directory.
+	retainSnapshots = 2
+	// leaderPollInterval is how often a node checks for a leader while the cluster elects one.
+	leaderPollInterval = 20 * time.Millisecond
+)
+
+// Peer is a voter of the cluster.
+type Peer struct {
+	ID      string `json:"id"`
+	Address string `json:"address"`
+}
+
+// Options are the options of a cluster node.
+type Options struct {
+	// NodeID is the unique id of the node in the cluster.
+	NodeID string
+	// Advertise is the address the other nodes reach the node at, for both raft and grpc.
+	// The address of the listener is used if it is empty.
+	Advertise string
+	// DataDir holds the raft log and the snapshots, it must be on the file system of the db.
+	DataDir string
+	// Bootstrap creates the cluster with the node and the peers, if the node has no raft state yet.
+	// It is only set on the first start of the initial nodes, the nodes added later join the cluster.
+	Bootstrap bool
+	// Peers are the other voters of the cluster, used by Bootstrap.
+	Peers []Peer
+	// SnapshotThreshold is how many log entries are written between two snapshots.
+	SnapshotThreshold uint64
+	// SnapshotInterval is how often the node checks whether it takes a snapshot.
+	SnapshotInterval time.Duration
+	// TrailingLogs is how many log entries are kept after a snapshot, a node further behind
+	// than that is sent the snapshot instead of the entries. 0 means the raft default.
+	TrailingLogs uint64
+	// ApplyTimeout is how long a write waits for the raft log.
+	ApplyTimeout time.Duration
+	// MaxMsgSize is the max size of the grpc messages sent to the leader.
+	MaxMsgSize int
+}
+
+// Node is a node of the raft cluster, the writes are applied to the db through the raft log,
+// and the nodes which are not the leader forward them to the leader.
+type Node struct {
+	options   Options
+	raft      *raft.Raft
+	fsm       *fsm
+	transport *raft.NetworkTransport
+	logStore  *raftboltdb.BoltStore
+
+	// readyTerm is the last term in which the node, as the leader, has applied
+	// all the entries of the former leaders, so its applied index is up to date.
+	readyTerm atomic.Uint64
+
+	mu    sync.Mutex
+	conns map[string]*grpc.ClientConn // the connections to the leaders by address
+}
+
+var node *Node
+
+// Init creates the cluster node of the server, which is returned by GetNode.
+func Init(db *rosedb.DB, dbOptions rosedb.Options, mux *Mux, grpcServer *grpc.Server, options Options) (*Node, error) {
+	n, err := NewNode(db, dbOptions, mux, grpcServer, options)
+	if err != nil {
+		return nil, err
+	}
+	node = n
+	return n, nil
+}
+
+// GetNode returns the cluster node of the server, nil if the cluster mode is not enabled.
+func GetNode() *Node {
+	return node
+}
+
+// NewNode starts a raft node on the db, the raft connections are accepted on the mux,
+// and the cluster service is registered to the grpc server, which serves the grpc listener of the mux.
+// The node owns the db from then on, as it is replaced when a snapshot is restored, and closes it in Close.
+func NewNode(db *rosedb.DB, dbOptions rosedb.Options, mux *Mux, grpcServer *grpc.Server, options Options) (*Node, error) {
+	if options.NodeID == "" {
+		return nil, errors.New("the node id is required")
+	}
+	if err := os.MkdirAll(options.DataDir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	var advertise net.Addr
+	if options.Advertise != "" {
+		addr, err := net.ResolveTCPAddr("tcp", options.Advertise)
+		if err != nil {
+			return nil, err
+		}
+		advertise = addr
+	}
+
+	logger := hclog.New(&hclog.LoggerOptions{Name: "raft", Level: hclog.Warn})
+	logStore, err := raftboltdb.NewBoltStore(filepath.Join(options.DataDir, raftLogFileName))
+	if err != nil {
+		return nil, err
+	}
+	snapshots, err := raft.NewFileSnapshotStoreWithLogger(options.DataDir, retainSnapshots, logger)
+	if err != nil {
+		_ = logStore.Close()
+		return nil, err
+	}
+	f := newFSM(db, dbOptions, options.DataDir)
+	if err = f.loadApplied(snapshots); err != nil {
+		_ = logStore.Close()
+		return nil, err
+	}
+	transport := raft.NewNetworkTransportWithLogger(mux.newStreamLayer(advertise), 3, 10*time.Second, logger)
+
+	config := raft.DefaultConfig()
+	config.LocalID = raft.ServerID(options.NodeID)
+	config.Logger = logger
+	config.NoSnapshotRestoreOnStart = true
+	if options.SnapshotThreshold > 0 {
+		config.SnapshotThreshold = options.SnapshotThreshold
+	}
+	if options.SnapshotInterval > 0 {
+		config.SnapshotInterval = options.SnapshotInterval
+	}
+	if options.TrailingLogs > 0 {
+		config.TrailingLogs = options.TrailingLogs
+	}
+
+	n := &Node{
+		options:   options,
+		fsm:       f,
+		transport: transport,
+		logStore:  logStore,
+		conns:     make(map[string]*grpc.ClientConn),
+	}
+	if options.Bootstrap {
+		if err = n.bootstrap(config, snapshots); err != nil {
+			_ = transport.Close()
+			_ = logStore.Close()
+			return nil, err
+		}
+	}
+	n.raft, err = raft.NewRaft(config, f, logStore, logStore, snapshots, transport)
+	if err != nil {
+		_ = transport.Close()
+		_ = logStore.Close()
+		return nil, err
+	}
+	grpcServer.RegisterService(&serviceDesc, &server{n: n})
+	return n, nil
+}
+
+// bootstrap creates the cluster with the node and the peers, unless the node already has raft state.
+func (n *Node) bootstrap(config *raft.Config, snapshots raft.SnapshotStore) error {
+	exists, err := raft.HasExistingState(n.logStore, n.logStore, snapshots)
+	if err != nil || exists {
+		return err
+	}
+	servers := []raft.Server{{ID: config.LocalID, Address: n.transport.LocalAddr()}}
+	for _, peer := range n.options.Peers {
+		if peer.ID == n.options.NodeID {
+			continue
+		}
+		servers = append(servers, raft.Server{ID: raft.ServerID(peer.ID), Address: raft.ServerAddress(peer.Address)})
+	}
+	return raft.BootstrapCluster(config, n.logStore, n.logStore, snapshots, n.transport,
+		raft.Configuration{Servers: servers})
+}
+
+// View calls fn with the db, which may be behind the leader, see ReadBarrier.
+func (n *Node) View(fn func(db *rosedb.DB) error) error {
+	return n.fsm.view(fn)
+}
+
+// Apply applies the command through the raft log, and returns the result or the error of the command.
+// The command is forwarded to the leader if the node is not the leader.
+func (n *Node) Apply(ctx context.Context, cmd *Command) ([]byte, error) {
+	return n.apply(ctx, cmd, 0)
+}
+
+func (n *Node) apply(ctx context.Context, cmd *Command, hops int) ([]byte, error) {
+	if n.raft.State() != raft.Leader {
+		resp := &ApplyResponse{}
+		err := n.forward(ctx, hops, "Apply", &ApplyRequest{Command: cmd, Hops: hops + 1}, resp)
+		return resp.Value, err
+	}
+	// the command is applied at the time of the leader on every node, see Command.Time.
+	stamped := *cmd
+	stamped.Time = time.Now().UnixNano()
+	data, err := json.Marshal(&stamped)
+	if err != nil {
+		return nil, err
+	}
+	future := n.raft.Apply(data, n.options.ApplyTimeout)
+	if err = future.Error(); err != nil {
+		return nil, err
+	}
+	switch resp := future.Response().(type) {
+	case error:
+		return nil, resp
+	case []byte:
+		return resp, nil
+	}
+	return nil, nil
+}
+
+// ReadBarrier waits until the node has applied all the writes committed before it is called,
+// so the reads on the node after it returns are linearizable.
+//
+// It asks the leader for its applied index, which the leader confirms by a heartbeat round
+// to a majority of the cluster, and waits for the node to apply the log up to that index.
+func (n *Node) ReadBarrier(ctx context.Context) error {
+	index, err := n.readIndex(ctx, 0)
+	if err != nil {
+		return err
+	}
+	return n.fsm.waitApplied(ctx, index)
+}
+
+func (n *Node) readIndex(ctx context.Context, hops int) (uint64, error) {
+	if n.raft.State() != raft.Leader {
+		resp := &ReadIndexResponse{}
+		err := n.forward(ctx, hops, "ReadIndex", &ReadIndexRequest{Hops: hops + 1}, resp)
+		return resp.Index, err
+	}
+
+	// a new leader may not have applied the entries committed by the former leaders,
+	// the barrier applies them once in each term.
+	term, err := strconv.ParseUint(n.raft.Stats()["term"], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if n.readyTerm.Load() != term {
+		if err = n.raft.Barrier(n.options.ApplyTimeout).Error(); err != nil {
+			return 0, err
+		}
+		n.readyTerm.Store(term)
+	}
+	// every write acknowledged to a client is applied on the leader before it is acknowledged.
+	index := n.fsm.appliedIndex()
+	if err = n.raft.VerifyLeader().Error(); err != nil {
+		return 0, err
+	}
+	return index, nil
+}
+
+// Join adds a voter to the cluster, it is forwarded to the leader if the node is not the leader.
+func (n *Node) Join(ctx context.Context, id, address string) error {
+	return n.join(ctx, &JoinRequest{ID: id, Address: address})
+}
+
+func (n *Node) join(ctx context.Context, req *JoinRequest) error {
+	if n.raft.State() != raft.Leader {
+		return n.forward(ctx, req.Hops, "Join", &JoinRequest{ID: req.ID, Address: req.Address, Hops: req.Hops + 1},
+			&MembershipResponse{})
+	}
+	return n.raft.AddVoter(raft.ServerID(req.ID), raft.ServerAddress(req.Address), 0, n.options.ApplyTimeout).Error()
+}
+
+// Leave removes a server from the cluster, it is forwarded to the leader if the node is not the leader.
+func (n *Node) Leave(ctx context.Context, id string) error {
+	return n.leave(ctx, &LeaveRequest{ID: id})
+}
+
+func (n *Node) leave(ctx context.Context, req *LeaveRequest) error {
+	if n.raft.State() != raft.Leader {
+		return n.forward(ctx, req.Hops, "Leave", &LeaveRequest{ID: req.ID, Hops: req.Hops + 1}, &MembershipResponse{})
+	}
+	return n.raft.RemoveServer(raft.ServerID(req.ID), 0, n.options.ApplyTimeout).Error()
+}
+
+// forward calls the method of the cluster service on the leader, it waits for the apply timeout at most.
+func (n *Node) forward(ctx context.Context, hops int, method string, req, resp any) error {
+	if hops >= maxHops {
+		return ErrTooManyHops
+	}
+	ctx, cancel := context.WithTimeout(ctx, n.options.ApplyTimeout)
+	defer cancel()
+	address, err := n.leaderAddress(ctx)
+	if err != nil {
+		return err
+	}
+	conn, err := n.conn(address)
+	if err != nil {
+		return err
+	}
+	err = conn.Invoke(ctx, "/"+serviceName+"/"+method, req, resp, grpc.CallContentSubtype(codec.JSON))
+	return fromStatus(err)
+}
+
+// leaderAddress returns the address of the leader, it waits for the cluster to elect one.
+func (n *Node) leaderAddress(ctx context.Context) (string, error) {
+	ticker := time.NewTicker(leaderPollInterval)
+	defer ticker.Stop()
+	for {
+		if address, _ := n.raft.LeaderWithID(); address != "" {
+			return string(address), nil
+		}
+		select {
+		case <-ctx.Done():
+			return "", ErrNoLeader
+		case <-ticker.C:
+		}
+	}
+}
+
+func (n *Node) conn(address string) (*grpc.ClientConn, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if conn, ok := n.conns[address]; ok {
+		return conn, nil
+	}
+	opts := []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	if n.options.MaxMsgSize > 0 {
+		opts = append(opts, grpc.WithDefaultCallOptions(grpc.MaxCallSendMsgSize(n.options.MaxMsgSize)))
+	}
+	conn, err := grpc.Dial(address, opts...)
+	if err != nil {
+		return nil, err
+	}
+	n.conns[address] = conn
+	return conn, nil
+}
+
+// Status is the state of a node in the cluster.
+type Status struct {
+	ID            string `json:"id"`
+	Address       string `json:"address"`
+	State         string `json:"state"`
+	LeaderID      string `json:"leaderId"`
+	LeaderAddress string `json:"leaderAddress"`
+	Term          uint64 `json:"term"`
+	CommitIndex   uint64 `json:"commitIndex"`
+	// AppliedIndex is the index of the last command applied to the db.
+	AppliedIndex uint64 `json:"appliedIndex"`
+	Servers      []Peer `json:"servers"`
+}
+
+// Status returns the state of the node, and the servers of the cluster it knows.
+func (n *Node) Status() (Status, error) {
+	leaderAddress, leaderID := n.raft.LeaderWithID()
+	term, _ := strconv.ParseUint(n.raft.Stats()["term"], 10, 64)
+	status := Status{
+		ID:            n.options.NodeID,
+		Address:       string(n.transport.LocalAddr()),
+		State:         n.raft.State().String(),
+		LeaderID:      string(leaderID),
+		LeaderAddress: string(leaderAddress),
+		Term:          term,
+		CommitIndex:   n.raft.CommitIndex(),
+		AppliedIndex:  n.fsm.appliedIndex(),
+	}
+	future := n.raft.GetConfiguration()
+	if err := future.Error(); err != nil {
+		return status, err
+	}
+	for _, server := range future.Configuration().Servers {
+		status.Servers = append(status.Servers, Peer{ID: string(server.ID), Address: string(server.Address)})
+	}
+	return status, nil
+}
+
+// IsLeader reports whether the node is the leader of the cluster.
+func (n *Node) IsLeader() bool {
+	return n.raft.State() == raft.Leader
+}
+
+// Close shuts down the raft node and closes the db.
+func (n *Node) Close() error {
+	err := n.raft.Shutdown().Error()
+	if closeErr := n.transport.Close(); err == nil {
+		err = closeErr
+	}
+	n.mu.Lock()
+	for address, conn := range n.conns {
+		_ = conn.Close()
+		delete(n.conns, address)
+	}
+	n.mu.Unlock()
+	if closeErr := n.logStore.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := n.fsm.close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("close cluster node: %w", err)
+	}
+	return nil
+}
+
+// server serves the cluster service of the node to the other nodes.
+type server struct {
+	n *Node
+}
+
+func (s *server) Apply(ctx context.Context, req *ApplyRequest) (*ApplyResponse, error) {
+	if req.Command == nil {
+		return nil, toStatus(ErrUnknownOp)
+	}
+	value, err := s.n.apply(ctx, req.Command, req.Hops)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &ApplyResponse{Value: value}, nil
+}
+
+func (s *server) ReadIndex(ctx context.Context, req *ReadIndexRequest) (*ReadIndexResponse, error) {
+	index, err := s.n.readIndex(ctx, req.Hops)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &ReadIndexResponse{Index: index}, nil
+}
+
+func (s *server) Join(ctx context.Context, req *JoinRequest) (*MembershipResponse, error) {
+	if err := s.n.join(ctx, req); err != nil {
+		return nil, toStatus(err)
+	}
+	return &MembershipResponse{}, nil
+}
+
+func (s *server) Leave(ctx context.Context, req *LeaveRequest) (*MembershipResponse, error) {
+	if err := s.n.leave(ctx, req); err != nil {
+		return nil, toStatus(err)
+	}
+	return &MembershipResponse{}, nil
+}
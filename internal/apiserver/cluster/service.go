@@ -0,0 +1,207 @@
+// Copyright 2024 Joy <joyssss94@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package cluster
+
+import (
+	"context"
+	"errors"
+
+	"github.com/hashicorp/raft"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/JoyZF/zoom/pkg/rosedb"
+)
+
+const serviceName = "zoom.cluster.Cluster"
+
+// maxHops is how many times a request is forwarded, a node may forward it to a former leader
+// which forwards it again while the cluster elects a new leader.
+const maxHops = 3
+
+// ApplyRequest applies a command on the leader.
+type ApplyRequest struct {
+	Command *Command `json:"command"`
+	Hops    int      `json:"hops"`
+}
+
+// ApplyResponse is the result of an ApplyRequest, the error of the command is returned as the error of the call.
+type ApplyResponse struct {
+	// Value is the result of the read-modify-write operation of the command, see applyCommand.
+	Value []byte `json:"value,omitempty"`
+}
+
+// ReadIndexRequest asks the leader for the index a linearizable read has to wait for.
+type ReadIndexRequest struct {
+	Hops int `json:"hops"`
+}
+
+// ReadIndexResponse is the index of the last command applied on the leader when it was still the leader.
+type ReadIndexResponse struct {
+	Index uint64 `json:"index"`
+}
+
+// JoinRequest adds a voter to the cluster.
+type JoinRequest struct {
+	ID      string `json:"id"`
+	Address string `json:"address"`
+	Hops    int    `json:"hops"`
+}
+
+// LeaveRequest removes a server from the cluster.
+type LeaveRequest struct {
+	ID   string `json:"id"`
+	Hops int    `json:"hops"`
+}
+
+// MembershipResponse is the result of a JoinRequest or a LeaveRequest.
+type MembershipResponse struct{}
+
+// clusterServer is the server API of the cluster service.
+type clusterServer interface {
+	Apply(ctx context.Context, req *ApplyRequest) (*ApplyResponse, error)
+	ReadIndex(ctx context.Context, req *ReadIndexRequest) (*ReadIndexResponse, error)
+	Join(ctx context.Context, req *JoinRequest) (*MembershipResponse, error)
+	Leave(ctx context.Context, req *LeaveRequest) (*MembershipResponse, error)
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: serviceName,
+	HandlerType: (*clusterServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Apply",
+			Handler:    applyHandler,
+		},
+		{
+			MethodName: "ReadIndex",
+			Handler:    readIndexHandler,
+		},
+		{
+			MethodName: "Join",
+			Handler:    joinHandler,
+		},
+		{
+			MethodName: "Leave",
+			Handler:    leaveHandler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "cluster",
+}
+
+func applyHandler(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+	req := &ApplyRequest{}
+	if err := dec(req); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(clusterServer).Apply(ctx, req)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + serviceName + "/Apply"}
+	return interceptor(ctx, req, info, func(ctx context.Context, req any) (any, error) {
+		return srv.(clusterServer).Apply(ctx, req.(*ApplyRequest))
+	})
+}
+
+func readIndexHandler(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+	req := &ReadIndexRequest{}
+	if err := dec(req); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(clusterServer).ReadIndex(ctx, req)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + serviceName + "/ReadIndex"}
+	return interceptor(ctx, req, info, func(ctx context.Context, req any) (any, error) {
+		return srv.(clusterServer).ReadIndex(ctx, req.(*ReadIndexRequest))
+	})
+}
+
+func joinHandler(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+	req := &JoinRequest{}
+	if err := dec(req); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(clusterServer).Join(ctx, req)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + serviceName + "/Join"}
+	return interceptor(ctx, req, info, func(ctx context.Context, req any) (any, error) {
+		return srv.(clusterServer).Join(ctx, req.(*JoinRequest))
+	})
+}
+
+func leaveHandler(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+	req := &LeaveRequest{}
+	if err := dec(req); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(clusterServer).Leave(ctx, req)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + serviceName + "/Leave"}
+	return interceptor(ctx, req, info, func(ctx context.Context, req any) (any, error) {
+		return srv.(clusterServer).Leave(ctx, req.(*LeaveRequest))
+	})
+}
+
+// knownErrors are the errors which are returned as they are by a forwarded request,
+// so the callers can compare them with errors.Is.
+var knownErrors = []error{
+	rosedb.ErrKeyIsEmpty,
+	rosedb.ErrKeyNotFound,
+	rosedb.ErrDBClosed,
+	rosedb.ErrPreconditionFailed,
+	rosedb.ErrValueNotInteger,
+	rosedb.ErrValueNotFloat,
+	rosedb.ErrIncrOverflow,
+	raft.ErrNotLeader,
+	raft.ErrLeadershipLost,
+	raft.ErrRaftShutdown,
+	raft.ErrEnqueueTimeout,
+	ErrUnknownOp,
+	ErrNoLeader,
+	ErrTooManyHops,
+}
+
+func toStatus(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	switch {
+	case errors.Is(err, rosedb.ErrKeyNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, rosedb.ErrKeyIsEmpty), errors.Is(err, ErrUnknownOp),
+		errors.Is(err, rosedb.ErrValueNotInteger), errors.Is(err, rosedb.ErrValueNotFloat),
+		errors.Is(err, rosedb.ErrIncrOverflow):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, rosedb.ErrPreconditionFailed):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, raft.ErrNotLeader), errors.Is(err, raft.ErrLeadershipLost),
+		errors.Is(err, ErrNoLeader), errors.Is(err, ErrTooManyHops):
+		return status.Error(codes.Unavailable, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
+
+// fromStatus returns the known error of the status returned by a forwarded request.
+func fromStatus(err error) error {
+	s, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+	for _, known := range knownErrors {
+		if s.Message() == known.Error() {
+			return known
+		}
+	}
+	return err
+}
@@ -0,0 +1,202 @@
+// Copyright 2024 Joy <joyssss94@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package cluster
+
+import (
+	"context"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/JoyZF/zoom/pkg/rosedb"
+)
+
+// Store is the store of a cluster node, the writes go through the raft log,
+// and the reads wait for a read barrier, so every node serves the same linearizable view.
+type Store struct {
+	node *Node
+}
+
+// NewStore returns the store of the node.
+func NewStore(node *Node) *Store {
+	return &Store{node: node}
+}
+
+// context returns the context of a request, which is bounded by the apply timeout.
+func (s *Store) context() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), s.node.options.ApplyTimeout)
+}
+
+func (s *Store) apply(ops ...*Op) error {
+	_, err := s.update(ops...)
+	return err
+}
+
+// update applies the ops, and returns the result of the command, see applyCommand.
+func (s *Store) update(ops ...*Op) ([]byte, error) {
+	ctx, cancel := s.context()
+	defer cancel()
+	return s.node.Apply(ctx, &Command{Ops: ops})
+}
+
+// read calls fn with the db after a read barrier.
+func (s *Store) read(fn func(db *rosedb.DB) error) error {
+	ctx, cancel := s.context()
+	defer cancel()
+	if err := s.node.ReadBarrier(ctx); err != nil {
+		return err
+	}
+	return s.node.View(fn)
+}
+
+func (s *Store) Sync() error {
+	return s.node.View(func(db *rosedb.DB) error {
+		return db.Sync()
+	})
+}
+
+// Stat returns the stat of the local db.
+func (s *Store) Stat() any {
+	var stat *rosedb.Stat
+	_ = s.node.View(func(db *rosedb.DB) error {
+		stat = db.Stat()
+		return nil
+	})
+	return stat
+}
+
+func (s *Store) Get(key []byte) ([]byte, error) {
+	var value []byte
+	err := s.read(func(db *rosedb.DB) error {
+		var err error
+		value, err = db.Get(key)
+		return err
+	})
+	return value, err
+}
+
+func (s *Store) Put(key, value []byte) error {
+	return s.apply(&Op{Type: OpPut, Key: key, Value: value})
+}
+
+func (s *Store) PutWithTTL(key []byte, value []byte, ttl time.Duration) error {
+	return s.apply(&Op{Type: OpPut, Key: key, Value: value, Expire: time.Now().Add(ttl).UnixNano()})
+}
+
+func (s *Store) Delete(key []byte) error {
+	return s.apply(&Op{Type: OpDelete, Key: key})
+}
+
+func (s *Store) MultiGet(keys [][]byte) ([][]byte, error) {
+	var values [][]byte
+	err := s.read(func(db *rosedb.DB) error {
+		var err error
+		values, err = db.MultiGet(keys)
+		return err
+	})
+	return values, err
+}
+
+// MultiPut applies the pairs in a single command, so they are written in a single batch.
+func (s *Store) MultiPut(keys, values [][]byte) error {
+	if len(keys) != len(values) {
+		return rosedb.ErrMultiLengthMismatch
+	}
+	ops := make([]*Op, 0, len(keys))
+	for i, key := range keys {
+		ops = append(ops, &Op{Type: OpPut, Key: key, Value: values[i]})
+	}
+	return s.apply(ops...)
+}
+
+// MultiDelete applies the deletes in a single command, so they are written in a single batch.
+func (s *Store) MultiDelete(keys [][]byte) error {
+	ops := make([]*Op, 0, len(keys))
+	for _, key := range keys {
+		ops = append(ops, &Op{Type: OpDelete, Key: key})
+	}
+	return s.apply(ops...)
+}
+
+func (s *Store) TTL(key []byte) (time.Duration, error) {
+	var ttl time.Duration
+	err := s.read(func(db *rosedb.DB) error {
+		var err error
+		ttl, err = db.TTL(key)
+		return err
+	})
+	return ttl, err
+}
+
+func (s *Store) Exist(key []byte) (bool, error) {
+	var exist bool
+	err := s.read(func(db *rosedb.DB) error {
+		var err error
+		exist, err = db.Exist(key)
+		return err
+	})
+	return exist, err
+}
+
+func (s *Store) Expire(key []byte, ttl time.Duration) error {
+	return s.apply(&Op{Type: OpExpire, Key: key, Expire: time.Now().Add(ttl).UnixNano()})
+}
+
+func (s *Store) Incr(key []byte) (int64, error) {
+	return s.IncrBy(key, 1)
+}
+
+func (s *Store) IncrBy(key []byte, delta int64) (int64, error) {
+	result, err := s.update(&Op{Type: OpIncrBy, Key: key, Delta: delta})
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(string(result), 10, 64)
+}
+
+func (s *Store) IncrByFloat(key []byte, delta float64) (float64, error) {
+	result, err := s.update(&Op{Type: OpIncrByFloat, Key: key, FloatDelta: delta})
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(string(result), 64)
+}
+
+func (s *Store) CompareAndSwap(key, expected, value []byte) error {
+	return s.apply(&Op{Type: OpCompareAndSwap, Key: key, Expected: expected, Value: value})
+}
+
+func (s *Store) PutIfAbsent(key, value []byte) error {
+	return s.apply(&Op{Type: OpPutIfAbsent, Key: key, Value: value})
+}
+
+func (s *Store) PutIfExists(key, value []byte) error {
+	return s.apply(&Op{Type: OpPutIfExists, Key: key, Value: value})
+}
+
+func (s *Store) GetAndSet(key, value []byte) ([]byte, error) {
+	return s.update(&Op{Type: OpGetAndSet, Key: key, Value: value})
+}
+
+func (s *Store) Export(w io.Writer, options rosedb.ExportOptions) (int, error) {
+	var count int
+	err := s.read(func(db *rosedb.DB) error {
+		var err error
+		count, err = db.Export(w, options)
+		return err
+	})
+	return count, err
+}
+
+// Import applies the records of the dump through the raft log, a command for each batch of records.
+func (s *Store) Import(r io.Reader, options rosedb.ImportOptions) (int, error) {
+	return rosedb.ReadDump(r, options, func(changes []*rosedb.Change) error {
+		ops := make([]*Op, 0, len(changes))
+		for _, change := range changes {
+			ops = append(ops, &Op{Type: OpPut, Key: change.Key, Value: change.Value, Expire: change.Expire})
+		}
+		return s.apply(ops...)
+	})
+}
@@ -0,0 +1,183 @@
+// Copyright 2024 Joy <joyssss94@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package cluster
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// raftMagic is the first byte sent on a raft connection, which tells it from a grpc connection
+// on the shared listener. A grpc connection starts with the HTTP/2 preface "PRI".
+const raftMagic byte = 'R'
+
+var errListenerClosed = errors.New("the listener is closed")
+
+// Mux splits the connections accepted on a listener between raft and grpc,
+// so a node serves both on one address, and the raft address of the leader
+// is also the address the writes are forwarded to.
+type Mux struct {
+	listener net.Listener
+	grpc     *muxListener
+	raft     *muxListener
+
+	closeOnce sync.Once
+}
+
+// NewMux starts splitting the connections accepted on the listener.
+func NewMux(listener net.Listener) *Mux {
+	m := &Mux{listener: listener}
+	m.grpc = newMuxListener(listener.Addr(), m.Close)
+	m.raft = newMuxListener(listener.Addr(), nil)
+	go m.serve()
+	return m
+}
+
+// GRPCListener returns the listener of the grpc connections.
+func (m *Mux) GRPCListener() net.Listener {
+	return m.grpc
+}
+
+// Close closes the listener.
+func (m *Mux) Close() error {
+	var err error
+	m.closeOnce.Do(func() {
+		err = m.listener.Close()
+		m.grpc.close()
+		m.raft.close()
+	})
+	return err
+}
+
+func (m *Mux) serve() {
+	for {
+		conn, err := m.listener.Accept()
+		if err != nil {
+			_ = m.Close()
+			return
+		}
+		go m.route(conn)
+	}
+}
+
+// route reads the first byte of the connection to hand it to raft or grpc.
+func (m *Mux) route(conn net.Conn) {
+	_ = conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	reader := bufio.NewReader(conn)
+	first, err := reader.Peek(1)
+	if err != nil {
+		_ = conn.Close()
+		return
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+	if first[0] == raftMagic {
+		_, _ = reader.Discard(1)
+		m.raft.deliver(&bufferedConn{Conn: conn, reader: reader})
+		return
+	}
+	m.grpc.deliver(&bufferedConn{Conn: conn, reader: reader})
+}
+
+// bufferedConn is a connection whose first bytes have been read into the reader.
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+// muxListener is the listener of the connections of one protocol.
+type muxListener struct {
+	addr     net.Addr
+	conns    chan net.Conn
+	done     chan struct{}
+	doneOnce sync.Once
+	closeMux func() error // closes the shared listener when this one is closed, if not nil
+}
+
+func newMuxListener(addr net.Addr, closeMux func() error) *muxListener {
+	return &muxListener{
+		addr:     addr,
+		conns:    make(chan net.Conn),
+		done:     make(chan struct{}),
+		closeMux: closeMux,
+	}
+}
+
+func (l *muxListener) deliver(conn net.Conn) {
+	select {
+	case l.conns <- conn:
+	case <-l.done:
+		_ = conn.Close()
+	}
+}
+
+func (l *muxListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, errListenerClosed
+	}
+}
+
+// Close stops accepting the connections of the protocol. Closing the grpc listener
+// closes the shared listener, as the grpc server only stops when the node goes down.
+func (l *muxListener) Close() error {
+	if l.closeMux != nil {
+		return l.closeMux()
+	}
+	l.close()
+	return nil
+}
+
+func (l *muxListener) close() {
+	l.doneOnce.Do(func() {
+		close(l.done)
+	})
+}
+
+func (l *muxListener) Addr() net.Addr {
+	return l.addr
+}
+
+// streamLayer is the raft.StreamLayer of the raft connections on the Mux.
+type streamLayer struct {
+	*muxListener
+	advertise net.Addr
+}
+
+// newStreamLayer returns the stream layer of raft, advertise is the address the other nodes dial,
+// the address of the listener is used if it is nil.
+func (m *Mux) newStreamLayer(advertise net.Addr) raft.StreamLayer {
+	if advertise == nil {
+		advertise = m.listener.Addr()
+	}
+	return &streamLayer{muxListener: m.raft, advertise: advertise}
+}
+
+// Dial opens a raft connection to the node at the address.
+func (s *streamLayer) Dial(address raft.ServerAddress, timeout time.Duration) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", string(address), timeout)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = conn.Write([]byte{raftMagic}); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (s *streamLayer) Addr() net.Addr {
+	return s.advertise
+}
@@ -0,0 +1,92 @@
+package cluster
+
+import (
+	"github.com/JoyZF/errors"
+	"github.com/gin-gonic/gin"
+
+	"github.com/JoyZF/zoom/internal/apiserver/cluster"
+	v1 "github.com/JoyZF/zoom/internal/apiserver/types/v1"
+	"github.com/JoyZF/zoom/internal/pkg/code"
+	"github.com/JoyZF/zoom/internal/pkg/response"
+)
+
+type ClusterController struct {
+}
+
+func NewClusterController() ClusterController {
+	return ClusterController{}
+}
+
+// Status
+//
+//	@Summary	get the raft state of the node and the servers of the cluster
+//	@Produce	json
+//	@Success	200	{object}	response.SuccessResponse	"成功"
+//	@Failure	400	{object}	response.ErrResponse		"失败"
+//	@Router		/v1/cluster/status [get]
+func (c ClusterController) Status(ctx *gin.Context) {
+	node := cluster.GetNode()
+	if node == nil {
+		response.WriteResponse(ctx, errors.WithCode(code.GenericServiceErrorCode, "the cluster mode is not enabled"), nil)
+		return
+	}
+	status, err := node.Status()
+	if err != nil {
+		response.WriteResponse(ctx, errors.WithCode(code.GenericServiceErrorCode, err.Error()), nil)
+		return
+	}
+	response.WriteResponse(ctx, nil, status)
+}
+
+// Join
+//
+//	@Summary	add a voter to the cluster
+//	@Produce	json
+//	@Param		id		body		string						true	"节点ID"
+//	@Param		address	body		string						true	"节点的grpc地址"
+//	@Success	200		{object}	response.SuccessResponse	"成功"
+//	@Failure	400		{object}	response.ErrResponse		"失败"
+//	@Router		/v1/cluster/join [post]
+func (c ClusterController) Join(ctx *gin.Context) {
+	node := cluster.GetNode()
+	if node == nil {
+		response.WriteResponse(ctx, errors.WithCode(code.GenericServiceErrorCode, "the cluster mode is not enabled"), nil)
+		return
+	}
+	req := v1.ClusterJoinReq{}
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		response.WriteResponse(ctx, errors.WithCode(code.ParamsError, err.Error()), nil)
+		return
+	}
+	if err := node.Join(ctx, req.ID, req.Address); err != nil {
+		response.WriteResponse(ctx, errors.WithCode(code.GenericServiceErrorCode, err.Error()), nil)
+		return
+	}
+	response.WriteResponse(ctx, nil, nil)
+}
+
+// Leave
+//
+//	@Summary	remove a server from the cluster
+//	@Produce	json
+//	@Param		id	body		string						true	"节点ID"
+//	@Success	200	{object}	response.SuccessResponse	"成功"
+//	@Failure	400	{object}	response.ErrResponse		"失败"
+//	@Router		/v1/cluster/leave [post]
+func (c ClusterController) Leave(ctx *gin.Context) {
+	node := cluster.GetNode()
+	if node == nil {
+		response.WriteResponse(ctx, errors.WithCode(code.GenericServiceErrorCode, "the cluster mode is not enabled"), nil)
+		return
+	}
+	req := v1.ClusterLeaveReq{}
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		response.WriteResponse(ctx, errors.WithCode(code.ParamsError, err.Error()), nil)
+		return
+	}
+	if err := node.Leave(ctx, req.ID); err != nil {
+		response.WriteResponse(ctx, errors.WithCode(code.GenericServiceErrorCode, err.Error()), nil)
+		return
+	}
+	response.WriteResponse(ctx, nil, nil)
+}
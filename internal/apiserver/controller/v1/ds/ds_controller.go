@@ -0,0 +1,97 @@
+package ds
+
+import (
+	"github.com/JoyZF/errors"
+	"github.com/gin-gonic/gin"
+
+	"github.com/JoyZF/zoom/internal/apiserver/service/ds"
+	v1 "github.com/JoyZF/zoom/internal/apiserver/types/v1"
+	"github.com/JoyZF/zoom/internal/pkg/code"
+	"github.com/JoyZF/zoom/internal/pkg/response"
+	rosedbds "github.com/JoyZF/zoom/pkg/rosedb/ds"
+)
+
+// DSController serves the hash, list, set and sorted set of the standalone store.
+type DSController struct {
+}
+
+func NewDSController() DSController {
+	return DSController{}
+}
+
+// writeError writes the error of the data structures, a key of another type has its own code.
+func writeError(ctx *gin.Context, err error) {
+	if err == rosedbds.ErrWrongType {
+		response.WriteResponse(ctx, errors.WithCode(code.WrongType, err.Error()), nil)
+		return
+	}
+	response.WriteResponse(ctx, errors.WithCode(code.GenericServiceErrorCode, err.Error()), nil)
+}
+
+// Expire
+//
+//	@Summary	set the ttl of a hash, list, set or sorted set
+//	@Produce	json
+//	@Param		key	body		string						true	"键名"
+//	@Param		ttl	body		int64						true	"过期时间"
+//	@Success	200	{object}	response.SuccessResponse	"成功"
+//	@Failure	400	{object}	response.ErrResponse		"失败"
+//	@Router		/v1/ds/expire [put]
+func (c DSController) Expire(ctx *gin.Context) {
+	req := v1.ExpireReq{}
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		response.WriteResponse(ctx, errors.WithCode(code.ParamsError, err.Error()), nil)
+		return
+	}
+
+	if err := ds.NewDS().Expire(ctx, &req); err != nil {
+		writeError(ctx, err)
+		return
+	}
+	response.WriteResponse(ctx, nil, nil)
+}
+
+// TTL
+//
+//	@Summary	get the ttl of a hash, list, set or sorted set
+//	@Produce	json
+//	@Param		key	query		string						true	"键名"
+//	@Success	200	{object}	response.SuccessResponse	"成功"
+//	@Failure	400	{object}	response.ErrResponse		"失败"
+//	@Router		/v1/ds/ttl [get]
+func (c DSController) TTL(ctx *gin.Context) {
+	req := v1.KeyReq{}
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		response.WriteResponse(ctx, errors.WithCode(code.ParamsError, err.Error()), nil)
+		return
+	}
+
+	ttl, err := ds.NewDS().TTL(ctx, &req)
+	if err != nil {
+		writeError(ctx, err)
+		return
+	}
+	response.WriteResponse(ctx, nil, ttl)
+}
+
+// Delete
+//
+//	@Summary	delete a hash, list, set or sorted set
+//	@Produce	json
+//	@Param		key	query		string						true	"键名"
+//	@Success	200	{object}	response.SuccessResponse	"成功"
+//	@Failure	400	{object}	response.ErrResponse		"失败"
+//	@Router		/v1/ds [delete]
+func (c DSController) Delete(ctx *gin.Context) {
+	req := v1.KeyReq{}
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		response.WriteResponse(ctx, errors.WithCode(code.ParamsError, err.Error()), nil)
+		return
+	}
+
+	if err := ds.NewDS().Delete(ctx, &req); err != nil {
+		writeError(ctx, err)
+		return
+	}
+	response.WriteResponse(ctx, nil, nil)
+}
@@ -0,0 +1,107 @@
+package ds
+
+import (
+	"github.com/JoyZF/errors"
+	"github.com/gin-gonic/gin"
+
+	"github.com/JoyZF/zoom/internal/apiserver/service/ds"
+	v1 "github.com/JoyZF/zoom/internal/apiserver/types/v1"
+	"github.com/JoyZF/zoom/internal/pkg/code"
+	"github.com/JoyZF/zoom/internal/pkg/response"
+)
+
+// HSet
+//
+//	@Summary	set the field of a hash, returns true if the field is new
+//	@Produce	json
+//	@Param		key		body		string						true	"键名"
+//	@Param		field	body		string						true	"字段名"
+//	@Param		value	body		string						true	"字段值"
+//	@Success	200		{object}	response.SuccessResponse	"成功"
+//	@Failure	400		{object}	response.ErrResponse		"失败"
+//	@Router		/v1/hash [put]
+func (c DSController) HSet(ctx *gin.Context) {
+	req := v1.HSetReq{}
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		response.WriteResponse(ctx, errors.WithCode(code.ParamsError, err.Error()), nil)
+		return
+	}
+
+	added, err := ds.NewDS().HSet(ctx, &req)
+	if err != nil {
+		writeError(ctx, err)
+		return
+	}
+	response.WriteResponse(ctx, nil, added)
+}
+
+// HGet
+//
+//	@Summary	get the field of a hash
+//	@Produce	json
+//	@Param		key		query		string						true	"键名"
+//	@Param		field	query		string						true	"字段名"
+//	@Success	200		{object}	response.SuccessResponse	"成功"
+//	@Failure	400		{object}	response.ErrResponse		"失败"
+//	@Router		/v1/hash [get]
+func (c DSController) HGet(ctx *gin.Context) {
+	req := v1.HashFieldReq{}
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		response.WriteResponse(ctx, errors.WithCode(code.ParamsError, err.Error()), nil)
+		return
+	}
+
+	value, err := ds.NewDS().HGet(ctx, &req)
+	if err != nil {
+		writeError(ctx, err)
+		return
+	}
+	response.WriteResponse(ctx, nil, value)
+}
+
+// HGetAll
+//
+//	@Summary	get all the fields of a hash
+//	@Produce	json
+//	@Param		key	query		string						true	"键名"
+//	@Success	200	{object}	response.SuccessResponse	"成功"
+//	@Failure	400	{object}	response.ErrResponse		"失败"
+//	@Router		/v1/hash/all [get]
+func (c DSController) HGetAll(ctx *gin.Context) {
+	req := v1.KeyReq{}
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		response.WriteResponse(ctx, errors.WithCode(code.ParamsError, err.Error()), nil)
+		return
+	}
+
+	hash, err := ds.NewDS().HGetAll(ctx, &req)
+	if err != nil {
+		writeError(ctx, err)
+		return
+	}
+	response.WriteResponse(ctx, nil, hash)
+}
+
+// HDel
+//
+//	@Summary	delete the fields of a hash, returns the number of the fields deleted
+//	@Produce	json
+//	@Param		key		body		string						true	"键名"
+//	@Param		fields	body		[]string					true	"字段名"
+//	@Success	200		{object}	response.SuccessResponse	"成功"
+//	@Failure	400		{object}	response.ErrResponse		"失败"
+//	@Router		/v1/hash [delete]
+func (c DSController) HDel(ctx *gin.Context) {
+	req := v1.HDelReq{}
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		response.WriteResponse(ctx, errors.WithCode(code.ParamsError, err.Error()), nil)
+		return
+	}
+
+	removed, err := ds.NewDS().HDel(ctx, &req)
+	if err != nil {
+		writeError(ctx, err)
+		return
+	}
+	response.WriteResponse(ctx, nil, removed)
+}
@@ -0,0 +1,83 @@
+package ds
+
+import (
+	"github.com/JoyZF/errors"
+	"github.com/gin-gonic/gin"
+
+	"github.com/JoyZF/zoom/internal/apiserver/service/ds"
+	v1 "github.com/JoyZF/zoom/internal/apiserver/types/v1"
+	"github.com/JoyZF/zoom/internal/pkg/code"
+	"github.com/JoyZF/zoom/internal/pkg/response"
+)
+
+// LPush
+//
+//	@Summary	insert the values at the head of a list, returns the length of the list
+//	@Produce	json
+//	@Param		key		body		string						true	"键名"
+//	@Param		values	body		[]string					true	"元素"
+//	@Success	200		{object}	response.SuccessResponse	"成功"
+//	@Failure	400		{object}	response.ErrResponse		"失败"
+//	@Router		/v1/list/lpush [post]
+func (c DSController) LPush(ctx *gin.Context) {
+	req := v1.LPushReq{}
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		response.WriteResponse(ctx, errors.WithCode(code.ParamsError, err.Error()), nil)
+		return
+	}
+
+	length, err := ds.NewDS().LPush(ctx, &req)
+	if err != nil {
+		writeError(ctx, err)
+		return
+	}
+	response.WriteResponse(ctx, nil, length)
+}
+
+// RPop
+//
+//	@Summary	remove and get the last item of a list
+//	@Produce	json
+//	@Param		key	body		string						true	"键名"
+//	@Success	200	{object}	response.SuccessResponse	"成功"
+//	@Failure	400	{object}	response.ErrResponse		"失败"
+//	@Router		/v1/list/rpop [post]
+func (c DSController) RPop(ctx *gin.Context) {
+	req := v1.RPopReq{}
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		response.WriteResponse(ctx, errors.WithCode(code.ParamsError, err.Error()), nil)
+		return
+	}
+
+	value, err := ds.NewDS().RPop(ctx, &req)
+	if err != nil {
+		writeError(ctx, err)
+		return
+	}
+	response.WriteResponse(ctx, nil, value)
+}
+
+// LRange
+//
+//	@Summary	get the items of a list from start to stop, both inclusive
+//	@Produce	json
+//	@Param		key		query		string						true	"键名"
+//	@Param		start	query		int							false	"起始下标(包含)"
+//	@Param		stop	query		int							false	"结束下标(包含), 默认 -1"
+//	@Success	200		{object}	response.SuccessResponse	"成功"
+//	@Failure	400		{object}	response.ErrResponse		"失败"
+//	@Router		/v1/list/range [get]
+func (c DSController) LRange(ctx *gin.Context) {
+	req := v1.LRangeReq{}
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		response.WriteResponse(ctx, errors.WithCode(code.ParamsError, err.Error()), nil)
+		return
+	}
+
+	values, err := ds.NewDS().LRange(ctx, &req)
+	if err != nil {
+		writeError(ctx, err)
+		return
+	}
+	response.WriteResponse(ctx, nil, values)
+}
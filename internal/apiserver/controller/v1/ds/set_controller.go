@@ -0,0 +1,82 @@
+package ds
+
+import (
+	"github.com/JoyZF/errors"
+	"github.com/gin-gonic/gin"
+
+	"github.com/JoyZF/zoom/internal/apiserver/service/ds"
+	v1 "github.com/JoyZF/zoom/internal/apiserver/types/v1"
+	"github.com/JoyZF/zoom/internal/pkg/code"
+	"github.com/JoyZF/zoom/internal/pkg/response"
+)
+
+// SAdd
+//
+//	@Summary	add the members to a set, returns the number of the members added
+//	@Produce	json
+//	@Param		key		body		string						true	"键名"
+//	@Param		members	body		[]string					true	"成员"
+//	@Success	200		{object}	response.SuccessResponse	"成功"
+//	@Failure	400		{object}	response.ErrResponse		"失败"
+//	@Router		/v1/set [put]
+func (c DSController) SAdd(ctx *gin.Context) {
+	req := v1.SAddReq{}
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		response.WriteResponse(ctx, errors.WithCode(code.ParamsError, err.Error()), nil)
+		return
+	}
+
+	added, err := ds.NewDS().SAdd(ctx, &req)
+	if err != nil {
+		writeError(ctx, err)
+		return
+	}
+	response.WriteResponse(ctx, nil, added)
+}
+
+// SIsMember
+//
+//	@Summary	check whether the member is in a set
+//	@Produce	json
+//	@Param		key		query		string						true	"键名"
+//	@Param		member	query		string						true	"成员"
+//	@Success	200		{object}	response.SuccessResponse	"成功"
+//	@Failure	400		{object}	response.ErrResponse		"失败"
+//	@Router		/v1/set/ismember [get]
+func (c DSController) SIsMember(ctx *gin.Context) {
+	req := v1.SetMemberReq{}
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		response.WriteResponse(ctx, errors.WithCode(code.ParamsError, err.Error()), nil)
+		return
+	}
+
+	ok, err := ds.NewDS().SIsMember(ctx, &req)
+	if err != nil {
+		writeError(ctx, err)
+		return
+	}
+	response.WriteResponse(ctx, nil, ok)
+}
+
+// SMembers
+//
+//	@Summary	get the members of a set
+//	@Produce	json
+//	@Param		key	query		string						true	"键名"
+//	@Success	200	{object}	response.SuccessResponse	"成功"
+//	@Failure	400	{object}	response.ErrResponse		"失败"
+//	@Router		/v1/set/members [get]
+func (c DSController) SMembers(ctx *gin.Context) {
+	req := v1.KeyReq{}
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		response.WriteResponse(ctx, errors.WithCode(code.ParamsError, err.Error()), nil)
+		return
+	}
+
+	members, err := ds.NewDS().SMembers(ctx, &req)
+	if err != nil {
+		writeError(ctx, err)
+		return
+	}
+	response.WriteResponse(ctx, nil, members)
+}
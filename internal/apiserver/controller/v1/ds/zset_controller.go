@@ -0,0 +1,85 @@
+package ds
+
+import (
+	"github.com/JoyZF/errors"
+	"github.com/gin-gonic/gin"
+
+	"github.com/JoyZF/zoom/internal/apiserver/service/ds"
+	v1 "github.com/JoyZF/zoom/internal/apiserver/types/v1"
+	"github.com/JoyZF/zoom/internal/pkg/code"
+	"github.com/JoyZF/zoom/internal/pkg/response"
+)
+
+// ZAdd
+//
+//	@Summary	add the member with the score to a sorted set, returns true if the member is new
+//	@Produce	json
+//	@Param		key		body		string						true	"键名"
+//	@Param		score	body		number						true	"分数"
+//	@Param		member	body		string						true	"成员"
+//	@Success	200		{object}	response.SuccessResponse	"成功"
+//	@Failure	400		{object}	response.ErrResponse		"失败"
+//	@Router		/v1/zset [put]
+func (c DSController) ZAdd(ctx *gin.Context) {
+	req := v1.ZAddReq{}
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		response.WriteResponse(ctx, errors.WithCode(code.ParamsError, err.Error()), nil)
+		return
+	}
+
+	added, err := ds.NewDS().ZAdd(ctx, &req)
+	if err != nil {
+		writeError(ctx, err)
+		return
+	}
+	response.WriteResponse(ctx, nil, added)
+}
+
+// ZRangeByScore
+//
+//	@Summary	get the members of a sorted set with a score between min and max, ordered by score
+//	@Produce	json
+//	@Param		key	query		string						true	"键名"
+//	@Param		min	query		number						true	"最小分数(包含), 可以是 -inf"
+//	@Param		max	query		number						true	"最大分数(包含), 可以是 +inf"
+//	@Success	200	{object}	response.SuccessResponse	"成功"
+//	@Failure	400	{object}	response.ErrResponse		"失败"
+//	@Router		/v1/zset/range [get]
+func (c DSController) ZRangeByScore(ctx *gin.Context) {
+	req := v1.ZRangeByScoreReq{}
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		response.WriteResponse(ctx, errors.WithCode(code.ParamsError, err.Error()), nil)
+		return
+	}
+
+	members, err := ds.NewDS().ZRangeByScore(ctx, &req)
+	if err != nil {
+		writeError(ctx, err)
+		return
+	}
+	response.WriteResponse(ctx, nil, members)
+}
+
+// ZRank
+//
+//	@Summary	get the rank of the member in a sorted set, the lowest score is 0
+//	@Produce	json
+//	@Param		key		query		string						true	"键名"
+//	@Param		member	query		string						true	"成员"
+//	@Success	200		{object}	response.SuccessResponse	"成功"
+//	@Failure	400		{object}	response.ErrResponse		"失败"
+//	@Router		/v1/zset/rank [get]
+func (c DSController) ZRank(ctx *gin.Context) {
+	req := v1.SetMemberReq{}
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		response.WriteResponse(ctx, errors.WithCode(code.ParamsError, err.Error()), nil)
+		return
+	}
+
+	rank, err := ds.NewDS().ZRank(ctx, &req)
+	if err != nil {
+		writeError(ctx, err)
+		return
+	}
+	response.WriteResponse(ctx, nil, rank)
+}
@@ -0,0 +1,63 @@
+package replication
+
+import (
+	"github.com/JoyZF/errors"
+	"github.com/gin-gonic/gin"
+
+	"github.com/JoyZF/zoom/internal/apiserver/replication"
+	"github.com/JoyZF/zoom/internal/pkg/code"
+	"github.com/JoyZF/zoom/internal/pkg/response"
+)
+
+type ReplicationController struct {
+}
+
+func NewReplicationController() ReplicationController {
+	return ReplicationController{}
+}
+
+// Status
+//
+//	@Summary	get the replication status and lag
+//	@Produce	json
+//	@Success	200	{object}	response.SuccessResponse	"成功"
+//	@Failure	400	{object}	response.ErrResponse		"失败"
+//	@Router		/v1/replication/status [get]
+func (c ReplicationController) Status(ctx *gin.Context) {
+	node := replication.GetNode()
+	if node == nil {
+		response.WriteResponse(ctx, errors.WithCode(code.GenericServiceErrorCode, "replication is not enabled"), nil)
+		return
+	}
+	response.WriteResponse(ctx, nil, node.Status())
+}
+
+// Promote
+//
+//	@Summary	promote the follower to leader
+//	@Produce	json
+//	@Success	200	{object}	response.SuccessResponse	"成功"
+//	@Failure	400	{object}	response.ErrResponse		"失败"
+//	@Router		/v1/replication/promote [post]
+func (c ReplicationController) Promote(ctx *gin.Context) {
+	node := replication.GetNode()
+	if node == nil {
+		response.WriteResponse(ctx, errors.WithCode(code.GenericServiceErrorCode, "replication is not enabled"), nil)
+		return
+	}
+	if err := node.Promote(); err != nil {
+		response.WriteResponse(ctx, errors.WithCode(code.GenericServiceErrorCode, err.Error()), nil)
+		return
+	}
+	response.WriteResponse(ctx, nil, node.Status())
+}
+
+// RejectWrites rejects the writes to a follower, which only applies the writes of the leader.
+func (c ReplicationController) RejectWrites(ctx *gin.Context) {
+	if node := replication.GetNode(); node != nil && node.ReadOnly() {
+		response.WriteResponse(ctx, code.ErrorWithCode(ctx, code.ReadOnlyReplica), nil)
+		ctx.Abort()
+		return
+	}
+	ctx.Next()
+}
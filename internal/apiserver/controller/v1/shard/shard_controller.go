@@ -0,0 +1,56 @@
+package shard
+
+import (
+	"github.com/JoyZF/errors"
+	"github.com/gin-gonic/gin"
+
+	"github.com/JoyZF/zoom/internal/apiserver/shard"
+	v1 "github.com/JoyZF/zoom/internal/apiserver/types/v1"
+	"github.com/JoyZF/zoom/internal/pkg/code"
+	"github.com/JoyZF/zoom/internal/pkg/response"
+)
+
+type ShardController struct {
+}
+
+func NewShardController() ShardController {
+	return ShardController{}
+}
+
+// Status
+//
+//	@Summary	get the membership of the node and the state of the rebalance
+//	@Produce	json
+//	@Success	200	{object}	response.SuccessResponse	"成功"
+//	@Failure	400	{object}	response.ErrResponse		"失败"
+//	@Router		/v1/shard/status [get]
+func (c ShardController) Status(ctx *gin.Context) {
+	node := shard.GetNode()
+	if node == nil {
+		response.WriteResponse(ctx, errors.WithCode(code.GenericServiceErrorCode, "the sharded mode is not enabled"), nil)
+		return
+	}
+	response.WriteResponse(ctx, nil, node.Status())
+}
+
+// Owner
+//
+//	@Summary	get the member which owns the key
+//	@Produce	json
+//	@Param		key	query		string						true	"键名"
+//	@Success	200	{object}	response.SuccessResponse	"成功"
+//	@Failure	400	{object}	response.ErrResponse		"失败"
+//	@Router		/v1/shard/owner [get]
+func (c ShardController) Owner(ctx *gin.Context) {
+	node := shard.GetNode()
+	if node == nil {
+		response.WriteResponse(ctx, errors.WithCode(code.GenericServiceErrorCode, "the sharded mode is not enabled"), nil)
+		return
+	}
+	req := v1.KeyReq{}
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		response.WriteResponse(ctx, errors.WithCode(code.ParamsError, err.Error()), nil)
+		return
+	}
+	response.WriteResponse(ctx, nil, node.Owner([]byte(req.Key)))
+}
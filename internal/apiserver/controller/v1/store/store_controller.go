@@ -1,11 +1,16 @@
 package store
 
 import (
+	"fmt"
+	"net/http"
+
 	"github.com/JoyZF/errors"
+	"github.com/JoyZF/zlog"
 	"github.com/JoyZF/zoom/internal/apiserver/service/store"
 	v1 "github.com/JoyZF/zoom/internal/apiserver/types/v1"
 	"github.com/JoyZF/zoom/internal/pkg/code"
 	"github.com/JoyZF/zoom/internal/pkg/response"
+	"github.com/JoyZF/zoom/pkg/rosedb"
 	"github.com/gin-gonic/gin"
 )
 
@@ -205,3 +210,295 @@ func (c StoreController) Expire(ctx *gin.Context) {
 	}
 	response.WriteResponse(ctx, nil, nil)
 }
+
+// writeUpdateError writes the error of a read-modify-write operation,
+// a precondition which is not met has its own code.
+func writeUpdateError(ctx *gin.Context, err error) {
+	if errors.Is(err, rosedb.ErrPreconditionFailed) {
+		response.WriteResponse(ctx, errors.WithCode(code.PreconditionFailed, err.Error()), nil)
+		return
+	}
+	response.WriteResponse(ctx, errors.WithCode(code.GenericServiceErrorCode, err.Error()), nil)
+}
+
+// Incr
+//
+//	@Summary	add the delta to the integer value of the key atomically, returns the new value
+//	@Produce	json
+//	@Param		key		body		string						true	"键名"
+//	@Param		delta	body		int64						false	"增量, 默认 1"
+//	@Success	200		{object}	response.SuccessResponse	"成功"
+//	@Failure	400		{object}	response.ErrResponse		"失败"
+//	@Router		/v1/store/incr [post]
+func (c StoreController) Incr(ctx *gin.Context) {
+	req := v1.IncrReq{}
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		response.WriteResponse(ctx, errors.WithCode(code.ParamsError, err.Error()), nil)
+		return
+	}
+
+	value, err := store.NewStore().Incr(ctx, &req)
+	if err != nil {
+		writeUpdateError(ctx, err)
+		return
+	}
+	response.WriteResponse(ctx, nil, value)
+}
+
+// IncrByFloat
+//
+//	@Summary	add the delta to the float value of the key atomically, returns the new value
+//	@Produce	json
+//	@Param		key		body		string						true	"键名"
+//	@Param		delta	body		number						true	"增量"
+//	@Success	200		{object}	response.SuccessResponse	"成功"
+//	@Failure	400		{object}	response.ErrResponse		"失败"
+//	@Router		/v1/store/incrbyfloat [post]
+func (c StoreController) IncrByFloat(ctx *gin.Context) {
+	req := v1.IncrByFloatReq{}
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		response.WriteResponse(ctx, errors.WithCode(code.ParamsError, err.Error()), nil)
+		return
+	}
+
+	value, err := store.NewStore().IncrByFloat(ctx, &req)
+	if err != nil {
+		writeUpdateError(ctx, err)
+		return
+	}
+	response.WriteResponse(ctx, nil, value)
+}
+
+// CompareAndSwap
+//
+//	@Summary	set the key to the value if its current value is the expected one
+//	@Produce	json
+//	@Param		key			body		string						true	"键名"
+//	@Param		expected	body		string						true	"期望的当前键值"
+//	@Param		value		body		string						true	"新键值"
+//	@Success	200			{object}	response.SuccessResponse	"成功"
+//	@Failure	400			{object}	response.ErrResponse		"失败"
+//	@Router		/v1/store/cas [post]
+func (c StoreController) CompareAndSwap(ctx *gin.Context) {
+	req := v1.CompareAndSwapReq{}
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		response.WriteResponse(ctx, errors.WithCode(code.ParamsError, err.Error()), nil)
+		return
+	}
+
+	if err := store.NewStore().CompareAndSwap(ctx, &req); err != nil {
+		writeUpdateError(ctx, err)
+		return
+	}
+	response.WriteResponse(ctx, nil, nil)
+}
+
+// PutIfAbsent
+//
+//	@Summary	put kv if the key does not exist
+//	@Produce	json
+//	@Param		key		body		string						true	"键名"
+//	@Param		value	body		string						true	"键值"
+//	@Success	200		{object}	response.SuccessResponse	"成功"
+//	@Failure	400		{object}	response.ErrResponse		"失败"
+//	@Router		/v1/store/ifabsent [put]
+func (c StoreController) PutIfAbsent(ctx *gin.Context) {
+	req := v1.StorePutReq{}
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		response.WriteResponse(ctx, errors.WithCode(code.ParamsError, err.Error()), nil)
+		return
+	}
+
+	if err := store.NewStore().PutIfAbsent(ctx, &req); err != nil {
+		writeUpdateError(ctx, err)
+		return
+	}
+	response.WriteResponse(ctx, nil, nil)
+}
+
+// PutIfExists
+//
+//	@Summary	put kv if the key exists
+//	@Produce	json
+//	@Param		key		body		string						true	"键名"
+//	@Param		value	body		string						true	"键值"
+//	@Success	200		{object}	response.SuccessResponse	"成功"
+//	@Failure	400		{object}	response.ErrResponse		"失败"
+//	@Router		/v1/store/ifexists [put]
+func (c StoreController) PutIfExists(ctx *gin.Context) {
+	req := v1.StorePutReq{}
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		response.WriteResponse(ctx, errors.WithCode(code.ParamsError, err.Error()), nil)
+		return
+	}
+
+	if err := store.NewStore().PutIfExists(ctx, &req); err != nil {
+		writeUpdateError(ctx, err)
+		return
+	}
+	response.WriteResponse(ctx, nil, nil)
+}
+
+// GetAndSet
+//
+//	@Summary	put kv and get the former value of the key, null if it did not exist
+//	@Produce	json
+//	@Param		key		body		string						true	"键名"
+//	@Param		value	body		string						true	"键值"
+//	@Success	200		{object}	response.SuccessResponse	"成功"
+//	@Failure	400		{object}	response.ErrResponse		"失败"
+//	@Router		/v1/store/getset [post]
+func (c StoreController) GetAndSet(ctx *gin.Context) {
+	req := v1.StorePutReq{}
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		response.WriteResponse(ctx, errors.WithCode(code.ParamsError, err.Error()), nil)
+		return
+	}
+
+	former, err := store.NewStore().GetAndSet(ctx, &req)
+	if err != nil {
+		writeUpdateError(ctx, err)
+		return
+	}
+	response.WriteResponse(ctx, nil, former)
+}
+
+// MultiGet
+//
+//	@Summary	get the values of the keys, the results are in the order of the keys
+//	@Produce	json
+//	@Param		keys	body		[]string					true	"键名"
+//	@Success	200		{object}	response.SuccessResponse	"成功"
+//	@Failure	400		{object}	response.ErrResponse		"失败"
+//	@Router		/v1/store/mget [post]
+func (c StoreController) MultiGet(ctx *gin.Context) {
+	req := v1.MultiKeyReq{}
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		response.WriteResponse(ctx, errors.WithCode(code.ParamsError, err.Error()), nil)
+		return
+	}
+
+	results, err := store.NewStore().MultiGet(ctx, &req)
+	if err != nil {
+		response.WriteResponse(ctx, errors.WithCode(code.GenericServiceErrorCode, err.Error()), nil)
+		return
+	}
+	response.WriteResponse(ctx, nil, results)
+}
+
+// MultiPut
+//
+//	@Summary	put the kv pairs atomically, the results list the keys written
+//	@Produce	json
+//	@Param		pairs	body		[]object					true	"键值对, 包含 key 和 value"
+//	@Success	200		{object}	response.SuccessResponse	"成功"
+//	@Failure	400		{object}	response.ErrResponse		"失败"
+//	@Router		/v1/store/mset [post]
+func (c StoreController) MultiPut(ctx *gin.Context) {
+	req := v1.MultiPutReq{}
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		response.WriteResponse(ctx, errors.WithCode(code.ParamsError, err.Error()), nil)
+		return
+	}
+
+	results, err := store.NewStore().MultiPut(ctx, &req)
+	if err != nil {
+		response.WriteResponse(ctx, errors.WithCode(code.GenericServiceErrorCode, err.Error()), nil)
+		return
+	}
+	response.WriteResponse(ctx, nil, results)
+}
+
+// MultiDelete
+//
+//	@Summary	delete the keys atomically, the results list the keys deleted
+//	@Produce	json
+//	@Param		keys	body		[]string					true	"键名"
+//	@Success	200		{object}	response.SuccessResponse	"成功"
+//	@Failure	400		{object}	response.ErrResponse		"失败"
+//	@Router		/v1/store/mdel [post]
+func (c StoreController) MultiDelete(ctx *gin.Context) {
+	req := v1.MultiKeyReq{}
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		response.WriteResponse(ctx, errors.WithCode(code.ParamsError, err.Error()), nil)
+		return
+	}
+
+	results, err := store.NewStore().MultiDelete(ctx, &req)
+	if err != nil {
+		response.WriteResponse(ctx, errors.WithCode(code.GenericServiceErrorCode, err.Error()), nil)
+		return
+	}
+	response.WriteResponse(ctx, nil, results)
+}
+
+// Export
+//
+//	@Summary	export keys as a stream of ndjson or csv
+//	@Produce	application/x-ndjson,text/csv
+//	@Param		format			query		string					false	"导出格式 ndjson 或 csv"
+//	@Param		prefix			query		string					false	"键名前缀"
+//	@Param		start			query		string					false	"起始键名(包含)"
+//	@Param		end				query		string					false	"结束键名(不包含)"
+//	@Param		absolute_expiry	query		bool					false	"导出绝对过期时间"
+//	@Param		batch_id		query		bool					false	"导出批次ID"
+//	@Success	200				{string}	string					"导出数据"
+//	@Failure	400				{object}	response.ErrResponse	"失败"
+//	@Router		/v1/admin/export [get]
+func (c StoreController) Export(ctx *gin.Context) {
+	req := v1.ExportReq{}
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		response.WriteResponse(ctx, errors.WithCode(code.ParamsError, err.Error()), nil)
+		return
+	}
+
+	contentType, ext := "application/x-ndjson", "ndjson"
+	if req.Format == "csv" {
+		contentType, ext = "text/csv", "csv"
+	}
+	ctx.Header("Content-Type", contentType)
+	ctx.Header("Content-Disposition", fmt.Sprintf("attachment; filename=zoom-export.%s", ext))
+	ctx.Status(http.StatusOK)
+
+	// the records are streamed to the body, so an error after the first write
+	// can only be logged, and the body is truncated.
+	count, err := store.NewStore().Export(ctx, &req, ctx.Writer)
+	if err != nil {
+		if ctx.Writer.Written() {
+			zlog.Errorf("export failed after %d records: %v", count, err)
+			return
+		}
+		ctx.Writer.Header().Del("Content-Type")
+		ctx.Writer.Header().Del("Content-Disposition")
+		response.WriteResponse(ctx, errors.WithCode(code.GenericServiceErrorCode, err.Error()), nil)
+	}
+}
+
+// Import
+//
+//	@Summary	import keys from a stream of ndjson or csv
+//	@Accept		application/x-ndjson,text/csv
+//	@Produce	json
+//	@Param		format		query		string						false	"导入格式 ndjson 或 csv"
+//	@Param		prefix		query		string						false	"键名前缀"
+//	@Param		start		query		string						false	"起始键名(包含)"
+//	@Param		end			query		string						false	"结束键名(不包含)"
+//	@Param		batch_size	query		int							false	"每批写入的记录数"
+//	@Success	200			{object}	response.SuccessResponse	"成功, 返回导入的记录数"
+//	@Failure	400			{object}	response.ErrResponse		"失败"
+//	@Router		/v1/admin/import [post]
+func (c StoreController) Import(ctx *gin.Context) {
+	req := v1.ImportReq{}
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		response.WriteResponse(ctx, errors.WithCode(code.ParamsError, err.Error()), nil)
+		return
+	}
+
+	count, err := store.NewStore().Import(ctx, &req, ctx.Request.Body)
+	if err != nil {
+		response.WriteResponse(ctx, errors.WithCode(code.GenericServiceErrorCode,
+			fmt.Sprintf("imported %d records: %v", count, err)), nil)
+		return
+	}
+	response.WriteResponse(ctx, nil, count)
+}
@@ -15,12 +15,17 @@ import (
 type grpcAPIServer struct {
 	*grpc.Server
 	address string
+	// listener is the listener to serve, the server listens on the address if it is nil.
+	listener net.Listener
 }
 
 func (s *grpcAPIServer) Run() {
-	listen, err := net.Listen("tcp", s.address)
-	if err != nil {
-		log.Fatalf("failed to listen: %s", err.Error())
+	listen := s.listener
+	if listen == nil {
+		var err error
+		if listen, err = net.Listen("tcp", s.address); err != nil {
+			log.Fatalf("failed to listen: %s", err.Error())
+		}
 	}
 
 	go func() {
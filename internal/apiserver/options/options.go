@@ -4,20 +4,46 @@
 
 package options
 
-import "github.com/JoyZF/zoom/internal/pkg/options"
+import (
+	"fmt"
+
+	"github.com/JoyZF/zoom/internal/pkg/options"
+)
 
 // Options runs a api server.
 type Options struct {
-	ServerRunOptions *options.ServerRunOptions `mapstructure:"serverrunoptions"`
-	GRPCOptions      *options.GRPCOptions      `mapstructure:"grpcoptions"`
-	LogOptions       *options.LogOptions       `mapstructure:"logoptions"`
-	StoreOptions     *options.StoreOptions     `mapstructure:"storeoptions"`
+	ServerRunOptions   *options.ServerRunOptions   `mapstructure:"serverrunoptions"`
+	GRPCOptions        *options.GRPCOptions        `mapstructure:"grpcoptions"`
+	LogOptions         *options.LogOptions         `mapstructure:"logoptions"`
+	StoreOptions       *options.StoreOptions       `mapstructure:"storeoptions"`
+	ReplicationOptions *options.ReplicationOptions `mapstructure:"replicationoptions"`
+	ClusterOptions     *options.ClusterOptions     `mapstructure:"clusteroptions"`
+	ShardOptions       *options.ShardOptions       `mapstructure:"shardoptions"`
 }
 
 func NewOptions() *Options {
-	return &Options{}
+	return &Options{
+		ReplicationOptions: options.NewReplicationOptions(),
+		ClusterOptions:     options.NewClusterOptions(),
+		ShardOptions:       options.NewShardOptions(),
+	}
 }
 
 func (o *Options) Validate() []error {
-	return nil
+	var errs []error
+	errs = append(errs, o.ReplicationOptions.Validate()...)
+	errs = append(errs, o.ClusterOptions.Validate()...)
+	// the cluster replicates the writes by itself, a node of it can not follow another leader.
+	if o.ClusterOptions.Enabled && o.ReplicationOptions.Role == "follower" {
+		errs = append(errs, fmt.Errorf("--cluster.enabled can not be used by a replication follower"))
+	}
+	errs = append(errs, o.ShardOptions.Validate()...)
+	// a shard owns a part of the keys, so it can neither follow a leader nor be a raft replica.
+	if o.ShardOptions.Enabled && o.ClusterOptions.Enabled {
+		errs = append(errs, fmt.Errorf("--shard.enabled can not be used with --cluster.enabled"))
+	}
+	if o.ShardOptions.Enabled && o.ReplicationOptions.Role == "follower" {
+		errs = append(errs, fmt.Errorf("--shard.enabled can not be used by a replication follower"))
+	}
+	return errs
 }
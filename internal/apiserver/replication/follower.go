@@ -0,0 +1,387 @@
+// Copyright 2024 Joy <joyssss94@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package replication
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JoyZF/zlog"
+	"github.com/bwmarrin/snowflake"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+
+	"github.com/JoyZF/zoom/pkg/rosedb"
+)
+
+const (
+	// the position is saved at most once per interval while streaming,
+	// the batches applied again after a restart are idempotent.
+	savePositionInterval = time.Second
+	// number of keys deleted in a batch when the local data is cleared for a snapshot.
+	clearBatchSize = 1000
+	// suffix of the file the snapshot of the leader is received into, after the position file.
+	snapshotFileSuffix = ".snapshot"
+)
+
+// State is what a follower is doing.
+type State string
+
+const (
+	StateConnecting State = "connecting"
+	StateResyncing  State = "resyncing"
+	StateStreaming  State = "streaming"
+	StateStopped    State = "stopped"
+)
+
+// Status is the replication status of a server.
+type Status struct {
+	Role   Role   `json:"role"`
+	Leader string `json:"leader,omitempty"`
+	State  State  `json:"state,omitempty"`
+	// Position is where the follower continues streaming from the leader.
+	Position string `json:"position,omitempty"`
+	// LeaderHead is the position after the last batch committed on the leader, as last reported by it.
+	LeaderHead string `json:"leader_head,omitempty"`
+	// LagMillis is how long ago the last batch applied was committed on the leader,
+	// measured when the leader last sent a message, 0 if the follower had caught up.
+	LagMillis   int64     `json:"lag_ms"`
+	LastContact time.Time `json:"last_contact,omitempty"`
+	// Resyncs is the number of snapshots loaded because the follower fell too far behind.
+	Resyncs int    `json:"resyncs"`
+	Error   string `json:"error,omitempty"`
+}
+
+// FollowerOptions is the options of a follower.
+type FollowerOptions struct {
+	// Leader is the address of the grpc server of the leader.
+	Leader string
+	// PositionFile is where the position applied from the leader is saved.
+	// The follower loads a snapshot of the leader if the file does not exist,
+	// the snapshot is received into the file with the suffix ".snapshot" before it is loaded.
+	PositionFile string
+	// RetryInterval is the time waited before connecting again after the stream fails.
+	RetryInterval time.Duration
+	// MaxMsgSize is the maximum size of a message received from the leader, 0 means the grpc default.
+	MaxMsgSize int
+}
+
+// Follower streams the batches committed on the leader and applies them to its own db.
+type Follower struct {
+	db      *rosedb.DB
+	options FollowerOptions
+
+	mu       sync.Mutex
+	status   Status
+	position rosedb.ChangePosition
+	saved    time.Time
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewFollower returns a follower of the leader in the options, it is started by Start.
+func NewFollower(db *rosedb.DB, options FollowerOptions) *Follower {
+	return &Follower{
+		db:      db,
+		options: options,
+		status:  Status{Role: RoleFollower, Leader: options.Leader, State: StateStopped},
+	}
+}
+
+// Start follows the leader in the background until Stop is called.
+func (f *Follower) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	f.cancel, f.done = cancel, make(chan struct{})
+	go func() {
+		defer close(f.done)
+		f.run(ctx)
+	}()
+}
+
+// Stop stops following the leader, the position applied so far is saved.
+func (f *Follower) Stop() {
+	if f.cancel == nil {
+		return
+	}
+	f.cancel()
+	<-f.done
+	f.cancel = nil
+}
+
+// Status returns the replication status of the follower.
+func (f *Follower) Status() Status {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.status
+}
+
+func (f *Follower) run(ctx context.Context) {
+	defer f.setState(StateStopped, nil)
+
+	opts := []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	if f.options.MaxMsgSize > 0 {
+		opts = append(opts, grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(f.options.MaxMsgSize)))
+	}
+	conn, err := grpc.DialContext(ctx, f.options.Leader, opts...)
+	if err != nil {
+		zlog.Errorf("replication: dial leader %s: %v", f.options.Leader, err)
+		return
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	for {
+		err = f.follow(ctx, conn)
+		if ctx.Err() != nil {
+			return
+		}
+		if status.Code(err) == codes.OutOfRange {
+			// the leader no longer has the batches after the position, load a snapshot again.
+			zlog.Warnf("replication: fell behind the leader %s, resyncing: %v", f.options.Leader, err)
+			if err = f.removePosition(); err == nil {
+				continue
+			}
+		}
+		zlog.Errorf("replication: follow leader %s: %v", f.options.Leader, err)
+		f.setState(StateConnecting, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(f.options.RetryInterval):
+		}
+	}
+}
+
+// follow applies the batches streamed from the leader, after loading a snapshot if there is no position.
+func (f *Follower) follow(ctx context.Context, conn *grpc.ClientConn) error {
+	f.setState(StateConnecting, nil)
+	position, ok, err := f.loadPosition()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		if position, err = f.resync(ctx, conn); err != nil {
+			return err
+		}
+	}
+	defer func() {
+		_ = f.savePosition()
+	}()
+
+	stream, err := newClientStream(ctx, conn, &serviceDesc.Streams[0], &StreamRequest{Position: position.String()})
+	if err != nil {
+		return err
+	}
+	for {
+		resp := &StreamResponse{}
+		if err = stream.RecvMsg(resp); err != nil {
+			return err
+		}
+		if resp.Batch != nil {
+			if err = f.db.ApplyChanges(resp.Batch.Changes, false); err != nil {
+				return err
+			}
+		}
+		f.applied(resp)
+		if time.Since(f.saved) >= savePositionInterval {
+			if err = f.savePosition(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// applied records the message received from the leader in the status.
+func (f *Follower) applied(resp *StreamResponse) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if resp.Batch != nil {
+		f.position = resp.Batch.Position
+	}
+	f.status.State, f.status.Error = StateStreaming, ""
+	f.status.Position, f.status.LeaderHead = f.position.String(), resp.Head
+	f.status.LastContact = time.Now()
+	switch {
+	case f.status.Position == resp.Head:
+		f.status.LagMillis = 0
+	case resp.Batch != nil:
+		committed := snowflake.ID(resp.Batch.BatchId).Time()
+		f.status.LagMillis = time.Unix(0, resp.Time).UnixMilli() - committed
+	}
+}
+
+// resync replaces the local data with a snapshot of the leader,
+// and returns the position where the stream continues after it.
+// The snapshot is received into a file next to the position file first, so the local data
+// is only replaced once the whole snapshot has been received.
+func (f *Follower) resync(ctx context.Context, conn *grpc.ClientConn) (rosedb.ChangePosition, error) {
+	f.setState(StateResyncing, nil)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := newClientStream(ctx, conn, &serviceDesc.Streams[1], &SnapshotRequest{})
+	if err != nil {
+		return rosedb.ChangePosition{}, err
+	}
+	resp := &SnapshotResponse{}
+	if err = stream.RecvMsg(resp); err != nil {
+		return rosedb.ChangePosition{}, err
+	}
+	position, err := rosedb.ParseChangePosition(resp.Position)
+	if err != nil {
+		return rosedb.ChangePosition{}, err
+	}
+
+	snapshotFile := f.options.PositionFile + snapshotFileSuffix
+	file, err := os.Create(snapshotFile)
+	if err != nil {
+		return rosedb.ChangePosition{}, err
+	}
+	defer func() {
+		_ = file.Close()
+		_ = os.Remove(snapshotFile)
+	}()
+	if err = receiveSnapshot(stream, file); err != nil {
+		return rosedb.ChangePosition{}, err
+	}
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		return rosedb.ChangePosition{}, err
+	}
+
+	if err = clearDB(f.db); err != nil {
+		return rosedb.ChangePosition{}, err
+	}
+	options := rosedb.DefaultImportOptions
+	options.Sync = false
+	if _, err = f.db.Import(bufio.NewReader(file), options); err != nil {
+		return rosedb.ChangePosition{}, err
+	}
+
+	f.mu.Lock()
+	f.position = position
+	f.status.Resyncs++
+	f.mu.Unlock()
+	if err = f.savePosition(); err != nil {
+		return rosedb.ChangePosition{}, err
+	}
+	return position, nil
+}
+
+// receiveSnapshot writes the dump of the snapshot streamed from the leader to w, until the stream ends.
+func receiveSnapshot(stream grpc.ClientStream, w io.Writer) error {
+	for {
+		resp := &SnapshotResponse{}
+		if err := stream.RecvMsg(resp); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if _, err := w.Write(resp.Data); err != nil {
+			return err
+		}
+	}
+}
+
+// clearDB deletes all keys of the db.
+func clearDB(db *rosedb.DB) error {
+	for {
+		iter, err := db.NewIterator(rosedb.IteratorOptions{})
+		if err != nil {
+			return err
+		}
+		var keys [][]byte
+		for ; iter.Valid() && len(keys) < clearBatchSize; iter.Next() {
+			keys = append(keys, append([]byte(nil), iter.Key()...))
+		}
+		err = iter.Err()
+		iter.Close()
+		if err != nil || len(keys) == 0 {
+			return err
+		}
+
+		batch := db.NewBatch(rosedb.BatchOptions{Sync: false})
+		for _, key := range keys {
+			if err = batch.Delete(key); err != nil {
+				_ = batch.Rollback()
+				return err
+			}
+		}
+		if err = batch.Commit(); err != nil {
+			return err
+		}
+	}
+}
+
+func (f *Follower) setState(state State, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.status.State = state
+	if err != nil {
+		f.status.Error = err.Error()
+	}
+}
+
+// loadPosition reads the position saved in the position file, it reports false if there is none.
+func (f *Follower) loadPosition() (rosedb.ChangePosition, bool, error) {
+	data, err := os.ReadFile(f.options.PositionFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return rosedb.ChangePosition{}, false, nil
+		}
+		return rosedb.ChangePosition{}, false, err
+	}
+	position, err := rosedb.ParseChangePosition(strings.TrimSpace(string(data)))
+	if err != nil {
+		return rosedb.ChangePosition{}, false, err
+	}
+	f.mu.Lock()
+	f.position = position
+	f.status.Position = position.String()
+	f.mu.Unlock()
+	return position, true, nil
+}
+
+// savePosition syncs the db and then writes the position applied to the position file,
+// so the position never runs ahead of the data persisted.
+func (f *Follower) savePosition() error {
+	f.mu.Lock()
+	position := f.position
+	f.mu.Unlock()
+	if position.IsZero() {
+		return nil
+	}
+	if err := f.db.Sync(); err != nil {
+		return err
+	}
+	tempFile := f.options.PositionFile + ".tmp"
+	if err := os.WriteFile(tempFile, []byte(position.String()), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tempFile, f.options.PositionFile); err != nil {
+		return err
+	}
+	f.saved = time.Now()
+	return nil
+}
+
+// removePosition removes the position file, so the follower loads a snapshot of the leader.
+func (f *Follower) removePosition() error {
+	f.mu.Lock()
+	f.position = rosedb.ChangePosition{}
+	f.mu.Unlock()
+	if err := os.Remove(f.options.PositionFile); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+// Copyright 2024 Joy <joyssss94@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package replication
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/JoyZF/zoom/pkg/rosedb"
+)
+
+// snapshotChunkSize is the size of the dump sent in a SnapshotResponse.
+const snapshotChunkSize = 64 * 1024
+
+// Leader serves the batches committed to the db to the followers.
+// Every server registers it, so a follower promoted to leader can be followed at once.
+type Leader struct {
+	db        *rosedb.DB
+	heartbeat time.Duration
+}
+
+// RegisterLeader registers the replication service of the db to the grpc server.
+// An idle stream sends a heartbeat every heartbeat interval, so the followers can report their lag.
+func RegisterLeader(s *grpc.Server, db *rosedb.DB, heartbeat time.Duration) *Leader {
+	leader := &Leader{db: db, heartbeat: heartbeat}
+	s.RegisterService(&serviceDesc, leader)
+	return leader
+}
+
+// Stream sends the batches committed after the position in the request, until the follower disconnects.
+// It fails with codes.OutOfRange if the batches after the position are no longer in the WAL,
+// then the follower has to load a snapshot again.
+func (l *Leader) Stream(req *StreamRequest, stream grpc.ServerStream) error {
+	if req.Position == "" {
+		return status.Error(codes.OutOfRange, "a snapshot must be loaded before streaming")
+	}
+	position, err := rosedb.ParseChangePosition(req.Position)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	feed, err := l.db.NewChangeFeed("")
+	if err != nil {
+		return toStatus(err)
+	}
+	if err = feed.ResumeFrom(position); err != nil {
+		return toStatus(err)
+	}
+
+	for {
+		ctx, cancel := context.WithTimeout(stream.Context(), l.heartbeat)
+		batch, err := feed.Next(ctx)
+		cancel()
+		if err != nil {
+			if stream.Context().Err() != nil {
+				return stream.Context().Err()
+			}
+			if err != context.DeadlineExceeded {
+				return toStatus(err)
+			}
+		}
+		head, err := l.db.ChangeHead()
+		if err != nil {
+			return toStatus(err)
+		}
+		err = stream.SendMsg(&StreamResponse{Batch: batch, Head: head.String(), Time: time.Now().UnixNano()})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Snapshot sends the position of the stream after the snapshot, then the dump of the snapshot.
+func (l *Leader) Snapshot(_ *SnapshotRequest, stream grpc.ServerStream) error {
+	snapshot, position, err := l.db.ChangeSnapshot()
+	if err != nil {
+		return toStatus(err)
+	}
+	defer snapshot.Release()
+
+	if err = stream.SendMsg(&SnapshotResponse{Position: position.String()}); err != nil {
+		return err
+	}
+	w := bufio.NewWriterSize(snapshotWriter{stream}, snapshotChunkSize)
+	options := rosedb.DefaultExportOptions
+	options.AbsoluteExpiry = true
+	if _, err = snapshot.Export(w, options); err != nil {
+		return toStatus(err)
+	}
+	return w.Flush()
+}
+
+// snapshotWriter sends the data written to it in SnapshotResponse.
+type snapshotWriter struct {
+	stream grpc.ServerStream
+}
+
+func (w snapshotWriter) Write(p []byte) (int, error) {
+	if err := w.stream.SendMsg(&SnapshotResponse{Data: p}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func toStatus(err error) error {
+	switch {
+	case errors.Is(err, rosedb.ErrChangeFeedBehind):
+		return status.Error(codes.OutOfRange, err.Error())
+	case errors.Is(err, rosedb.ErrDBClosed):
+		return status.Error(codes.Unavailable, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2024 Joy <joyssss94@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package replication
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/JoyZF/zoom/pkg/rosedb"
+)
+
+// Role is the replication role of a server.
+type Role string
+
+const (
+	// RoleLeader accepts writes, and serves them to the followers.
+	RoleLeader Role = "leader"
+	// RoleFollower applies the writes of the leader, and only serves reads.
+	RoleFollower Role = "follower"
+)
+
+var ErrNotFollower = errors.New("the server is not a follower")
+
+// Node is the replication role of the server.
+type Node struct {
+	mu       sync.Mutex
+	role     Role
+	follower *Follower
+}
+
+var node *Node
+
+// Init creates the replication node of the server, which is returned by GetNode.
+// A follower starts following the leader in the options at once.
+func Init(db *rosedb.DB, role Role, options FollowerOptions) (*Node, error) {
+	n := &Node{role: role}
+	switch role {
+	case RoleLeader:
+	case RoleFollower:
+		if options.Leader == "" {
+			return nil, errors.New("the leader address of the follower is required")
+		}
+		n.follower = NewFollower(db, options)
+		n.follower.Start()
+	default:
+		return nil, fmt.Errorf("unknown replication role %q", role)
+	}
+	node = n
+	return n, nil
+}
+
+// GetNode returns the replication node of the server, nil if replication is not enabled.
+func GetNode() *Node {
+	return node
+}
+
+// ReadOnly reports whether the writes of the clients are rejected, which is the case for a follower.
+func (n *Node) ReadOnly() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.role == RoleFollower
+}
+
+// Status returns the replication status of the server.
+func (n *Node) Status() Status {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.role == RoleFollower {
+		return n.follower.Status()
+	}
+	return Status{Role: RoleLeader}
+}
+
+// Promote stops following the leader and makes the server a leader which accepts writes.
+// It is meant to be called by hand once the leader is gone, the other followers must then
+// be pointed to the new leader, and load a snapshot of it.
+func (n *Node) Promote() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.role != RoleFollower {
+		return ErrNotFollower
+	}
+	n.follower.Stop()
+	// the position is in the WAL of the old leader, it is meaningless for any other one.
+	if err := n.follower.removePosition(); err != nil {
+		return err
+	}
+	n.role, n.follower = RoleLeader, nil
+	return nil
+}
+
+// Close stops following the leader.
+func (n *Node) Close() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.follower != nil {
+		n.follower.Stop()
+	}
+}
@@ -0,0 +1,218 @@
+// Copyright 2024 Joy <joyssss94@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package replication
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/JoyZF/zoom/pkg/rosedb"
+	"github.com/JoyZF/zoom/utils"
+)
+
+func openTestDB(t *testing.T, name string) *rosedb.DB {
+	options := rosedb.DefaultOptions
+	options.DirPath = filepath.Join(t.TempDir(), name)
+	db, err := rosedb.Open(options)
+	assert.Nil(t, err)
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	return db
+}
+
+// startLeader serves the replication service of the db on a local port.
+func startLeader(t *testing.T, db *rosedb.DB) (*grpc.Server, string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	server := grpc.NewServer()
+	RegisterLeader(server, db, 20*time.Millisecond)
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	t.Cleanup(server.Stop)
+	return server, listener.Addr().String()
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met before the deadline")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// caughtUp reports whether the follower has applied all batches of the leader.
+func caughtUp(t *testing.T, leader *rosedb.DB, n *Node) func() bool {
+	return func() bool {
+		head, err := leader.ChangeHead()
+		assert.Nil(t, err)
+		status := n.Status()
+		return status.State == StateStreaming && status.Position == head.String()
+	}
+}
+
+func TestReplication(t *testing.T) {
+	leaderDB := openTestDB(t, "leader")
+	followerDB := openTestDB(t, "follower")
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, leaderDB.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+	// the follower has a key the leader does not have, it is removed by the snapshot.
+	assert.Nil(t, followerDB.Put([]byte("stale"), []byte("v")))
+	server, addr := startLeader(t, leaderDB)
+
+	positionFile := filepath.Join(t.TempDir(), "REPLICATION")
+	n, err := Init(followerDB, RoleFollower, FollowerOptions{
+		Leader:        addr,
+		PositionFile:  positionFile,
+		RetryInterval: 20 * time.Millisecond,
+	})
+	assert.Nil(t, err)
+	defer n.Close()
+	assert.Equal(t, n, GetNode())
+	assert.True(t, n.ReadOnly())
+
+	// the follower loads a snapshot, then applies the batches committed after it
+	waitFor(t, caughtUp(t, leaderDB, n))
+	assert.Equal(t, 1, n.Status().Resyncs)
+	_, err = followerDB.Get([]byte("stale"))
+	assert.Equal(t, rosedb.ErrKeyNotFound, err)
+	assert.Nil(t, leaderDB.Delete(utils.GetTestKey(0)))
+	assert.Nil(t, leaderDB.PutWithTTL([]byte("ttl"), []byte("v"), time.Hour))
+	waitFor(t, caughtUp(t, leaderDB, n))
+	assert.Zero(t, n.Status().LagMillis)
+	_, err = followerDB.Get(utils.GetTestKey(0))
+	assert.Equal(t, rosedb.ErrKeyNotFound, err)
+	for i := 1; i < 100; i++ {
+		expected, err := leaderDB.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		value, err := followerDB.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, expected, value)
+	}
+	ttl, err := followerDB.TTL([]byte("ttl"))
+	assert.Nil(t, err)
+	assert.True(t, ttl > 59*time.Minute)
+
+	// the position is saved when the follower stops, and it continues from it
+	n.Close()
+	_, err = os.Stat(positionFile)
+	assert.Nil(t, err)
+	assert.Nil(t, leaderDB.Put([]byte("after-restart"), []byte("v")))
+	n, err = Init(followerDB, RoleFollower, FollowerOptions{
+		Leader:        addr,
+		PositionFile:  positionFile,
+		RetryInterval: 20 * time.Millisecond,
+	})
+	assert.Nil(t, err)
+	defer n.Close()
+	waitFor(t, caughtUp(t, leaderDB, n))
+	assert.Equal(t, 0, n.Status().Resyncs)
+	value, err := followerDB.Get([]byte("after-restart"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v"), value)
+
+	// the leader dies, and the follower is promoted by hand
+	server.Stop()
+	waitFor(t, func() bool {
+		return n.Status().State == StateConnecting
+	})
+	assert.Nil(t, n.Promote())
+	assert.False(t, n.ReadOnly())
+	assert.Equal(t, RoleLeader, n.Status().Role)
+	assert.Equal(t, ErrNotFollower, n.Promote())
+	_, err = os.Stat(positionFile)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestReplication_Resync(t *testing.T) {
+	leaderDB := openTestDB(t, "leader")
+	followerDB := openTestDB(t, "follower")
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, leaderDB.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+	_, addr := startLeader(t, leaderDB)
+
+	// the segment of the position is no longer in the WAL of the leader
+	positionFile := filepath.Join(t.TempDir(), "REPLICATION")
+	assert.Nil(t, os.WriteFile(positionFile, []byte("100-0-0"), 0644))
+	n, err := Init(followerDB, RoleFollower, FollowerOptions{
+		Leader:        addr,
+		PositionFile:  positionFile,
+		RetryInterval: 20 * time.Millisecond,
+	})
+	assert.Nil(t, err)
+	defer n.Close()
+	waitFor(t, caughtUp(t, leaderDB, n))
+	assert.Equal(t, 1, n.Status().Resyncs)
+	assert.Equal(t, 10, followerDB.Stat().KeysNum)
+
+	_, err = Init(followerDB, RoleFollower, FollowerOptions{})
+	assert.NotNil(t, err)
+	_, err = Init(followerDB, Role("observer"), FollowerOptions{})
+	assert.NotNil(t, err)
+}
+
+// brokenSnapshotLeader fails the snapshot stream after sending a part of the dump.
+type brokenSnapshotLeader struct {
+	*Leader
+}
+
+func (l brokenSnapshotLeader) Snapshot(_ *SnapshotRequest, stream grpc.ServerStream) error {
+	if err := stream.SendMsg(&SnapshotResponse{Position: "1-0-0"}); err != nil {
+		return err
+	}
+	if err := stream.SendMsg(&SnapshotResponse{Data: []byte(`{"key":"`)}); err != nil {
+		return err
+	}
+	return status.Error(codes.Unavailable, "the leader is shutting down")
+}
+
+func TestReplication_ResyncFailure(t *testing.T) {
+	followerDB := openTestDB(t, "follower")
+	assert.Nil(t, followerDB.Put([]byte("local"), []byte("v")))
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	server := grpc.NewServer()
+	server.RegisterService(&serviceDesc, brokenSnapshotLeader{&Leader{heartbeat: 20 * time.Millisecond}})
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	defer server.Stop()
+
+	positionFile := filepath.Join(t.TempDir(), "REPLICATION")
+	n, err := Init(followerDB, RoleFollower, FollowerOptions{
+		Leader:        listener.Addr().String(),
+		PositionFile:  positionFile,
+		RetryInterval: 20 * time.Millisecond,
+	})
+	assert.Nil(t, err)
+	defer n.Close()
+	waitFor(t, func() bool {
+		status := n.Status()
+		return status.State == StateConnecting && status.Error != ""
+	})
+
+	// the local data is only replaced once the whole snapshot has been received
+	n.Close()
+	assert.Zero(t, n.Status().Resyncs)
+	value, err := followerDB.Get([]byte("local"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v"), value)
+	_, err = os.Stat(positionFile + snapshotFileSuffix)
+	assert.True(t, os.IsNotExist(err))
+}
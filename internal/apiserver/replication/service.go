@@ -0,0 +1,97 @@
+// Copyright 2024 Joy <joyssss94@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package replication
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+
+	"github.com/JoyZF/zoom/internal/pkg/codec"
+	"github.com/JoyZF/zoom/pkg/rosedb"
+)
+
+const serviceName = "zoom.replication.Replication"
+
+// StreamRequest starts streaming the batches committed on the leader after Position.
+type StreamRequest struct {
+	Position string `json:"position"`
+}
+
+// StreamResponse is a batch committed on the leader, or a heartbeat if Batch is nil.
+type StreamResponse struct {
+	Batch *rosedb.ChangeBatch `json:"batch,omitempty"`
+	// Head is the position after the last batch committed on the leader when the message was sent.
+	Head string `json:"head"`
+	// Time is the time of the leader when the message was sent, in unix nanoseconds.
+	Time int64 `json:"time"`
+}
+
+// SnapshotRequest asks for a snapshot of the leader.
+type SnapshotRequest struct{}
+
+// SnapshotResponse is a piece of the snapshot of the leader.
+// The first one only holds the position where the stream continues after the snapshot,
+// the next ones hold the NDJSON dump of the snapshot.
+type SnapshotResponse struct {
+	Position string `json:"position,omitempty"`
+	Data     []byte `json:"data,omitempty"`
+}
+
+// replicationServer is the server API of the replication service.
+type replicationServer interface {
+	Stream(req *StreamRequest, stream grpc.ServerStream) error
+	Snapshot(req *SnapshotRequest, stream grpc.ServerStream) error
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: serviceName,
+	HandlerType: (*replicationServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Stream",
+			Handler:       streamHandler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Snapshot",
+			Handler:       snapshotHandler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "replication",
+}
+
+func streamHandler(srv any, stream grpc.ServerStream) error {
+	req := &StreamRequest{}
+	if err := stream.RecvMsg(req); err != nil {
+		return err
+	}
+	return srv.(replicationServer).Stream(req, stream)
+}
+
+func snapshotHandler(srv any, stream grpc.ServerStream) error {
+	req := &SnapshotRequest{}
+	if err := stream.RecvMsg(req); err != nil {
+		return err
+	}
+	return srv.(replicationServer).Snapshot(req, stream)
+}
+
+// newClientStream calls the server streaming method of the replication service with the request.
+func newClientStream(ctx context.Context, conn *grpc.ClientConn, desc *grpc.StreamDesc, req any) (grpc.ClientStream, error) {
+	stream, err := conn.NewStream(ctx, desc, "/"+serviceName+"/"+desc.StreamName, grpc.CallContentSubtype(codec.JSON))
+	if err != nil {
+		return nil, err
+	}
+	if err = stream.SendMsg(req); err != nil {
+		return nil, err
+	}
+	if err = stream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return stream, nil
+}
@@ -6,6 +6,10 @@ package apiserver
 
 import (
 	_ "github.com/JoyZF/zoom/docs"
+	"github.com/JoyZF/zoom/internal/apiserver/controller/v1/cluster"
+	"github.com/JoyZF/zoom/internal/apiserver/controller/v1/ds"
+	"github.com/JoyZF/zoom/internal/apiserver/controller/v1/replication"
+	"github.com/JoyZF/zoom/internal/apiserver/controller/v1/shard"
 	"github.com/JoyZF/zoom/internal/apiserver/controller/v1/store"
 	"github.com/gin-gonic/gin"
 	swaggerFiles "github.com/swaggo/files"
@@ -37,15 +41,62 @@ func installController(g *gin.Engine) *gin.Engine {
 	v1 := g.Group("/v1")
 	{
 		sc := store.NewStoreController()
+		rc := replication.NewReplicationController()
+		// the writes are rejected by a follower
 		v1.GET("/store", sc.Get)
-		v1.PUT("/store", sc.Put)
-		v1.PUT("/store/ttl", sc.PutWithTTL)
-		v1.DELETE("/store", sc.Delete)
+		v1.PUT("/store", rc.RejectWrites, sc.Put)
+		v1.PUT("/store/ttl", rc.RejectWrites, sc.PutWithTTL)
+		v1.DELETE("/store", rc.RejectWrites, sc.Delete)
 		v1.GET("/store/ttl", sc.TTL)
 		v1.GET("/store/sync", sc.Sync)
 		v1.GET("/store/stat", sc.Stat)
 		v1.GET("/store/exist", sc.Exist)
-		v1.GET("/store/expire", sc.Expire)
+		v1.GET("/store/expire", rc.RejectWrites, sc.Expire)
+		v1.POST("/store/incr", rc.RejectWrites, sc.Incr)
+		v1.POST("/store/incrbyfloat", rc.RejectWrites, sc.IncrByFloat)
+		v1.POST("/store/cas", rc.RejectWrites, sc.CompareAndSwap)
+		v1.PUT("/store/ifabsent", rc.RejectWrites, sc.PutIfAbsent)
+		v1.PUT("/store/ifexists", rc.RejectWrites, sc.PutIfExists)
+		v1.POST("/store/getset", rc.RejectWrites, sc.GetAndSet)
+		v1.POST("/store/mget", sc.MultiGet)
+		v1.POST("/store/mset", rc.RejectWrites, sc.MultiPut)
+		v1.POST("/store/mdel", rc.RejectWrites, sc.MultiDelete)
+
+		// admin handlers, the bodies are streamed
+		admin := v1.Group("/admin")
+		admin.GET("/export", sc.Export)
+		admin.POST("/import", rc.RejectWrites, sc.Import)
+
+		v1.GET("/replication/status", rc.Status)
+		v1.POST("/replication/promote", rc.Promote)
+
+		cc := cluster.NewClusterController()
+		v1.GET("/cluster/status", cc.Status)
+		v1.POST("/cluster/join", cc.Join)
+		v1.POST("/cluster/leave", cc.Leave)
+
+		shc := shard.NewShardController()
+		v1.GET("/shard/status", shc.Status)
+		v1.GET("/shard/owner", shc.Owner)
+
+		// hash, list, set and sorted set of the standalone store
+		dc := ds.NewDSController()
+		v1.GET("/hash", dc.HGet)
+		v1.GET("/hash/all", dc.HGetAll)
+		v1.PUT("/hash", rc.RejectWrites, dc.HSet)
+		v1.DELETE("/hash", rc.RejectWrites, dc.HDel)
+		v1.POST("/list/lpush", rc.RejectWrites, dc.LPush)
+		v1.POST("/list/rpop", rc.RejectWrites, dc.RPop)
+		v1.GET("/list/range", dc.LRange)
+		v1.PUT("/set", rc.RejectWrites, dc.SAdd)
+		v1.GET("/set/ismember", dc.SIsMember)
+		v1.GET("/set/members", dc.SMembers)
+		v1.PUT("/zset", rc.RejectWrites, dc.ZAdd)
+		v1.GET("/zset/range", dc.ZRangeByScore)
+		v1.GET("/zset/rank", dc.ZRank)
+		v1.PUT("/ds/expire", rc.RejectWrites, dc.Expire)
+		v1.GET("/ds/ttl", dc.TTL)
+		v1.DELETE("/ds", rc.RejectWrites, dc.Delete)
 	}
 
 	return g
@@ -6,8 +6,14 @@ package apiserver
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+
 	"github.com/JoyZF/zlog"
+	"github.com/JoyZF/zoom/pkg/rosedb"
 	"github.com/JoyZF/zoom/pkg/store"
 	"github.com/marmotedu/iam/pkg/shutdown"
 	"github.com/marmotedu/iam/pkg/shutdown/shutdownmanagers/posixsignal"
@@ -15,7 +21,10 @@ import (
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/reflection"
 
+	"github.com/JoyZF/zoom/internal/apiserver/cluster"
 	"github.com/JoyZF/zoom/internal/apiserver/config"
+	"github.com/JoyZF/zoom/internal/apiserver/replication"
+	"github.com/JoyZF/zoom/internal/apiserver/shard"
 	"github.com/JoyZF/zoom/internal/pkg/options"
 	"github.com/JoyZF/zoom/internal/pkg/server"
 )
@@ -25,6 +34,9 @@ type apiServer struct {
 	redisOptions     *options.RedisOptions
 	gRPCAPIServer    *grpcAPIServer
 	genericAPIServer *server.GenericAPIServer
+	replicationNode  *replication.Node
+	clusterNode      *cluster.Node
+	shardNode        *shard.Node
 }
 
 // ExtraConfig defines extra configuration for the iam-apiserver.
@@ -63,11 +75,26 @@ func createAPIServer(cfg *config.Config) (*apiServer, error) {
 	if err != nil {
 		return nil, err
 	}
+	clusterNode, err := buildClusterNode(cfg, extraServer)
+	if err != nil {
+		return nil, err
+	}
+	shardNode, err := buildShardNode(cfg, extraServer)
+	if err != nil {
+		return nil, err
+	}
+	replicationNode, err := buildReplicationNode(cfg, extraServer)
+	if err != nil {
+		return nil, err
+	}
 
 	s := &apiServer{
 		gs:               gs,
 		genericAPIServer: genericServer,
 		gRPCAPIServer:    extraServer,
+		replicationNode:  replicationNode,
+		clusterNode:      clusterNode,
+		shardNode:        shardNode,
 	}
 
 	return s, nil
@@ -79,7 +106,16 @@ func (s *apiServer) PrepareRun() preparedAPIServer {
 	s.gs.AddShutdownCallback(shutdown.ShutdownFunc(func(string) error {
 		s.gRPCAPIServer.Close()
 		s.genericAPIServer.Close()
+		if s.replicationNode != nil {
+			s.replicationNode.Close()
+		}
+		if s.shardNode != nil {
+			s.shardNode.Close()
+		}
 		_ = store.GetStore().Sync()
+		if s.clusterNode != nil {
+			_ = s.clusterNode.Close()
+		}
 		return nil
 	}))
 
@@ -141,6 +177,141 @@ func buildExtraConfig(cfg *config.Config) (*ExtraConfig, error) {
 	}, nil
 }
 
+// buildReplicationNode registers the replication service of the store to the grpc server,
+// and starts following the leader if the server is a follower.
+func buildReplicationNode(cfg *config.Config, grpcServer *grpcAPIServer) (*replication.Node, error) {
+	opts := cfg.ReplicationOptions
+	if errs := opts.Validate(); len(errs) > 0 {
+		return nil, errs[0]
+	}
+	// the cluster replicates the writes through the raft log instead.
+	if cfg.ClusterOptions.Enabled {
+		if opts.Role == string(replication.RoleFollower) {
+			return nil, errors.New("a replication follower can not run in the cluster mode")
+		}
+		return nil, nil
+	}
+	// a shard only has a part of the keys.
+	if cfg.ShardOptions.Enabled {
+		if opts.Role == string(replication.RoleFollower) {
+			return nil, errors.New("a replication follower can not run in the sharded mode")
+		}
+		return nil, nil
+	}
+	roseDB, ok := store.GetStore().(*store.RoseDB)
+	if !ok {
+		if opts.Role == string(replication.RoleFollower) {
+			return nil, errors.New("replication requires the ROSEDB store driver")
+		}
+		return nil, nil
+	}
+
+	replication.RegisterLeader(grpcServer.Server, roseDB.DB, opts.HeartbeatInterval)
+	positionFile := opts.PositionFile
+	if positionFile == "" {
+		positionFile = filepath.Join(rosedb.DefaultOptions.DirPath, "REPLICATION")
+	}
+	return replication.Init(roseDB.DB, replication.Role(opts.Role), replication.FollowerOptions{
+		Leader:        opts.LeaderAddress,
+		PositionFile:  positionFile,
+		RetryInterval: opts.RetryInterval,
+		MaxMsgSize:    cfg.GRPCOptions.MaxMsgSize,
+	})
+}
+
+// buildClusterNode starts the raft node of the server in the cluster mode, and replaces the store
+// by the store of the cluster. Raft shares the grpc listener, so a node has one address in the cluster.
+func buildClusterNode(cfg *config.Config, grpcServer *grpcAPIServer) (*cluster.Node, error) {
+	opts := cfg.ClusterOptions
+	if !opts.Enabled {
+		return nil, nil
+	}
+	if errs := opts.Validate(); len(errs) > 0 {
+		return nil, errs[0]
+	}
+	roseDB, ok := store.GetStore().(*store.RoseDB)
+	if !ok {
+		return nil, errors.New("the cluster mode requires the ROSEDB store driver")
+	}
+
+	peers := make([]cluster.Peer, 0, len(opts.Peers))
+	for _, peer := range opts.Peers {
+		id, address, _ := strings.Cut(peer, "=")
+		peers = append(peers, cluster.Peer{ID: id, Address: address})
+	}
+	dataDir := opts.DataDir
+	if dataDir == "" {
+		dataDir = rosedb.DefaultOptions.DirPath + "-raft"
+	}
+	listener, err := net.Listen("tcp", grpcServer.address)
+	if err != nil {
+		return nil, err
+	}
+	mux := cluster.NewMux(listener)
+	node, err := cluster.Init(roseDB.DB, rosedb.DefaultOptions, mux, grpcServer.Server, cluster.Options{
+		NodeID:            opts.NodeID,
+		Advertise:         opts.Advertise,
+		DataDir:           dataDir,
+		Bootstrap:         opts.Bootstrap,
+		Peers:             peers,
+		SnapshotThreshold: opts.SnapshotThreshold,
+		SnapshotInterval:  opts.SnapshotInterval,
+		ApplyTimeout:      opts.ApplyTimeout,
+		MaxMsgSize:        cfg.GRPCOptions.MaxMsgSize,
+	})
+	if err != nil {
+		_ = mux.Close()
+		return nil, err
+	}
+	grpcServer.listener = mux.GRPCListener()
+	store.SetStore(cluster.NewStore(node))
+	return node, nil
+}
+
+// buildShardNode starts the shard node of the server in the sharded mode, and replaces the store
+// by the store of the shard, which forwards the requests to the owners of the keys.
+func buildShardNode(cfg *config.Config, grpcServer *grpcAPIServer) (*shard.Node, error) {
+	opts := cfg.ShardOptions
+	if !opts.Enabled {
+		return nil, nil
+	}
+	if errs := opts.Validate(); len(errs) > 0 {
+		return nil, errs[0]
+	}
+	if cfg.ClusterOptions.Enabled {
+		return nil, errors.New("the sharded mode can not be used with the cluster mode")
+	}
+	roseDB, ok := store.GetStore().(*store.RoseDB)
+	if !ok {
+		return nil, errors.New("the sharded mode requires the ROSEDB store driver")
+	}
+
+	membership := shard.Membership{VirtualNodes: opts.VirtualNodes}
+	for _, member := range opts.Members {
+		id, address, _ := strings.Cut(member, "=")
+		membership.Members = append(membership.Members, shard.Member{ID: id, Address: address})
+	}
+	stateFile := opts.StateFile
+	if stateFile == "" {
+		stateFile = filepath.Join(rosedb.DefaultOptions.DirPath, "SHARDS")
+	}
+	node, err := shard.Init(roseDB.DB, grpcServer.Server, shard.Options{
+		NodeID:            opts.NodeID,
+		Membership:        membership,
+		MembershipFile:    opts.MembershipFile,
+		ReloadInterval:    opts.ReloadInterval,
+		StateFile:         stateFile,
+		TransferBatchSize: opts.TransferBatchSize,
+		RequestTimeout:    opts.RequestTimeout,
+		MaxMsgSize:        cfg.GRPCOptions.MaxMsgSize,
+	})
+	if err != nil {
+		return nil, err
+	}
+	store.SetStore(shard.NewStore(node))
+	return node, nil
+}
+
 // Complete fills in any fields not set that are required to have valid data and can be derived from other fields.
 func (c *ExtraConfig) complete() *completedExtraConfig {
 	if c.Addr == "" {
@@ -157,5 +328,5 @@ func (c *completedExtraConfig) New() (*grpcAPIServer, error) {
 
 	reflection.Register(grpcServer)
 
-	return &grpcAPIServer{grpcServer, c.Addr}, nil
+	return &grpcAPIServer{Server: grpcServer, address: c.Addr}, nil
 }
@@ -0,0 +1,213 @@
+package ds
+
+import (
+	"context"
+	"errors"
+	"math"
+	"time"
+
+	v1 "github.com/JoyZF/zoom/internal/apiserver/types/v1"
+	"github.com/JoyZF/zoom/pkg/rosedb/ds"
+	"github.com/JoyZF/zoom/pkg/store"
+)
+
+var ErrNotStandalone = errors.New("the data structures require the standalone ROSEDB store")
+
+type DS struct {
+}
+
+func NewDS() DS {
+	return DS{}
+}
+
+// structures returns the data structures on the db of the standalone store,
+// they are not replicated by the cluster and sharded modes.
+func structures() (*ds.DS, error) {
+	roseDB, ok := store.GetStore().(*store.RoseDB)
+	if !ok {
+		return nil, ErrNotStandalone
+	}
+	return ds.New(roseDB.DB)
+}
+
+func (s DS) HSet(ctx context.Context, req *v1.HSetReq) (bool, error) {
+	d, err := structures()
+	if err != nil {
+		return false, err
+	}
+	return d.HSet([]byte(req.Key), []byte(req.Field), []byte(req.Value))
+}
+
+func (s DS) HGet(ctx context.Context, req *v1.HashFieldReq) (string, error) {
+	d, err := structures()
+	if err != nil {
+		return "", err
+	}
+	value, err := d.HGet([]byte(req.Key), []byte(req.Field))
+	if err != nil {
+		return "", err
+	}
+	return string(value), nil
+}
+
+func (s DS) HGetAll(ctx context.Context, req *v1.KeyReq) (map[string]string, error) {
+	d, err := structures()
+	if err != nil {
+		return nil, err
+	}
+	fields, err := d.HGetAll([]byte(req.Key))
+	if err != nil {
+		return nil, err
+	}
+	hash := make(map[string]string, len(fields))
+	for _, field := range fields {
+		hash[string(field.Field)] = string(field.Value)
+	}
+	return hash, nil
+}
+
+func (s DS) HDel(ctx context.Context, req *v1.HDelReq) (int, error) {
+	d, err := structures()
+	if err != nil {
+		return 0, err
+	}
+	return d.HDel([]byte(req.Key), toBytes(req.Fields)...)
+}
+
+func (s DS) LPush(ctx context.Context, req *v1.LPushReq) (int, error) {
+	d, err := structures()
+	if err != nil {
+		return 0, err
+	}
+	return d.LPush([]byte(req.Key), toBytes(req.Values)...)
+}
+
+func (s DS) RPop(ctx context.Context, req *v1.RPopReq) (string, error) {
+	d, err := structures()
+	if err != nil {
+		return "", err
+	}
+	value, err := d.RPop([]byte(req.Key))
+	if err != nil {
+		return "", err
+	}
+	return string(value), nil
+}
+
+func (s DS) LRange(ctx context.Context, req *v1.LRangeReq) ([]string, error) {
+	d, err := structures()
+	if err != nil {
+		return nil, err
+	}
+	values, err := d.LRange([]byte(req.Key), req.Start, req.Stop)
+	if err != nil {
+		return nil, err
+	}
+	return toStrings(values), nil
+}
+
+func (s DS) SAdd(ctx context.Context, req *v1.SAddReq) (int, error) {
+	d, err := structures()
+	if err != nil {
+		return 0, err
+	}
+	return d.SAdd([]byte(req.Key), toBytes(req.Members)...)
+}
+
+func (s DS) SIsMember(ctx context.Context, req *v1.SetMemberReq) (bool, error) {
+	d, err := structures()
+	if err != nil {
+		return false, err
+	}
+	return d.SIsMember([]byte(req.Key), []byte(req.Member))
+}
+
+func (s DS) SMembers(ctx context.Context, req *v1.KeyReq) ([]string, error) {
+	d, err := structures()
+	if err != nil {
+		return nil, err
+	}
+	members, err := d.SMembers([]byte(req.Key))
+	if err != nil {
+		return nil, err
+	}
+	return toStrings(members), nil
+}
+
+func (s DS) ZAdd(ctx context.Context, req *v1.ZAddReq) (bool, error) {
+	d, err := structures()
+	if err != nil {
+		return false, err
+	}
+	return d.ZAdd([]byte(req.Key), *req.Score, []byte(req.Member))
+}
+
+func (s DS) ZRangeByScore(ctx context.Context, req *v1.ZRangeByScoreReq) ([]v1.ZSetMember, error) {
+	d, err := structures()
+	if err != nil {
+		return nil, err
+	}
+	members, err := d.ZRangeByScore([]byte(req.Key), *req.Min, *req.Max)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]v1.ZSetMember, 0, len(members))
+	for _, member := range members {
+		// json can not encode the infinities
+		score := math.Max(math.Min(member.Score, math.MaxFloat64), -math.MaxFloat64)
+		result = append(result, v1.ZSetMember{Member: string(member.Member), Score: score})
+	}
+	return result, nil
+}
+
+func (s DS) ZRank(ctx context.Context, req *v1.SetMemberReq) (int, error) {
+	d, err := structures()
+	if err != nil {
+		return 0, err
+	}
+	return d.ZRank([]byte(req.Key), []byte(req.Member))
+}
+
+func (s DS) Expire(ctx context.Context, req *v1.ExpireReq) error {
+	d, err := structures()
+	if err != nil {
+		return err
+	}
+	return d.Expire([]byte(req.Key), time.Duration(req.TTL)*time.Second)
+}
+
+func (s DS) TTL(ctx context.Context, req *v1.KeyReq) (int64, error) {
+	d, err := structures()
+	if err != nil {
+		return 0, err
+	}
+	ttl, err := d.TTL([]byte(req.Key))
+	if err != nil {
+		return 0, err
+	}
+	return ttl.Milliseconds() / 1e3, nil
+}
+
+func (s DS) Delete(ctx context.Context, req *v1.KeyReq) error {
+	d, err := structures()
+	if err != nil {
+		return err
+	}
+	return d.Delete([]byte(req.Key))
+}
+
+func toBytes(values []string) [][]byte {
+	result := make([][]byte, len(values))
+	for i, value := range values {
+		result[i] = []byte(value)
+	}
+	return result
+}
+
+func toStrings(values [][]byte) []string {
+	result := make([]string, len(values))
+	for i, value := range values {
+		result[i] = string(value)
+	}
+	return result
+}
@@ -2,9 +2,12 @@ package store
 
 import (
 	"context"
+	"io"
+	"time"
+
 	v1 "github.com/JoyZF/zoom/internal/apiserver/types/v1"
+	"github.com/JoyZF/zoom/pkg/rosedb"
 	"github.com/JoyZF/zoom/pkg/store"
-	"time"
 )
 
 type Store struct {
@@ -59,3 +62,133 @@ func (s Store) Exist(ctx context.Context, key string) (bool, error) {
 func (s Store) Expire(ctx context.Context, req *v1.ExpireReq) error {
 	return store.GetStore().Expire([]byte(req.Key), time.Duration(req.TTL)*time.Second)
 }
+
+func (s Store) Incr(ctx context.Context, req *v1.IncrReq) (int64, error) {
+	if req.Delta == nil {
+		return store.GetStore().Incr([]byte(req.Key))
+	}
+	return store.GetStore().IncrBy([]byte(req.Key), *req.Delta)
+}
+
+func (s Store) IncrByFloat(ctx context.Context, req *v1.IncrByFloatReq) (float64, error) {
+	return store.GetStore().IncrByFloat([]byte(req.Key), *req.Delta)
+}
+
+func (s Store) CompareAndSwap(ctx context.Context, req *v1.CompareAndSwapReq) error {
+	return store.GetStore().CompareAndSwap([]byte(req.Key), []byte(req.Expected), []byte(req.Value))
+}
+
+func (s Store) PutIfAbsent(ctx context.Context, req *v1.StorePutReq) error {
+	return store.GetStore().PutIfAbsent([]byte(req.Key), []byte(req.Value))
+}
+
+func (s Store) PutIfExists(ctx context.Context, req *v1.StorePutReq) error {
+	return store.GetStore().PutIfExists([]byte(req.Key), []byte(req.Value))
+}
+
+// GetAndSet returns the former value of the key, nil if it did not exist.
+func (s Store) GetAndSet(ctx context.Context, req *v1.StorePutReq) (*string, error) {
+	former, err := store.GetStore().GetAndSet([]byte(req.Key), []byte(req.Value))
+	if err != nil || former == nil {
+		return nil, err
+	}
+	value := string(former)
+	return &value, nil
+}
+
+// MultiGet returns the result of each key, in the order of the keys.
+func (s Store) MultiGet(ctx context.Context, req *v1.MultiKeyReq) ([]v1.KeyResult, error) {
+	keys := toBytes(req.Keys)
+	values, err := store.GetStore().MultiGet(keys)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]v1.KeyResult, len(keys))
+	for i, value := range values {
+		results[i].Key = req.Keys[i]
+		if value != nil {
+			v := string(value)
+			results[i].Value = &v
+		}
+		exist := value != nil
+		results[i].Exist = &exist
+	}
+	return results, nil
+}
+
+// MultiPut writes the pairs atomically, and returns a result of each key written.
+// The results do not report whether the keys existed before, which is not read within the write.
+func (s Store) MultiPut(ctx context.Context, req *v1.MultiPutReq) ([]v1.KeyResult, error) {
+	keys := make([]string, 0, len(req.Pairs))
+	values := make([][]byte, 0, len(req.Pairs))
+	for _, pair := range req.Pairs {
+		keys = append(keys, pair.Key)
+		values = append(values, []byte(pair.Value))
+	}
+	if err := store.GetStore().MultiPut(toBytes(keys), values); err != nil {
+		return nil, err
+	}
+	return keyResults(keys), nil
+}
+
+// MultiDelete deletes the keys atomically, and returns a result of each key deleted.
+func (s Store) MultiDelete(ctx context.Context, req *v1.MultiKeyReq) ([]v1.KeyResult, error) {
+	if err := store.GetStore().MultiDelete(toBytes(req.Keys)); err != nil {
+		return nil, err
+	}
+	return keyResults(req.Keys), nil
+}
+
+// keyResults returns the results of the keys without their values.
+func keyResults(keys []string) []v1.KeyResult {
+	results := make([]v1.KeyResult, len(keys))
+	for i, key := range keys {
+		results[i].Key = key
+	}
+	return results
+}
+
+func (s Store) Export(ctx context.Context, req *v1.ExportReq, w io.Writer) (int, error) {
+	format, err := rosedb.ParseDumpFormat(req.Format)
+	if err != nil {
+		return 0, err
+	}
+	return store.GetStore().Export(w, rosedb.ExportOptions{
+		Format:         format,
+		Prefix:         optionalBytes(req.Prefix),
+		Start:          optionalBytes(req.Start),
+		End:            optionalBytes(req.End),
+		AbsoluteExpiry: req.AbsoluteExpiry,
+		WithBatchId:    req.WithBatchId,
+	})
+}
+
+func (s Store) Import(ctx context.Context, req *v1.ImportReq, r io.Reader) (int, error) {
+	format, err := rosedb.ParseDumpFormat(req.Format)
+	if err != nil {
+		return 0, err
+	}
+	options := rosedb.DefaultImportOptions
+	options.Format = format
+	options.Prefix, options.Start, options.End = optionalBytes(req.Prefix), optionalBytes(req.Start), optionalBytes(req.End)
+	if req.BatchSize > 0 {
+		options.BatchSize = req.BatchSize
+	}
+	return store.GetStore().Import(r, options)
+}
+
+// optionalBytes returns nil for an empty string, which means no bound.
+func optionalBytes(s string) []byte {
+	if s == "" {
+		return nil
+	}
+	return []byte(s)
+}
+
+func toBytes(keys []string) [][]byte {
+	b := make([][]byte, 0, len(keys))
+	for _, key := range keys {
+		b = append(b, []byte(key))
+	}
+	return b
+}
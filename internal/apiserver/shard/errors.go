@@ -0,0 +1,15 @@
+// Copyright 2024 Joy <joyssss94@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package shard
+
+import "errors"
+
+var (
+	ErrNoMembers          = errors.New("the membership has no members")
+	ErrUnknownOp          = errors.New("the operation of the request is unknown")
+	ErrNodeStopped        = errors.New("the shard node is stopped")
+	ErrMembershipMismatch = errors.New("the membership of the nodes is not the same")
+	ErrInvalidResponse    = errors.New("the response of the node does not match the request")
+)
@@ -0,0 +1,124 @@
+// Copyright 2024 Joy <joyssss94@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package shard
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// DefaultVirtualNodes is the number of virtual nodes of a member if it is not set.
+const DefaultVirtualNodes = 128
+
+// Membership is the members of the ring, configured statically or in a membership file.
+//
+// The membership file is a JSON document like
+//
+//	{"virtualNodes": 128, "members": [{"id": "a", "address": "10.0.0.1:8081"}]}
+//
+// and every node must be given the same membership, the nodes route the keys by their own ring.
+type Membership struct {
+	VirtualNodes int      `json:"virtualNodes"`
+	Members      []Member `json:"members"`
+}
+
+// LoadMembership reads the membership file.
+func LoadMembership(path string) (Membership, error) {
+	var membership Membership
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return membership, err
+	}
+	if err = json.Unmarshal(data, &membership); err != nil {
+		return membership, fmt.Errorf("invalid membership file %s: %w", path, err)
+	}
+	if membership.VirtualNodes == 0 {
+		membership.VirtualNodes = DefaultVirtualNodes
+	}
+	return membership, membership.Validate()
+}
+
+// Validate checks that the membership has members with unique ids and addresses.
+func (m Membership) Validate() error {
+	if len(m.Members) == 0 {
+		return ErrNoMembers
+	}
+	if m.VirtualNodes <= 0 {
+		return fmt.Errorf("the number of virtual nodes %d must be greater than 0", m.VirtualNodes)
+	}
+	ids := make(map[string]struct{}, len(m.Members))
+	addresses := make(map[string]struct{}, len(m.Members))
+	for _, member := range m.Members {
+		if member.ID == "" || member.Address == "" {
+			return fmt.Errorf("the member %+v must have an id and an address", member)
+		}
+		if _, ok := ids[member.ID]; ok {
+			return fmt.Errorf("the member id %q is duplicated", member.ID)
+		}
+		if _, ok := addresses[member.Address]; ok {
+			return fmt.Errorf("the member address %q is duplicated", member.Address)
+		}
+		ids[member.ID] = struct{}{}
+		addresses[member.Address] = struct{}{}
+	}
+	return nil
+}
+
+// Equal reports whether the memberships have the same members and virtual nodes.
+func (m Membership) Equal(other Membership) bool {
+	if m.VirtualNodes != other.VirtualNodes || len(m.Members) != len(other.Members) {
+		return false
+	}
+	members, others := m.sortedMembers(), other.sortedMembers()
+	for i := range members {
+		if members[i] != others[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (m Membership) sortedMembers() []Member {
+	members := append([]Member(nil), m.Members...)
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].ID < members[j].ID
+	})
+	return members
+}
+
+// saveMembership writes the membership to the file atomically.
+func saveMembership(path string, membership Membership) error {
+	data, err := json.Marshal(membership)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err = os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// loadState reads the membership saved by saveMembership, the second result is false if there is none.
+func loadState(path string) (Membership, bool, error) {
+	var membership Membership
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return membership, false, nil
+	}
+	if err != nil {
+		return membership, false, err
+	}
+	if err = json.Unmarshal(data, &membership); err != nil {
+		return membership, false, err
+	}
+	return membership, true, nil
+}
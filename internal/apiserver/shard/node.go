@@ -0,0 +1,726 @@
+// Copyright 2024 Joy <joyssss94@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package shard
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"github.com/JoyZF/zoom/internal/pkg/codec"
+	"github.com/JoyZF/zoom/pkg/rosedb"
+)
+
+// Options are the options of a shard node.
+type Options struct {
+	// NodeID is the id of the node in the membership.
+	NodeID string
+	// Membership is the static membership, it is used if MembershipFile is empty.
+	Membership Membership
+	// MembershipFile is the file of the membership, it is checked every ReloadInterval,
+	// and the keys are rebalanced when it changes.
+	MembershipFile string
+	// ReloadInterval is how often the membership file is checked, and a failed rebalance is retried.
+	ReloadInterval time.Duration
+	// StateFile is where the membership is saved once the keys are rebalanced,
+	// the keys are rebalanced on start if the membership is not the saved one.
+	StateFile string
+	// TransferBatchSize is the number of keys sent in a TransferRequest.
+	TransferBatchSize int
+	// RequestTimeout is how long a request forwarded to another node waits.
+	RequestTimeout time.Duration
+	// MaxMsgSize is the max size of the grpc messages sent to the other nodes.
+	MaxMsgSize int
+}
+
+// handoff is the keys moved to the node from their former owner after the ring changed.
+// Until the former owner is done, a key missing on the node is read from the former owner,
+// and the keys written on the node are not overwritten by the keys streamed from it.
+type handoff struct {
+	from    Member
+	done    atomic.Bool
+	mu      sync.Mutex          // serializes the writes of the keys moved from the former owner
+	touched map[string]struct{} // the keys written on the node during the handoff
+}
+
+// Node is a node of the hash-sharded keyspace, it serves the keys it owns from the db,
+// and forwards the requests for the other keys to their owners.
+type Node struct {
+	db      *rosedb.DB
+	options Options
+
+	mu         sync.RWMutex
+	membership Membership
+	ring       *Ring
+	previous   *Ring               // the ring before the last change, nil once the keys are handed off
+	incoming   map[string]*handoff // the pending handoffs by the id of the former owner
+	rebalance  RebalanceStatus
+
+	connMu sync.Mutex
+	conns  map[string]*grpc.ClientConn // the connections to the other nodes by address
+
+	reloadCh  chan struct{}
+	ctx       context.Context
+	cancel    context.CancelFunc
+	doneCh    chan struct{}
+	startOnce sync.Once
+	closeOnce sync.Once
+}
+
+// RebalanceStatus is the state of the rebalance of the keys of the node.
+type RebalanceStatus struct {
+	// State is pending, running, failed or done.
+	State string `json:"state"`
+	// Moved is the number of keys moved to other nodes by the last rebalance.
+	Moved    int       `json:"moved"`
+	Error    string    `json:"error,omitempty"`
+	Finished time.Time `json:"finished,omitempty"`
+}
+
+const (
+	rebalancePending = "pending"
+	rebalanceRunning = "running"
+	rebalanceFailed  = "failed"
+	rebalanceDone    = "done"
+)
+
+var node *Node
+
+// Init creates the shard node of the server, which is returned by GetNode, and starts it.
+func Init(db *rosedb.DB, grpcServer *grpc.Server, options Options) (*Node, error) {
+	n, err := NewNode(db, grpcServer, options)
+	if err != nil {
+		return nil, err
+	}
+	n.Start()
+	node = n
+	return n, nil
+}
+
+// GetNode returns the shard node of the server, nil if the sharding is not enabled.
+func GetNode() *Node {
+	return node
+}
+
+// NewNode creates a shard node on the db, and registers the shard service to the grpc server.
+// The membership saved in the state file is the ring the keys were rebalanced for,
+// if it is not the current membership, the keys moved between them are handed off once the node starts.
+// A node without a state file is a new member, which receives its keys from the other members.
+func NewNode(db *rosedb.DB, grpcServer *grpc.Server, options Options) (*Node, error) {
+	if options.NodeID == "" {
+		return nil, errors.New("the node id is required")
+	}
+	if options.TransferBatchSize <= 0 {
+		options.TransferBatchSize = rosedb.DefaultImportOptions.BatchSize
+	}
+	if options.ReloadInterval <= 0 {
+		options.ReloadInterval = 10 * time.Second
+	}
+	if options.RequestTimeout <= 0 {
+		options.RequestTimeout = 5 * time.Second
+	}
+	n := &Node{
+		db:       db,
+		options:  options,
+		conns:    make(map[string]*grpc.ClientConn),
+		reloadCh: make(chan struct{}, 1),
+		doneCh:   make(chan struct{}),
+	}
+	n.ctx, n.cancel = context.WithCancel(context.Background())
+
+	membership := options.Membership
+	if options.MembershipFile != "" {
+		var err error
+		if membership, err = LoadMembership(options.MembershipFile); err != nil {
+			return nil, err
+		}
+	}
+	if err := membership.Validate(); err != nil {
+		return nil, err
+	}
+	if options.StateFile != "" {
+		saved, ok, err := loadState(options.StateFile)
+		if err != nil {
+			return nil, err
+		}
+		if ok && saved.Validate() == nil {
+			n.membership, n.ring = saved, NewRing(saved)
+		}
+	}
+	if n.ring == nil {
+		// a new node joins a ring without it, so the keys it owns are handed off by the other members.
+		previous := Membership{VirtualNodes: membership.VirtualNodes}
+		for _, member := range membership.Members {
+			if member.ID != options.NodeID {
+				previous.Members = append(previous.Members, member)
+			}
+		}
+		if len(previous.Members) > 0 && len(previous.Members) < len(membership.Members) {
+			n.membership, n.ring = previous, NewRing(previous)
+		}
+	}
+	n.setMembership(membership)
+	grpcServer.RegisterService(&serviceDesc, &server{n: n})
+	return n, nil
+}
+
+// setMembership replaces the ring, and records the handoffs of the keys moved to the node.
+func (n *Node) setMembership(membership Membership) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	next := NewRing(membership)
+	n.previous, n.incoming = nil, nil
+	if n.ring != nil && !n.membership.Equal(membership) {
+		for _, member := range n.ring.Members() {
+			if member.ID == n.options.NodeID {
+				continue
+			}
+			for _, move := range n.ring.Moves(next, member.ID) {
+				if move.To.ID == n.options.NodeID {
+					if n.incoming == nil {
+						n.incoming = make(map[string]*handoff)
+					}
+					n.incoming[member.ID] = &handoff{from: member, touched: make(map[string]struct{})}
+					break
+				}
+			}
+		}
+		n.previous = n.ring
+	}
+	n.membership, n.ring = membership, next
+	n.rebalance = RebalanceStatus{State: rebalancePending}
+}
+
+// Start starts rebalancing the keys, and watching the membership file.
+func (n *Node) Start() {
+	n.startOnce.Do(func() {
+		go n.run()
+	})
+}
+
+// Reload checks the membership file at once.
+func (n *Node) Reload() {
+	select {
+	case n.reloadCh <- struct{}{}:
+	default:
+	}
+}
+
+func (n *Node) run() {
+	defer close(n.doneCh)
+	ticker := time.NewTicker(n.options.ReloadInterval)
+	defer ticker.Stop()
+	for {
+		if n.rebalanceStatus().State != rebalanceDone {
+			n.runRebalance()
+		}
+		select {
+		case <-n.ctx.Done():
+			return
+		case <-ticker.C:
+		case <-n.reloadCh:
+		}
+		n.reload()
+	}
+}
+
+// reload replaces the membership if the membership file has changed.
+func (n *Node) reload() {
+	if n.options.MembershipFile == "" {
+		return
+	}
+	membership, err := LoadMembership(n.options.MembershipFile)
+	if err != nil {
+		n.mu.Lock()
+		n.rebalance.Error = err.Error()
+		n.mu.Unlock()
+		return
+	}
+	n.mu.RLock()
+	changed := !n.membership.Equal(membership)
+	n.mu.RUnlock()
+	if changed {
+		n.setMembership(membership)
+	}
+}
+
+// Close stops the node.
+func (n *Node) Close() {
+	n.closeOnce.Do(func() {
+		n.cancel()
+		n.startOnce.Do(func() {
+			close(n.doneCh)
+		})
+		<-n.doneCh
+		n.connMu.Lock()
+		for address, conn := range n.conns {
+			_ = conn.Close()
+			delete(n.conns, address)
+		}
+		n.connMu.Unlock()
+	})
+}
+
+// Owner returns the member which owns the key.
+func (n *Node) Owner(key []byte) Member {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return n.ring.Owner(key)
+}
+
+// Status is the state of the shard node.
+type Status struct {
+	ID         string          `json:"id"`
+	Membership Membership      `json:"membership"`
+	Rebalance  RebalanceStatus `json:"rebalance"`
+	// Incoming is the former owners which have not handed off the keys moved to the node.
+	Incoming []string `json:"incoming"`
+}
+
+// Status returns the state of the node.
+func (n *Node) Status() Status {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	status := Status{ID: n.options.NodeID, Membership: n.membership, Rebalance: n.rebalance, Incoming: []string{}}
+	for id, h := range n.incoming {
+		if !h.done.Load() {
+			status.Incoming = append(status.Incoming, id)
+		}
+	}
+	sort.Strings(status.Incoming)
+	return status
+}
+
+func (n *Node) rebalanceStatus() RebalanceStatus {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return n.rebalance
+}
+
+// Get returns the value of the key from its owner.
+func (n *Node) Get(ctx context.Context, key []byte) ([]byte, error) {
+	change, err := n.read(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return change.Value, nil
+}
+
+// TTL returns the ttl of the key from its owner, -1 if the key has no ttl.
+func (n *Node) TTL(ctx context.Context, key []byte) (time.Duration, error) {
+	change, err := n.read(ctx, key)
+	if err != nil {
+		return -1, err
+	}
+	if change.Expire == 0 {
+		return -1, nil
+	}
+	return time.Until(time.Unix(0, change.Expire)), nil
+}
+
+// Exist reports whether the key exists on its owner.
+func (n *Node) Exist(ctx context.Context, key []byte) (bool, error) {
+	_, err := n.read(ctx, key)
+	if errors.Is(err, rosedb.ErrKeyNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// read returns the key from its owner, or rosedb.ErrKeyNotFound.
+func (n *Node) read(ctx context.Context, key []byte) (*rosedb.Change, error) {
+	resp, err := n.do(ctx, &Request{Op: OpRead, Key: key})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Change == nil {
+		return nil, rosedb.ErrKeyNotFound
+	}
+	return resp.Change, nil
+}
+
+// MultiGet returns the values of the keys from their owners, nil for the keys which are not found.
+// The keys owned by a node are read together, but the reads of different nodes are not a point-in-time view.
+func (n *Node) MultiGet(ctx context.Context, keys [][]byte) ([][]byte, error) {
+	for _, key := range keys {
+		if len(key) == 0 {
+			return nil, rosedb.ErrKeyIsEmpty
+		}
+	}
+	resp, err := n.do(ctx, &Request{Op: OpMultiRead, Keys: keys})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Values, nil
+}
+
+// Apply writes the puts and deletes on the owners of their keys.
+// The changes owned by a node are written in a batch, but the changes of different nodes are not atomic.
+func (n *Node) Apply(ctx context.Context, changes []*rosedb.Change) error {
+	_, err := n.do(ctx, &Request{Op: OpApply, Changes: changes})
+	return err
+}
+
+// Expire sets the expiration time of the key on its owner.
+func (n *Node) Expire(ctx context.Context, key []byte, expire time.Time) error {
+	_, err := n.do(ctx, &Request{Op: OpExpire, Key: key, Expire: expire.UnixNano()})
+	return err
+}
+
+// Update runs the read-modify-write operation of the request on the owner of its key,
+// and returns the Value of the response.
+func (n *Node) Update(ctx context.Context, req *Request) ([]byte, error) {
+	resp, err := n.do(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Value, nil
+}
+
+// do serves the request, or forwards it to the owner of its keys.
+func (n *Node) do(ctx context.Context, req *Request) (*Response, error) {
+	if n.ctx.Err() != nil {
+		return nil, ErrNodeStopped
+	}
+	switch req.Op {
+	case OpFetch:
+		change, err := n.fetch(req.Key)
+		return &Response{Change: change}, err
+	case OpApply:
+		return &Response{}, n.applyChanges(ctx, req.Changes, req.Hops)
+	case OpMultiRead:
+		values, err := n.multiRead(ctx, req.Keys, req.Hops)
+		return &Response{Values: values}, err
+	case OpRead, OpExpire, OpIncrBy, OpIncrByFloat, OpCompareAndSwap, OpPutIfAbsent, OpPutIfExists, OpGetAndSet:
+		if owner := n.Owner(req.Key); owner.ID != n.options.NodeID && req.Hops < maxHops {
+			forwarded := *req
+			forwarded.Hops++
+			return n.call(ctx, owner, &forwarded)
+		}
+		switch req.Op {
+		case OpRead:
+			change, err := n.readLocal(ctx, req.Key)
+			return &Response{Change: change}, err
+		case OpExpire:
+			return &Response{}, n.expireLocal(ctx, req.Key, req.Expire)
+		}
+		value, err := n.updateLocal(ctx, req)
+		return &Response{Value: value}, err
+	default:
+		return nil, ErrUnknownOp
+	}
+}
+
+// applyChanges writes the changes owned by the node, and forwards the others to their owners.
+func (n *Node) applyChanges(ctx context.Context, changes []*rosedb.Change, hops int) error {
+	var local []*rosedb.Change
+	remote := make(map[string][]*rosedb.Change)
+	members := make(map[string]Member)
+	for _, change := range changes {
+		owner := n.Owner(change.Key)
+		if owner.ID == n.options.NodeID || hops >= maxHops {
+			local = append(local, change)
+			continue
+		}
+		remote[owner.ID] = append(remote[owner.ID], change)
+		members[owner.ID] = owner
+	}
+	if len(local) > 0 {
+		keys := make([][]byte, 0, len(local))
+		for _, change := range local {
+			keys = append(keys, change.Key)
+		}
+		err := n.writeLocal(ctx, keys, false, func() error {
+			return n.db.ApplyChanges(local, false)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for id, changes := range remote {
+		if _, err := n.call(ctx, members[id], &Request{Op: OpApply, Changes: changes, Hops: hops + 1}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// multiRead reads the keys owned by the node, and forwards the others to their owners.
+// The values are in the order of the keys.
+func (n *Node) multiRead(ctx context.Context, keys [][]byte, hops int) ([][]byte, error) {
+	values := make([][]byte, len(keys))
+	var local []int
+	remote := make(map[string][]int)
+	members := make(map[string]Member)
+	for i, key := range keys {
+		owner := n.Owner(key)
+		if owner.ID == n.options.NodeID || hops >= maxHops {
+			local = append(local, i)
+			continue
+		}
+		remote[owner.ID] = append(remote[owner.ID], i)
+		members[owner.ID] = owner
+	}
+	if len(local) > 0 {
+		localValues, err := n.db.MultiGet(pick(keys, local))
+		if err != nil {
+			return nil, err
+		}
+		for j, i := range local {
+			value := localValues[j]
+			if value == nil && n.pendingHandoff(keys[i]) != nil {
+				// the key may not be handed off yet.
+				change, err := n.readLocal(ctx, keys[i])
+				if err != nil {
+					return nil, err
+				}
+				if change != nil {
+					value = append([]byte{}, change.Value...)
+				}
+			}
+			values[i] = value
+		}
+	}
+	for id, indexes := range remote {
+		resp, err := n.call(ctx, members[id], &Request{Op: OpMultiRead, Keys: pick(keys, indexes), Hops: hops + 1})
+		if err != nil {
+			return nil, err
+		}
+		if len(resp.Values) != len(indexes) {
+			return nil, ErrInvalidResponse
+		}
+		for j, i := range indexes {
+			values[i] = resp.Values[j]
+		}
+	}
+	return values, nil
+}
+
+// pick returns the keys at the indexes.
+func pick(keys [][]byte, indexes []int) [][]byte {
+	picked := make([][]byte, 0, len(indexes))
+	for _, i := range indexes {
+		picked = append(picked, keys[i])
+	}
+	return picked
+}
+
+// fetch reads the key from the db, the change is nil if the key is not found.
+func (n *Node) fetch(key []byte) (*rosedb.Change, error) {
+	batch := n.db.NewBatch(rosedb.BatchOptions{ReadOnly: true})
+	defer func() {
+		_ = batch.Commit()
+	}()
+	value, err := batch.Get(key)
+	if errors.Is(err, rosedb.ErrKeyNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	ttl, err := batch.TTL(key)
+	if errors.Is(err, rosedb.ErrKeyNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	change := &rosedb.Change{Action: rosedb.WatchActionPut, Key: key, Value: value}
+	if ttl >= 0 {
+		change.Expire = time.Now().Add(ttl).UnixNano()
+	}
+	return change, nil
+}
+
+// pendingHandoff returns the handoff of the key, nil if the key is not being moved to the node.
+func (n *Node) pendingHandoff(key []byte) *handoff {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	if n.previous == nil {
+		return nil
+	}
+	h := n.incoming[n.previous.Owner(key).ID]
+	if h == nil || h.done.Load() {
+		return nil
+	}
+	return h
+}
+
+// readLocal reads the key owned by the node, from its former owner if the key has not been handed off yet.
+func (n *Node) readLocal(ctx context.Context, key []byte) (*rosedb.Change, error) {
+	change, err := n.fetch(key)
+	if change != nil || err != nil {
+		return change, err
+	}
+	h := n.pendingHandoff(key)
+	if h == nil {
+		return nil, nil
+	}
+	h.mu.Lock()
+	_, touched := h.touched[string(key)]
+	h.mu.Unlock()
+	if touched {
+		return nil, nil
+	}
+	// the former owner is asked first, then the key is read again, as the former owner
+	// deletes the key only after it is handed off.
+	resp, err := n.call(ctx, h.from, &Request{Op: OpFetch, Key: key})
+	if err == nil && resp.Change != nil {
+		return resp.Change, nil
+	}
+	return n.fetch(key)
+}
+
+// writeLocal calls write, which writes the keys owned by the node. The keys being handed off are marked as written,
+// so the handoff does not overwrite them. If pull is set, the keys which are not handed off yet are read
+// from their former owner first, for the writes which update a key instead of replacing it.
+func (n *Node) writeLocal(ctx context.Context, keys [][]byte, pull bool, write func() error) error {
+	handoffs := make(map[*handoff][][]byte)
+	for _, key := range keys {
+		if h := n.pendingHandoff(key); h != nil {
+			handoffs[h] = append(handoffs[h], key)
+		}
+	}
+	if len(handoffs) == 0 {
+		return write()
+	}
+
+	// the handoffs are locked in the order of the former owners, so the concurrent writes do not deadlock.
+	locked := make([]*handoff, 0, len(handoffs))
+	for h := range handoffs {
+		locked = append(locked, h)
+	}
+	sort.Slice(locked, func(i, j int) bool {
+		return locked[i].from.ID < locked[j].from.ID
+	})
+	for _, h := range locked {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+	}
+
+	for h, keys := range handoffs {
+		for _, key := range keys {
+			if _, ok := h.touched[string(key)]; ok || h.done.Load() {
+				continue
+			}
+			if pull {
+				if err := n.pull(ctx, h, key); err != nil {
+					return err
+				}
+			}
+			h.touched[string(key)] = struct{}{}
+		}
+	}
+	return write()
+}
+
+// pull copies the key from its former owner, if it is not on the node yet.
+func (n *Node) pull(ctx context.Context, h *handoff, key []byte) error {
+	change, err := n.fetch(key)
+	if change != nil || err != nil {
+		return err
+	}
+	resp, err := n.call(ctx, h.from, &Request{Op: OpFetch, Key: key})
+	if err != nil || resp.Change == nil {
+		return err
+	}
+	return n.db.ApplyChanges([]*rosedb.Change{resp.Change}, false)
+}
+
+func (n *Node) expireLocal(ctx context.Context, key []byte, expire int64) error {
+	return n.writeLocal(ctx, [][]byte{key}, true, func() error {
+		// an expiration time in the past expires the key at once.
+		return n.db.Expire(key, time.Until(time.Unix(0, expire)))
+	})
+}
+
+// updateLocal runs the read-modify-write operation of the request on the db,
+// the key is read from its former owner first if it is not handed off yet.
+func (n *Node) updateLocal(ctx context.Context, req *Request) ([]byte, error) {
+	var result []byte
+	err := n.writeLocal(ctx, [][]byte{req.Key}, true, func() error {
+		var err error
+		switch req.Op {
+		case OpIncrBy:
+			var value int64
+			value, err = n.db.IncrBy(req.Key, req.Delta)
+			result = strconv.AppendInt(nil, value, 10)
+		case OpIncrByFloat:
+			var value float64
+			value, err = n.db.IncrByFloat(req.Key, req.FloatDelta)
+			result = strconv.AppendFloat(nil, value, 'f', -1, 64)
+		case OpCompareAndSwap:
+			err = n.db.CompareAndSwap(req.Key, req.Expected, req.Value)
+		case OpPutIfAbsent:
+			err = n.db.PutIfAbsent(req.Key, req.Value)
+		case OpPutIfExists:
+			err = n.db.PutIfExists(req.Key, req.Value)
+		case OpGetAndSet:
+			result, err = n.db.GetAndSet(req.Key, req.Value)
+		default:
+			err = ErrUnknownOp
+		}
+		return err
+	})
+	return result, err
+}
+
+// call sends the request to the member.
+func (n *Node) call(ctx context.Context, member Member, req *Request) (*Response, error) {
+	conn, err := n.conn(member.Address)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, n.options.RequestTimeout)
+	defer cancel()
+	resp := &Response{}
+	err = conn.Invoke(ctx, "/"+serviceName+"/Do", req, resp, grpc.CallContentSubtype(codec.JSON))
+	if err != nil {
+		return nil, fromStatus(err)
+	}
+	return resp, nil
+}
+
+func (n *Node) conn(address string) (*grpc.ClientConn, error) {
+	n.connMu.Lock()
+	defer n.connMu.Unlock()
+	if conn, ok := n.conns[address]; ok {
+		return conn, nil
+	}
+	opts := []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	if n.options.MaxMsgSize > 0 {
+		opts = append(opts, grpc.WithDefaultCallOptions(grpc.MaxCallSendMsgSize(n.options.MaxMsgSize)))
+	}
+	conn, err := grpc.Dial(address, opts...)
+	if err != nil {
+		return nil, err
+	}
+	n.conns[address] = conn
+	return conn, nil
+}
+
+// server serves the shard service of the node to the other nodes.
+type server struct {
+	n *Node
+}
+
+func (s *server) Do(ctx context.Context, req *Request) (*Response, error) {
+	resp, err := s.n.do(ctx, req)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return resp, nil
+}
+
+func (s *server) Transfer(stream grpc.ServerStream) error {
+	return toStatus(s.n.receive(stream))
+}
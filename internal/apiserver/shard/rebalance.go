@@ -0,0 +1,291 @@
+// Copyright 2024 Joy <joyssss94@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package shard
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/JoyZF/zlog"
+	"google.golang.org/grpc"
+
+	"github.com/JoyZF/zoom/internal/pkg/codec"
+	"github.com/JoyZF/zoom/pkg/rosedb"
+)
+
+// runRebalance moves the keys the node does not own anymore to their owners, and records the result.
+func (n *Node) runRebalance() {
+	n.mu.Lock()
+	n.rebalance = RebalanceStatus{State: rebalanceRunning}
+	membership, ring := n.membership, n.ring
+	n.mu.Unlock()
+
+	moved, err := n.transfer(n.ctx, membership, ring)
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.ring != ring {
+		// the membership changed during the rebalance, it is run again for the new ring.
+		return
+	}
+	n.rebalance = RebalanceStatus{State: rebalanceDone, Moved: moved, Finished: time.Now()}
+	if err != nil {
+		n.rebalance.State, n.rebalance.Error = rebalanceFailed, err.Error()
+		zlog.Errorf("shard: rebalance the keys of node %s: %v", n.options.NodeID, err)
+		return
+	}
+	n.finishHandoff()
+}
+
+// finishHandoff forgets the previous ring and saves the membership, once the node has moved its keys
+// and received all the keys moved to it. It must be called with n.mu held.
+func (n *Node) finishHandoff() {
+	if n.rebalance.State != rebalanceDone {
+		return
+	}
+	for _, h := range n.incoming {
+		if !h.done.Load() {
+			return
+		}
+	}
+	n.previous, n.incoming = nil, nil
+	if n.options.StateFile == "" {
+		return
+	}
+	if err := saveMembership(n.options.StateFile, n.membership); err != nil {
+		zlog.Errorf("shard: save the state file %s: %v", n.options.StateFile, err)
+	}
+}
+
+// transfer streams the keys which are not owned by the node on the ring to their owners, then deletes them.
+// Every other member is sent a Done, even if no key is moved to it, as it may expect a handoff from the node.
+func (n *Node) transfer(ctx context.Context, membership Membership, ring *Ring) (int, error) {
+	streams := make(map[string]*transferStream)
+	defer func() {
+		for _, stream := range streams {
+			stream.cancel()
+		}
+	}()
+	open := func(member Member) (*transferStream, error) {
+		if stream, ok := streams[member.ID]; ok {
+			return stream, nil
+		}
+		stream, err := n.openTransfer(ctx, member, membership)
+		if err != nil {
+			return nil, err
+		}
+		streams[member.ID] = stream
+		return stream, nil
+	}
+	for _, member := range ring.Members() {
+		if member.ID == n.options.NodeID {
+			continue
+		}
+		if _, err := open(member); err != nil {
+			return 0, err
+		}
+	}
+
+	iter, err := n.db.NewIterator(rosedb.IteratorOptions{})
+	if err != nil {
+		return 0, err
+	}
+	defer iter.Close()
+	var moved [][]byte
+	for ; iter.Valid(); iter.Next() {
+		if err = ctx.Err(); err != nil {
+			return 0, err
+		}
+		owner := ring.Owner(iter.Key())
+		if owner.ID == n.options.NodeID {
+			continue
+		}
+		stream, err := open(owner)
+		if err != nil {
+			return 0, err
+		}
+		key := append([]byte(nil), iter.Key()...)
+		value := append([]byte(nil), iter.Value()...)
+		change := &rosedb.Change{Action: rosedb.WatchActionPut, Key: key, Value: value, Expire: iter.Expire()}
+		if err = stream.add(change, n.options.TransferBatchSize); err != nil {
+			return 0, err
+		}
+		moved = append(moved, key)
+	}
+	if err = iter.Err(); err != nil {
+		return 0, err
+	}
+	for _, stream := range streams {
+		if err = stream.finish(); err != nil {
+			return 0, err
+		}
+	}
+
+	// the keys are deleted once their new owners have all of them.
+	count := len(moved)
+	for len(moved) > 0 {
+		size := n.options.TransferBatchSize
+		if size > len(moved) {
+			size = len(moved)
+		}
+		batch := n.db.NewBatch(rosedb.DefaultBatchOptions)
+		for _, key := range moved[:size] {
+			if err = batch.Delete(key); err != nil {
+				_ = batch.Rollback()
+				return 0, err
+			}
+		}
+		if err = batch.Commit(); err != nil {
+			return 0, err
+		}
+		moved = moved[size:]
+	}
+	return count, nil
+}
+
+// transferStream is a Transfer stream to the new owner of some keys of the node.
+type transferStream struct {
+	from       string
+	membership Membership
+	stream     grpc.ClientStream
+	cancel     context.CancelFunc
+	pending    []*rosedb.Change
+	count      int
+}
+
+func (n *Node) openTransfer(ctx context.Context, member Member, membership Membership) (*transferStream, error) {
+	conn, err := n.conn(member.Address)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	stream, err := conn.NewStream(ctx, &serviceDesc.Streams[0], "/"+serviceName+"/Transfer", grpc.CallContentSubtype(codec.JSON))
+	if err != nil {
+		cancel()
+		return nil, fromStatus(err)
+	}
+	return &transferStream{from: n.options.NodeID, membership: membership, stream: stream, cancel: cancel}, nil
+}
+
+// add sends the pending changes once there are size of them.
+func (s *transferStream) add(change *rosedb.Change, size int) error {
+	s.pending = append(s.pending, change)
+	if len(s.pending) < size {
+		return nil
+	}
+	return s.send(false)
+}
+
+func (s *transferStream) send(done bool) error {
+	if err := s.stream.SendMsg(&TransferRequest{From: s.from, Membership: s.membership, Changes: s.pending, Done: done}); err != nil {
+		// the error of the stream is returned by RecvMsg.
+		if errors.Is(err, io.EOF) {
+			return fromStatus(s.stream.RecvMsg(&TransferResponse{}))
+		}
+		return err
+	}
+	s.count += len(s.pending)
+	s.pending = nil
+	return nil
+}
+
+// finish sends the remaining changes with Done, and waits for the receiver to apply them.
+func (s *transferStream) finish() error {
+	if err := s.send(true); err != nil {
+		return err
+	}
+	if err := s.stream.CloseSend(); err != nil {
+		return err
+	}
+	resp := &TransferResponse{}
+	if err := s.stream.RecvMsg(resp); err != nil {
+		return fromStatus(err)
+	}
+	if resp.Count != s.count {
+		return errors.New("the receiver has not applied all the keys transferred")
+	}
+	return nil
+}
+
+// receive applies the keys streamed by their former owner.
+func (n *Node) receive(stream grpc.ServerStream) error {
+	count := 0
+	for {
+		req := &TransferRequest{}
+		err := stream.RecvMsg(req)
+		if errors.Is(err, io.EOF) {
+			return stream.SendMsg(&TransferResponse{Count: count})
+		}
+		if err != nil {
+			return err
+		}
+		if err = n.applyTransfer(req); err != nil {
+			return err
+		}
+		count += len(req.Changes)
+	}
+}
+
+// applyTransfer applies a batch of keys streamed by their former owner.
+// The keys written on the node during the handoff are newer, so they are kept.
+func (n *Node) applyTransfer(req *TransferRequest) error {
+	n.mu.RLock()
+	h, same := n.incoming[req.From], n.membership.Equal(req.Membership)
+	n.mu.RUnlock()
+	if !same {
+		// the sender retries once the node has loaded the same membership.
+		n.Reload()
+		return ErrMembershipMismatch
+	}
+	if h == nil {
+		// an unexpected handoff, e.g. retried after the node forgot it, never overwrites the keys.
+		return n.applyAbsent(req.Changes)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.done.Load() {
+		return n.applyAbsent(req.Changes)
+	}
+	changes := make([]*rosedb.Change, 0, len(req.Changes))
+	for _, change := range req.Changes {
+		if _, ok := h.touched[string(change.Key)]; !ok {
+			changes = append(changes, change)
+		}
+	}
+	if len(changes) > 0 {
+		if err := n.db.ApplyChanges(changes, false); err != nil {
+			return err
+		}
+	}
+	if req.Done {
+		h.done.Store(true)
+		h.touched = nil
+		n.mu.Lock()
+		n.finishHandoff()
+		n.mu.Unlock()
+	}
+	return nil
+}
+
+// applyAbsent applies the changes of the keys which are not in the db.
+func (n *Node) applyAbsent(changes []*rosedb.Change) error {
+	absent := make([]*rosedb.Change, 0, len(changes))
+	for _, change := range changes {
+		ok, err := n.db.Exist(change.Key)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			absent = append(absent, change)
+		}
+	}
+	if len(absent) == 0 {
+		return nil
+	}
+	return n.db.ApplyChanges(absent, false)
+}
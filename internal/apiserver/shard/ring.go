@@ -0,0 +1,147 @@
+// Copyright 2024 Joy <joyssss94@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package shard
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+// Member is a node of the ring.
+type Member struct {
+	ID      string `json:"id"`
+	Address string `json:"address"` // the grpc address of the node
+}
+
+// point is a virtual node of a member on the ring.
+type point struct {
+	hash   uint64
+	member int // index of the member
+}
+
+// Ring is a consistent-hash ring, each member has a number of virtual nodes on it,
+// and a key is owned by the member of the first virtual node at or after the hash of the key.
+// Adding or removing a member only moves the keys between the virtual nodes of that member and their neighbours.
+type Ring struct {
+	members []Member
+	points  []point
+}
+
+// NewRing creates the ring of the membership.
+func NewRing(membership Membership) *Ring {
+	r := &Ring{members: membership.sortedMembers()}
+	for i, member := range r.members {
+		for v := 0; v < membership.VirtualNodes; v++ {
+			r.points = append(r.points, point{hash: hashString(member.ID + "#" + strconv.Itoa(v)), member: i})
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool {
+		if r.points[i].hash == r.points[j].hash {
+			return r.points[i].member < r.points[j].member
+		}
+		return r.points[i].hash < r.points[j].hash
+	})
+	return r
+}
+
+// Owner returns the member which owns the key.
+func (r *Ring) Owner(key []byte) Member {
+	return r.ownerOf(hashKey(key))
+}
+
+func (r *Ring) ownerOf(hash uint64) Member {
+	i := sort.Search(len(r.points), func(i int) bool {
+		return r.points[i].hash >= hash
+	})
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.members[r.points[i].member]
+}
+
+// Members returns the members of the ring, ordered by id.
+func (r *Ring) Members() []Member {
+	return append([]Member(nil), r.members...)
+}
+
+// Range is the hash range (Start, End] of the ring, it wraps around when Start >= End.
+type Range struct {
+	Start uint64 `json:"start"`
+	End   uint64 `json:"end"`
+}
+
+// contains reports whether the hash is in the range.
+func (r Range) contains(hash uint64) bool {
+	if r.Start < r.End {
+		return hash > r.Start && hash <= r.End
+	}
+	return hash > r.Start || hash <= r.End
+}
+
+// Move is a range of the ring which is moved to another member.
+type Move struct {
+	Range Range  `json:"range"`
+	From  Member `json:"from"`
+	To    Member `json:"to"`
+}
+
+// Moves returns the ranges owned by the member on this ring, which are owned by another member on the next ring.
+// The keys in these ranges are streamed from the member to their new owners when the ring changes.
+func (r *Ring) Moves(next *Ring, id string) []Move {
+	// the owners of both rings only change at the points of the rings,
+	// so the ring is split at all the points, and each piece has one owner on each ring.
+	hashes := make([]uint64, 0, len(r.points)+len(next.points))
+	for _, p := range r.points {
+		hashes = append(hashes, p.hash)
+	}
+	for _, p := range next.points {
+		hashes = append(hashes, p.hash)
+	}
+	if len(hashes) == 0 {
+		return nil
+	}
+	sort.Slice(hashes, func(i, j int) bool { return hashes[i] < hashes[j] })
+
+	var moves []Move
+	prev := hashes[len(hashes)-1]
+	for i, hash := range hashes {
+		if i > 0 && hash == hashes[i-1] {
+			continue
+		}
+		from, to := r.ownerOf(hash), next.ownerOf(hash)
+		if from.ID == id && to.ID != id {
+			// merge the adjacent pieces moved to the same member
+			if last := len(moves) - 1; last >= 0 && moves[last].Range.End == prev && moves[last].To == to {
+				moves[last].Range.End = hash
+			} else {
+				moves = append(moves, Move{Range: Range{Start: prev, End: hash}, From: from, To: to})
+			}
+		}
+		prev = hash
+	}
+	return moves
+}
+
+func hashKey(key []byte) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write(key)
+	return mix(h.Sum64())
+}
+
+func hashString(s string) uint64 {
+	return hashKey([]byte(s))
+}
+
+// mix spreads the bits of the hash, so the virtual nodes of a member, whose names only differ
+// in the last characters, are evenly placed on the ring.
+func mix(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
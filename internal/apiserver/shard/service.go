@@ -0,0 +1,180 @@
+// Copyright 2024 Joy <joyssss94@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package shard
+
+import (
+	"context"
+	"errors"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/JoyZF/zoom/pkg/rosedb"
+)
+
+const serviceName = "zoom.shard.Shard"
+
+// maxHops is how many times a request is forwarded. The nodes may route a key to different owners
+// while a new membership is rolled out, the last node handles the request by itself.
+const maxHops = 2
+
+// Op is the operation of a Request.
+type Op string
+
+const (
+	// OpRead reads a key, the Change of the response is nil if the key is not found.
+	OpRead Op = "read"
+	// OpMultiRead reads the Keys, the Values of the response are in their order, nil for the keys which are not found.
+	OpMultiRead Op = "multi_read"
+	// OpFetch reads a key from the db of the node, it is neither forwarded nor read from a former owner.
+	OpFetch Op = "fetch"
+	// OpApply writes the puts and deletes of the Changes.
+	OpApply Op = "apply"
+	// OpExpire sets the expiration time of a key.
+	OpExpire Op = "expire"
+	// the read-modify-write operations on a key, see rosedb.DB.IncrBy and the others.
+	// The Value of the response is the new value of a counter, or the former value of OpGetAndSet.
+	OpIncrBy         Op = "incr_by"
+	OpIncrByFloat    Op = "incr_by_float"
+	OpCompareAndSwap Op = "compare_and_swap"
+	OpPutIfAbsent    Op = "put_if_absent"
+	OpPutIfExists    Op = "put_if_exists"
+	OpGetAndSet      Op = "get_and_set"
+)
+
+// Request is an operation on the keys of the owner.
+type Request struct {
+	Op      Op               `json:"op"`
+	Key     []byte           `json:"key,omitempty"`
+	Keys    [][]byte         `json:"keys,omitempty"`
+	Changes []*rosedb.Change `json:"changes,omitempty"`
+	// Expire is the absolute expiration time of OpExpire in unix nanoseconds.
+	Expire int64 `json:"expire,omitempty"`
+	// Value is the value written by a read-modify-write operation, and Expected is compared by OpCompareAndSwap.
+	Value    []byte `json:"value,omitempty"`
+	Expected []byte `json:"expected,omitempty"`
+	// Delta is added by OpIncrBy, and FloatDelta by OpIncrByFloat.
+	Delta      int64   `json:"delta,omitempty"`
+	FloatDelta float64 `json:"float_delta,omitempty"`
+	Hops       int     `json:"hops"`
+}
+
+// Response is the result of a Request.
+type Response struct {
+	Change *rosedb.Change `json:"change,omitempty"`
+	Value  []byte         `json:"value,omitempty"`
+	Values [][]byte       `json:"values,omitempty"`
+}
+
+// TransferRequest is a batch of the keys streamed to their new owner when the ring changes.
+// The last one of a stream has Done set, once all the keys moved to the receiver are sent.
+// The receiver rejects the keys if its membership is not the Membership of the sender.
+type TransferRequest struct {
+	From       string           `json:"from"`
+	Membership Membership       `json:"membership"`
+	Changes    []*rosedb.Change `json:"changes,omitempty"`
+	Done       bool             `json:"done,omitempty"`
+}
+
+// TransferResponse is sent when the stream is closed by the sender.
+type TransferResponse struct {
+	Count int `json:"count"`
+}
+
+// shardServer is the server API of the shard service.
+type shardServer interface {
+	Do(ctx context.Context, req *Request) (*Response, error)
+	Transfer(stream grpc.ServerStream) error
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: serviceName,
+	HandlerType: (*shardServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Do",
+			Handler:    doHandler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Transfer",
+			Handler:       transferHandler,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "shard",
+}
+
+func doHandler(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+	req := &Request{}
+	if err := dec(req); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(shardServer).Do(ctx, req)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + serviceName + "/Do"}
+	return interceptor(ctx, req, info, func(ctx context.Context, req any) (any, error) {
+		return srv.(shardServer).Do(ctx, req.(*Request))
+	})
+}
+
+func transferHandler(srv any, stream grpc.ServerStream) error {
+	return srv.(shardServer).Transfer(stream)
+}
+
+// knownErrors are the errors which are returned as they are by a forwarded request,
+// so the callers can compare them with errors.Is.
+var knownErrors = []error{
+	rosedb.ErrKeyIsEmpty,
+	rosedb.ErrKeyNotFound,
+	rosedb.ErrDBClosed,
+	rosedb.ErrPreconditionFailed,
+	rosedb.ErrValueNotInteger,
+	rosedb.ErrValueNotFloat,
+	rosedb.ErrIncrOverflow,
+	ErrUnknownOp,
+	ErrNodeStopped,
+	ErrMembershipMismatch,
+}
+
+func toStatus(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	switch {
+	case errors.Is(err, rosedb.ErrKeyNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, rosedb.ErrKeyIsEmpty), errors.Is(err, ErrUnknownOp),
+		errors.Is(err, rosedb.ErrValueNotInteger), errors.Is(err, rosedb.ErrValueNotFloat),
+		errors.Is(err, rosedb.ErrIncrOverflow):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, rosedb.ErrDBClosed), errors.Is(err, ErrNodeStopped):
+		return status.Error(codes.Unavailable, err.Error())
+	case errors.Is(err, ErrMembershipMismatch), errors.Is(err, rosedb.ErrPreconditionFailed):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
+
+// fromStatus returns the known error of the status returned by a forwarded request.
+func fromStatus(err error) error {
+	s, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+	for _, known := range knownErrors {
+		if s.Message() == known.Error() {
+			return known
+		}
+	}
+	return err
+}
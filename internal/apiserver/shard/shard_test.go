@@ -0,0 +1,321 @@
+// Copyright 2024 Joy <joyssss94@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package shard
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+
+	"github.com/JoyZF/zoom/pkg/rosedb"
+	"github.com/JoyZF/zoom/utils"
+)
+
+func testMembership(ids ...string) Membership {
+	membership := Membership{VirtualNodes: DefaultVirtualNodes}
+	for _, id := range ids {
+		membership.Members = append(membership.Members, Member{ID: id, Address: id + ":8081"})
+	}
+	return membership
+}
+
+func TestRing_Owner(t *testing.T) {
+	ring := NewRing(testMembership("a", "b", "c"))
+	counts := make(map[string]int)
+	for i := 0; i < 30000; i++ {
+		owner := ring.Owner(utils.GetTestKey(i))
+		assert.Equal(t, owner, ring.Owner(utils.GetTestKey(i)))
+		counts[owner.ID]++
+	}
+	// each member owns about a third of the keys
+	assert.Len(t, counts, 3)
+	for _, count := range counts {
+		assert.True(t, count > 7000 && count < 13000, count)
+	}
+
+	// adding a member only moves keys to it
+	next := NewRing(testMembership("a", "b", "c", "d"))
+	moved := 0
+	for i := 0; i < 30000; i++ {
+		key := utils.GetTestKey(i)
+		if from, to := ring.Owner(key), next.Owner(key); from != to {
+			assert.Equal(t, "d", to.ID)
+			moved++
+		}
+	}
+	assert.True(t, moved > 5000 && moved < 10000, moved)
+}
+
+func TestRing_Moves(t *testing.T) {
+	ring := NewRing(testMembership("a", "b", "c"))
+	next := NewRing(testMembership("a", "c", "d"))
+	for _, id := range []string{"a", "b", "c"} {
+		moves := ring.Moves(next, id)
+		for i := 0; i < 10000; i++ {
+			key := utils.GetTestKey(i)
+			from, to := ring.Owner(key), next.Owner(key)
+			var found *Move
+			for j := range moves {
+				if moves[j].Range.contains(hashKey(key)) {
+					found = &moves[j]
+					break
+				}
+			}
+			if from.ID == id && to.ID != id {
+				if assert.NotNil(t, found, string(key)) {
+					assert.Equal(t, to, found.To)
+					assert.Equal(t, id, found.From.ID)
+				}
+			} else {
+				assert.Nil(t, found, string(key))
+			}
+		}
+	}
+	assert.Empty(t, ring.Moves(ring, "a"))
+}
+
+type testNode struct {
+	*Node
+	store  *Store
+	server *grpc.Server
+}
+
+// startNode starts a shard node serving on the listener, with its db in dir.
+func startNode(t *testing.T, dir, id string, listener net.Listener, membershipFile string) *testNode {
+	dbOptions := rosedb.DefaultOptions
+	dbOptions.DirPath = filepath.Join(dir, id)
+	db, err := rosedb.Open(dbOptions)
+	assert.Nil(t, err)
+
+	server := grpc.NewServer()
+	n, err := NewNode(db, server, Options{
+		NodeID:            id,
+		MembershipFile:    membershipFile,
+		ReloadInterval:    100 * time.Millisecond,
+		StateFile:         filepath.Join(dir, id+"-SHARDS"),
+		TransferBatchSize: 16,
+		RequestTimeout:    5 * time.Second,
+	})
+	assert.Nil(t, err)
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	n.Start()
+	t.Cleanup(func() {
+		n.Close()
+		server.Stop()
+		_ = db.Close()
+	})
+	return &testNode{Node: n, store: NewStore(n), server: server}
+}
+
+func listen(t *testing.T) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	return listener
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	deadline := time.Now().Add(15 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met before the deadline")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// localKeys returns the keys in the db of the node.
+func localKeys(t *testing.T, n *testNode) map[string]struct{} {
+	iter, err := n.db.NewIterator(rosedb.IteratorOptions{})
+	assert.Nil(t, err)
+	defer iter.Close()
+	keys := make(map[string]struct{})
+	for ; iter.Valid(); iter.Next() {
+		keys[string(iter.Key())] = struct{}{}
+	}
+	return keys
+}
+
+func rebalanced(nodes []*testNode) bool {
+	for _, n := range nodes {
+		status := n.Status()
+		if status.Rebalance.State != rebalanceDone || len(status.Incoming) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func TestShard(t *testing.T) {
+	dir := t.TempDir()
+	listeners := []net.Listener{listen(t), listen(t), listen(t)}
+	membership := Membership{VirtualNodes: DefaultVirtualNodes}
+	for i, id := range []string{"a", "b", "c"} {
+		membership.Members = append(membership.Members, Member{ID: id, Address: listeners[i].Addr().String()})
+	}
+	membershipFile := filepath.Join(dir, "membership.json")
+	// the third node joins later
+	assert.Nil(t, saveMembership(membershipFile, Membership{VirtualNodes: DefaultVirtualNodes, Members: membership.Members[:2]}))
+
+	nodes := []*testNode{
+		startNode(t, dir, "a", listeners[0], membershipFile),
+		startNode(t, dir, "b", listeners[1], membershipFile),
+	}
+	waitFor(t, func() bool { return rebalanced(nodes) })
+
+	// the keys are written and read through any node, and stored by their owner only
+	for i := 0; i < 200; i++ {
+		assert.Nil(t, nodes[i%2].store.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	assert.Nil(t, nodes[0].store.PutWithTTL([]byte("ttl"), []byte("v"), time.Hour))
+	for _, n := range nodes {
+		for i := 0; i < 200; i++ {
+			value, err := n.store.Get(utils.GetTestKey(i))
+			assert.Nil(t, err)
+			assert.Equal(t, utils.GetTestKey(i), value)
+		}
+	}
+	keysA, keysB := localKeys(t, nodes[0]), localKeys(t, nodes[1])
+	assert.Equal(t, 201, len(keysA)+len(keysB))
+	for key := range keysA {
+		assert.Equal(t, "a", nodes[0].Owner([]byte(key)).ID)
+	}
+	assert.Nil(t, nodes[1].store.Delete(utils.GetTestKey(0)))
+	_, err := nodes[0].store.Get(utils.GetTestKey(0))
+	assert.Equal(t, rosedb.ErrKeyNotFound, err)
+	assert.Equal(t, rosedb.ErrKeyNotFound, nodes[0].store.Expire(utils.GetTestKey(0), time.Minute))
+
+	// the read-modify-write operations run on the owner, whichever node receives them
+	counter := []byte("counter")
+	for i := 1; i <= 10; i++ {
+		incremented, err := nodes[i%2].store.Incr(counter)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(i), incremented)
+	}
+	float, err := nodes[0].store.IncrByFloat(counter, -0.5)
+	assert.Nil(t, err)
+	assert.Equal(t, 9.5, float)
+	assert.Equal(t, rosedb.ErrPreconditionFailed, nodes[1].store.PutIfAbsent(counter, []byte("1")))
+	assert.Equal(t, rosedb.ErrPreconditionFailed, nodes[0].store.CompareAndSwap(counter, []byte("10"), []byte("1")))
+	assert.Nil(t, nodes[1].store.PutIfExists(counter, []byte("1")))
+	former, err := nodes[0].store.GetAndSet(counter, []byte("2"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("1"), former)
+	assert.Nil(t, nodes[1].store.Delete(counter))
+
+	// the multi-key operations split the keys by their owners
+	multiKeys := [][]byte{[]byte("m1"), []byte("m2"), []byte("m3"), []byte("m4")}
+	assert.Nil(t, nodes[0].store.MultiPut(multiKeys, [][]byte{[]byte("v1"), []byte("v2"), []byte("v3"), {}}))
+	values, err := nodes[1].store.MultiGet(append(multiKeys, utils.GetTestKey(0), utils.GetTestKey(1)))
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("v1"), []byte("v2"), []byte("v3"), {}, nil, utils.GetTestKey(1)}, values)
+	assert.Nil(t, nodes[1].store.MultiDelete(multiKeys))
+	values, err = nodes[0].store.MultiGet(multiKeys)
+	assert.Nil(t, err)
+	assert.Equal(t, make([][]byte, len(multiKeys)), values)
+	assert.Equal(t, rosedb.ErrKeyIsEmpty, nodes[0].store.MultiDelete([][]byte{nil}))
+
+	// adding a node to the membership file moves the keys it owns to it
+	nodes = append(nodes, startNode(t, dir, "c", listeners[2], membershipFile))
+	assert.Nil(t, saveMembership(membershipFile, membership))
+	for _, n := range nodes {
+		n.Reload()
+	}
+	waitFor(t, func() bool { return rebalanced(nodes) })
+
+	total := 0
+	for _, n := range nodes {
+		keys := localKeys(t, n)
+		total += len(keys)
+		for key := range keys {
+			assert.Equal(t, n.options.NodeID, n.Owner([]byte(key)).ID)
+		}
+	}
+	assert.Equal(t, 200, total)
+	assert.True(t, nodes[0].Status().Rebalance.Moved+nodes[1].Status().Rebalance.Moved > 0)
+	for _, n := range nodes {
+		for i := 1; i < 200; i++ {
+			value, err := n.store.Get(utils.GetTestKey(i))
+			assert.Nil(t, err)
+			assert.Equal(t, utils.GetTestKey(i), value)
+		}
+		ttl, err := n.store.TTL([]byte("ttl"))
+		assert.Nil(t, err)
+		assert.True(t, ttl > 59*time.Minute)
+		assert.Equal(t, membership, n.Status().Membership)
+	}
+	state, ok, err := loadState(filepath.Join(dir, "c-SHARDS"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.True(t, membership.Equal(state))
+}
+
+func TestNode_Handoff(t *testing.T) {
+	dir := t.TempDir()
+	listeners := []net.Listener{listen(t), listen(t)}
+	membership := Membership{VirtualNodes: DefaultVirtualNodes}
+	for i, id := range []string{"a", "b"} {
+		membership.Members = append(membership.Members, Member{ID: id, Address: listeners[i].Addr().String()})
+	}
+	fileA, fileB := filepath.Join(dir, "a.json"), filepath.Join(dir, "b.json")
+	assert.Nil(t, saveMembership(fileA, Membership{VirtualNodes: DefaultVirtualNodes, Members: membership.Members[:1]}))
+	assert.Nil(t, saveMembership(fileB, membership))
+	a := startNode(t, dir, "a", listeners[0], fileA)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, a.store.Put(utils.GetTestKey(i), []byte("old")))
+	}
+
+	// b joins, but a has not loaded the new membership, so it does not hand off the keys yet
+	b := startNode(t, dir, "b", listeners[1], fileB)
+	assert.Equal(t, []string{"a"}, b.Status().Incoming)
+	var moved [][]byte
+	for i := 0; i < 100; i++ {
+		if key := utils.GetTestKey(i); b.Owner(key).ID == "b" {
+			moved = append(moved, key)
+		}
+	}
+	assert.True(t, len(moved) > 4)
+	written, deleted, expired, untouched := moved[0], moved[1], moved[2], moved[3:]
+	assert.Nil(t, b.store.Put(written, []byte("new")))
+	assert.Nil(t, b.store.Delete(deleted))
+	assert.Nil(t, b.store.Expire(expired, time.Hour))
+	// the keys which are not handed off yet are read from a
+	for _, key := range untouched {
+		value, err := b.store.Get(key)
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("old"), value)
+	}
+	_, err := b.store.Get(deleted)
+	assert.Equal(t, rosedb.ErrKeyNotFound, err)
+
+	assert.Nil(t, saveMembership(fileA, membership))
+	a.Reload()
+	waitFor(t, func() bool { return rebalanced([]*testNode{a, b}) })
+
+	// the keys written on b during the handoff are kept
+	value, err := b.store.Get(written)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("new"), value)
+	_, err = b.store.Get(deleted)
+	assert.Equal(t, rosedb.ErrKeyNotFound, err)
+	value, err = b.store.Get(expired)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("old"), value)
+	ttl, err := b.store.TTL(expired)
+	assert.Nil(t, err)
+	assert.True(t, ttl > 59*time.Minute)
+	keysB := localKeys(t, b)
+	for _, key := range untouched {
+		assert.Contains(t, keysB, string(key))
+	}
+	for key := range localKeys(t, a) {
+		assert.Equal(t, "a", a.Owner([]byte(key)).ID)
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright 2024 Joy <joyssss94@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package shard
+
+import (
+	"context"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/JoyZF/zoom/pkg/rosedb"
+)
+
+// Store is the store of a shard node, the requests for a key are served by its owner,
+// whichever node receives them. Sync, Stat and Export only cover the keys of the local db.
+type Store struct {
+	node *Node
+}
+
+// NewStore returns the store of the node.
+func NewStore(node *Node) *Store {
+	return &Store{node: node}
+}
+
+func (s *Store) apply(changes ...*rosedb.Change) error {
+	return s.node.Apply(context.Background(), changes)
+}
+
+func (s *Store) Sync() error {
+	return s.node.db.Sync()
+}
+
+// Stat returns the stat of the local db.
+func (s *Store) Stat() any {
+	return s.node.db.Stat()
+}
+
+func (s *Store) Get(key []byte) ([]byte, error) {
+	return s.node.Get(context.Background(), key)
+}
+
+func (s *Store) Put(key, value []byte) error {
+	return s.apply(&rosedb.Change{Action: rosedb.WatchActionPut, Key: key, Value: value})
+}
+
+func (s *Store) PutWithTTL(key []byte, value []byte, ttl time.Duration) error {
+	return s.apply(&rosedb.Change{Action: rosedb.WatchActionPut, Key: key, Value: value, Expire: time.Now().Add(ttl).UnixNano()})
+}
+
+func (s *Store) Delete(key []byte) error {
+	return s.apply(&rosedb.Change{Action: rosedb.WatchActionDelete, Key: key})
+}
+
+func (s *Store) MultiGet(keys [][]byte) ([][]byte, error) {
+	return s.node.MultiGet(context.Background(), keys)
+}
+
+// MultiPut writes the pairs owned by a node in a batch, but the pairs of different nodes are not atomic.
+func (s *Store) MultiPut(keys, values [][]byte) error {
+	if len(keys) != len(values) {
+		return rosedb.ErrMultiLengthMismatch
+	}
+	changes := make([]*rosedb.Change, 0, len(keys))
+	for i, key := range keys {
+		if len(key) == 0 {
+			return rosedb.ErrKeyIsEmpty
+		}
+		changes = append(changes, &rosedb.Change{Action: rosedb.WatchActionPut, Key: key, Value: values[i]})
+	}
+	return s.apply(changes...)
+}
+
+// MultiDelete deletes the keys owned by a node in a batch, but the keys of different nodes are not atomic.
+func (s *Store) MultiDelete(keys [][]byte) error {
+	changes := make([]*rosedb.Change, 0, len(keys))
+	for _, key := range keys {
+		if len(key) == 0 {
+			return rosedb.ErrKeyIsEmpty
+		}
+		changes = append(changes, &rosedb.Change{Action: rosedb.WatchActionDelete, Key: key})
+	}
+	return s.apply(changes...)
+}
+
+func (s *Store) TTL(key []byte) (time.Duration, error) {
+	return s.node.TTL(context.Background(), key)
+}
+
+func (s *Store) Exist(key []byte) (bool, error) {
+	return s.node.Exist(context.Background(), key)
+}
+
+func (s *Store) Expire(key []byte, ttl time.Duration) error {
+	return s.node.Expire(context.Background(), key, time.Now().Add(ttl))
+}
+
+func (s *Store) Incr(key []byte) (int64, error) {
+	return s.IncrBy(key, 1)
+}
+
+func (s *Store) IncrBy(key []byte, delta int64) (int64, error) {
+	result, err := s.node.Update(context.Background(), &Request{Op: OpIncrBy, Key: key, Delta: delta})
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(string(result), 10, 64)
+}
+
+func (s *Store) IncrByFloat(key []byte, delta float64) (float64, error) {
+	result, err := s.node.Update(context.Background(), &Request{Op: OpIncrByFloat, Key: key, FloatDelta: delta})
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(string(result), 64)
+}
+
+func (s *Store) CompareAndSwap(key, expected, value []byte) error {
+	_, err := s.node.Update(context.Background(), &Request{Op: OpCompareAndSwap, Key: key, Expected: expected, Value: value})
+	return err
+}
+
+func (s *Store) PutIfAbsent(key, value []byte) error {
+	_, err := s.node.Update(context.Background(), &Request{Op: OpPutIfAbsent, Key: key, Value: value})
+	return err
+}
+
+func (s *Store) PutIfExists(key, value []byte) error {
+	_, err := s.node.Update(context.Background(), &Request{Op: OpPutIfExists, Key: key, Value: value})
+	return err
+}
+
+func (s *Store) GetAndSet(key, value []byte) ([]byte, error) {
+	return s.node.Update(context.Background(), &Request{Op: OpGetAndSet, Key: key, Value: value})
+}
+
+// Export writes the keys of the local db.
+func (s *Store) Export(w io.Writer, options rosedb.ExportOptions) (int, error) {
+	return s.node.db.Export(w, options)
+}
+
+// Import writes each batch of records of the dump to the owners of their keys.
+func (s *Store) Import(r io.Reader, options rosedb.ImportOptions) (int, error) {
+	return rosedb.ReadDump(r, options, func(changes []*rosedb.Change) error {
+		return s.apply(changes...)
+	})
+}
@@ -0,0 +1,10 @@
+package v1
+
+type ClusterJoinReq struct {
+	ID      string `json:"id" binding:"required,max=255,min=1"`      // 节点ID
+	Address string `json:"address" binding:"required,hostname_port"` // 节点的grpc地址
+}
+
+type ClusterLeaveReq struct {
+	ID string `json:"id" binding:"required,max=255,min=1"` // 节点ID
+}
@@ -0,0 +1,59 @@
+package v1
+
+type HashFieldReq struct {
+	Key   string `form:"key" binding:"required,max=255,min=1"`   // 键名
+	Field string `form:"field" binding:"required,max=255,min=1"` // 字段名
+}
+
+type HSetReq struct {
+	Key   string `json:"key" binding:"required,max=255,min=1"`   // 键名
+	Field string `json:"field" binding:"required,max=255,min=1"` // 字段名
+	Value string `json:"value" binding:"max=255"`                // 字段值
+}
+
+type HDelReq struct {
+	Key    string   `json:"key" binding:"required,max=255,min=1"`               // 键名
+	Fields []string `json:"fields" binding:"required,min=1,dive,min=1,max=255"` // 字段名
+}
+
+type LPushReq struct {
+	Key    string   `json:"key" binding:"required,max=255,min=1"`         // 键名
+	Values []string `json:"values" binding:"required,min=1,dive,max=255"` // 元素
+}
+
+type RPopReq struct {
+	Key string `json:"key" binding:"required,max=255,min=1"` // 键名
+}
+
+type LRangeReq struct {
+	Key   string `form:"key" binding:"required,max=255,min=1"` // 键名
+	Start int    `form:"start"`                                // 起始下标(包含), 负数从末尾计数
+	Stop  int    `form:"stop,default=-1"`                      // 结束下标(包含), 负数从末尾计数
+}
+
+type SAddReq struct {
+	Key     string   `json:"key" binding:"required,max=255,min=1"`                // 键名
+	Members []string `json:"members" binding:"required,min=1,dive,min=1,max=255"` // 成员
+}
+
+type SetMemberReq struct {
+	Key    string `form:"key" binding:"required,max=255,min=1"`    // 键名
+	Member string `form:"member" binding:"required,max=255,min=1"` // 成员
+}
+
+type ZAddReq struct {
+	Key    string   `json:"key" binding:"required,max=255,min=1"`    // 键名
+	Score  *float64 `json:"score" binding:"required"`                // 分数
+	Member string   `json:"member" binding:"required,max=255,min=1"` // 成员
+}
+
+type ZRangeByScoreReq struct {
+	Key string   `form:"key" binding:"required,max=255,min=1"` // 键名
+	Min *float64 `form:"min" binding:"required"`               // 最小分数(包含), 可以是 -inf
+	Max *float64 `form:"max" binding:"required"`               // 最大分数(包含), 可以是 +inf
+}
+
+type ZSetMember struct {
+	Member string  `json:"member"` // 成员
+	Score  float64 `json:"score"`  // 分数
+}
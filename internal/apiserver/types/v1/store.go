@@ -19,3 +19,50 @@ type ExpireReq struct {
 	Key string `json:"key" binding:"required,max=255,min=1"`
 	TTL int64  `json:"ttl" binding:"required"`
 }
+
+type IncrReq struct {
+	Key   string `json:"key" binding:"required,max=255,min=1"` // 键名
+	Delta *int64 `json:"delta"`                                // 增量, 默认 1
+}
+
+type IncrByFloatReq struct {
+	Key   string   `json:"key" binding:"required,max=255,min=1"` // 键名
+	Delta *float64 `json:"delta" binding:"required"`             // 增量
+}
+
+type CompareAndSwapReq struct {
+	Key      string `json:"key" binding:"required,max=255,min=1"`      // 键名
+	Expected string `json:"expected" binding:"required,max=255,min=1"` // 期望的当前键值
+	Value    string `json:"value" binding:"required,max=255,min=1"`    // 新键值
+}
+
+type MultiKeyReq struct {
+	Keys []string `json:"keys" binding:"required,min=1,max=1000,dive,min=1,max=255"` // 键名
+}
+
+type MultiPutReq struct {
+	Pairs []StorePutReq `json:"pairs" binding:"required,min=1,max=1000,dive"` // 键值对
+}
+
+type KeyResult struct {
+	Key   string  `json:"key"`             // 键名
+	Value *string `json:"value,omitempty"` // 键值, 键不存在时为空
+	Exist *bool   `json:"exist,omitempty"` // 键是否存在, 仅在读取时返回
+}
+
+type ExportReq struct {
+	Format         string `form:"format" binding:"omitempty,oneof=ndjson csv"` // 导出格式
+	Prefix         string `form:"prefix"`                                      // 键名前缀
+	Start          string `form:"start"`                                       // 起始键名(包含)
+	End            string `form:"end"`                                         // 结束键名(不包含)
+	AbsoluteExpiry bool   `form:"absolute_expiry"`                             // 导出绝对过期时间
+	WithBatchId    bool   `form:"batch_id"`                                    // 导出批次ID
+}
+
+type ImportReq struct {
+	Format    string `form:"format" binding:"omitempty,oneof=ndjson csv"` // 导入格式
+	Prefix    string `form:"prefix"`                                      // 键名前缀
+	Start     string `form:"start"`                                       // 起始键名(包含)
+	End       string `form:"end"`                                         // 结束键名(不包含)
+	BatchSize int    `form:"batch_size" binding:"omitempty,min=1"`        // 每批写入的记录数
+}
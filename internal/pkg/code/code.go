@@ -29,6 +29,9 @@ const (
 const (
 	ParamsError             = 10001
 	GenericServiceErrorCode = 10002
+	ReadOnlyReplica         = 10003
+	WrongType               = 10004
+	PreconditionFailed      = 10005
 )
 
 func RegisterCoder() {
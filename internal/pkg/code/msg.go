@@ -53,4 +53,7 @@ var CodeMap = map[int]map[string]CodeMsg{
 	NotFound:                {global.ZH_CN: CodeMsg{C: NotFound, Msg: "未找到"}, global.EN_US: CodeMsg{C: NotFound, Msg: "not found"}},
 	ParamsError:             {global.ZH_CN: CodeMsg{C: ParamsError, Msg: "参数错误"}, global.EN_US: CodeMsg{C: ParamsError, Msg: "params error"}},
 	GenericServiceErrorCode: {global.ZH_CN: CodeMsg{C: GenericServiceErrorCode, Msg: "Generic service error code"}, global.EN_US: CodeMsg{C: GenericServiceErrorCode, Msg: "Generic service error code"}},
+	ReadOnlyReplica:         {global.ZH_CN: CodeMsg{C: ReadOnlyReplica, Msg: "从节点只读"}, global.EN_US: CodeMsg{C: ReadOnlyReplica, Msg: "the follower is read only"}},
+	WrongType:               {global.ZH_CN: CodeMsg{C: WrongType, Msg: "键的类型不匹配"}, global.EN_US: CodeMsg{C: WrongType, Msg: "the key holds a value of another type"}},
+	PreconditionFailed:      {global.ZH_CN: CodeMsg{C: PreconditionFailed, Msg: "写入条件不满足"}, global.EN_US: CodeMsg{C: PreconditionFailed, Msg: "the precondition of the write is not met"}},
 }
@@ -0,0 +1,96 @@
+// Copyright 2024 Joy <joyssss94@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package options
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// ClusterOptions contains the options of the raft cluster mode.
+type ClusterOptions struct {
+	// Enabled replicates the writes through a raft log between the nodes of the cluster.
+	Enabled bool `mapstructure:"enabled"`
+	// NodeID is the unique id of the node in the cluster.
+	NodeID string `mapstructure:"node-id"`
+	// Advertise is the grpc address the other nodes reach the node at, raft shares the grpc port.
+	Advertise string `mapstructure:"advertise"`
+	// Bootstrap creates the cluster with the node and the peers on the first start.
+	Bootstrap bool `mapstructure:"bootstrap"`
+	// Peers are the other initial voters of the cluster, in the form id=address.
+	Peers []string `mapstructure:"peers"`
+	// DataDir holds the raft log and the snapshots, defaults to the data directory with a -raft suffix.
+	DataDir string `mapstructure:"data-dir"`
+	// SnapshotThreshold is how many log entries are written between two snapshots.
+	SnapshotThreshold uint64 `mapstructure:"snapshot-threshold"`
+	// SnapshotInterval is how often the node checks whether it takes a snapshot.
+	SnapshotInterval time.Duration `mapstructure:"snapshot-interval"`
+	// ApplyTimeout is how long a request waits for the raft log.
+	ApplyTimeout time.Duration `mapstructure:"apply-timeout"`
+}
+
+// NewClusterOptions creates a ClusterOptions object with default parameters.
+func NewClusterOptions() *ClusterOptions {
+	return &ClusterOptions{
+		Enabled:           false,
+		NodeID:            "",
+		Advertise:         "",
+		Bootstrap:         false,
+		Peers:             []string{},
+		DataDir:           "",
+		SnapshotThreshold: 8192,
+		SnapshotInterval:  2 * time.Minute,
+		ApplyTimeout:      5 * time.Second,
+	}
+}
+
+// Validate checks validation of ClusterOptions.
+func (o *ClusterOptions) Validate() []error {
+	var errors []error
+	if !o.Enabled {
+		return errors
+	}
+
+	if o.NodeID == "" {
+		errors = append(errors, fmt.Errorf("--cluster.node-id is required in the cluster mode"))
+	}
+	for _, peer := range o.Peers {
+		if id, address, ok := strings.Cut(peer, "="); !ok || id == "" || address == "" {
+			errors = append(errors, fmt.Errorf("--cluster.peers %q must be in the form id=address", peer))
+		}
+	}
+	if o.SnapshotInterval <= 0 {
+		errors = append(errors, fmt.Errorf("--cluster.snapshot-interval must be greater than 0"))
+	}
+	if o.ApplyTimeout <= 0 {
+		errors = append(errors, fmt.Errorf("--cluster.apply-timeout must be greater than 0"))
+	}
+
+	return errors
+}
+
+// AddFlags adds flags related to the cluster mode for a specific api server to the specified FlagSet.
+func (o *ClusterOptions) AddFlags(fs *pflag.FlagSet) {
+	fs.BoolVar(&o.Enabled, "cluster.enabled", o.Enabled,
+		"Replicate the writes through a raft log between the nodes of the cluster.")
+	fs.StringVar(&o.NodeID, "cluster.node-id", o.NodeID, "The unique id of the node in the cluster.")
+	fs.StringVar(&o.Advertise, "cluster.advertise", o.Advertise, ""+
+		"The grpc address the other nodes reach the node at, defaults to the grpc bind address.")
+	fs.BoolVar(&o.Bootstrap, "cluster.bootstrap", o.Bootstrap,
+		"Create the cluster with the node and the peers on the first start.")
+	fs.StringSliceVar(&o.Peers, "cluster.peers", o.Peers,
+		"The other initial voters of the cluster, in the form id=address.")
+	fs.StringVar(&o.DataDir, "cluster.data-dir", o.DataDir, ""+
+		"The directory of the raft log and the snapshots, defaults to the data directory with a -raft suffix.")
+	fs.Uint64Var(&o.SnapshotThreshold, "cluster.snapshot-threshold", o.SnapshotThreshold,
+		"How many log entries are written between two snapshots.")
+	fs.DurationVar(&o.SnapshotInterval, "cluster.snapshot-interval", o.SnapshotInterval,
+		"How often the node checks whether it takes a snapshot.")
+	fs.DurationVar(&o.ApplyTimeout, "cluster.apply-timeout", o.ApplyTimeout,
+		"How long a request waits for the raft log.")
+}
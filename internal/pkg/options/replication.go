@@ -0,0 +1,75 @@
+// Copyright 2024 Joy <joyssss94@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package options
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// ReplicationOptions contains the options of the leader/follower replication.
+type ReplicationOptions struct {
+	// Role is leader or follower, a follower applies the writes of the leader and rejects the writes of the clients.
+	Role string `mapstructure:"role"`
+	// LeaderAddress is the grpc address of the leader, required by a follower.
+	LeaderAddress string `mapstructure:"leader-address"`
+	// PositionFile is where a follower saves the position applied from the leader,
+	// a follower without it loads a snapshot of the leader.
+	PositionFile string `mapstructure:"position-file"`
+	// HeartbeatInterval is how often the leader sends a heartbeat on an idle stream.
+	HeartbeatInterval time.Duration `mapstructure:"heartbeat-interval"`
+	// RetryInterval is how long a follower waits before connecting to the leader again.
+	RetryInterval time.Duration `mapstructure:"retry-interval"`
+}
+
+// NewReplicationOptions creates a ReplicationOptions object with default parameters.
+func NewReplicationOptions() *ReplicationOptions {
+	return &ReplicationOptions{
+		Role:              "leader",
+		LeaderAddress:     "",
+		PositionFile:      "",
+		HeartbeatInterval: time.Second,
+		RetryInterval:     3 * time.Second,
+	}
+}
+
+// Validate checks validation of ReplicationOptions.
+func (o *ReplicationOptions) Validate() []error {
+	var errors []error
+
+	switch o.Role {
+	case "leader":
+	case "follower":
+		if o.LeaderAddress == "" {
+			errors = append(errors, fmt.Errorf("--replication.leader-address is required by a follower"))
+		}
+	default:
+		errors = append(errors, fmt.Errorf("--replication.role %q must be leader or follower", o.Role))
+	}
+	if o.HeartbeatInterval <= 0 {
+		errors = append(errors, fmt.Errorf("--replication.heartbeat-interval must be greater than 0"))
+	}
+	if o.RetryInterval <= 0 {
+		errors = append(errors, fmt.Errorf("--replication.retry-interval must be greater than 0"))
+	}
+
+	return errors
+}
+
+// AddFlags adds flags related to replication for a specific api server to the specified FlagSet.
+func (o *ReplicationOptions) AddFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&o.Role, "replication.role", o.Role, "The replication role of the server, leader or follower.")
+	fs.StringVar(&o.LeaderAddress, "replication.leader-address", o.LeaderAddress,
+		"The grpc address of the leader followed by a follower.")
+	fs.StringVar(&o.PositionFile, "replication.position-file", o.PositionFile, ""+
+		"The file where a follower saves the position applied from the leader, "+
+		"defaults to the REPLICATION file in the data directory.")
+	fs.DurationVar(&o.HeartbeatInterval, "replication.heartbeat-interval", o.HeartbeatInterval,
+		"How often the leader sends a heartbeat to an idle follower.")
+	fs.DurationVar(&o.RetryInterval, "replication.retry-interval", o.RetryInterval,
+		"How long a follower waits before connecting to the leader again.")
+}
@@ -0,0 +1,106 @@
+// Copyright 2024 Joy <joyssss94@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package options
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// ShardOptions contains the options of the hash-sharded mode.
+type ShardOptions struct {
+	// Enabled spreads the keys over the members on a consistent-hash ring.
+	Enabled bool `mapstructure:"enabled"`
+	// NodeID is the id of the node in the membership.
+	NodeID string `mapstructure:"node-id"`
+	// Members is the static membership, in the form id=address, used if MembershipFile is empty.
+	Members []string `mapstructure:"members"`
+	// VirtualNodes is the number of virtual nodes of a member of the static membership.
+	VirtualNodes int `mapstructure:"virtual-nodes"`
+	// MembershipFile is the JSON file of the membership, it is reloaded when it changes.
+	MembershipFile string `mapstructure:"membership-file"`
+	// ReloadInterval is how often the membership file is checked.
+	ReloadInterval time.Duration `mapstructure:"reload-interval"`
+	// StateFile is where the membership is saved once the keys are rebalanced,
+	// defaults to the SHARDS file in the data directory.
+	StateFile string `mapstructure:"state-file"`
+	// TransferBatchSize is the number of keys sent in a batch when they are moved to another node.
+	TransferBatchSize int `mapstructure:"transfer-batch-size"`
+	// RequestTimeout is how long a request forwarded to the owner of a key waits.
+	RequestTimeout time.Duration `mapstructure:"request-timeout"`
+}
+
+// NewShardOptions creates a ShardOptions object with default parameters.
+func NewShardOptions() *ShardOptions {
+	return &ShardOptions{
+		Enabled:           false,
+		NodeID:            "",
+		Members:           []string{},
+		VirtualNodes:      128,
+		MembershipFile:    "",
+		ReloadInterval:    10 * time.Second,
+		StateFile:         "",
+		TransferBatchSize: 1000,
+		RequestTimeout:    5 * time.Second,
+	}
+}
+
+// Validate checks validation of ShardOptions.
+func (o *ShardOptions) Validate() []error {
+	var errors []error
+	if !o.Enabled {
+		return errors
+	}
+
+	if o.NodeID == "" {
+		errors = append(errors, fmt.Errorf("--shard.node-id is required in the sharded mode"))
+	}
+	if o.MembershipFile == "" && len(o.Members) == 0 {
+		errors = append(errors, fmt.Errorf("--shard.members or --shard.membership-file is required in the sharded mode"))
+	}
+	for _, member := range o.Members {
+		if id, address, ok := strings.Cut(member, "="); !ok || id == "" || address == "" {
+			errors = append(errors, fmt.Errorf("--shard.members %q must be in the form id=address", member))
+		}
+	}
+	if o.VirtualNodes <= 0 {
+		errors = append(errors, fmt.Errorf("--shard.virtual-nodes must be greater than 0"))
+	}
+	if o.ReloadInterval <= 0 {
+		errors = append(errors, fmt.Errorf("--shard.reload-interval must be greater than 0"))
+	}
+	if o.TransferBatchSize <= 0 {
+		errors = append(errors, fmt.Errorf("--shard.transfer-batch-size must be greater than 0"))
+	}
+	if o.RequestTimeout <= 0 {
+		errors = append(errors, fmt.Errorf("--shard.request-timeout must be greater than 0"))
+	}
+
+	return errors
+}
+
+// AddFlags adds flags related to the sharded mode for a specific api server to the specified FlagSet.
+func (o *ShardOptions) AddFlags(fs *pflag.FlagSet) {
+	fs.BoolVar(&o.Enabled, "shard.enabled", o.Enabled,
+		"Spread the keys over the members on a consistent-hash ring, any node forwards a request to the owner of the key.")
+	fs.StringVar(&o.NodeID, "shard.node-id", o.NodeID, "The id of the node in the membership.")
+	fs.StringSliceVar(&o.Members, "shard.members", o.Members, ""+
+		"The static membership, in the form id=address, the address is the grpc address of the member.")
+	fs.IntVar(&o.VirtualNodes, "shard.virtual-nodes", o.VirtualNodes,
+		"The number of virtual nodes of a member of the static membership.")
+	fs.StringVar(&o.MembershipFile, "shard.membership-file", o.MembershipFile, ""+
+		"The JSON file of the membership, the keys are rebalanced when it changes. It overrides --shard.members.")
+	fs.DurationVar(&o.ReloadInterval, "shard.reload-interval", o.ReloadInterval,
+		"How often the membership file is checked.")
+	fs.StringVar(&o.StateFile, "shard.state-file", o.StateFile, ""+
+		"The file the membership is saved to once the keys are rebalanced, defaults to SHARDS in the data directory.")
+	fs.IntVar(&o.TransferBatchSize, "shard.transfer-batch-size", o.TransferBatchSize,
+		"The number of keys sent in a batch when they are moved to another node.")
+	fs.DurationVar(&o.RequestTimeout, "shard.request-timeout", o.RequestTimeout,
+		"How long a request forwarded to the owner of a key waits.")
+}
@@ -0,0 +1,195 @@
+// Copyright 2024 Joy <joyssss94@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package rosedb
+
+import (
+	"bytes"
+	"math"
+	"strconv"
+	"time"
+)
+
+// The read-modify-write operations read the key and write it in the same batch,
+// which holds the lock of the db, so no other write can come in between.
+//
+// The counters are stored as decimal strings, so they can be read with Get.
+
+// IncrBy adds delta to the integer value of the key and returns the new value,
+// a key which does not exist is set to delta. The ttl of the key is kept.
+func (b *Batch) IncrBy(key []byte, delta int64) (int64, error) {
+	value, ttl, err := b.getWithTTL(key)
+	if err != nil {
+		return 0, err
+	}
+	var current int64
+	if value != nil {
+		if current, err = strconv.ParseInt(string(value), 10, 64); err != nil {
+			return 0, ErrValueNotInteger
+		}
+	}
+	if (delta > 0 && current > math.MaxInt64-delta) || (delta < 0 && current < math.MinInt64-delta) {
+		return 0, ErrIncrOverflow
+	}
+	current += delta
+	return current, b.putKeepTTL(key, strconv.AppendInt(nil, current, 10), ttl)
+}
+
+// Incr adds 1 to the integer value of the key, see IncrBy.
+func (b *Batch) Incr(key []byte) (int64, error) {
+	return b.IncrBy(key, 1)
+}
+
+// IncrByFloat adds delta to the float value of the key and returns the new value,
+// a key which does not exist is set to delta. The ttl of the key is kept.
+func (b *Batch) IncrByFloat(key []byte, delta float64) (float64, error) {
+	value, ttl, err := b.getWithTTL(key)
+	if err != nil {
+		return 0, err
+	}
+	var current float64
+	if value != nil {
+		if current, err = strconv.ParseFloat(string(value), 64); err != nil {
+			return 0, ErrValueNotFloat
+		}
+	}
+	current += delta
+	if math.IsNaN(current) || math.IsInf(current, 0) {
+		return 0, ErrIncrOverflow
+	}
+	return current, b.putKeepTTL(key, strconv.AppendFloat(nil, current, 'f', -1, 64), ttl)
+}
+
+// CompareAndSwap sets the key to value if its current value is expected,
+// otherwise it returns ErrPreconditionFailed, which is returned as well if the key does not exist.
+func (b *Batch) CompareAndSwap(key, expected, value []byte) error {
+	current, err := b.Get(key)
+	if err == ErrKeyNotFound || (err == nil && !bytes.Equal(current, expected)) {
+		return ErrPreconditionFailed
+	}
+	if err != nil {
+		return err
+	}
+	return b.Put(key, value)
+}
+
+// PutIfAbsent sets the key to value if it does not exist, otherwise it returns ErrPreconditionFailed.
+func (b *Batch) PutIfAbsent(key, value []byte) error {
+	exist, err := b.Exist(key)
+	if err != nil {
+		return err
+	}
+	if exist {
+		return ErrPreconditionFailed
+	}
+	return b.Put(key, value)
+}
+
+// PutIfExists sets the key to value if it exists, otherwise it returns ErrPreconditionFailed.
+func (b *Batch) PutIfExists(key, value []byte) error {
+	exist, err := b.Exist(key)
+	if err != nil {
+		return err
+	}
+	if !exist {
+		return ErrPreconditionFailed
+	}
+	return b.Put(key, value)
+}
+
+// GetAndSet sets the key to value and returns its former value, nil if it did not exist.
+func (b *Batch) GetAndSet(key, value []byte) ([]byte, error) {
+	current, err := b.Get(key)
+	if err != nil && err != ErrKeyNotFound {
+		return nil, err
+	}
+	if err = b.Put(key, value); err != nil {
+		return nil, err
+	}
+	return current, nil
+}
+
+// getWithTTL returns the value of the key and its ttl, -1 if it has none,
+// or a nil value if the key does not exist.
+func (b *Batch) getWithTTL(key []byte) ([]byte, time.Duration, error) {
+	value, err := b.Get(key)
+	if err == ErrKeyNotFound {
+		return nil, -1, nil
+	}
+	if err != nil {
+		return nil, -1, err
+	}
+	ttl, err := b.TTL(key)
+	if err != nil {
+		return nil, -1, err
+	}
+	return value, ttl, nil
+}
+
+// putKeepTTL writes the key with the ttl returned by getWithTTL.
+func (b *Batch) putKeepTTL(key, value []byte, ttl time.Duration) error {
+	if ttl > 0 {
+		return b.PutWithTTL(key, value, ttl)
+	}
+	return b.Put(key, value)
+}
+
+// IncrBy adds delta to the integer value of the key and returns the new value, see Batch.IncrBy.
+func (db *DB) IncrBy(key []byte, delta int64) (int64, error) {
+	var value int64
+	err := db.update(func(batch *Batch) error {
+		var err error
+		value, err = batch.IncrBy(key, delta)
+		return err
+	})
+	return value, err
+}
+
+// Incr adds 1 to the integer value of the key and returns the new value, see Batch.IncrBy.
+func (db *DB) Incr(key []byte) (int64, error) {
+	return db.IncrBy(key, 1)
+}
+
+// IncrByFloat adds delta to the float value of the key and returns the new value, see Batch.IncrByFloat.
+func (db *DB) IncrByFloat(key []byte, delta float64) (float64, error) {
+	var value float64
+	err := db.update(func(batch *Batch) error {
+		var err error
+		value, err = batch.IncrByFloat(key, delta)
+		return err
+	})
+	return value, err
+}
+
+// CompareAndSwap sets the key to value if its current value is expected, see Batch.CompareAndSwap.
+func (db *DB) CompareAndSwap(key, expected, value []byte) error {
+	return db.update(func(batch *Batch) error {
+		return batch.CompareAndSwap(key, expected, value)
+	})
+}
+
+// PutIfAbsent sets the key to value if it does not exist, see Batch.PutIfAbsent.
+func (db *DB) PutIfAbsent(key, value []byte) error {
+	return db.update(func(batch *Batch) error {
+		return batch.PutIfAbsent(key, value)
+	})
+}
+
+// PutIfExists sets the key to value if it exists, see Batch.PutIfExists.
+func (db *DB) PutIfExists(key, value []byte) error {
+	return db.update(func(batch *Batch) error {
+		return batch.PutIfExists(key, value)
+	})
+}
+
+// GetAndSet sets the key to value and returns its former value, see Batch.GetAndSet.
+func (db *DB) GetAndSet(key, value []byte) ([]byte, error) {
+	var former []byte
+	err := db.update(func(batch *Batch) error {
+		var err error
+		former, err = batch.GetAndSet(key, value)
+		return err
+	})
+	return former, err
+}
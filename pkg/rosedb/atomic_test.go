@@ -0,0 +1,181 @@
+// Copyright 2024 Joy <joyssss94@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package rosedb
+
+import (
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_IncrBy(t *testing.T) {
+	db, err := Open(DefaultOptions)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	key := []byte("counter")
+	value, err := db.Incr(key)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), value)
+	value, err = db.IncrBy(key, -11)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(-10), value)
+	stored, err := db.Get(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("-10"), stored)
+
+	// the ttl is kept
+	assert.Nil(t, db.Expire(key, time.Hour))
+	_, err = db.Incr(key)
+	assert.Nil(t, err)
+	ttl, err := db.TTL(key)
+	assert.Nil(t, err)
+	assert.True(t, ttl > 59*time.Minute)
+
+	assert.Nil(t, db.Put(key, []byte("a")))
+	_, err = db.Incr(key)
+	assert.Equal(t, ErrValueNotInteger, err)
+	assert.Nil(t, db.Put(key, []byte("9223372036854775807")))
+	_, err = db.Incr(key)
+	assert.Equal(t, ErrIncrOverflow, err)
+	_, err = db.IncrBy(nil, 1)
+	assert.Equal(t, ErrKeyIsEmpty, err)
+}
+
+func TestDB_Incr_Concurrent(t *testing.T) {
+	db, err := Open(DefaultOptions)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	key := []byte("counter")
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				_, err := db.Incr(key)
+				assert.Nil(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+	value, err := db.IncrBy(key, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1000), value)
+}
+
+func TestDB_IncrByFloat(t *testing.T) {
+	db, err := Open(DefaultOptions)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	key := []byte("float")
+	value, err := db.IncrByFloat(key, 1.5)
+	assert.Nil(t, err)
+	assert.Equal(t, 1.5, value)
+	assert.Nil(t, db.Put(key, []byte("10")))
+	value, err = db.IncrByFloat(key, -0.25)
+	assert.Nil(t, err)
+	assert.Equal(t, 9.75, value)
+	stored, err := db.Get(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("9.75"), stored)
+
+	_, err = db.IncrByFloat(key, math.Inf(1))
+	assert.Equal(t, ErrIncrOverflow, err)
+	assert.Nil(t, db.Put(key, []byte("a")))
+	_, err = db.IncrByFloat(key, 1)
+	assert.Equal(t, ErrValueNotFloat, err)
+}
+
+func TestDB_CompareAndSwap(t *testing.T) {
+	db, err := Open(DefaultOptions)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	key := []byte("key")
+	err = db.CompareAndSwap(key, []byte("a"), []byte("b"))
+	assert.Equal(t, ErrPreconditionFailed, err)
+	assert.Nil(t, db.Put(key, []byte("a")))
+	err = db.CompareAndSwap(key, []byte("x"), []byte("b"))
+	assert.Equal(t, ErrPreconditionFailed, err)
+	assert.Nil(t, db.CompareAndSwap(key, []byte("a"), []byte("b")))
+	value, err := db.Get(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("b"), value)
+}
+
+func TestDB_PutIfAbsent_PutIfExists(t *testing.T) {
+	db, err := Open(DefaultOptions)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	key := []byte("key")
+	assert.Equal(t, ErrPreconditionFailed, db.PutIfExists(key, []byte("a")))
+	_, err = db.Get(key)
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	assert.Nil(t, db.PutIfAbsent(key, []byte("a")))
+	assert.Equal(t, ErrPreconditionFailed, db.PutIfAbsent(key, []byte("b")))
+	value, err := db.Get(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("a"), value)
+
+	assert.Nil(t, db.PutIfExists(key, []byte("c")))
+	value, err = db.Get(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("c"), value)
+
+	// an expired key is absent
+	assert.Nil(t, db.PutWithTTL(key, []byte("d"), time.Millisecond))
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, ErrPreconditionFailed, db.PutIfExists(key, []byte("e")))
+	assert.Nil(t, db.PutIfAbsent(key, []byte("e")))
+}
+
+func TestDB_GetAndSet(t *testing.T) {
+	db, err := Open(DefaultOptions)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	key := []byte("key")
+	former, err := db.GetAndSet(key, []byte("a"))
+	assert.Nil(t, err)
+	assert.Nil(t, former)
+	former, err = db.GetAndSet(key, []byte("b"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("a"), former)
+	value, err := db.Get(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("b"), value)
+}
+
+func TestBatch_IncrBy(t *testing.T) {
+	db, err := Open(DefaultOptions)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	// the writes of the batch are seen by the later operations
+	key := []byte("counter")
+	batch := db.NewBatch(DefaultBatchOptions)
+	assert.Nil(t, batch.Put(key, []byte("5")))
+	value, err := batch.IncrBy(key, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(7), value)
+	assert.Nil(t, batch.CompareAndSwap(key, []byte("7"), []byte("8")))
+	assert.Nil(t, batch.Commit())
+	stored, err := db.Get(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("8"), stored)
+
+	batch = db.NewBatch(BatchOptions{ReadOnly: true})
+	_, err = batch.Incr(key)
+	assert.Equal(t, ErrReadOnlyBatch, err)
+	assert.Nil(t, batch.Commit())
+}
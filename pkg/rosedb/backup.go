@@ -0,0 +1,394 @@
+// Copyright 2024 Joy <joyssss94@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package rosedb
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/JoyZF/zoom/pkg/wal"
+)
+
+const (
+	backupManifestName = "BACKUP"
+	backupTempSuffix   = ".tmp"
+)
+
+// BackupManifest describes the files of a backup, it is stored in the backup directory.
+type BackupManifest struct {
+	// CutSegmentId is the id of the last data segment in the backup,
+	// all the writes after the cut point are not included.
+	CutSegmentId wal.SegmentID `json:"cut_segment_id"`
+	// CreatedAt is the time the cut point was taken.
+	CreatedAt time.Time `json:"created_at"`
+	// Incremental reports whether the last backup into the directory was incremental.
+	Incremental bool         `json:"incremental"`
+	Files       []BackupFile `json:"files"`
+}
+
+// BackupFile is a file in the backup.
+type BackupFile struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	CRC32   uint32    `json:"crc32"`
+	ModTime time.Time `json:"mod_time"` // modification time of the source file
+}
+
+// backupSource is a file to be backed up, opened while the db lock is held,
+// so it is not affected by a Merge replacing the files in the db directory.
+type backupSource struct {
+	name string
+	file *os.File
+	info os.FileInfo
+}
+
+// Backup copies the db into destDir, which must not exist or be empty.
+// The db keeps serving reads and writes during the backup.
+//
+// The active segment is rotated first, and the backup contains all the data
+// written before that point, the writes after it are not included.
+// The copy is consistent and can be opened directly or restored by RestoreBackup,
+// with the same KeyProvider if the db is encrypted.
+func (db *DB) Backup(destDir string) (*BackupManifest, error) {
+	if err := checkBackupDir(destDir); err != nil {
+		return nil, err
+	}
+	return db.backup(destDir, nil, false)
+}
+
+// IncrementalBackup updates the backup in destDir, which is created by Backup before.
+// Only the segments written since the last backup, and the files rewritten by Merge, are copied.
+func (db *DB) IncrementalBackup(destDir string) (*BackupManifest, error) {
+	last, err := readBackupManifest(destDir)
+	if err != nil {
+		return nil, err
+	}
+	return db.backup(destDir, last, false)
+}
+
+// Checkpoint is like Backup, but hard links the files into destDir instead of copying them,
+// so it is fast and takes little extra space. destDir must be on the same file system as the db,
+// otherwise the files are copied.
+//
+// The files in the db directory are never modified in place once the active segment is rotated,
+// Merge replaces them with new files, so the checkpoint stays consistent.
+// The last segment is copied, so the checkpoint can be opened and written without touching the db.
+func (db *DB) Checkpoint(destDir string) (*BackupManifest, error) {
+	if err := checkBackupDir(destDir); err != nil {
+		return nil, err
+	}
+	return db.backup(destDir, nil, true)
+}
+
+func (db *DB) backup(destDir string, last *BackupManifest, link bool) (*BackupManifest, error) {
+	manifest, sources, err := db.backupSources()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		for _, source := range sources {
+			_ = source.file.Close()
+		}
+	}()
+
+	if err = os.MkdirAll(destDir, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	cutSegmentName := wal.SegmentFileName("", dataFileNameSuffix, manifest.CutSegmentId)
+	lastFiles := make(map[string]BackupFile)
+	if last != nil {
+		manifest.Incremental = true
+		for _, file := range last.Files {
+			lastFiles[file.Name] = file
+		}
+	}
+	for _, source := range sources {
+		// the file is unchanged since the last backup
+		if file, ok := lastFiles[source.name]; ok &&
+			file.Size == source.info.Size() && file.ModTime.Equal(source.info.ModTime()) {
+			manifest.Files = append(manifest.Files, file)
+			delete(lastFiles, source.name)
+			continue
+		}
+		delete(lastFiles, source.name)
+
+		file := BackupFile{Name: source.name, Size: source.info.Size(), ModTime: source.info.ModTime()}
+		destPath := filepath.Join(destDir, source.name)
+		// the last segment is always copied, because it becomes the active segment
+		// if the backup is opened, and writes to it must not go to the db.
+		if link && source.name != cutSegmentName {
+			file.CRC32, err = linkFile(filepath.Join(db.options.DirPath, source.name), destPath, source.file)
+		} else {
+			file.CRC32, err = copyFile(source.file, destPath)
+		}
+		if err != nil {
+			return nil, err
+		}
+		manifest.Files = append(manifest.Files, file)
+	}
+
+	// remove the files which are no longer in the db, e.g. the segments removed by Merge.
+	for name := range lastFiles {
+		if err = os.Remove(filepath.Join(destDir, name)); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	// the manifest is written at last, so a backup without it is incomplete.
+	if err = writeBackupManifest(destDir, manifest); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// backupSources rotates the active segment and opens all the files to be backed up.
+func (db *DB) backupSources() (*BackupManifest, []*backupSource, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed {
+		return nil, nil, ErrDBClosed
+	}
+
+	// the batches hold the db lock until they are committed,
+	// so no batch is half written at the cut point.
+	cutSegmentId := db.dataFiles.ActiveSegmentID()
+	if !db.dataFiles.IsEmpty() {
+		if err := db.dataFiles.OpenNewActiveSegment(); err != nil {
+			return nil, nil, err
+		}
+	}
+	// the blob files are sealed at the cut point as well, the sealed ones are never written again.
+	cutBlobId, err := db.blobs.seal()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = db.blobs.persist(); err != nil {
+		return nil, nil, err
+	}
+	manifest := &BackupManifest{CutSegmentId: cutSegmentId, CreatedAt: time.Now()}
+
+	entries, err := os.ReadDir(db.options.DirPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	var sources []*backupSource
+	closeSources := func() {
+		for _, source := range sources {
+			_ = source.file.Close()
+		}
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !isBackupFile(entry.Name(), cutSegmentId, cutBlobId) {
+			continue
+		}
+		file, err := os.Open(filepath.Join(db.options.DirPath, entry.Name()))
+		if err != nil {
+			closeSources()
+			return nil, nil, err
+		}
+		info, err := file.Stat()
+		if err != nil {
+			_ = file.Close()
+			closeSources()
+			return nil, nil, err
+		}
+		sources = append(sources, &backupSource{name: entry.Name(), file: file, info: info})
+	}
+	return manifest, sources, nil
+}
+
+// isBackupFile reports whether the file in the db directory belongs to the backup.
+func isBackupFile(name string, cutSegmentId wal.SegmentID, cutBlobId uint32) bool {
+	if strings.HasSuffix(name, dataFileNameSuffix) {
+		var id wal.SegmentID
+		if _, err := fmt.Sscanf(name, "%d"+dataFileNameSuffix, &id); err != nil {
+			return false
+		}
+		return id <= cutSegmentId
+	}
+	if strings.HasSuffix(name, blobFileNameSuffix) {
+		var id uint32
+		if _, err := fmt.Sscanf(name, "%d"+blobFileNameSuffix, &id); err != nil {
+			return false
+		}
+		return id <= cutBlobId
+	}
+	return strings.HasSuffix(name, hintFileNameSuffix) || strings.HasSuffix(name, mergeFinNameSuffix) ||
+		name == blobStatFileName || name == namespaceFileName
+}
+
+// RestoreBackup validates the backup in backupDir, and copies it into dirPath,
+// which must not exist or be empty. The db can be opened in dirPath after that.
+func RestoreBackup(backupDir, dirPath string) (*BackupManifest, error) {
+	manifest, err := VerifyBackup(backupDir)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkBackupDir(dirPath); err != nil {
+		return nil, err
+	}
+	if err = os.MkdirAll(dirPath, os.ModePerm); err != nil {
+		return nil, err
+	}
+	for _, file := range manifest.Files {
+		src, err := os.Open(filepath.Join(backupDir, file.Name))
+		if err != nil {
+			return nil, err
+		}
+		_, err = copyFile(src, filepath.Join(dirPath, file.Name))
+		_ = src.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return manifest, nil
+}
+
+// VerifyBackup checks that all the files of the backup in dir exist and match their checksums.
+func VerifyBackup(dir string) (*BackupManifest, error) {
+	manifest, err := readBackupManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	var hasSegment bool
+	for _, file := range manifest.Files {
+		if strings.HasSuffix(file.Name, dataFileNameSuffix) {
+			hasSegment = true
+		}
+		f, err := os.Open(filepath.Join(dir, file.Name))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+		}
+		hash := crc32.NewIEEE()
+		size, err := io.Copy(hash, f)
+		_ = f.Close()
+		if err != nil {
+			return nil, err
+		}
+		if size != file.Size || hash.Sum32() != file.CRC32 {
+			return nil, fmt.Errorf("%w: %s is corrupted", ErrInvalidBackup, file.Name)
+		}
+	}
+	if !hasSegment && manifest.CutSegmentId > 0 {
+		return nil, fmt.Errorf("%w: no data segment", ErrInvalidBackup)
+	}
+	return manifest, nil
+}
+
+// checkBackupDir returns ErrBackupDirNotEmpty if dir contains any file.
+func checkBackupDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(entries) > 0 {
+		return ErrBackupDirNotEmpty
+	}
+	return nil
+}
+
+func readBackupManifest(dir string) (*BackupManifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, backupManifestName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%w: manifest not found", ErrInvalidBackup)
+		}
+		return nil, err
+	}
+	manifest := &BackupManifest{}
+	if err = json.Unmarshal(data, manifest); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+	}
+	return manifest, nil
+}
+
+func writeBackupManifest(dir string, manifest *BackupManifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, backupManifestName)
+	if err = writeFileSync(path+backupTempSuffix, data); err != nil {
+		return err
+	}
+	return os.Rename(path+backupTempSuffix, path)
+}
+
+func writeFileSync(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// copyFile copies the content of src into a new file at destPath, and returns the crc32 of it.
+// The content is written to a temporary file first, so destPath is either the old or the new file.
+func copyFile(src *os.File, destPath string) (uint32, error) {
+	dest, err := os.OpenFile(destPath+backupTempSuffix, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return 0, err
+	}
+	hash := crc32.NewIEEE()
+	_, err = io.Copy(io.MultiWriter(dest, hash), io.NewSectionReader(src, 0, 1<<62))
+	if err == nil {
+		err = dest.Sync()
+	}
+	if closeErr := dest.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(destPath + backupTempSuffix)
+		return 0, err
+	}
+	return hash.Sum32(), os.Rename(destPath+backupTempSuffix, destPath)
+}
+
+// linkFile hard links srcPath to destPath, or copies src if the link fails,
+// and returns the crc32 of the file.
+func linkFile(srcPath, destPath string, src *os.File) (uint32, error) {
+	_ = os.Remove(destPath)
+	if err := os.Link(srcPath, destPath); err != nil {
+		return copyFile(src, destPath)
+	}
+	// make sure the linked file is the one opened at the cut point,
+	// it may have been replaced by Merge since then.
+	linked, err := os.Stat(destPath)
+	if err != nil {
+		return 0, err
+	}
+	srcInfo, err := src.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if !os.SameFile(linked, srcInfo) {
+		_ = os.Remove(destPath)
+		return copyFile(src, destPath)
+	}
+	hash := crc32.NewIEEE()
+	if _, err = io.Copy(hash, io.NewSectionReader(src, 0, 1<<62)); err != nil {
+		return 0, err
+	}
+	return hash.Sum32(), nil
+}
@@ -0,0 +1,228 @@
+// Copyright 2024 Joy <joyssss94@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package rosedb
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/JoyZF/zoom/pkg/wal"
+	"github.com/JoyZF/zoom/utils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func backupTestDir(t *testing.T, name string) string {
+	dir := filepath.Join(os.TempDir(), "rosedb-"+name)
+	_ = os.RemoveAll(dir)
+	t.Cleanup(func() {
+		_ = os.RemoveAll(dir)
+	})
+	return dir
+}
+
+// assertBackupContent opens the backup and checks that it contains exactly the given keys.
+func assertBackupContent(t *testing.T, dir string, values map[string][]byte) {
+	options := DefaultOptions
+	options.DirPath = dir
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer func() {
+		_ = db.Close()
+	}()
+	assert.Equal(t, len(values), db.Stat().KeysNum)
+	for key, value := range values {
+		val, err := db.Get([]byte(key))
+		assert.Nil(t, err)
+		assert.Equal(t, value, val)
+	}
+}
+
+// assertRestoredContent restores the backup into a new directory, and checks its content there,
+// as opening the backup itself writes the files of the db into it.
+func assertRestoredContent(t *testing.T, backupDir string, values map[string][]byte) {
+	dir := filepath.Join(t.TempDir(), "restored")
+	_, err := RestoreBackup(backupDir, dir)
+	assert.Nil(t, err)
+	assertBackupContent(t, dir, values)
+}
+
+func TestDB_Backup_Restore(t *testing.T) {
+	options := DefaultOptions
+	options.SegmentSize = 32 * wal.KB
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	values := make(map[string][]byte)
+	for i := 0; i < 1000; i++ {
+		key, value := utils.GetTestKey(i), utils.RandomValue(128)
+		values[string(key)] = value
+		assert.Nil(t, db.Put(key, value))
+	}
+
+	backupDir := backupTestDir(t, "backup")
+	manifest, err := db.Backup(backupDir)
+	assert.Nil(t, err)
+	assert.False(t, manifest.Incremental)
+	assert.True(t, manifest.CutSegmentId > 1)
+
+	// the writes after the cut point are not in the backup
+	assert.Nil(t, db.Put([]byte("after-backup"), []byte("value")))
+	_, err = db.Backup(backupDir)
+	assert.Equal(t, ErrBackupDirNotEmpty, err)
+
+	restoreDir := backupTestDir(t, "restore")
+	restored, err := RestoreBackup(backupDir, restoreDir)
+	assert.Nil(t, err)
+	assert.Equal(t, manifest.CutSegmentId, restored.CutSegmentId)
+	assertBackupContent(t, restoreDir, values)
+
+	// a corrupted backup can not be restored
+	segment := wal.SegmentFileName(backupDir, dataFileNameSuffix, 1)
+	data, err := os.ReadFile(segment)
+	assert.Nil(t, err)
+	data[len(data)/2] ^= 0xff
+	assert.Nil(t, os.WriteFile(segment, data, 0644))
+	_, err = RestoreBackup(backupDir, backupTestDir(t, "restore-corrupted"))
+	assert.True(t, errors.Is(err, ErrInvalidBackup))
+
+	_, err = VerifyBackup(backupTestDir(t, "not-exist"))
+	assert.True(t, errors.Is(err, ErrInvalidBackup))
+}
+
+func TestDB_IncrementalBackup(t *testing.T) {
+	options := DefaultOptions
+	options.SegmentSize = 32 * wal.KB
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	values := make(map[string][]byte)
+	put := func(from, to int) {
+		for i := from; i < to; i++ {
+			key, value := utils.GetTestKey(i), utils.RandomValue(128)
+			values[string(key)] = value
+			assert.Nil(t, db.Put(key, value))
+		}
+	}
+
+	backupDir := backupTestDir(t, "incremental")
+	_, err = db.IncrementalBackup(backupDir)
+	assert.True(t, errors.Is(err, ErrInvalidBackup))
+
+	put(0, 500)
+	first, err := db.Backup(backupDir)
+	assert.Nil(t, err)
+	firstFiles := make(map[string]BackupFile)
+	for _, file := range first.Files {
+		firstFiles[file.Name] = file
+	}
+
+	// only the new segments are copied, the old ones are left as they are
+	put(500, 1000)
+	second, err := db.IncrementalBackup(backupDir)
+	assert.Nil(t, err)
+	assert.True(t, second.Incremental)
+	assert.True(t, second.CutSegmentId > first.CutSegmentId)
+	for _, file := range second.Files {
+		if old, ok := firstFiles[file.Name]; ok {
+			assert.Equal(t, old.CRC32, file.CRC32)
+			assert.True(t, old.ModTime.Equal(file.ModTime))
+		}
+	}
+	_, err = VerifyBackup(backupDir)
+	assert.Nil(t, err)
+	assertRestoredContent(t, backupDir, values)
+
+	// the segments rewritten by merge are copied again, and the removed ones are removed
+	for i := 0; i < 500; i++ {
+		key := utils.GetTestKey(i)
+		delete(values, string(key))
+		assert.Nil(t, db.Delete(key))
+	}
+	assert.Nil(t, db.Merge(true))
+	third, err := db.IncrementalBackup(backupDir)
+	assert.Nil(t, err)
+	names := make(map[string]bool)
+	for _, file := range third.Files {
+		names[file.Name] = true
+	}
+	entries, err := os.ReadDir(backupDir)
+	assert.Nil(t, err)
+	for _, entry := range entries {
+		if entry.Name() != backupManifestName && entry.Name() != fileLockName {
+			assert.True(t, names[entry.Name()], entry.Name())
+		}
+	}
+	_, err = VerifyBackup(backupDir)
+	assert.Nil(t, err)
+	assertRestoredContent(t, backupDir, values)
+}
+
+func TestDB_Checkpoint(t *testing.T) {
+	options := DefaultOptions
+	options.SegmentSize = 32 * wal.KB
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	values := make(map[string][]byte)
+	for i := 0; i < 1000; i++ {
+		key, value := utils.GetTestKey(i), utils.RandomValue(128)
+		values[string(key)] = value
+		assert.Nil(t, db.Put(key, value))
+	}
+
+	// keep writing during the checkpoint
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 1000; i < 2000; i++ {
+			assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+		}
+	}()
+	checkpointDir := backupTestDir(t, "checkpoint")
+	manifest, err := db.Checkpoint(checkpointDir)
+	assert.Nil(t, err)
+	wg.Wait()
+
+	// the sealed segments are linked, the last one is copied
+	for _, file := range manifest.Files {
+		src, err := os.Stat(filepath.Join(options.DirPath, file.Name))
+		assert.Nil(t, err)
+		dest, err := os.Stat(filepath.Join(checkpointDir, file.Name))
+		assert.Nil(t, err)
+		last := file.Name == wal.SegmentFileName("", dataFileNameSuffix, manifest.CutSegmentId)
+		assert.Equal(t, !last, os.SameFile(src, dest), file.Name)
+	}
+	_, err = VerifyBackup(checkpointDir)
+	assert.Nil(t, err)
+
+	// the checkpoint contains the data before the cut point, and maybe some written concurrently
+	checkpointOptions := DefaultOptions
+	checkpointOptions.DirPath = checkpointDir
+	checkpoint, err := Open(checkpointOptions)
+	assert.Nil(t, err)
+	for key, value := range values {
+		val, err := checkpoint.Get([]byte(key))
+		assert.Nil(t, err)
+		assert.Equal(t, value, val)
+	}
+	// writing to the checkpoint does not affect the db
+	assert.Nil(t, checkpoint.Put([]byte("checkpoint-only"), []byte("value")))
+	assert.Nil(t, checkpoint.Close())
+
+	assert.Nil(t, db.Close())
+	db, err = Open(options)
+	assert.Nil(t, err)
+	_, err = db.Get([]byte("checkpoint-only"))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Equal(t, 2000, db.Stat().KeysNum)
+}
@@ -8,10 +8,14 @@ import (
 	"bytes"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/bwmarrin/snowflake"
 	"github.com/valyala/bytebufferpool"
+
+	"github.com/JoyZF/zoom/pkg/rosedb/index"
+	"github.com/JoyZF/zoom/pkg/wal"
 )
 
 type Batch struct {
@@ -28,6 +32,10 @@ type Batch struct {
 type BatchOptions struct {
 	Sync     bool
 	ReadOnly bool
+	// Now is the time the batch computes the expiration times from, and checks them against.
+	// The zero time means the current time of each operation.
+	// A replicated log sets it to the time of its entry, so the batch has the same effect on every replica.
+	Now time.Time
 }
 
 // NewBatch creates a new Batch instance.
@@ -84,8 +92,17 @@ func (b *Batch) reset() {
 	b.buffers = b.buffers[:0]
 }
 
+// now returns BatchOptions.Now, or the current time if it is not set.
+func (b *Batch) now() time.Time {
+	if !b.options.Now.IsZero() {
+		return b.options.Now
+	}
+	return time.Now()
+}
+
 // lock if readonly is true, use RLock else use Lock
 func (b *Batch) lock() {
+	atomic.AddUint64(&b.db.requests, 1)
 	if b.options.ReadOnly {
 		b.db.mu.RLock()
 	} else {
@@ -101,28 +118,30 @@ func (b *Batch) unlock() {
 		b.db.mu.Unlock()
 	}
 }
+
 func (b *Batch) Put(key []byte, value []byte) error {
+	return b.put(defaultNamespaceID, key, value)
+}
+
+func (b *Batch) put(ns uint32, key []byte, value []byte) error {
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
 	}
 	if b.db.closed {
 		return ErrDBClosed
 	}
+	if b.db.namespaceIndex(ns) == nil {
+		return ErrNamespaceNotFound
+	}
 	if b.options.ReadOnly {
 		return ErrReadOnlyBatch
 	}
 
 	b.mu.Lock()
 	// write to pendingWrites
-	var record *LogRecord
 	// 如果已存在在pendingWrites  更新value
 	// if the key exists in pendingWrites, update the value directly
-	for i := len(b.pendingWrites) - 1; i >= 0; i-- {
-		if bytes.Equal(key, b.pendingWrites[i].Key) {
-			record = b.pendingWrites[i]
-			break
-		}
-	}
+	record := b.lookupPendingWrite(ns, key)
 	if record == nil {
 		// if the key does not exist in pendingWrites, write a new record
 		// 不存在 写入一条记录
@@ -131,33 +150,35 @@ func (b *Batch) Put(key []byte, value []byte) error {
 		b.pendingWrites = append(b.pendingWrites, record)
 	}
 
-	record.Key, record.Value = key, value
-	record.Type, record.Expire = LogRecordNormal, 0
+	record.Key, record.Value, record.namespace = key, value, ns
+	record.Type, record.Expire, record.blob = LogRecordNormal, 0, false
 	b.mu.Unlock()
 
 	return nil
 }
+
 func (b *Batch) PutWithTTL(key []byte, value []byte, ttl time.Duration) error {
+	return b.putWithTTL(defaultNamespaceID, key, value, ttl)
+}
+
+func (b *Batch) putWithTTL(ns uint32, key []byte, value []byte, ttl time.Duration) error {
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
 	}
 	if b.db.closed {
 		return ErrDBClosed
 	}
+	if b.db.namespaceIndex(ns) == nil {
+		return ErrNamespaceNotFound
+	}
 	if b.options.ReadOnly {
 		return ErrReadOnlyBatch
 	}
 
 	b.mu.Lock()
 	// write to pendingWrites
-	var record *LogRecord
 	// if the key exists in pendingWrites, update the value directly
-	for i := len(b.pendingWrites) - 1; i >= 0; i-- {
-		if bytes.Equal(key, b.pendingWrites[i].Key) {
-			record = b.pendingWrites[i]
-			break
-		}
-	}
+	record := b.lookupPendingWrite(ns, key)
 	if record == nil {
 		// if the key does not exist in pendingWrites, write a new record
 		// the record will be put back to the pool when the batch is committed or rollbacked
@@ -165,31 +186,33 @@ func (b *Batch) PutWithTTL(key []byte, value []byte, ttl time.Duration) error {
 		b.pendingWrites = append(b.pendingWrites, record)
 	}
 
-	record.Key, record.Value = key, value
-	record.Type, record.Expire = LogRecordNormal, time.Now().Add(ttl).UnixNano()
+	record.Key, record.Value, record.namespace = key, value, ns
+	record.Type, record.Expire, record.blob = LogRecordNormal, b.now().Add(ttl).UnixNano(), false
 	b.mu.Unlock()
 
 	return nil
 }
 
 func (b *Batch) Get(key []byte) ([]byte, error) {
+	return b.get(defaultNamespaceID, key)
+}
+
+func (b *Batch) get(ns uint32, key []byte) ([]byte, error) {
 	if len(key) == 0 {
 		return nil, ErrKeyIsEmpty
 	}
 	if b.db.closed {
 		return nil, ErrDBClosed
 	}
+	idx := b.db.namespaceIndex(ns)
+	if idx == nil {
+		return nil, ErrNamespaceNotFound
+	}
 
-	now := time.Now().UnixNano()
+	now := b.now().UnixNano()
 	// get from pendingWrites
 	b.mu.Lock()
-	var record *LogRecord
-	for i := len(b.pendingWrites) - 1; i >= 0; i-- {
-		if bytes.Equal(key, b.pendingWrites[i].Key) {
-			record = b.pendingWrites[i]
-			break
-		}
-	}
+	record := b.lookupPendingWrite(ns, key)
 	b.mu.Unlock()
 
 	// if the record is in pendingWrites, return the value directly
@@ -197,83 +220,109 @@ func (b *Batch) Get(key []byte) ([]byte, error) {
 		if record.Type == LogRecordDeleted || record.IsExpired(now) {
 			return nil, ErrKeyNotFound
 		}
+		// the records rewritten by Expire and Persist keep their values in the blob files.
+		if record.blob {
+			loaded := *record
+			if err := b.db.loadValue(&loaded); err != nil {
+				return nil, err
+			}
+			return loaded.Value, nil
+		}
 		return record.Value, nil
 	}
 
 	// get key/value from data file
-	chunkPosition := b.db.index.Get(key)
+	chunkPosition := idx.Get(key)
 	if chunkPosition == nil {
 		return nil, ErrKeyNotFound
 	}
-	chunk, err := b.db.dataFiles.Read(chunkPosition)
+	return b.db.readValue(chunkPosition, now)
+}
+
+// readValue reads the value of the record at the position in the data files,
+// it returns ErrKeyNotFound if the record is expired at now.
+func (db *DB) readValue(chunkPosition *wal.ChunkPosition, now int64) ([]byte, error) {
+	chunk, err := db.dataFiles.Read(chunkPosition)
 	if err != nil {
 		return nil, err
 	}
 
 	// check if the record is deleted or expired
-	record = decodeLogRecord(chunk)
+	record, err := decodeLogRecord(chunk, db.cipher)
+	if err != nil {
+		return nil, err
+	}
 	if record.Type == LogRecordDeleted {
 		panic("Deleted data cannot exist in the index")
 	}
 	if record.IsExpired(now) {
-		b.db.index.Delete(record.Key)
+		db.discardExpired(record.Key, record)
 		return nil, ErrKeyNotFound
 	}
+	if err := db.loadValue(record); err != nil {
+		return nil, err
+	}
 	return record.Value, nil
 }
 
 func (b *Batch) Delete(key []byte) error {
+	return b.delete(defaultNamespaceID, key)
+}
+
+func (b *Batch) delete(ns uint32, key []byte) error {
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
 	}
 	if b.db.closed {
 		return ErrDBClosed
 	}
+	if b.db.namespaceIndex(ns) == nil {
+		return ErrNamespaceNotFound
+	}
 	if b.options.ReadOnly {
 		return ErrReadOnlyBatch
 	}
 
 	b.mu.Lock()
 	// only need key and type when deleting a value.
-	var exist bool
-	for i := len(b.pendingWrites) - 1; i >= 0; i-- {
-		if bytes.Equal(key, b.pendingWrites[i].Key) {
-			// 标记删除
-			b.pendingWrites[i].Type = LogRecordDeleted
-			b.pendingWrites[i].Value = nil
-			b.pendingWrites[i].Expire = 0
-			exist = true
-			break
-		}
-	}
-	if !exist {
+	if record := b.lookupPendingWrite(ns, key); record != nil {
+		// 标记删除
+		record.Type = LogRecordDeleted
+		record.Value = nil
+		record.Expire = 0
+		record.blob = false
+	} else {
 		// 加入一条删除的记录 ？ 为什么不直接返回key 不存在
 		b.pendingWrites = append(b.pendingWrites, &LogRecord{
-			Key:  key,
-			Type: LogRecordDeleted,
+			Key:       key,
+			Type:      LogRecordDeleted,
+			namespace: ns,
 		})
 	}
 	b.mu.Unlock()
 	return nil
 }
+
 func (b *Batch) Exist(key []byte) (bool, error) {
+	return b.exist(defaultNamespaceID, key)
+}
+
+func (b *Batch) exist(ns uint32, key []byte) (bool, error) {
 	if len(key) == 0 {
 		return false, ErrKeyIsEmpty
 	}
 	if b.db.closed {
 		return false, ErrDBClosed
 	}
+	idx := b.db.namespaceIndex(ns)
+	if idx == nil {
+		return false, ErrNamespaceNotFound
+	}
 
-	now := time.Now().UnixNano()
+	now := b.now().UnixNano()
 	// check if the key exists in pendingWrites
 	b.mu.RLock()
-	var record *LogRecord
-	for i := len(b.pendingWrites) - 1; i >= 0; i-- {
-		if bytes.Equal(key, b.pendingWrites[i].Key) {
-			record = b.pendingWrites[i]
-			break
-		}
-	}
+	record := b.lookupPendingWrite(ns, key)
 	b.mu.RUnlock()
 
 	if record != nil {
@@ -282,7 +331,7 @@ func (b *Batch) Exist(key []byte) (bool, error) {
 
 	// check if the key exists in index
 	// 不在索引上
-	position := b.db.index.Get(key)
+	position := idx.Get(key)
 	if position == nil {
 		return false, nil
 	}
@@ -294,20 +343,31 @@ func (b *Batch) Exist(key []byte) (bool, error) {
 		return false, err
 	}
 
-	record = decodeLogRecord(chunk)
+	if record, err = decodeLogRecord(chunk, b.db.cipher); err != nil {
+		return false, err
+	}
 	if record.Type == LogRecordDeleted || record.IsExpired(now) {
-		b.db.index.Delete(record.Key)
+		b.db.discardExpired(record.Key, record)
 		return false, nil
 	}
 	return true, nil
 }
+
 func (b *Batch) Expire(key []byte, ttl time.Duration) error {
+	return b.expire(defaultNamespaceID, key, ttl)
+}
+
+func (b *Batch) expire(ns uint32, key []byte, ttl time.Duration) error {
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
 	}
 	if b.db.closed {
 		return ErrDBClosed
 	}
+	idx := b.db.namespaceIndex(ns)
+	if idx == nil {
+		return ErrNamespaceNotFound
+	}
 	if b.options.ReadOnly {
 		return ErrReadOnlyBatch
 	}
@@ -315,24 +375,18 @@ func (b *Batch) Expire(key []byte, ttl time.Duration) error {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
-	var record *LogRecord
-	for i := len(b.pendingWrites) - 1; i >= 0; i-- {
-		if bytes.Equal(key, b.pendingWrites[i].Key) {
-			record = b.pendingWrites[i]
-			break
-		}
-	}
+	record := b.lookupPendingWrite(ns, key)
 
 	// if the key exists in pendingWrites, update the expiry time directly
 	if record != nil {
 		// return key not found if the record is deleted or expired
-		if record.Type == LogRecordDeleted || record.IsExpired(time.Now().UnixNano()) {
+		if record.Type == LogRecordDeleted || record.IsExpired(b.now().UnixNano()) {
 			return ErrKeyNotFound
 		}
-		record.Expire = time.Now().Add(ttl).UnixNano()
+		record.Expire = b.now().Add(ttl).UnixNano()
 	} else {
 		// if the key does not exist in pendingWrites, get the value from wal
-		position := b.db.index.Get(key)
+		position := idx.Get(key)
 		if position == nil {
 			return ErrKeyNotFound
 		}
@@ -341,16 +395,24 @@ func (b *Batch) Expire(key []byte, ttl time.Duration) error {
 			return err
 		}
 
-		now := time.Now()
-		record = decodeLogRecord(chunk)
+		now := b.now()
+		if record, err = decodeLogRecord(chunk, b.db.cipher); err != nil {
+			return err
+		}
 		// if the record is deleted or expired, we can assume that the key does not exist,
 		// and delete the key from the index
 		if record.Type == LogRecordDeleted || record.IsExpired(now.UnixNano()) {
-			b.db.index.Delete(key)
+			b.db.discardExpired(key, record)
 			return ErrKeyNotFound
 		}
 		// now we get the value from wal, update the expiry time
-		// and rewrite the record to pendingWrites
+		// and rewrite the record to pendingWrites,
+		// a value in the blob files is not moved, the record keeps pointing to it.
+		if !record.blob {
+			if err := record.decompress(); err != nil {
+				return err
+			}
+		}
 		record.Expire = now.Add(ttl).UnixNano()
 		b.pendingWrites = append(b.pendingWrites, record)
 	}
@@ -358,26 +420,28 @@ func (b *Batch) Expire(key []byte, ttl time.Duration) error {
 }
 
 func (b *Batch) TTL(key []byte) (time.Duration, error) {
+	return b.ttl(defaultNamespaceID, key)
+}
+
+func (b *Batch) ttl(ns uint32, key []byte) (time.Duration, error) {
 	if len(key) == 0 {
 		return -1, ErrKeyIsEmpty
 	}
 	if b.db.closed {
 		return -1, ErrDBClosed
 	}
+	idx := b.db.namespaceIndex(ns)
+	if idx == nil {
+		return -1, ErrNamespaceNotFound
+	}
 
-	now := time.Now()
+	now := b.now()
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
 	// check if the key exists in pendingWrites
 	if len(b.pendingWrites) > 0 {
-		var record *LogRecord
-		for i := len(b.pendingWrites) - 1; i >= 0; i-- {
-			if bytes.Equal(key, b.pendingWrites[i].Key) {
-				record = b.pendingWrites[i]
-				break
-			}
-		}
+		record := b.lookupPendingWrite(ns, key)
 		// if the key exists in pendingWrites, return the ttl directly
 		if record != nil {
 			if record.Expire == 0 {
@@ -393,7 +457,7 @@ func (b *Batch) TTL(key []byte) (time.Duration, error) {
 	}
 
 	// if the key does not exist in pendingWrites, get the value from wal
-	position := b.db.index.Get(key)
+	position := idx.Get(key)
 	if position == nil {
 		return -1, ErrKeyNotFound
 	}
@@ -403,12 +467,15 @@ func (b *Batch) TTL(key []byte) (time.Duration, error) {
 	}
 
 	// return key not found if the record is deleted or expired
-	record := decodeLogRecord(chunk)
+	record, err := decodeLogRecord(chunk, b.db.cipher)
+	if err != nil {
+		return -1, err
+	}
 	if record.Type == LogRecordDeleted {
 		return -1, ErrKeyNotFound
 	}
 	if record.IsExpired(now.UnixNano()) {
-		b.db.index.Delete(key)
+		b.db.discardExpired(key, record)
 		return -1, ErrKeyNotFound
 	}
 
@@ -422,12 +489,20 @@ func (b *Batch) TTL(key []byte) (time.Duration, error) {
 
 // Persist 将过期时间设置为不过期 相当于keep live
 func (b *Batch) Persist(key []byte) error {
+	return b.persist(defaultNamespaceID, key)
+}
+
+func (b *Batch) persist(ns uint32, key []byte) error {
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
 	}
 	if b.db.closed {
 		return ErrDBClosed
 	}
+	idx := b.db.namespaceIndex(ns)
+	if idx == nil {
+		return ErrNamespaceNotFound
+	}
 	if b.options.ReadOnly {
 		return ErrReadOnlyBatch
 	}
@@ -436,22 +511,16 @@ func (b *Batch) Persist(key []byte) error {
 	defer b.mu.Unlock()
 
 	// if the key exists in pendingWrites, update the expiry time directly
-	var record *LogRecord
-	for i := len(b.pendingWrites) - 1; i >= 0; i-- {
-		if bytes.Equal(key, b.pendingWrites[i].Key) {
-			record = b.pendingWrites[i]
-			break
-		}
-	}
+	record := b.lookupPendingWrite(ns, key)
 
 	if record != nil {
-		if record.Type == LogRecordDeleted && record.IsExpired(time.Now().UnixNano()) {
+		if record.Type == LogRecordDeleted && record.IsExpired(b.now().UnixNano()) {
 			return ErrKeyNotFound
 		}
 		record.Expire = 0
 	} else {
 		// check if the key exists in index
-		position := b.db.index.Get(key)
+		position := idx.Get(key)
 		if position == nil {
 			return ErrKeyNotFound
 		}
@@ -460,11 +529,14 @@ func (b *Batch) Persist(key []byte) error {
 			return err
 		}
 
-		record := decodeLogRecord(chunk)
-		now := time.Now().UnixNano()
+		record, err := decodeLogRecord(chunk, b.db.cipher)
+		if err != nil {
+			return err
+		}
+		now := b.now().UnixNano()
 		// check if the record is deleted or expired
 		if record.Type == LogRecordDeleted || record.IsExpired(now) {
-			b.db.index.Delete(record.Key)
+			b.db.discardExpired(record.Key, record)
 			return ErrKeyNotFound
 		}
 		// if the expiration time is 0, it means that the key has no expiration time,
@@ -474,6 +546,11 @@ func (b *Batch) Persist(key []byte) error {
 		}
 
 		// set the expiration time to 0, and rewrite the record to wal
+		if !record.blob {
+			if err := record.decompress(); err != nil {
+				return err
+			}
+		}
 		record.Expire = 0
 		b.pendingWrites = append(b.pendingWrites, record)
 	}
@@ -481,6 +558,17 @@ func (b *Batch) Persist(key []byte) error {
 	return nil
 }
 
+// lookupPendingWrite returns the last pending write of the key in the namespace, nil if there is none.
+// The caller must hold b.mu.
+func (b *Batch) lookupPendingWrite(ns uint32, key []byte) *LogRecord {
+	for i := len(b.pendingWrites) - 1; i >= 0; i-- {
+		if b.pendingWrites[i].namespace == ns && bytes.Equal(key, b.pendingWrites[i].Key) {
+			return b.pendingWrites[i]
+		}
+	}
+	return nil
+}
+
 // Commit commits the batch, if the batch is readonly or empty, it will return directly.
 //
 // It will iterate the pendingWrites and write the data to the database,
@@ -508,23 +596,47 @@ func (b *Batch) Commit() error {
 	}
 
 	batchId := b.batchId.Generate()
-	now := time.Now().UnixNano()
+	now := b.now().UnixNano()
+	// the records whose values are moved to the blob files
+	storedRecords := make(map[*LogRecord]*LogRecord)
 	// write to wal buffer
 	for _, record := range b.pendingWrites {
 		buf := bytebufferpool.Get()
 		b.buffers = append(b.buffers, buf)
 		record.BatchId = uint64(batchId)
-		encRecord := encodeLogRecord(record, b.db.encodeHeader, buf)
+		compressed, err := b.db.compressRecord(record)
+		if err != nil {
+			b.db.dataFiles.ClearPendingWrites()
+			return err
+		}
+		// large values are written to the blob files, the record only keeps a pointer to it.
+		stored, err := b.db.separateValue(record, compressed)
+		if err != nil {
+			b.db.dataFiles.ClearPendingWrites()
+			return err
+		}
+		if stored != compressed {
+			storedRecords[record] = stored
+		}
+		encRecord, err := encodeLogRecord(stored, b.db.encodeHeader, buf, b.db.cipher)
+		if err != nil {
+			b.db.dataFiles.ClearPendingWrites()
+			return err
+		}
 		b.db.dataFiles.PendingWrites(encRecord)
 	}
 
 	// write a record to indicate the end of the batch
 	buf := bytebufferpool.Get()
 	b.buffers = append(b.buffers, buf)
-	endRecord := encodeLogRecord(&LogRecord{
+	endRecord, err := encodeLogRecord(&LogRecord{
 		Key:  batchId.Bytes(),
 		Type: LogRecordBatchFinished,
-	}, b.db.encodeHeader, buf)
+	}, b.db.encodeHeader, buf, b.db.cipher)
+	if err != nil {
+		b.db.dataFiles.ClearPendingWrites()
+		return err
+	}
 	b.db.dataFiles.PendingWrites(endRecord)
 
 	// write to wal file
@@ -536,8 +648,16 @@ func (b *Batch) Commit() error {
 	if len(chunkPositions) != len(b.pendingWrites)+1 {
 		panic("chunk positions length is not equal to pending writes length")
 	}
+	b.db.walPosition = chunkPositions[len(chunkPositions)-1]
+	// the batch finished record is only needed to rebuild the index.
+	b.db.garbage.add(b.db.walPosition)
 
 	// flush wal if necessary
+	if len(storedRecords) > 0 && (b.options.Sync || b.db.options.Sync) {
+		if err := b.db.blobs.sync(); err != nil {
+			return err
+		}
+	}
 	if b.options.Sync && !b.db.options.Sync {
 		if err := b.db.dataFiles.Sync(); err != nil {
 			return err
@@ -546,26 +666,69 @@ func (b *Batch) Commit() error {
 
 	// write to index
 	for i, record := range b.pendingWrites {
+		stored := record
+		if separated, ok := storedRecords[record]; ok {
+			stored = separated
+		}
+		// the namespace can not be dropped while the batch holds the db lock.
+		idx := b.db.namespaceIndex(record.namespace)
 		if record.Type == LogRecordDeleted || record.IsExpired(now) {
-			b.db.index.Delete(record.Key)
+			oldPosition, _ := idx.Delete(record.Key)
+			// both the deleted record and the delete record itself become garbage
+			b.db.garbage.add(oldPosition)
+			b.db.garbage.add(chunkPositions[i])
+			// the value of the deleted record becomes garbage if it is in the blob files
+			b.db.discardOldBlob(oldPosition, nil)
+			if separated, ok := storedRecords[record]; ok {
+				b.db.discardBlob(separated)
+			}
+			if b.db.expirer != nil {
+				b.db.expirer.remove(record.namespace, record.Key)
+			}
 		} else {
-			b.db.index.Put(record.Key, chunkPositions[i])
+			oldPosition := idx.Put(record.Key, chunkPositions[i])
+			b.db.garbage.add(oldPosition)
+			b.db.discardOldBlob(oldPosition, stored)
+			if b.db.expirer != nil {
+				if record.Expire > 0 {
+					b.db.expirer.add(record.namespace, record.Key, record.Expire)
+				} else {
+					b.db.expirer.remove(record.namespace, record.Key)
+				}
+			}
 		}
 
-		if b.db.options.WatchQueueSize > 0 {
-			e := &Event{Key: record.Key, Value: record.Value, BatchId: record.BatchId}
+		if b.db.watching() {
+			e := &Event{Key: record.Key, Value: record.Value, BatchId: record.BatchId,
+				Namespace: b.db.namespaceName(record.namespace)}
+			if record.blob {
+				loaded := *record
+				if err := b.db.loadValue(&loaded); err == nil {
+					e.Value = loaded.Value
+				}
+			}
 			if record.Type == LogRecordDeleted {
 				e.Action = WatchActionDelete
 			} else {
 				e.Action = WatchActionPut
 			}
-			b.db.watcher.putEvent(e)
+			b.db.publish(e)
 		}
 		// put the record back to the pool
 		b.db.recordPool.Put(record)
 	}
 
 	b.committed = true
+	// wake up the change feeds waiting for new batches.
+	close(b.db.changeNotify)
+	b.db.changeNotify = make(chan struct{})
+
+	// persist the changes of the disk index once they take too much memory,
+	// a failure is retried by the next commit, and the WAL is replayed if the db crashes before.
+	if diskIndex, ok := b.db.index.(index.DiskIndexer); ok &&
+		diskIndex.DirtySize() >= b.db.options.IndexCacheSize/2 {
+		_ = b.db.flushIndex()
+	}
 	return nil
 }
 
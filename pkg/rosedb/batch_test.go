@@ -7,9 +7,9 @@ package rosedb
 import (
 	"os"
 	"testing"
+	"time"
 
-	"github.com/JoyZF/wal"
-
+	"github.com/JoyZF/zoom/pkg/wal"
 	"github.com/JoyZF/zoom/utils"
 
 	"github.com/stretchr/testify/assert"
@@ -221,3 +221,33 @@ func TestBatch_Rollback(t *testing.T) {
 	assert.Equal(t, ErrKeyNotFound, err)
 	assert.Empty(t, resp)
 }
+
+func TestBatch_Now(t *testing.T) {
+	options := DefaultOptions
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	past := time.Now().Add(-time.Hour)
+	batcher := db.NewBatch(BatchOptions{Sync: true, Now: past})
+	assert.Nil(t, batcher.PutWithTTL([]byte("k1"), []byte("v1"), 2*time.Hour))
+	// the key expires at past+ttl, which is already over.
+	assert.Nil(t, batcher.PutWithTTL([]byte("k2"), []byte("v2"), time.Minute))
+	ttl, err := batcher.TTL([]byte("k1"))
+	assert.Nil(t, err)
+	assert.Equal(t, 2*time.Hour, ttl)
+	assert.Nil(t, batcher.Commit())
+
+	ttl, err = db.TTL([]byte("k1"))
+	assert.Nil(t, err)
+	assert.True(t, ttl > 59*time.Minute && ttl <= time.Hour)
+	_, err = db.Get([]byte("k2"))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// the keys which expired at now are not found by the batch.
+	batcher = db.NewBatch(BatchOptions{Sync: true, Now: past.Add(3 * time.Hour)})
+	_, err = batcher.Get([]byte("k1"))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Equal(t, ErrKeyNotFound, batcher.Expire([]byte("k1"), time.Hour))
+	assert.Nil(t, batcher.Rollback())
+}
@@ -0,0 +1,620 @@
+// Copyright 2024 Joy <joyssss94@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package rosedb
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/valyala/bytebufferpool"
+
+	"github.com/JoyZF/zoom/pkg/wal"
+)
+
+const (
+	blobFileNameSuffix = ".BLOB"
+	blobStatFileName   = "BLOBSTAT"
+	// crc32 and payload length
+	blobEntryHeaderSize = 8
+	// number of blobs moved in a batch by BlobGC, the db lock is held while a batch is written.
+	blobGCBatchSize = 128
+)
+
+// blobPointer is the location of a value in the blob files,
+// it is stored as the value of a log record whose blob flag is set.
+type blobPointer struct {
+	fileId uint32
+	offset int64  // offset of the entry in the file
+	size   uint32 // size of the entry payload
+}
+
+func (p *blobPointer) encode() []byte {
+	buf := make([]byte, binary.MaxVarintLen32*2+binary.MaxVarintLen64)
+	n := binary.PutUvarint(buf, uint64(p.fileId))
+	n += binary.PutUvarint(buf[n:], uint64(p.offset))
+	n += binary.PutUvarint(buf[n:], uint64(p.size))
+	return buf[:n]
+}
+
+func decodeBlobPointer(buf []byte) (*blobPointer, error) {
+	var fields [3]uint64
+	var index int
+	for i := range fields {
+		value, n := binary.Uvarint(buf[index:])
+		if n <= 0 {
+			return nil, ErrInvalidLogRecord
+		}
+		fields[i] = value
+		index += n
+	}
+	return &blobPointer{fileId: uint32(fields[0]), offset: int64(fields[1]), size: uint32(fields[2])}, nil
+}
+
+// BlobFileStat is the garbage accounting of a blob file.
+type BlobFileStat struct {
+	Id      uint32 `json:"id"`
+	Size    int64  `json:"size"`
+	Garbage int64  `json:"garbage"` // bytes of the values which have been overwritten, deleted or expired
+}
+
+// blobFile is an append-only file of values, every entry is
+//
+//	+--------+--------+-----------------------+
+//	| crc32  | length | encoded log record    |
+//	+--------+--------+-----------------------+
+//
+// The log record holds the key and the value, compressed and encrypted the same way as
+// the records in the data files, the key is used by BlobGC to find the record pointing to the value.
+type blobFile struct {
+	id      uint32
+	fd      *os.File
+	size    int64
+	garbage int64
+	// collected by BlobGC, the file is only kept open for the snapshots still reading it.
+	collected bool
+}
+
+// blobStore manages the blob files of the db, see Options.BlobThreshold.
+//
+// A new blob file is created every time the db is opened, so a file which is not active
+// is never written again, and BlobGC only works on those files.
+type blobStore struct {
+	dirPath  string
+	fileSize int64
+	cipher   *recordCipher
+	mu       sync.RWMutex
+	files    map[uint32]*blobFile
+	active   *blobFile
+	maxId    uint32
+	obsolete []uint32 // files collected by BlobGC but still referenced by snapshots
+	header   []byte
+}
+
+// blobStatFile is the content of the BLOBSTAT file.
+type blobStatFile struct {
+	Garbage  map[uint32]int64 `json:"garbage"`
+	Obsolete []uint32         `json:"obsolete"`
+}
+
+func openBlobStore(dirPath string, fileSize int64, cipher *recordCipher) (*blobStore, error) {
+	store := &blobStore{
+		dirPath:  dirPath,
+		fileSize: fileSize,
+		cipher:   cipher,
+		files:    make(map[uint32]*blobFile),
+		header:   make([]byte, maxLogRecordHeaderSize),
+	}
+
+	stat := &blobStatFile{}
+	data, err := os.ReadFile(filepath.Join(dirPath, blobStatFileName))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(data) > 0 {
+		if err = json.Unmarshal(data, stat); err != nil {
+			return nil, err
+		}
+	}
+	// the files collected by BlobGC before the db was closed.
+	for _, id := range stat.Obsolete {
+		if err = os.Remove(blobFileName(dirPath, id)); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		var id uint32
+		if entry.IsDir() || filepath.Ext(entry.Name()) != blobFileNameSuffix {
+			continue
+		}
+		if _, err = fmt.Sscanf(entry.Name(), "%d"+blobFileNameSuffix, &id); err != nil {
+			continue
+		}
+		fd, err := os.OpenFile(filepath.Join(dirPath, entry.Name()), os.O_RDWR, 0644)
+		if err != nil {
+			store.close()
+			return nil, err
+		}
+		info, err := fd.Stat()
+		if err != nil {
+			_ = fd.Close()
+			store.close()
+			return nil, err
+		}
+		store.files[id] = &blobFile{id: id, fd: fd, size: info.Size(), garbage: stat.Garbage[id]}
+		if id > store.maxId {
+			store.maxId = id
+		}
+	}
+	return store, nil
+}
+
+func blobFileName(dirPath string, id uint32) string {
+	return filepath.Join(dirPath, fmt.Sprintf("%09d"+blobFileNameSuffix, id))
+}
+
+// write appends the record to the active blob file, and returns the pointer to it.
+func (s *blobStore) write(record *LogRecord) (*blobPointer, error) {
+	buf := bytebufferpool.Get()
+	defer bytebufferpool.Put(buf)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	payload, err := encodeLogRecord(record, s.header, buf, s.cipher)
+	if err != nil {
+		return nil, err
+	}
+	return s.writePayload(payload)
+}
+
+// writePayload appends an encoded record to the active blob file.
+// The caller must hold s.mu.
+func (s *blobStore) writePayload(payload []byte) (*blobPointer, error) {
+	entrySize := int64(blobEntryHeaderSize + len(payload))
+	if s.active == nil || (s.active.size > 0 && s.active.size+entrySize > s.fileSize) {
+		if err := s.rotate(); err != nil {
+			return nil, err
+		}
+	}
+
+	entry := make([]byte, entrySize)
+	binary.LittleEndian.PutUint32(entry, crc32.ChecksumIEEE(payload))
+	binary.LittleEndian.PutUint32(entry[4:], uint32(len(payload)))
+	copy(entry[blobEntryHeaderSize:], payload)
+	if _, err := s.active.fd.WriteAt(entry, s.active.size); err != nil {
+		return nil, err
+	}
+	pointer := &blobPointer{fileId: s.active.id, offset: s.active.size, size: uint32(len(payload))}
+	s.active.size += entrySize
+	return pointer, nil
+}
+
+// rotate seals the active blob file and creates a new one.
+// The caller must hold s.mu.
+func (s *blobStore) rotate() error {
+	if s.active != nil {
+		if err := s.active.fd.Sync(); err != nil {
+			return err
+		}
+	}
+	id := s.maxId + 1
+	fd, err := os.OpenFile(blobFileName(s.dirPath, id), os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	s.active = &blobFile{id: id, fd: fd}
+	s.files[id] = s.active
+	s.maxId = id
+	return nil
+}
+
+// seal makes sure the blob files written so far are not written again,
+// and returns the id of the last sealed file.
+func (s *blobStore) seal() (uint32, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.active == nil {
+		return s.maxId, nil
+	}
+	if s.active.size > 0 {
+		if err := s.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	return s.active.id - 1, nil
+}
+
+// read returns the record the pointer points to.
+func (s *blobStore) read(pointer *blobPointer) (*LogRecord, error) {
+	s.mu.RLock()
+	file, ok := s.files[pointer.fileId]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: blob file %d not found", ErrInvalidLogRecord, pointer.fileId)
+	}
+	payload, err := readBlobEntry(file.fd, pointer.offset, pointer.size)
+	if err != nil {
+		return nil, err
+	}
+	return decodeLogRecord(payload, s.cipher)
+}
+
+// readBlobEntry reads and checks the payload of the entry at the offset.
+func readBlobEntry(r io.ReaderAt, offset int64, size uint32) ([]byte, error) {
+	entry := make([]byte, blobEntryHeaderSize+int(size))
+	if _, err := r.ReadAt(entry, offset); err != nil {
+		return nil, err
+	}
+	payload := entry[blobEntryHeaderSize:]
+	if binary.LittleEndian.Uint32(entry[4:]) != size ||
+		binary.LittleEndian.Uint32(entry) != crc32.ChecksumIEEE(payload) {
+		return nil, fmt.Errorf("%w: corrupted blob entry", ErrInvalidLogRecord)
+	}
+	return payload, nil
+}
+
+// scan calls fn with the pointer and payload of every entry in the sealed file.
+// A partly written entry at the end of the file is ignored.
+func (s *blobStore) scan(file *blobFile, fn func(pointer *blobPointer, payload []byte) error) error {
+	header := make([]byte, blobEntryHeaderSize)
+	for offset := int64(0); offset+blobEntryHeaderSize <= file.size; {
+		if _, err := file.fd.ReadAt(header, offset); err != nil {
+			return err
+		}
+		size := binary.LittleEndian.Uint32(header[4:])
+		if offset+blobEntryHeaderSize+int64(size) > file.size {
+			return nil
+		}
+		payload, err := readBlobEntry(file.fd, offset, size)
+		if err != nil {
+			return err
+		}
+		if err = fn(&blobPointer{fileId: file.id, offset: offset, size: size}, payload); err != nil {
+			return err
+		}
+		offset += blobEntryHeaderSize + int64(size)
+	}
+	return nil
+}
+
+// addGarbage records that the value the pointer points to is no longer referenced.
+func (s *blobStore) addGarbage(pointer *blobPointer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if file, ok := s.files[pointer.fileId]; ok {
+		file.garbage += blobEntryHeaderSize + int64(pointer.size)
+		if file.garbage > file.size {
+			file.garbage = file.size
+		}
+	}
+}
+
+func (s *blobStore) sync() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.active == nil {
+		return nil
+	}
+	return s.active.fd.Sync()
+}
+
+func (s *blobStore) stat() []BlobFileStat {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	stats := make([]BlobFileStat, 0, len(s.files))
+	for _, file := range s.files {
+		if file.collected {
+			continue
+		}
+		stats = append(stats, BlobFileStat{Id: file.id, Size: file.size, Garbage: file.garbage})
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Id < stats[j].Id
+	})
+	return stats
+}
+
+// gcCandidates returns the sealed files whose garbage ratio is at least ratio.
+func (s *blobStore) gcCandidates(ratio float64) []*blobFile {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var files []*blobFile
+	for _, file := range s.files {
+		if file == s.active || file.size == 0 || file.collected {
+			continue
+		}
+		if float64(file.garbage)/float64(file.size) >= ratio {
+			files = append(files, file)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].id < files[j].id
+	})
+	return files
+}
+
+// remove deletes the blob file, or marks it obsolete if it may still be read by snapshots.
+func (s *blobStore) remove(id uint32, referenced bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if referenced {
+		if file, ok := s.files[id]; ok {
+			file.collected = true
+		}
+		s.obsolete = append(s.obsolete, id)
+		return nil
+	}
+	return s.removeFile(id)
+}
+
+// removeObsolete deletes the files marked obsolete, once no snapshot may read them.
+func (s *blobStore) removeObsolete() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for len(s.obsolete) > 0 {
+		if err := s.removeFile(s.obsolete[0]); err != nil {
+			return err
+		}
+		s.obsolete = s.obsolete[1:]
+	}
+	return nil
+}
+
+// removeFile deletes the blob file, the caller must hold s.mu.
+func (s *blobStore) removeFile(id uint32) error {
+	if file, ok := s.files[id]; ok {
+		_ = file.fd.Close()
+		delete(s.files, id)
+	}
+	if err := os.Remove(blobFileName(s.dirPath, id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// persist writes the garbage accounting to the BLOBSTAT file.
+func (s *blobStore) persist() error {
+	s.mu.RLock()
+	if len(s.files) == 0 && len(s.obsolete) == 0 {
+		s.mu.RUnlock()
+		return nil
+	}
+	stat := &blobStatFile{Garbage: make(map[uint32]int64), Obsolete: s.obsolete}
+	for _, file := range s.files {
+		if file.garbage > 0 && !file.collected {
+			stat.Garbage[file.id] = file.garbage
+		}
+	}
+	data, err := json.Marshal(stat)
+	s.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(s.dirPath, blobStatFileName)
+	if err = writeFileSync(path+backupTempSuffix, data); err != nil {
+		return err
+	}
+	return os.Rename(path+backupTempSuffix, path)
+}
+
+func (s *blobStore) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, file := range s.files {
+		_ = file.fd.Sync()
+		_ = file.fd.Close()
+	}
+	s.files = make(map[uint32]*blobFile)
+	s.active = nil
+}
+
+// separateValue moves the value of the record to the blob files if it is large enough,
+// the returned record holds the pointer to the value instead.
+// record is the record as written by the user, and stored is the one to be encoded,
+// whose value may have been compressed.
+// The values of the namespaces other than the default one are never separated,
+// as the blob files only know the keys of the default namespace.
+func (db *DB) separateValue(record, stored *LogRecord) (*LogRecord, error) {
+	if db.options.BlobThreshold <= 0 || record.blob || record.Type != LogRecordNormal ||
+		record.namespace != defaultNamespaceID || len(record.Value) < db.options.BlobThreshold {
+		return stored, nil
+	}
+	pointer, err := db.blobs.write(stored)
+	if err != nil {
+		return nil, err
+	}
+	separated := *stored
+	separated.Value, separated.codec, separated.blob = pointer.encode(), CompressionNone, true
+	return &separated, nil
+}
+
+// loadValue reads the value of the record from the blob files if it is separated,
+// and decompresses it.
+func (db *DB) loadValue(record *LogRecord) error {
+	if record.blob {
+		pointer, err := decodeBlobPointer(record.Value)
+		if err != nil {
+			return err
+		}
+		blobRecord, err := db.blobs.read(pointer)
+		if err != nil {
+			return err
+		}
+		record.Value, record.codec, record.blob = blobRecord.Value, blobRecord.codec, false
+	}
+	return record.decompress()
+}
+
+// discardBlob records the value of the record as garbage if it is in the blob files.
+func (db *DB) discardBlob(record *LogRecord) {
+	if !record.blob {
+		return
+	}
+	if pointer, err := decodeBlobPointer(record.Value); err == nil {
+		db.blobs.addGarbage(pointer)
+	}
+}
+
+// discardOldBlob records the value of the record at the old position as garbage
+// if it is in the blob files and not referenced by the new record.
+// The caller must hold db.mu.
+func (db *DB) discardOldBlob(oldPosition *wal.ChunkPosition, newRecord *LogRecord) {
+	if oldPosition == nil || len(db.blobs.files) == 0 {
+		return
+	}
+	chunk, err := db.dataFiles.Read(oldPosition)
+	// only the records pointing to the blob files need to be decoded.
+	if err != nil || len(chunk) == 0 || chunk[0]&recordBlobFlag == 0 {
+		return
+	}
+	oldRecord, err := decodeLogRecord(chunk, db.cipher)
+	if err != nil {
+		return
+	}
+	if newRecord != nil && newRecord.blob && string(newRecord.Value) == string(oldRecord.Value) {
+		return
+	}
+	db.discardBlob(oldRecord)
+}
+
+// BlobStat returns the size and garbage of the blob files.
+func (db *DB) BlobStat() []BlobFileStat {
+	return db.blobs.stat()
+}
+
+// BlobGC rewrites the values still referenced in the blob files whose garbage ratio
+// is at least ratio into the active blob file, and deletes the old files.
+// It returns the number of blob files deleted.
+//
+// Unlike Merge, it only touches the blob files, the records pointing to the moved values
+// are rewritten to the data files. The db lock is held while a small batch of values
+// is moved, so the db keeps serving reads and writes during the GC.
+// Like Merge, it may fail the iterators opened before it, snapshots are not affected.
+func (db *DB) BlobGC(ratio float64) (int, error) {
+	db.mu.Lock()
+	if db.closed {
+		db.mu.Unlock()
+		return 0, ErrDBClosed
+	}
+	if !atomic.CompareAndSwapUint32(&db.blobGCRunning, 0, 1) {
+		db.mu.Unlock()
+		return 0, ErrBlobGCRunning
+	}
+	defer atomic.StoreUint32(&db.blobGCRunning, 0)
+	candidates := db.blobs.gcCandidates(ratio)
+	db.mu.Unlock()
+
+	var collected int
+	for _, file := range candidates {
+		if err := db.collectBlobFile(file); err != nil {
+			return collected, err
+		}
+		collected++
+	}
+	if err := db.blobs.persist(); err != nil {
+		return collected, err
+	}
+	return collected, nil
+}
+
+// collectBlobFile moves the live values of the blob file to the active one, and removes the file.
+func (db *DB) collectBlobFile(file *blobFile) error {
+	type blobEntry struct {
+		key     []byte
+		pointer *blobPointer
+		payload []byte
+	}
+	var entries []*blobEntry
+	moveEntries := func() error {
+		if len(entries) == 0 {
+			return nil
+		}
+		batch := db.NewBatch(BatchOptions{Sync: false})
+		if db.closed {
+			_ = batch.Rollback()
+			return ErrDBClosed
+		}
+		now := time.Now().UnixNano()
+		for _, entry := range entries {
+			// the value is live only if the current record of the key still points to it.
+			position := db.index.Get(entry.key)
+			if position == nil {
+				continue
+			}
+			chunk, err := db.dataFiles.Read(position)
+			if err != nil {
+				_ = batch.Rollback()
+				return err
+			}
+			record, err := decodeLogRecord(chunk, db.cipher)
+			if err != nil {
+				_ = batch.Rollback()
+				return err
+			}
+			if !record.blob || record.IsExpired(now) {
+				continue
+			}
+			if pointer, err := decodeBlobPointer(record.Value); err != nil || *pointer != *entry.pointer {
+				continue
+			}
+
+			// the payload is copied as it is, so it keeps its codec and encryption key.
+			db.blobs.mu.Lock()
+			pointer, err := db.blobs.writePayload(entry.payload)
+			db.blobs.mu.Unlock()
+			if err != nil {
+				_ = batch.Rollback()
+				return err
+			}
+			record.Value = pointer.encode()
+			batch.pendingWrites = append(batch.pendingWrites, record)
+		}
+		entries = entries[:0]
+		return batch.Commit()
+	}
+
+	err := db.blobs.scan(file, func(pointer *blobPointer, payload []byte) error {
+		record, err := decodeLogRecord(payload, db.cipher)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, &blobEntry{key: record.Key, pointer: pointer, payload: payload})
+		if len(entries) == blobGCBatchSize {
+			return moveEntries()
+		}
+		return nil
+	})
+	if err == nil {
+		err = moveEntries()
+	}
+	if err != nil {
+		return err
+	}
+
+	// make sure the moved values and the records pointing to them are persisted
+	// before the old file is removed.
+	if err = db.blobs.sync(); err != nil {
+		return err
+	}
+	if err = db.dataFiles.Sync(); err != nil {
+		return err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.blobs.remove(file.id, len(db.snapshots) > 0)
+}
@@ -0,0 +1,326 @@
+// Copyright 2024 Joy <joyssss94@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package rosedb
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/JoyZF/zoom/pkg/wal"
+	"github.com/JoyZF/zoom/utils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func blobTestOptions() Options {
+	options := DefaultOptions
+	options.BlobThreshold = 1024
+	options.BlobFileSize = 64 * wal.KB
+	return options
+}
+
+// blobGarbage returns the total size and garbage of the blob files.
+func blobGarbage(db *DB) (int64, int64) {
+	var size, garbage int64
+	for _, stat := range db.BlobStat() {
+		size += stat.Size
+		garbage += stat.Garbage
+	}
+	return size, garbage
+}
+
+func TestDB_Blob_Normal(t *testing.T) {
+	for _, setup := range []func(options *Options){
+		func(options *Options) {},
+		func(options *Options) { options.Compression = CompressionSnappy },
+		func(options *Options) { options.KeyProvider = newTestKeyProvider(t, 1, 1) },
+	} {
+		options := blobTestOptions()
+		setup(&options)
+		db, err := Open(options)
+		assert.Nil(t, err)
+
+		values := make(map[string][]byte)
+		for i := 0; i < 200; i++ {
+			key, value := utils.GetTestKey(i), utils.RandomValue(2048)
+			if i%2 == 0 {
+				value = bytes.Repeat([]byte("small"), 10)
+			}
+			values[string(key)] = value
+			assert.Nil(t, db.Put(key, value))
+		}
+
+		// the large values are only written to the blob files
+		chunk, err := db.dataFiles.Read(db.index.Get(utils.GetTestKey(1)))
+		assert.Nil(t, err)
+		assert.NotZero(t, chunk[0]&recordBlobFlag)
+		assert.True(t, len(chunk) < 100)
+		chunk, err = db.dataFiles.Read(db.index.Get(utils.GetTestKey(0)))
+		assert.Nil(t, err)
+		assert.Zero(t, chunk[0]&recordBlobFlag)
+		assert.True(t, len(db.BlobStat()) > 1)
+
+		for i := 0; i < 2; i++ {
+			for key, value := range values {
+				val, err := db.Get([]byte(key))
+				assert.Nil(t, err)
+				assert.Equal(t, value, val)
+			}
+			iter, err := db.NewIterator(IteratorOptions{})
+			assert.Nil(t, err)
+			var count int
+			for ; iter.Valid(); iter.Next() {
+				assert.Equal(t, values[string(iter.Key())], iter.Value())
+				count++
+			}
+			assert.Nil(t, iter.Err())
+			iter.Close()
+			assert.Equal(t, len(values), count)
+
+			assert.Nil(t, db.Close())
+			db, err = Open(options)
+			assert.Nil(t, err)
+		}
+
+		// the ttl of a separated value can be changed without moving the value
+		key := utils.GetTestKey(1)
+		size, _ := blobGarbage(db)
+		assert.Nil(t, db.Expire(key, time.Hour))
+		val, err := db.Get(key)
+		assert.Nil(t, err)
+		assert.Equal(t, values[string(key)], val)
+		assert.Nil(t, db.Persist(key))
+		newSize, garbage := blobGarbage(db)
+		assert.Equal(t, size, newSize)
+		assert.Zero(t, garbage)
+		destroyDB(db)
+	}
+}
+
+func TestDB_Blob_Merge(t *testing.T) {
+	options := blobTestOptions()
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	values := make(map[string][]byte)
+	for i := 0; i < 100; i++ {
+		key, value := utils.GetTestKey(i), utils.RandomValue(4096)
+		values[string(key)] = value
+		assert.Nil(t, db.Put(key, value))
+	}
+	for i := 0; i < 50; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), []byte("small")))
+		values[string(utils.GetTestKey(i))] = []byte("small")
+	}
+
+	// merge only rewrites the pointers, the blob files are left as they are
+	before := db.BlobStat()
+	assert.Nil(t, db.Merge(true))
+	assert.Equal(t, before, db.BlobStat())
+	for key, value := range values {
+		val, err := db.Get([]byte(key))
+		assert.Nil(t, err)
+		assert.Equal(t, value, val)
+	}
+}
+
+func TestDB_Blob_Garbage(t *testing.T) {
+	options := blobTestOptions()
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(2048)))
+	}
+	_, garbage := blobGarbage(db)
+	assert.Zero(t, garbage)
+
+	// overwritten, deleted and expired values are garbage
+	for _, i := range []int{0, 1, 2} {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), []byte("small")))
+	}
+	_, garbage = blobGarbage(db)
+	assert.True(t, garbage > 3*2048, garbage)
+	entrySize := garbage / 3
+	assert.Nil(t, db.Delete(utils.GetTestKey(3)))
+	_, garbage = blobGarbage(db)
+	assert.Equal(t, 4*entrySize, garbage)
+	// both the overwritten value and the expired one
+	assert.Nil(t, db.PutWithTTL(utils.GetTestKey(4), utils.RandomValue(2048), time.Millisecond))
+	time.Sleep(10 * time.Millisecond)
+	_, err = db.Get(utils.GetTestKey(4))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, garbage = blobGarbage(db)
+	assert.True(t, garbage > 5*entrySize, garbage)
+
+	// the garbage accounting is persisted
+	assert.Nil(t, db.Close())
+	db, err = Open(options)
+	assert.Nil(t, err)
+	_, persisted := blobGarbage(db)
+	assert.Equal(t, garbage, persisted)
+	assert.Equal(t, db.BlobStat(), db.Stat().Blobs)
+}
+
+func TestDB_BlobGC(t *testing.T) {
+	options := blobTestOptions()
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	values := make(map[string][]byte)
+	for i := 0; i < 200; i++ {
+		key, value := utils.GetTestKey(i), utils.RandomValue(2048)
+		values[string(key)] = value
+		assert.Nil(t, db.Put(key, value))
+	}
+	for i := 0; i < 150; i++ {
+		key := utils.GetTestKey(i)
+		if i%3 == 0 {
+			assert.Nil(t, db.Delete(key))
+			delete(values, string(key))
+		} else {
+			value := utils.RandomValue(2048)
+			values[string(key)] = value
+			assert.Nil(t, db.Put(key, value))
+		}
+	}
+	sizeBefore, _ := blobGarbage(db)
+
+	// the active file is not collected
+	collected, err := db.BlobGC(0.5)
+	assert.Nil(t, err)
+	assert.True(t, collected > 0)
+	for _, stat := range db.BlobStat() {
+		_, err := os.Stat(blobFileName(options.DirPath, stat.Id))
+		assert.Nil(t, err)
+	}
+	sizeAfter, _ := blobGarbage(db)
+	assert.True(t, sizeAfter < sizeBefore, "%d %d", sizeAfter, sizeBefore)
+	files, err := filepath.Glob(filepath.Join(options.DirPath, "*"+blobFileNameSuffix))
+	assert.Nil(t, err)
+	assert.Equal(t, len(db.BlobStat()), len(files))
+
+	check := func() {
+		assert.Equal(t, len(values), db.Stat().KeysNum)
+		for key, value := range values {
+			val, err := db.Get([]byte(key))
+			assert.Nil(t, err)
+			assert.Equal(t, value, val)
+		}
+	}
+	check()
+	assert.Nil(t, db.Close())
+	db, err = Open(options)
+	assert.Nil(t, err)
+	check()
+}
+
+func TestDB_BlobGC_Snapshot(t *testing.T) {
+	options := blobTestOptions()
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	old := make(map[string][]byte)
+	for i := 0; i < 100; i++ {
+		key, value := utils.GetTestKey(i), utils.RandomValue(2048)
+		old[string(key)] = value
+		assert.Nil(t, db.Put(key, value))
+	}
+	snap, err := db.Snapshot()
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+
+	// the collected files are kept until the snapshot is released
+	collected, err := db.BlobGC(0.5)
+	assert.Nil(t, err)
+	assert.True(t, collected > 0)
+	files, err := filepath.Glob(filepath.Join(options.DirPath, "*"+blobFileNameSuffix))
+	assert.Nil(t, err)
+	assert.True(t, len(files) > len(db.BlobStat()))
+	for key, value := range old {
+		val, err := snap.Get([]byte(key))
+		assert.Nil(t, err)
+		assert.Equal(t, value, val)
+	}
+
+	snap.Release()
+	files, err = filepath.Glob(filepath.Join(options.DirPath, "*"+blobFileNameSuffix))
+	assert.Nil(t, err)
+	assert.Equal(t, len(db.BlobStat()), len(files))
+}
+
+func TestDB_Blob_Backup(t *testing.T) {
+	options := blobTestOptions()
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	values := make(map[string][]byte)
+	for i := 0; i < 100; i++ {
+		key, value := utils.GetTestKey(i), utils.RandomValue(2048)
+		values[string(key)] = value
+		assert.Nil(t, db.Put(key, value))
+	}
+	assert.Nil(t, db.Delete(utils.GetTestKey(0)))
+	delete(values, string(utils.GetTestKey(0)))
+
+	// the blob files are sealed at the cut point and copied with the segments
+	backupDir := backupTestDir(t, "blob-backup")
+	_, err = db.Backup(backupDir)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte("after-backup"), utils.RandomValue(2048)))
+
+	restoreDir := backupTestDir(t, "blob-restore")
+	_, err = RestoreBackup(backupDir, restoreDir)
+	assert.Nil(t, err)
+	restoreOptions := options
+	restoreOptions.DirPath = restoreDir
+	restored, err := Open(restoreOptions)
+	assert.Nil(t, err)
+	defer func() {
+		_ = restored.Close()
+	}()
+	assert.Equal(t, len(values), restored.Stat().KeysNum)
+	for key, value := range values {
+		val, err := restored.Get([]byte(key))
+		assert.Nil(t, err)
+		assert.Equal(t, value, val)
+	}
+	_, garbage := blobGarbage(restored)
+	assert.NotZero(t, garbage)
+}
+
+func TestDB_Blob_OpenFailure(t *testing.T) {
+	options := blobTestOptions()
+	db, err := Open(options)
+	assert.Nil(t, err)
+	value := utils.RandomValue(2048)
+	assert.Nil(t, db.Put(utils.GetTestKey(0), value))
+	assert.Nil(t, db.Close())
+
+	// a segment file that can not be opened fails Open after the blob files are opened
+	segment := wal.SegmentFileName(options.DirPath, dataFileNameSuffix, 2)
+	assert.Nil(t, os.Symlink(filepath.Join(options.DirPath, "missing", "segment"), segment))
+	_, err = Open(options)
+	assert.NotNil(t, err)
+
+	// the files and the lock are released, so the db can be opened again
+	assert.Nil(t, os.Remove(segment))
+	db, err = Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+	val, err := db.Get(utils.GetTestKey(0))
+	assert.Nil(t, err)
+	assert.Equal(t, value, val)
+}
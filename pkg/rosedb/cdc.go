@@ -0,0 +1,493 @@
+// Copyright 2024 Joy <joyssss94@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package rosedb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/bwmarrin/snowflake"
+
+	"github.com/JoyZF/zoom/pkg/wal"
+)
+
+const (
+	changeOffsetsFileName = "CDCOFFSETS"
+	// the size of the header of each chunk in the WAL blocks
+	walChunkHeaderSize = 7
+)
+
+// ChangePosition is the position in the WAL where a change feed continues reading.
+// Consumers persist it, in the form of String, to resume the feed after a restart.
+// The zero position is the oldest change still in the WAL, which is the first one after the last merge.
+type ChangePosition struct {
+	SegmentId   wal.SegmentID `json:"segment_id"`
+	BlockNumber uint32        `json:"block_number"`
+	ChunkOffset int64         `json:"chunk_offset"`
+}
+
+// IsZero reports whether p is the zero position.
+func (p ChangePosition) IsZero() bool {
+	return p == ChangePosition{}
+}
+
+func (p ChangePosition) String() string {
+	return fmt.Sprintf("%d-%d-%d", p.SegmentId, p.BlockNumber, p.ChunkOffset)
+}
+
+// ParseChangePosition parses a position in the form of ChangePosition.String.
+func ParseChangePosition(s string) (ChangePosition, error) {
+	var p ChangePosition
+	if _, err := fmt.Sscanf(s, "%d-%d-%d", &p.SegmentId, &p.BlockNumber, &p.ChunkOffset); err != nil {
+		return ChangePosition{}, fmt.Errorf("invalid change position %q: %v", s, err)
+	}
+	return p, nil
+}
+
+// nextChunkPosition returns the position of the chunk after the one of dataSize bytes at p,
+// following how the WAL splits a chunk into blocks.
+func (p ChangePosition) nextChunkPosition(dataSize int) ChangePosition {
+	left := int64(dataSize)
+	for p.ChunkOffset+walChunkHeaderSize+left > walBlockSize {
+		left -= walBlockSize - p.ChunkOffset - walChunkHeaderSize
+		p.BlockNumber++
+		p.ChunkOffset = 0
+	}
+	p.ChunkOffset += walChunkHeaderSize + left
+	return p.skipPadding()
+}
+
+// skipPadding moves p to the start of the next block if the rest of the block is padding,
+// which is the case when it can not hold a chunk header.
+func (p ChangePosition) skipPadding() ChangePosition {
+	if p.ChunkOffset+walChunkHeaderSize >= walBlockSize {
+		p.BlockNumber++
+		p.ChunkOffset = 0
+	}
+	return p
+}
+
+// Change is a write in a committed batch.
+type Change struct {
+	Action WatchActionType // WatchActionPut or WatchActionDelete
+	Key    []byte
+	Value  []byte
+	Expire int64 // expiration time in unix nanoseconds, 0 means no ttl
+	// Namespace is the name of the namespace of Key, empty for the default namespace.
+	Namespace string
+}
+
+// ChangeBatch is the writes committed together by a batch, a transaction or a single write.
+type ChangeBatch struct {
+	BatchId uint64
+	Changes []*Change
+	// Position is where the feed continues after this batch, consumers acknowledge it once it is handled.
+	Position ChangePosition
+}
+
+// ChangeFeed reads the committed batches from the WAL in commit order, see DB.NewChangeFeed.
+// It is not safe for concurrent use.
+type ChangeFeed struct {
+	db       *DB
+	consumer string
+	position ChangePosition
+}
+
+// NewChangeFeed returns a feed of the batches committed to the db, read from the WAL
+// so it can be resumed after a restart.
+//
+// If consumer is not empty, the feed is registered under that name, and starts from the position
+// the consumer last acknowledged with Ack, or from the zero position the first time.
+// Merge and CompactSegments keep the segment files holding the changes not acknowledged
+// by the registered consumers, until they are removed by RemoveChangeConsumer.
+// An unregistered feed starts from the zero position, and fails with ErrChangeFeedBehind once
+// the segment file it reads is merged.
+//
+// The records rewritten by CompactSegments and BlobGC appear in the feed again with their current values.
+func (db *DB) NewChangeFeed(consumer string) (*ChangeFeed, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return nil, ErrDBClosed
+	}
+
+	feed := &ChangeFeed{db: db, consumer: consumer}
+	if consumer == "" {
+		return feed, nil
+	}
+	position, ok := db.changeConsumers[consumer]
+	if !ok {
+		db.changeConsumers[consumer] = position
+		if err := db.saveChangeConsumers(); err != nil {
+			delete(db.changeConsumers, consumer)
+			return nil, err
+		}
+	}
+	feed.position = position
+	return feed, nil
+}
+
+// Position returns where the feed continues reading.
+func (f *ChangeFeed) Position() ChangePosition {
+	return f.position
+}
+
+// ResumeFrom makes the feed continue from the position of a batch returned before.
+// It returns ErrChangeFeedBehind if the segment file of the position has been merged or compacted.
+func (f *ChangeFeed) ResumeFrom(position ChangePosition) error {
+	f.db.mu.RLock()
+	defer f.db.mu.RUnlock()
+	if f.db.closed {
+		return ErrDBClosed
+	}
+	if err := f.db.checkChangePosition(position); err != nil {
+		return err
+	}
+	f.position = position
+	return nil
+}
+
+// Next returns the next committed batch, it waits for one to be committed if the feed has read all of them.
+// It returns the error of ctx if ctx is done first, and ErrDBClosed once the db is closed.
+func (f *ChangeFeed) Next(ctx context.Context) (*ChangeBatch, error) {
+	for {
+		f.db.mu.RLock()
+		if f.db.closed {
+			f.db.mu.RUnlock()
+			return nil, ErrDBClosed
+		}
+		// the channel is taken before reading, so a batch committed after the read wakes the feed up.
+		notify := f.db.changeNotify
+		batch, err := f.db.readChangeBatch(f.position)
+		f.db.mu.RUnlock()
+		if err != nil {
+			return nil, err
+		}
+		if batch != nil {
+			f.position = batch.Position
+			return batch, nil
+		}
+
+		select {
+		case <-notify:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Ack records that the registered consumer of the feed has handled the batches before position,
+// so the feed starts from it when it is created again, and the segment files before it can be merged.
+func (f *ChangeFeed) Ack(position ChangePosition) error {
+	if f.consumer == "" {
+		return ErrChangeConsumerNotFound
+	}
+	f.db.mu.Lock()
+	defer f.db.mu.Unlock()
+	if f.db.closed {
+		return ErrDBClosed
+	}
+	previous, ok := f.db.changeConsumers[f.consumer]
+	if !ok {
+		return ErrChangeConsumerNotFound
+	}
+	f.db.changeConsumers[f.consumer] = position
+	if err := f.db.saveChangeConsumers(); err != nil {
+		f.db.changeConsumers[f.consumer] = previous
+		return err
+	}
+	return nil
+}
+
+// ChangeSnapshot returns a snapshot of the db, and the position of the change feed right after it.
+// A replica loads the snapshot, for example with Snapshot.Export and Import, and then follows
+// the feed from the position to apply the batches committed after the snapshot.
+// The snapshot must be released after use.
+func (db *DB) ChangeSnapshot() (*Snapshot, ChangePosition, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return nil, ChangePosition{}, ErrDBClosed
+	}
+	position, err := db.changeHead()
+	if err != nil {
+		return nil, ChangePosition{}, err
+	}
+	return db.snapshotLocked(), position, nil
+}
+
+// ChangeHead returns the position of the change feed after the last committed batch.
+func (db *DB) ChangeHead() (ChangePosition, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return ChangePosition{}, ErrDBClosed
+	}
+	return db.changeHead()
+}
+
+// changeHead returns the end of the active segment file.
+// The caller must hold db.mu.
+func (db *DB) changeHead() (ChangePosition, error) {
+	id := db.dataFiles.ActiveSegmentID()
+	info, err := os.Stat(wal.SegmentFileName(db.options.DirPath, dataFileNameSuffix, id))
+	if err != nil {
+		return ChangePosition{}, err
+	}
+	position := ChangePosition{
+		SegmentId:   id,
+		BlockNumber: uint32(info.Size() / walBlockSize),
+		ChunkOffset: info.Size() % walBlockSize,
+	}
+	return position.skipPadding(), nil
+}
+
+// ApplyChanges writes the changes of a batch read from the change feed of another db
+// in a single batch, keeping their expiration time, so a replica ends up with the same data.
+// A put which has expired is applied as a delete.
+// The namespaces of the changes are created if they do not exist.
+func (db *DB) ApplyChanges(changes []*Change, sync bool) error {
+	// the namespaces are created before the batch holds the db lock.
+	namespaces := make(map[string]uint32)
+	for _, change := range changes {
+		if _, ok := namespaces[change.Namespace]; ok || change.Namespace == "" {
+			continue
+		}
+		ns, err := db.Namespace(change.Namespace)
+		if err != nil {
+			return err
+		}
+		namespaces[change.Namespace] = ns.id
+	}
+
+	batch := db.NewBatch(BatchOptions{Sync: sync})
+	now := time.Now().UnixNano()
+	for _, change := range changes {
+		var err error
+		ns := namespaces[change.Namespace]
+		switch {
+		case change.Action == WatchActionDelete, change.Expire > 0 && change.Expire <= now:
+			err = batch.delete(ns, change.Key)
+		case change.Expire > 0:
+			err = batch.putWithTTL(ns, change.Key, change.Value, time.Duration(change.Expire-now))
+		default:
+			err = batch.put(ns, change.Key, change.Value)
+		}
+		if err != nil {
+			_ = batch.Rollback()
+			return err
+		}
+	}
+	return batch.Commit()
+}
+
+// ChangeConsumers returns the registered change feed consumers and the positions they acknowledged.
+func (db *DB) ChangeConsumers() map[string]ChangePosition {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	consumers := make(map[string]ChangePosition, len(db.changeConsumers))
+	for name, position := range db.changeConsumers {
+		consumers[name] = position
+	}
+	return consumers
+}
+
+// RemoveChangeConsumer unregisters the change feed consumer,
+// so the segment files it has not acknowledged can be merged.
+func (db *DB) RemoveChangeConsumer(consumer string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return ErrDBClosed
+	}
+	position, ok := db.changeConsumers[consumer]
+	if !ok {
+		return ErrChangeConsumerNotFound
+	}
+	delete(db.changeConsumers, consumer)
+	if err := db.saveChangeConsumers(); err != nil {
+		db.changeConsumers[consumer] = position
+		return err
+	}
+	return nil
+}
+
+// loadChangeConsumers reads the registered consumers from the CDCOFFSETS file.
+func (db *DB) loadChangeConsumers() error {
+	db.changeConsumers = make(map[string]ChangePosition)
+	data, err := os.ReadFile(filepath.Join(db.options.DirPath, changeOffsetsFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return json.Unmarshal(data, &db.changeConsumers)
+}
+
+// saveChangeConsumers writes the registered consumers to the CDCOFFSETS file.
+// The caller must hold db.mu.
+func (db *DB) saveChangeConsumers() error {
+	data, err := json.Marshal(db.changeConsumers)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(db.options.DirPath, changeOffsetsFileName)
+	if err = writeFileSync(path+backupTempSuffix, data); err != nil {
+		return err
+	}
+	return os.Rename(path+backupTempSuffix, path)
+}
+
+// changeRetention returns the last segment id which can be merged or compacted without losing
+// the changes the registered consumers have not acknowledged.
+// It reports false if there is no registered consumer.
+// The caller must hold db.mu.
+func (db *DB) changeRetention() (wal.SegmentID, bool, error) {
+	if len(db.changeConsumers) == 0 {
+		return 0, false, nil
+	}
+	mergeFinSegmentId, err := getMergeFinSegmentId(db.options.DirPath)
+	if err != nil {
+		return 0, false, err
+	}
+	retention := wal.SegmentID(math.MaxUint32)
+	for _, position := range db.changeConsumers {
+		last := mergeFinSegmentId
+		if !position.IsZero() && position.SegmentId > 0 {
+			last = position.SegmentId - 1
+		}
+		if last < retention {
+			retention = last
+		}
+	}
+	return retention, true, nil
+}
+
+// checkChangePosition returns ErrChangeFeedBehind if the changes at the position are no longer in the WAL.
+// The caller must hold db.mu.
+func (db *DB) checkChangePosition(position ChangePosition) error {
+	if position.IsZero() {
+		return nil
+	}
+	mergeFinSegmentId, err := getMergeFinSegmentId(db.options.DirPath)
+	if err != nil {
+		return err
+	}
+	if position.SegmentId <= mergeFinSegmentId {
+		return ErrChangeFeedBehind
+	}
+	if _, err = os.Stat(wal.SegmentFileName(db.options.DirPath, dataFileNameSuffix, position.SegmentId)); err != nil {
+		if os.IsNotExist(err) {
+			return ErrChangeFeedBehind
+		}
+		return err
+	}
+	return nil
+}
+
+// readChangeBatch reads the first batch committed at or after the position, nil if there is none yet.
+// The caller must hold db.mu.
+func (db *DB) readChangeBatch(position ChangePosition) (*ChangeBatch, error) {
+	if err := db.checkChangePosition(position); err != nil {
+		return nil, err
+	}
+	if position.IsZero() {
+		mergeFinSegmentId, err := getMergeFinSegmentId(db.options.DirPath)
+		if err != nil {
+			return nil, err
+		}
+		position.SegmentId = mergeFinSegmentId
+		if position, err = db.nextChangeSegment(position); err != nil || position.IsZero() {
+			return nil, err
+		}
+	}
+
+	var batch *ChangeBatch
+	for {
+		chunk, err := db.dataFiles.Read(&wal.ChunkPosition{
+			SegmentId:   position.SegmentId,
+			BlockNumber: position.BlockNumber,
+			ChunkOffset: position.ChunkOffset,
+		})
+		if err == io.EOF {
+			// the batches in the active segment are not written yet.
+			if position.SegmentId >= db.dataFiles.ActiveSegmentID() {
+				return nil, nil
+			}
+			if position, err = db.nextChangeSegment(position); err != nil || position.IsZero() {
+				return nil, err
+			}
+			batch = nil
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		record, err := decodeLogRecord(chunk, db.cipher)
+		if err != nil {
+			return nil, err
+		}
+		position = position.nextChunkPosition(len(chunk))
+
+		switch {
+		case record.Type == LogRecordBatchFinished:
+			batchId, err := snowflake.ParseBytes(record.Key)
+			if err != nil {
+				return nil, err
+			}
+			if batch != nil && batch.BatchId == uint64(batchId) {
+				batch.Position = position
+				return batch, nil
+			}
+			batch = nil
+		case record.BatchId == mergeFinishedBatchID:
+			// the records rewritten by a merge are not changes.
+		default:
+			// the records of a batch never finished are dropped when the next batch starts.
+			if batch == nil || batch.BatchId != record.BatchId {
+				batch = &ChangeBatch{BatchId: record.BatchId}
+			}
+			// the changes of a dropped namespace are left out.
+			if db.namespaceIndex(record.namespace) == nil {
+				continue
+			}
+			change := &Change{Action: WatchActionPut, Key: record.Key, Expire: record.Expire,
+				Namespace: db.namespaceName(record.namespace)}
+			if record.Type == LogRecordDeleted {
+				change.Action = WatchActionDelete
+			} else {
+				if err = db.loadValue(record); err != nil {
+					// the value is no longer in the blob files if BlobGC has collected it.
+					return nil, fmt.Errorf("%w: %v", ErrChangeFeedBehind, err)
+				}
+				change.Value = record.Value
+			}
+			batch.Changes = append(batch.Changes, change)
+		}
+	}
+}
+
+// nextChangeSegment returns the start of the first segment file after the one of the position,
+// or the zero position if there is none.
+// The caller must hold db.mu.
+func (db *DB) nextChangeSegment(position ChangePosition) (ChangePosition, error) {
+	ids, err := db.walSegmentIds()
+	if err != nil {
+		return ChangePosition{}, err
+	}
+	for _, id := range ids {
+		if id > position.SegmentId {
+			return ChangePosition{SegmentId: id}, nil
+		}
+	}
+	return ChangePosition{}, nil
+}
@@ -0,0 +1,270 @@
+// Copyright 2024 Joy <joyssss94@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package rosedb
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/JoyZF/zoom/pkg/wal"
+	"github.com/JoyZF/zoom/utils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func nextChangeBatch(t *testing.T, feed *ChangeFeed) *ChangeBatch {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	batch, err := feed.Next(ctx)
+	assert.Nil(t, err)
+	return batch
+}
+
+func TestChangePosition(t *testing.T) {
+	position := ChangePosition{SegmentId: 3, BlockNumber: 10, ChunkOffset: 1024}
+	parsed, err := ParseChangePosition(position.String())
+	assert.Nil(t, err)
+	assert.Equal(t, position, parsed)
+	_, err = ParseChangePosition("3-10")
+	assert.NotNil(t, err)
+
+	assert.True(t, ChangePosition{}.IsZero())
+	assert.False(t, position.IsZero())
+
+	// a chunk fits in the block
+	next := ChangePosition{SegmentId: 1}.nextChunkPosition(100)
+	assert.Equal(t, ChangePosition{SegmentId: 1, ChunkOffset: 107}, next)
+	// the rest of the block is padding
+	next = ChangePosition{SegmentId: 1, ChunkOffset: walBlockSize - 120}.nextChunkPosition(110)
+	assert.Equal(t, ChangePosition{SegmentId: 1, BlockNumber: 1}, next)
+	// a chunk spans blocks
+	next = ChangePosition{SegmentId: 1, ChunkOffset: 100}.nextChunkPosition(walBlockSize)
+	assert.Equal(t, ChangePosition{SegmentId: 1, BlockNumber: 1, ChunkOffset: 100 + 2*walChunkHeaderSize}, next)
+}
+
+func TestDB_ChangeFeed(t *testing.T) {
+	db, err := Open(DefaultOptions)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	feed, err := db.NewChangeFeed("")
+	assert.Nil(t, err)
+
+	assert.Nil(t, db.Put([]byte("a"), []byte("1")))
+	batch := db.NewBatch(DefaultBatchOptions)
+	assert.Nil(t, batch.Put([]byte("b"), []byte("2")))
+	assert.Nil(t, batch.PutWithTTL([]byte("c"), []byte("3"), time.Hour))
+	assert.Nil(t, batch.Delete([]byte("a")))
+	assert.Nil(t, batch.Commit())
+
+	first := nextChangeBatch(t, feed)
+	assert.Equal(t, 1, len(first.Changes))
+	assert.Equal(t, &Change{Action: WatchActionPut, Key: []byte("a"), Value: []byte("1")}, first.Changes[0])
+	second := nextChangeBatch(t, feed)
+	assert.Equal(t, 3, len(second.Changes))
+	assert.Equal(t, []byte("b"), second.Changes[0].Key)
+	assert.True(t, second.Changes[1].Expire > 0)
+	assert.Equal(t, WatchActionDelete, second.Changes[2].Action)
+	assert.Equal(t, second.Position, feed.Position())
+
+	// Next waits for the next commit
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	_, err = feed.Next(ctx)
+	cancel()
+	assert.Equal(t, context.DeadlineExceeded, err)
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		_ = db.Put([]byte("d"), []byte("4"))
+	}()
+	third := nextChangeBatch(t, feed)
+	assert.Equal(t, []byte("d"), third.Changes[0].Key)
+
+	// the feed can be resumed from any batch returned before
+	assert.Nil(t, feed.ResumeFrom(first.Position))
+	assert.Equal(t, second.Changes, nextChangeBatch(t, feed).Changes)
+
+	// values spanning the blocks and many small records crossing the block boundaries
+	for i := 0; i < 200; i++ {
+		size := 10 + i*13
+		if i%50 == 0 {
+			size = 100 * 1024
+		}
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(size)))
+	}
+	assert.Equal(t, third.Changes, nextChangeBatch(t, feed).Changes)
+	for i := 0; i < 200; i++ {
+		change := nextChangeBatch(t, feed).Changes[0]
+		value, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, value, change.Value)
+	}
+
+	// a closed db ends the waiting feed
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		_ = db.Close()
+	}()
+	_, err = feed.Next(context.Background())
+	assert.Equal(t, ErrDBClosed, err)
+}
+
+func TestDB_ChangeFeed_Resume(t *testing.T) {
+	options := DefaultOptions
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer func() {
+		destroyDB(db)
+	}()
+
+	feed, err := db.NewChangeFeed("consumer")
+	assert.Nil(t, err)
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+		if i == 4 {
+			// the batches are read across the segment files
+			db = sealActiveSegment(t, db)
+			feed, err = db.NewChangeFeed("consumer")
+			assert.Nil(t, err)
+		}
+	}
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, utils.GetTestKey(i), nextChangeBatch(t, feed).Changes[0].Key)
+	}
+	assert.Nil(t, feed.Ack(feed.Position()))
+	acked := feed.Position()
+	for i := 5; i < 7; i++ {
+		assert.Equal(t, utils.GetTestKey(i), nextChangeBatch(t, feed).Changes[0].Key)
+	}
+	assert.Equal(t, map[string]ChangePosition{"consumer": acked}, db.ChangeConsumers())
+
+	// the registered consumer starts from the position acknowledged
+	assert.Nil(t, db.Close())
+	db, err = Open(options)
+	assert.Nil(t, err)
+	feed, err = db.NewChangeFeed("consumer")
+	assert.Nil(t, err)
+	assert.Equal(t, acked, feed.Position())
+	assert.Equal(t, utils.GetTestKey(5), nextChangeBatch(t, feed).Changes[0].Key)
+
+	// an unregistered feed can not acknowledge
+	other, err := db.NewChangeFeed("")
+	assert.Nil(t, err)
+	assert.Equal(t, ErrChangeConsumerNotFound, other.Ack(acked))
+	assert.Nil(t, other.ResumeFrom(acked))
+	assert.Equal(t, utils.GetTestKey(5), nextChangeBatch(t, other).Changes[0].Key)
+
+	assert.Nil(t, db.RemoveChangeConsumer("consumer"))
+	assert.Empty(t, db.ChangeConsumers())
+	assert.Equal(t, ErrChangeConsumerNotFound, db.RemoveChangeConsumer("consumer"))
+}
+
+func TestDB_ChangeFeed_Retention(t *testing.T) {
+	options := DefaultOptions
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer func() {
+		destroyDB(db)
+	}()
+
+	feed, err := db.NewChangeFeed("consumer")
+	assert.Nil(t, err)
+	for i := 0; i < 300; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i%10), utils.RandomValue(128)))
+		if i%100 == 99 {
+			db = sealActiveSegment(t, db)
+		}
+	}
+	feed, err = db.NewChangeFeed("consumer")
+	assert.Nil(t, err)
+	unregistered, err := db.NewChangeFeed("")
+	assert.Nil(t, err)
+	assert.NotNil(t, nextChangeBatch(t, unregistered))
+	stale := unregistered.Position()
+
+	// nothing is merged or compacted before the consumer acknowledges the changes
+	assert.Nil(t, db.Merge(false))
+	_, err = os.Stat(mergeDirPath(options.DirPath))
+	assert.True(t, os.IsNotExist(err))
+	compacted, err := db.CompactSegments(0.5)
+	assert.Nil(t, err)
+	assert.Zero(t, compacted)
+
+	// the segments before the acknowledged position can be compacted
+	for i := 0; i < 150; i++ {
+		assert.NotNil(t, nextChangeBatch(t, feed))
+	}
+	assert.Equal(t, wal.SegmentID(2), feed.Position().SegmentId)
+	assert.Nil(t, feed.Ack(feed.Position()))
+	compacted, err = db.CompactSegments(0.5)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, compacted)
+
+	// the feed reading the compacted segment has fallen behind
+	_, err = unregistered.Next(context.Background())
+	assert.ErrorIs(t, err, ErrChangeFeedBehind)
+	assert.ErrorIs(t, unregistered.ResumeFrom(stale), ErrChangeFeedBehind)
+	for i := 150; i < 300; i++ {
+		assert.NotNil(t, nextChangeBatch(t, feed))
+	}
+}
+
+func TestDB_ChangeSnapshot(t *testing.T) {
+	db, err := Open(DefaultOptions)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+	replicaOptions := DefaultOptions
+	replicaOptions.DirPath = backupTestDir(t, "change-replica")
+	replica, err := Open(replicaOptions)
+	assert.Nil(t, err)
+	defer destroyDB(replica)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(1024)))
+	}
+	assert.Nil(t, db.PutWithTTL([]byte("ttl"), []byte("v"), time.Hour))
+	head, err := db.ChangeHead()
+	assert.Nil(t, err)
+	snapshot, position, err := db.ChangeSnapshot()
+	assert.Nil(t, err)
+	assert.Equal(t, head, position)
+
+	// the writes after the snapshot are applied from the feed
+	assert.Nil(t, db.Delete(utils.GetTestKey(0)))
+	assert.Nil(t, db.Put(utils.GetTestKey(1), []byte("updated")))
+	batch := db.NewBatch(DefaultBatchOptions)
+	assert.Nil(t, batch.Delete([]byte("ttl")))
+	assert.Nil(t, batch.PutWithTTL([]byte("ttl2"), []byte("v"), time.Hour))
+	assert.Nil(t, batch.Commit())
+
+	var buf bytes.Buffer
+	options := DefaultExportOptions
+	options.AbsoluteExpiry = true
+	count, err := snapshot.Export(&buf, options)
+	assert.Nil(t, err)
+	assert.Equal(t, 101, count)
+	snapshot.Release()
+	_, err = replica.Import(&buf, DefaultImportOptions)
+	assert.Nil(t, err)
+
+	feed, err := db.NewChangeFeed("")
+	assert.Nil(t, err)
+	assert.Nil(t, feed.ResumeFrom(position))
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, replica.ApplyChanges(nextChangeBatch(t, feed).Changes, false))
+	}
+	head, err = db.ChangeHead()
+	assert.Nil(t, err)
+	assert.Equal(t, head, feed.Position())
+
+	var expected, actual bytes.Buffer
+	_, err = db.Export(&expected, options)
+	assert.Nil(t, err)
+	_, err = replica.Export(&actual, options)
+	assert.Nil(t, err)
+	assert.Equal(t, expected.String(), actual.String())
+}
@@ -0,0 +1,364 @@
+// Copyright 2024 Joy <joyssss94@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package rosedb
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/JoyZF/zoom/pkg/wal"
+)
+
+const (
+	segmentStatFileName = "SEGSTAT"
+	// number of records rewritten in a batch by CompactSegments, the db lock is held while a batch is written.
+	compactBatchSize = 128
+)
+
+// SegmentStat is the garbage accounting of a segment file of the WAL.
+type SegmentStat struct {
+	Id           wal.SegmentID `json:"id"`
+	Size         int64         `json:"size"`
+	Garbage      int64         `json:"garbage"`       // bytes of the records which have been overwritten, deleted or expired
+	GarbageRatio float64       `json:"garbage_ratio"` // Garbage / Size
+}
+
+// segmentGarbage counts the bytes of the dead records in each segment file.
+//
+// A record is dead once it is overwritten, deleted or expired, the delete records and
+// the batch finished records are dead as soon as they are written.
+// The space is only reclaimed by Merge and CompactSegments.
+type segmentGarbage struct {
+	mu      sync.Mutex
+	garbage map[wal.SegmentID]int64
+}
+
+// segmentStatFile is the content of the SEGSTAT file.
+// The garbage is only loaded if the index is rebuilt up to the same checkpoint,
+// see encodeIndexCheckpoint, otherwise it is counted again from the WAL replayed.
+type segmentStatFile struct {
+	Checkpoint []byte                  `json:"checkpoint"`
+	Garbage    map[wal.SegmentID]int64 `json:"garbage"`
+}
+
+func newSegmentGarbage() *segmentGarbage {
+	return &segmentGarbage{garbage: make(map[wal.SegmentID]int64)}
+}
+
+// add records that the record at the position is dead.
+func (g *segmentGarbage) add(position *wal.ChunkPosition) {
+	if position == nil {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.garbage[position.SegmentId] += int64(position.ChunkSize)
+}
+
+func (g *segmentGarbage) get(id wal.SegmentID) int64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.garbage[id]
+}
+
+// remove forgets the segments deleted by CompactSegments.
+func (g *segmentGarbage) remove(ids []wal.SegmentID) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, id := range ids {
+		delete(g.garbage, id)
+	}
+}
+
+// replace discards the garbage counted so far, and sets it to the given one.
+func (g *segmentGarbage) replace(garbage map[wal.SegmentID]int64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.garbage = make(map[wal.SegmentID]int64, len(garbage))
+	for id, size := range garbage {
+		g.garbage[id] = size
+	}
+}
+
+// saveSegmentGarbage writes the garbage accounting to the SEGSTAT file,
+// with the checkpoint of the index it is consistent with.
+func (db *DB) saveSegmentGarbage(checkpoint []byte) error {
+	db.garbage.mu.Lock()
+	data, err := json.Marshal(&segmentStatFile{Checkpoint: checkpoint, Garbage: db.garbage.garbage})
+	db.garbage.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(db.options.DirPath, segmentStatFileName)
+	if err = writeFileSync(path+backupTempSuffix, data); err != nil {
+		return err
+	}
+	return os.Rename(path+backupTempSuffix, path)
+}
+
+// loadSegmentGarbage replaces the garbage accounting with the one in the SEGSTAT file,
+// if it was written at the checkpoint. It reports whether the file is loaded.
+func (db *DB) loadSegmentGarbage(checkpoint []byte) bool {
+	data, err := os.ReadFile(filepath.Join(db.options.DirPath, segmentStatFileName))
+	if err != nil {
+		return false
+	}
+	stat := &segmentStatFile{}
+	if err = json.Unmarshal(data, stat); err != nil || !bytes.Equal(stat.Checkpoint, checkpoint) {
+		return false
+	}
+	db.garbage.replace(stat.Garbage)
+	return true
+}
+
+// discardExpired removes the expired record of the key from the index,
+// the record and its value in the blob files become garbage.
+func (db *DB) discardExpired(key []byte, record *LogRecord) {
+	idx := db.namespaceIndex(record.namespace)
+	if idx == nil {
+		return
+	}
+	if position, ok := idx.Delete(key); ok {
+		db.garbage.add(position)
+		db.discardBlob(record)
+		if db.watching() {
+			db.publish(&Event{Action: WatchActionExpire, Key: key, BatchId: record.BatchId,
+				Namespace: db.namespaceName(record.namespace)})
+		}
+	}
+}
+
+// segmentStats returns the size and garbage of the segment files in ascending order of id.
+// The caller must hold db.mu.
+func (db *DB) segmentStats() ([]SegmentStat, error) {
+	ids, err := db.walSegmentIds()
+	if err != nil {
+		return nil, err
+	}
+	stats := make([]SegmentStat, 0, len(ids))
+	for _, id := range ids {
+		info, err := os.Stat(wal.SegmentFileName(db.options.DirPath, dataFileNameSuffix, id))
+		if err != nil {
+			return nil, err
+		}
+		stat := SegmentStat{Id: id, Size: info.Size(), Garbage: db.garbage.get(id)}
+		if stat.Garbage > stat.Size {
+			stat.Garbage = stat.Size
+		}
+		if stat.Size > 0 {
+			stat.GarbageRatio = float64(stat.Garbage) / float64(stat.Size)
+		}
+		stats = append(stats, stat)
+	}
+	return stats, nil
+}
+
+// CompactSegments rewrites the live records of the sealed segment files whose garbage ratio
+// is above threshold into the active segment, and deletes the old files.
+// It returns the number of segment files deleted.
+//
+// Unlike Merge, the segments with little garbage are left as they are. The db lock is held
+// while a small batch of records is rewritten, so the db keeps serving reads and writes.
+// A delete record is rewritten as well if an older segment may still hold the key,
+// only Merge drops them.
+// Like Merge, it may fail the iterators opened before it, snapshots are not affected.
+func (db *DB) CompactSegments(threshold float64) (int, error) {
+	db.mu.Lock()
+	if db.closed {
+		db.mu.Unlock()
+		return 0, ErrDBClosed
+	}
+	if !atomic.CompareAndSwapUint32(&db.mergeRunning, 0, 1) {
+		db.mu.Unlock()
+		return 0, ErrMergeRunning
+	}
+	defer atomic.StoreUint32(&db.mergeRunning, 0)
+
+	stats, err := db.segmentStats()
+	if err != nil {
+		db.mu.Unlock()
+		return 0, err
+	}
+	mergeFinSegmentId, err := getMergeFinSegmentId(db.options.DirPath)
+	if err != nil {
+		db.mu.Unlock()
+		return 0, err
+	}
+	activeSegmentId := db.dataFiles.ActiveSegmentID()
+	// the segment files holding the changes not acknowledged by the change feed consumers are kept.
+	retention, retained, err := db.changeRetention()
+	if err != nil {
+		db.mu.Unlock()
+		return 0, err
+	}
+	db.mu.Unlock()
+
+	var candidates []wal.SegmentID
+	// the hint file may point to the keys of any merged segment.
+	keepDeletes := mergeFinSegmentId > 0
+	for _, stat := range stats {
+		if stat.Id >= activeSegmentId || (retained && stat.Id > retention) {
+			break
+		}
+		if stat.Size == 0 {
+			continue
+		}
+		if stat.GarbageRatio <= threshold {
+			// an older segment is kept, so it may hold the keys deleted in the later ones.
+			keepDeletes = true
+			continue
+		}
+		if err = db.compactSegment(stat.Id, keepDeletes); err != nil {
+			return 0, err
+		}
+		candidates = append(candidates, stat.Id)
+	}
+	if len(candidates) == 0 {
+		return 0, nil
+	}
+	if err = db.removeSegments(candidates); err != nil {
+		return 0, err
+	}
+	return len(candidates), nil
+}
+
+// compactSegment rewrites the live records of the segment into the active segment.
+// If keepDeletes is true, a delete record is written for every key of the dead records
+// which is not in the index, so the older records of the key stay deleted when
+// the index is rebuilt from the WAL.
+func (db *DB) compactSegment(id wal.SegmentID, keepDeletes bool) error {
+	type segmentEntry struct {
+		record   *LogRecord
+		position *wal.ChunkPosition
+	}
+	type deletedKey struct {
+		namespace uint32
+		key       string
+	}
+	var entries []*segmentEntry
+	deleted := make(map[deletedKey]struct{})
+	rewriteEntries := func() error {
+		if len(entries) == 0 {
+			return nil
+		}
+		batch := db.NewBatch(BatchOptions{Sync: false})
+		if db.closed {
+			_ = batch.Rollback()
+			return ErrDBClosed
+		}
+		now := time.Now().UnixNano()
+		for _, entry := range entries {
+			record := entry.record
+			idx := db.namespaceIndex(record.namespace)
+			if idx == nil {
+				// the records of a dropped namespace are all garbage.
+				continue
+			}
+			position := idx.Get(record.Key)
+			if position != nil && positionEquals(position, entry.position) {
+				if !record.IsExpired(now) {
+					// the value is rewritten with the codec in the current options, like Merge does.
+					if err := record.decompress(); err != nil {
+						_ = batch.Rollback()
+						return err
+					}
+					batch.pendingWrites = append(batch.pendingWrites, record)
+					continue
+				}
+				db.discardExpired(record.Key, record)
+				position = nil
+			}
+			key := deletedKey{namespace: record.namespace, key: string(record.Key)}
+			if _, ok := deleted[key]; position == nil && keepDeletes && !ok {
+				deleted[key] = struct{}{}
+				batch.pendingWrites = append(batch.pendingWrites,
+					&LogRecord{Key: record.Key, Type: LogRecordDeleted, namespace: record.namespace})
+			}
+		}
+		entries = entries[:0]
+		return batch.Commit()
+	}
+
+	reader := db.dataFiles.NewReaderWithMax(id)
+	for reader.CurrentSegmentId() < id {
+		reader.SkipCurrentSegment()
+	}
+	for {
+		chunk, position, err := reader.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+		record, err := decodeLogRecord(chunk, db.cipher)
+		if err != nil {
+			return err
+		}
+		if record.Type == LogRecordBatchFinished {
+			continue
+		}
+		entries = append(entries, &segmentEntry{record: record, position: position})
+		if len(entries) == compactBatchSize {
+			if err = rewriteEntries(); err != nil {
+				return err
+			}
+		}
+	}
+	return rewriteEntries()
+}
+
+// removeSegments deletes the segment files whose live records have been rewritten.
+func (db *DB) removeSegments(ids []wal.SegmentID) error {
+	// make sure the rewritten records are persisted before the old files are removed.
+	if err := db.dataFiles.Sync(); err != nil {
+		return err
+	}
+
+	db.indexSnapshotMu.Lock()
+	defer db.indexSnapshotMu.Unlock()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return ErrDBClosed
+	}
+
+	// the persisted index must not point to the removed segments.
+	if err := db.flushIndex(); err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(db.options.DirPath, indexSnapshotFileName)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	db.indexSnapshotCheckpoint = nil
+
+	// If live snapshots still reference the data files, they stay open,
+	// so the snapshots can keep reading the removed segments.
+	if err := db.closeFiles(); err != nil {
+		return err
+	}
+	var err error
+	for _, id := range ids {
+		if err = os.Remove(wal.SegmentFileName(db.options.DirPath, dataFileNameSuffix, id)); err != nil &&
+			!os.IsNotExist(err) {
+			break
+		}
+		err = nil
+	}
+	// the data files are opened again even if a file can not be removed, the db is still usable.
+	dataFiles, openErr := db.openWalFiles()
+	if openErr != nil {
+		return openErr
+	}
+	db.dataFiles = dataFiles
+	db.garbage.remove(ids)
+	return err
+}
@@ -0,0 +1,170 @@
+// Copyright 2024 Joy <joyssss94@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package rosedb
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/JoyZF/zoom/pkg/wal"
+	"github.com/JoyZF/zoom/utils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// segmentGarbageOf returns the garbage of the segment files by id.
+func segmentGarbageOf(db *DB) map[wal.SegmentID]int64 {
+	garbage := make(map[wal.SegmentID]int64)
+	for _, stat := range db.Stat().Segments {
+		garbage[stat.Id] = stat.Garbage
+	}
+	return garbage
+}
+
+func totalGarbage(db *DB) int64 {
+	var total int64
+	for _, garbage := range segmentGarbageOf(db) {
+		total += garbage
+	}
+	return total
+}
+
+// sealActiveSegment reopens the db with an empty segment file after the active one,
+// so the records written so far are in sealed segments.
+func sealActiveSegment(t *testing.T, db *DB) *DB {
+	options := db.options
+	activeSegmentId := db.dataFiles.ActiveSegmentID()
+	assert.Nil(t, db.Close())
+	fd, err := os.Create(wal.SegmentFileName(options.DirPath, dataFileNameSuffix, activeSegmentId+1))
+	assert.Nil(t, err)
+	assert.Nil(t, fd.Close())
+	db, err = Open(options)
+	assert.Nil(t, err)
+	return db
+}
+
+func TestDB_Segment_Garbage(t *testing.T) {
+	options := DefaultOptions
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+	// only the batch finished records are garbage
+	garbage := totalGarbage(db)
+	assert.True(t, garbage > 0)
+
+	// overwritten, deleted and expired records are garbage
+	assert.Nil(t, db.Put(utils.GetTestKey(0), utils.RandomValue(128)))
+	assert.True(t, totalGarbage(db) > garbage+128)
+	garbage = totalGarbage(db)
+	assert.Nil(t, db.Delete(utils.GetTestKey(1)))
+	assert.True(t, totalGarbage(db) > garbage+128)
+	garbage = totalGarbage(db)
+	assert.Nil(t, db.PutWithTTL(utils.GetTestKey(2), utils.RandomValue(128), time.Millisecond))
+	time.Sleep(10 * time.Millisecond)
+	_, err = db.Get(utils.GetTestKey(2))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.True(t, totalGarbage(db) > garbage+2*128)
+	garbage = totalGarbage(db)
+
+	stats := db.Stat().Segments
+	assert.Equal(t, 1, len(stats))
+	assert.Equal(t, float64(stats[0].Garbage)/float64(stats[0].Size), stats[0].GarbageRatio)
+
+	// the garbage accounting survives restarts
+	assert.Nil(t, db.Close())
+	db, err = Open(options)
+	assert.Nil(t, err)
+	assert.Equal(t, garbage, totalGarbage(db))
+
+	// and is counted again from the WAL if the stat file is lost
+	assert.Nil(t, db.Close())
+	assert.Nil(t, os.Remove(options.DirPath+"/"+segmentStatFileName))
+	db, err = Open(options)
+	assert.Nil(t, err)
+	assert.Equal(t, garbage, totalGarbage(db))
+}
+
+func TestDB_CompactSegments(t *testing.T) {
+	options := DefaultOptions
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer func() {
+		destroyDB(db)
+	}()
+
+	values := make(map[string][]byte)
+	for i := 0; i < 1000; i++ {
+		key, value := utils.GetTestKey(i), utils.RandomValue(128)
+		values[string(key)] = value
+		assert.Nil(t, db.Put(key, value))
+		if i%100 == 99 {
+			db = sealActiveSegment(t, db)
+		}
+	}
+	// the keys in the first segments are mostly overwritten or deleted
+	for i := 0; i < 300; i++ {
+		key := utils.GetTestKey(i)
+		if i%2 == 0 {
+			assert.Nil(t, db.Delete(key))
+			delete(values, string(key))
+		} else {
+			value := utils.RandomValue(128)
+			values[string(key)] = value
+			assert.Nil(t, db.Put(key, value))
+		}
+	}
+	assert.Nil(t, db.PutWithTTL(utils.GetTestKey(0), utils.RandomValue(128), time.Millisecond))
+	db = sealActiveSegment(t, db)
+	time.Sleep(10 * time.Millisecond)
+
+	before := db.Stat().Segments
+	var expected int
+	for _, stat := range before[:len(before)-1] {
+		if stat.GarbageRatio > 0.5 {
+			expected++
+		}
+	}
+	assert.True(t, expected > 0)
+	assert.True(t, expected < len(before)-1)
+
+	compacted, err := db.CompactSegments(0.5)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, compacted)
+	after := db.Stat().Segments
+	for _, stat := range before[:len(before)-1] {
+		_, err := os.Stat(wal.SegmentFileName(options.DirPath, dataFileNameSuffix, stat.Id))
+		assert.Equal(t, stat.GarbageRatio > 0.5, os.IsNotExist(err), stat.Id)
+	}
+	assert.Equal(t, len(before)-compacted, len(after)-(int(after[len(after)-1].Id)-int(before[len(before)-1].Id)))
+
+	check := func() {
+		for key, value := range values {
+			val, err := db.Get([]byte(key))
+			assert.Nil(t, err)
+			assert.Equal(t, value, val)
+		}
+		for i := 0; i < 300; i += 2 {
+			_, err := db.Get(utils.GetTestKey(i))
+			assert.Equal(t, ErrKeyNotFound, err)
+		}
+		assert.Equal(t, len(values), db.Stat().KeysNum)
+	}
+	check()
+	// the deleted keys stay deleted when the index is rebuilt without the compacted segments
+	assert.Nil(t, db.Close())
+	db, err = Open(options)
+	assert.Nil(t, err)
+	check()
+
+	// nothing to do if the garbage ratio is below the threshold
+	compacted, err = db.CompactSegments(0.99)
+	assert.Nil(t, err)
+	assert.Zero(t, compacted)
+}
@@ -0,0 +1,138 @@
+// Copyright 2024 Joy <joyssss94@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package rosedb
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"sync"
+
+	"github.com/golang/snappy"
+)
+
+// CompressionType is the codec used to compress the values of the log records.
+type CompressionType = byte
+
+const (
+	// CompressionNone stores the values as they are.
+	CompressionNone CompressionType = iota
+	// CompressionFlate compresses the values with DEFLATE.
+	CompressionFlate
+	// CompressionGzip compresses the values with gzip.
+	CompressionGzip
+	// CompressionSnappy compresses the values with snappy, it is the fastest but compresses the least.
+	CompressionSnappy
+)
+
+var (
+	flateWriterPool = sync.Pool{New: func() interface{} {
+		w, _ := flate.NewWriter(nil, flate.DefaultCompression)
+		return w
+	}}
+	gzipWriterPool = sync.Pool{New: func() interface{} {
+		return gzip.NewWriter(nil)
+	}}
+)
+
+// compressValue compresses the value with the given codec.
+func compressValue(codec CompressionType, value []byte) ([]byte, error) {
+	switch codec {
+	case CompressionNone:
+		return value, nil
+	case CompressionFlate:
+		var buf bytes.Buffer
+		w := flateWriterPool.Get().(*flate.Writer)
+		defer flateWriterPool.Put(w)
+		w.Reset(&buf)
+		if _, err := w.Write(value); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case CompressionGzip:
+		var buf bytes.Buffer
+		w := gzipWriterPool.Get().(*gzip.Writer)
+		defer gzipWriterPool.Put(w)
+		w.Reset(&buf)
+		if _, err := w.Write(value); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case CompressionSnappy:
+		return snappy.Encode(nil, value), nil
+	default:
+		return nil, ErrInvalidCompression
+	}
+}
+
+// decompressValue decompresses the value which is compressed with the given codec.
+func decompressValue(codec CompressionType, value []byte) ([]byte, error) {
+	switch codec {
+	case CompressionNone:
+		return value, nil
+	case CompressionFlate:
+		r := flate.NewReader(bytes.NewReader(value))
+		defer func() {
+			_ = r.Close()
+		}()
+		return io.ReadAll(r)
+	case CompressionGzip:
+		r, err := gzip.NewReader(bytes.NewReader(value))
+		if err != nil {
+			return nil, err
+		}
+		defer func() {
+			_ = r.Close()
+		}()
+		return io.ReadAll(r)
+	case CompressionSnappy:
+		return snappy.Decode(nil, value)
+	default:
+		return nil, ErrInvalidCompression
+	}
+}
+
+// compressRecord returns the record to be encoded into the data files,
+// whose value is compressed with the codec in the options.
+// The given record is not modified, values smaller than CompressionMinSize,
+// or not getting smaller after compression, are stored as they are.
+func (db *DB) compressRecord(record *LogRecord) (*LogRecord, error) {
+	codec := db.options.Compression
+	if record.codec != CompressionNone || record.blob || codec == CompressionNone ||
+		len(record.Value) < db.options.CompressionMinSize {
+		return record, nil
+	}
+
+	value, err := compressValue(codec, record.Value)
+	if err != nil {
+		return nil, err
+	}
+	if len(value) >= len(record.Value) {
+		return record, nil
+	}
+	compressed := *record
+	compressed.Value, compressed.codec = value, codec
+	return &compressed, nil
+}
+
+// decompress decompresses the value of the record in place.
+func (lr *LogRecord) decompress() error {
+	if lr.codec == CompressionNone {
+		return nil
+	}
+	value, err := decompressValue(lr.codec, lr.Value)
+	if err != nil {
+		return err
+	}
+	lr.Value, lr.codec = value, CompressionNone
+	return nil
+}
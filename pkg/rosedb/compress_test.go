@@ -0,0 +1,118 @@
+// Copyright 2024 Joy <joyssss94@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package rosedb
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/JoyZF/zoom/utils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_compressValue(t *testing.T) {
+	value := bytes.Repeat([]byte(`{"name":"zoom","type":"kv"}`), 100)
+	for _, codec := range []CompressionType{CompressionNone, CompressionFlate, CompressionGzip, CompressionSnappy} {
+		compressed, err := compressValue(codec, value)
+		assert.Nil(t, err)
+		if codec != CompressionNone {
+			assert.Less(t, len(compressed), len(value))
+		}
+		decompressed, err := decompressValue(codec, compressed)
+		assert.Nil(t, err)
+		assert.Equal(t, value, decompressed)
+	}
+
+	_, err := compressValue(CompressionSnappy+1, value)
+	assert.Equal(t, ErrInvalidCompression, err)
+}
+
+func TestDB_Compression_Mixed_Codecs(t *testing.T) {
+	options := DefaultOptions
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	value := bytes.Repeat([]byte("rosedb-compress-"), 64)
+	small := []byte("small")
+	// written without compression
+	assert.Nil(t, db.Put([]byte("none"), value))
+
+	// reopen with each codec, and the records written before keep decoding
+	codecs := map[string]CompressionType{
+		"flate":  CompressionFlate,
+		"gzip":   CompressionGzip,
+		"snappy": CompressionSnappy,
+	}
+	for name, codec := range codecs {
+		assert.Nil(t, db.Close())
+		options.Compression = codec
+		db, err = Open(options)
+		assert.Nil(t, err)
+		assert.Nil(t, db.Put([]byte(name), value))
+		assert.Nil(t, db.Put([]byte(name+"-small"), small))
+	}
+
+	for _, key := range []string{"none", "flate", "gzip", "snappy"} {
+		val, err := db.Get([]byte(key))
+		assert.Nil(t, err)
+		assert.Equal(t, value, val)
+	}
+	val, err := db.Get([]byte("gzip-small"))
+	assert.Nil(t, err)
+	assert.Equal(t, small, val)
+
+	// merge rewrites all the records with the current codec
+	assert.Nil(t, db.Merge(true))
+	for _, key := range []string{"none", "flate", "gzip", "snappy"} {
+		pos := db.index.Get([]byte(key))
+		chunk, err := db.dataFiles.Read(pos)
+		assert.Nil(t, err)
+		record, err := decodeLogRecord(chunk, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, options.Compression, record.codec)
+
+		val, err := db.Get([]byte(key))
+		assert.Nil(t, err)
+		assert.Equal(t, value, val)
+	}
+	pos := db.index.Get([]byte("gzip-small"))
+	chunk, err := db.dataFiles.Read(pos)
+	assert.Nil(t, err)
+	record, err := decodeLogRecord(chunk, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, CompressionNone, record.codec)
+}
+
+func TestDB_Compression_Expire_Persist(t *testing.T) {
+	options := DefaultOptions
+	options.Compression = CompressionSnappy
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	key, value := utils.GetTestKey(1), bytes.Repeat([]byte("a"), 1024)
+	assert.Nil(t, db.Put(key, value))
+	assert.Nil(t, db.Expire(key, 60*time.Second))
+	assert.Nil(t, db.Persist(key))
+
+	val, err := db.Get(key)
+	assert.Nil(t, err)
+	assert.Equal(t, value, val)
+
+	iter, err := db.NewIterator(IteratorOptions{})
+	assert.Nil(t, err)
+	defer iter.Close()
+	assert.Equal(t, value, iter.Value())
+}
+
+func TestDB_Invalid_Compression(t *testing.T) {
+	options := DefaultOptions
+	options.Compression = CompressionSnappy + 1
+	_, err := Open(options)
+	assert.Equal(t, ErrInvalidCompression, err)
+}
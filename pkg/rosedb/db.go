@@ -6,22 +6,21 @@ package rosedb
 
 import (
 	"context"
+	"encoding/binary"
 	"fmt"
 	"github.com/JoyZF/zoom/utils"
-	"io"
 	"os"
 	"path/filepath"
 	"regexp"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/JoyZF/errors"
-	"github.com/JoyZF/wal"
-	"github.com/bwmarrin/snowflake"
 	"github.com/gofrs/flock"
-	"github.com/robfig/cron/v3"
 
 	"github.com/JoyZF/zoom/pkg/rosedb/index"
+	"github.com/JoyZF/zoom/pkg/wal"
 )
 
 const (
@@ -29,6 +28,8 @@ const (
 	dataFileNameSuffix = ".SEG"
 	hintFileNameSuffix = ".HINT"
 	mergeFinNameSuffix = ".MERGEFIN"
+	// the block size of the wal segment files
+	walBlockSize = 32 * wal.KB
 )
 
 type DB struct {
@@ -45,13 +46,48 @@ type DB struct {
 	encodeHeader     []byte
 	watchCh          chan *Event // user consume channel for watch events
 	watcher          *Watcher
-	expiredCursorKey []byte     // the location to which DeleteExpiredKeys executes.
-	cronScheduler    *cron.Cron // cron scheduler for auto merge task
+	expiredCursorKey []byte             // the location to which DeleteExpiredKeys executes.
+	snapshots        map[*wal.WAL]int   // number of live snapshots referencing each data files instance
+	cipher           *recordCipher      // encrypts the records, nil if encryption is disabled
+	blobs            *blobStore         // large values separated from the data files
+	blobGCRunning    uint32             // indicate if the blob gc is running
+	walPosition      *wal.ChunkPosition // position of the last record written to the WAL
+	garbage          *segmentGarbage    // dead bytes in each segment file
+	expirer          *expirer           // removes the expired keys in the background, nil if disabled
+
+	namespaces      map[uint32]*namespaceState // live namespaces other than the default one by id
+	nextNamespaceId uint32                     // id of the next namespace created, the ids are never reused
+
+	subscriptionsMu sync.Mutex
+	subscriptions   atomic.Pointer[[]*Subscription] // replaced as a whole, so events are published without a lock
+
+	changeConsumers map[string]ChangePosition // positions acknowledged by the registered change feed consumers
+	changeNotify    chan struct{}             // closed and replaced when a batch is committed
+
+	indexSnapshotMu         sync.Mutex    // serializes the writes of the index snapshot
+	indexSnapshotCheckpoint []byte        // checkpoint of the latest index snapshot file
+	indexSnapshotStop       chan struct{} // stops the periodic index snapshots
+	indexSnapshotDone       chan struct{} // closed when the periodic index snapshots stop
+	indexSnapshotStopOnce   sync.Once
+
+	recoveryStat RecoveryStat // statistics of the latest index rebuild from the WAL
+
+	mergeProgressMu sync.Mutex
+	mergeProgress   MergeProgress      // progress of the running merge, or of the last one
+	autoMergeCancel context.CancelFunc // stops the auto merge when the db is closed
+	autoMergeDone   chan struct{}      // closed when the auto merge stops
+	requests        uint64             // number of reads and writes, the load seen by the merge policy
 }
 
 type Stat struct {
 	KeysNum  int
 	DiskSize int64
+	Blobs    []BlobFileStat // size and garbage of the blob files
+	Segments []SegmentStat  // size and garbage of the segment files
+	Recovery RecoveryStat   // statistics of the latest index rebuild from the WAL
+	Merge    MergeProgress  // progress of the running merge, or of the last one
+	// Namespaces are the namespaces other than the default one, whose keys are not in KeysNum.
+	Namespaces []NamespaceStat
 }
 
 // Open a database with the specified options.
@@ -63,7 +99,8 @@ type Stat struct {
 // It will open the wal files in the database directory and load the index from them.
 // Return the DB instance, or an error if any.
 func Open(options Options) (*DB, error) {
-	// check options
+	// check options, the zero index knobs and recovery workers mean the defaults
+	options = options.withDefaults()
 	if err := checkOptions(options); err != nil {
 		return nil, err
 	}
@@ -87,26 +124,74 @@ func Open(options Options) (*DB, error) {
 
 	// load merge files if exists
 	if err = loadMergeFiles(options.DirPath); err != nil {
+		_ = fileLock.Unlock()
 		return nil, err
 	}
 
 	// init DB instance
 	db := &DB{
-		index:        index.NewIndexer(),
 		options:      options,
 		fileLock:     fileLock,
 		batchPool:    sync.Pool{New: newBatch},
 		recordPool:   sync.Pool{New: newRecord},
 		encodeHeader: make([]byte, maxLogRecordHeaderSize),
+		snapshots:    make(map[*wal.WAL]int),
+		cipher:       newRecordCipher(options.KeyProvider),
+		garbage:      newSegmentGarbage(),
+		changeNotify: make(chan struct{}),
+	}
+
+	// load the registered change feed consumers
+	if err = db.loadChangeConsumers(); err != nil {
+		_ = fileLock.Unlock()
+		return nil, err
+	}
+
+	// load the namespaces, their keys are loaded into their indexes along with the index
+	if err = db.loadNamespaces(); err != nil {
+		_ = fileLock.Unlock()
+		return nil, err
+	}
+
+	// make sure the current encryption key is available before writing anything
+	if db.cipher != nil {
+		if _, err = db.cipher.currentKeyID(); err != nil {
+			_ = fileLock.Unlock()
+			return nil, err
+		}
+	}
+
+	// open blob files
+	if db.blobs, err = openBlobStore(options.DirPath, options.BlobFileSize, db.cipher); err != nil {
+		_ = fileLock.Unlock()
+		return nil, err
+	}
+
+	if options.ActiveExpireInterval > 0 {
+		db.expirer = newExpirer()
 	}
 
 	// open data files
 	if db.dataFiles, err = db.openWalFiles(); err != nil {
+		db.blobs.close()
+		_ = fileLock.Unlock()
 		return nil, err
 	}
 
 	// load index
+	if db.index, err = db.openIndex(); err != nil {
+		_ = db.dataFiles.Close()
+		db.blobs.close()
+		_ = fileLock.Unlock()
+		return nil, err
+	}
 	if err = db.loadIndex(); err != nil {
+		// the index can not be loaded with a wrong encryption key,
+		// release the files so the db can be opened again with the right one.
+		db.closeIndex()
+		_ = db.dataFiles.Close()
+		db.blobs.close()
+		_ = fileLock.Unlock()
 		return nil, err
 	}
 
@@ -118,24 +203,24 @@ func Open(options Options) (*DB, error) {
 		go db.watcher.sendEvent(db.watchCh)
 	}
 
-	// enable auto merge task
-	if len(options.AutoMergeCronExpr) > 0 {
-		db.cronScheduler = cron.New(
-			cron.WithParser(
-				cron.NewParser(cron.SecondOptional | cron.Minute | cron.Hour |
-					cron.Dom | cron.Month | cron.Dow | cron.Descriptor),
-			),
-		)
-		_, err = db.cronScheduler.AddFunc(options.AutoMergeCronExpr, func() {
-			// maybe we should deal with different errors with different logic, but a background task can't omit its
-			// error.
-			// after auto merge, we should close and reopen the db.
-			_ = db.Merge(true)
-		})
-		if err != nil {
-			return nil, err
-		}
-		db.cronScheduler.Start()
+	// enable periodic index snapshots, the disk index is persisted as it changes instead.
+	if options.IndexSnapshotInterval > 0 && index.IsMemory(options.IndexType) {
+		db.indexSnapshotStop = make(chan struct{})
+		db.indexSnapshotDone = make(chan struct{})
+		go db.runIndexSnapshots(options.IndexSnapshotInterval)
+	}
+
+	// enable active expiration
+	if db.expirer != nil {
+		go db.runExpirer(options.ActiveExpireInterval, options.ActiveExpireStepTime)
+	}
+
+	// enable auto merge task, the policy is checked by checkOptions.
+	if policy, _ := options.mergePolicy(); policy != nil {
+		var autoMergeCtx context.Context
+		autoMergeCtx, db.autoMergeCancel = context.WithCancel(context.Background())
+		db.autoMergeDone = make(chan struct{})
+		go db.runMergePolicy(autoMergeCtx, policy, options.MergePolicyInterval)
 	}
 
 	return db, nil
@@ -148,12 +233,55 @@ func checkOptions(options Options) error {
 	if options.SegmentSize <= 0 {
 		return errors.New("database data file size must be greater than 0")
 	}
-
-	if len(options.AutoMergeCronExpr) > 0 {
-		if _, err := cron.NewParser(cron.SecondOptional | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor).
-			Parse(options.AutoMergeCronExpr); err != nil {
-			return fmt.Errorf("databse auto merge cron expression is invalid, err: %s", err)
+	if options.Compression > CompressionSnappy {
+		return ErrInvalidCompression
+	}
+	if options.BlobThreshold > 0 && options.BlobFileSize <= 0 {
+		return errors.New("database blob file size must be greater than 0")
+	}
+	if options.IndexType != index.BPTree && !index.IsMemory(options.IndexType) {
+		return errors.New("database index type is invalid")
+	}
+	// only the knobs of the index type are checked, the namespaces and the index snapshots
+	// of index.BPTree are btrees.
+	switch options.IndexType {
+	case index.BTree, index.BPTree:
+		if options.IndexBTreeDegree <= 1 {
+			return errors.New("database index btree degree must be greater than 1")
 		}
+	case index.SkipList:
+		if options.IndexSkipListMaxLevel <= 0 {
+			return errors.New("database index skiplist max level must be greater than 0")
+		}
+	case index.Hash:
+		if options.IndexHashShards <= 0 {
+			return errors.New("database index hash shards must be greater than 0")
+		}
+	}
+	if options.MergeBytesPerSecond < 0 {
+		return errors.New("database merge bytes per second must not be negative")
+	}
+	if options.ActiveExpireInterval < 0 {
+		return errors.New("database active expire interval must not be negative")
+	}
+	if options.ActiveExpireInterval > 0 && options.ActiveExpireStepTime <= 0 {
+		return errors.New("database active expire step time must be greater than 0")
+	}
+	if options.RecoveryWorkers < 0 {
+		return errors.New("database recovery workers must not be negative")
+	}
+	if options.IndexType == index.BPTree && options.IndexCacheSize <= 0 {
+		return errors.New("database index cache size must be greater than 0")
+	}
+
+	if options.MergePolicy != nil && len(options.AutoMergeCronExpr) > 0 {
+		return errors.New("database merge policy and auto merge cron expression can not be both set")
+	}
+	if _, err := options.mergePolicy(); err != nil {
+		return err
+	}
+	if (options.MergePolicy != nil || len(options.AutoMergeCronExpr) > 0) && options.MergePolicyInterval <= 0 {
+		return errors.New("database merge policy interval must be greater than 0")
 	}
 
 	return nil
@@ -171,19 +299,180 @@ func (db *DB) openWalFiles() (*wal.WAL, error) {
 	})
 }
 
+// openIndex opens the index of the type in the options.
+func (db *DB) openIndex() (index.Indexer, error) {
+	if db.options.IndexType == index.BPTree {
+		return index.OpenBPTree(index.BPTreeOptions{
+			DirPath:   db.options.DirPath,
+			CacheSize: db.options.IndexCacheSize,
+		})
+	}
+	return index.NewIndexer(db.options.memoryIndexOptions()), nil
+}
+
 func (db *DB) loadIndex() error {
+	// the garbage is counted again along with the index.
+	db.garbage.replace(nil)
+	// the keys with a ttl are found by scanning the new index.
+	if db.expirer != nil {
+		db.expirer.reset()
+	}
+	// the indexes of the namespaces are kept in memory, they are loaded from the snapshot
+	// of the index, or rebuilt from the hint file and the whole WAL along with it.
+	db.resetNamespaceIndexes()
+
+	// the disk index only needs the WAL written after it was persisted,
+	// if the indexes of the namespaces were persisted with it.
+	diskIndex, ok := db.index.(index.DiskIndexer)
+	if ok {
+		checkpoint := diskIndex.Checkpoint()
+		if start, valid := db.checkpointPosition(checkpoint); valid {
+			if dropped, loaded := db.loadNamespaceSnapshot(checkpoint); loaded {
+				db.loadSegmentGarbage(checkpoint)
+				db.addDroppedGarbage(dropped)
+				if err := db.loadIndexFromWAL(start); err != nil {
+					return err
+				}
+				return db.flushIndex()
+			}
+		}
+		// the disk index is stale, rebuild it from scratch.
+		if err := diskIndex.Reset(); err != nil {
+			return err
+		}
+	} else if start, dropped, loaded := db.loadIndexSnapshot(); loaded {
+		db.loadSegmentGarbage(db.indexSnapshotCheckpoint)
+		db.addDroppedGarbage(dropped)
+		// the snapshot has the keys in the hint file as well.
+		return db.loadIndexFromWAL(start)
+	}
+
 	// load index frm hint file
 	if err := db.loadIndexFromHintFile(); err != nil {
 		return err
 	}
 	// load index from data files
-	if err := db.loadIndexFromWAL(); err != nil {
+	if err := db.loadIndexFromWAL(nil); err != nil {
+		return err
+	}
+	// the garbage of the records dropped without a trace in the WAL, like the expired keys
+	// in the merged segments, is only known if it was saved after the last write.
+	mergeFinSegmentId, err := getMergeFinSegmentId(db.options.DirPath)
+	if err != nil {
 		return err
 	}
+	db.loadSegmentGarbage(encodeIndexCheckpoint(mergeFinSegmentId, db.walPosition))
+	return db.flushIndex()
+}
+
+// resetIndex discards all keys in the index, the snapshots keep their copies.
+func (db *DB) resetIndex() error {
+	if diskIndex, ok := db.index.(index.DiskIndexer); ok {
+		return diskIndex.Reset()
+	}
+	db.index = index.NewIndexer(db.options.memoryIndexOptions())
 	return nil
 }
 
+// closeIndex releases the files of the disk index, the changes not flushed are discarded.
+func (db *DB) closeIndex() {
+	if diskIndex, ok := db.index.(index.DiskIndexer); ok {
+		_ = diskIndex.Close()
+	}
+}
+
+// flushIndex persists the disk index, with a checkpoint of the last record in the WAL.
+// The indexes of the namespaces, which are only kept in memory, are written along with it.
+// The caller must hold db.mu.
+func (db *DB) flushIndex() error {
+	diskIndex, ok := db.index.(index.DiskIndexer)
+	if !ok {
+		return nil
+	}
+	// the persisted index must not point to the records which may be lost in a crash.
+	if err := db.dataFiles.Sync(); err != nil {
+		return err
+	}
+	mergeFinSegmentId, err := getMergeFinSegmentId(db.options.DirPath)
+	if err != nil {
+		return err
+	}
+	checkpoint := encodeIndexCheckpoint(mergeFinSegmentId, db.walPosition)
+	if err = db.saveNamespaceSnapshot(checkpoint); err != nil {
+		return err
+	}
+	if err = diskIndex.Flush(checkpoint); err != nil {
+		return err
+	}
+	return db.saveSegmentGarbage(checkpoint)
+}
+
+// +--------------------+--------------------------+
+// | merge fin seg id   |  position of last record |
+// +--------------------+--------------------------+
+//
+//	uvarint               empty if the WAL is empty
+func encodeIndexCheckpoint(mergeFinSegmentId wal.SegmentID, position *wal.ChunkPosition) []byte {
+	buf := binary.AppendUvarint(nil, uint64(mergeFinSegmentId))
+	if position != nil {
+		buf = append(buf, position.Encode()...)
+	}
+	return buf
+}
+
+// checkpointPosition returns the position in the checkpoint of a persisted index, after which
+// the records in the WAL are not in the index, and false if the index can not be used,
+// because it is not persisted, or the WAL has been merged or truncated since.
+func (db *DB) checkpointPosition(checkpoint []byte) (*wal.ChunkPosition, bool) {
+	if len(checkpoint) == 0 {
+		return nil, false
+	}
+	mergeFinSegmentId, n := binary.Uvarint(checkpoint)
+	if n <= 0 {
+		return nil, false
+	}
+	current, err := getMergeFinSegmentId(db.options.DirPath)
+	if err != nil || wal.SegmentID(mergeFinSegmentId) != current {
+		return nil, false
+	}
+	if n == len(checkpoint) {
+		return nil, true
+	}
+
+	position := wal.DecodeChunkPosition(checkpoint[n:])
+	if position.SegmentId > db.dataFiles.ActiveSegmentID() {
+		return nil, false
+	}
+	info, err := os.Stat(wal.SegmentFileName(db.options.DirPath, dataFileNameSuffix, position.SegmentId))
+	if err != nil || info.Size() < int64(position.BlockNumber)*walBlockSize+position.ChunkOffset {
+		return nil, false
+	}
+	return position, true
+}
+
+// positionAfter reports whether the position a is after b in the WAL.
+func positionAfter(a, b *wal.ChunkPosition) bool {
+	if a.SegmentId != b.SegmentId {
+		return a.SegmentId > b.SegmentId
+	}
+	if a.BlockNumber != b.BlockNumber {
+		return a.BlockNumber > b.BlockNumber
+	}
+	return a.ChunkOffset > b.ChunkOffset
+}
+
 func (db *DB) Close() error {
+	// the periodic index snapshot takes db.mu, so it is stopped before the lock is held.
+	db.stopIndexSnapshots()
+	db.stopExpirer()
+	// the auto merge running is stopped, it leaves nothing behind.
+	if db.autoMergeCancel != nil {
+		db.autoMergeCancel()
+		<-db.autoMergeDone
+	}
+
+	db.indexSnapshotMu.Lock()
+	defer db.indexSnapshotMu.Unlock()
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
@@ -191,10 +480,48 @@ func (db *DB) Close() error {
 		return nil
 	}
 
+	// persist the index, so it does not have to be rebuilt the next time the db is opened.
+	if err := db.flushIndex(); err != nil {
+		return err
+	}
+	if db.options.IndexSnapshotInterval > 0 && index.IsMemory(db.options.IndexType) {
+		indexes, checkpoint, err := db.indexSnapshot()
+		if err != nil {
+			return err
+		}
+		if indexes != nil {
+			if err = db.writeIndexSnapshot(indexes, checkpoint); err != nil {
+				return err
+			}
+		}
+	}
+	db.closeIndex()
+	// persist the garbage accounting, so it is not lost for the segments not replayed next time.
+	mergeFinSegmentId, err := getMergeFinSegmentId(db.options.DirPath)
+	if err != nil {
+		return err
+	}
+	if err = db.saveSegmentGarbage(encodeIndexCheckpoint(mergeFinSegmentId, db.walPosition)); err != nil {
+		return err
+	}
+
 	// close file
 	if err := db.closeFiles(); err != nil {
 		return err
 	}
+	// close the data files still referenced by live snapshots
+	for dataFiles := range db.snapshots {
+		_ = dataFiles.Close()
+	}
+	db.snapshots = nil
+	// the blob files collected by BlobGC can be removed now that no snapshot reads them
+	if err := db.blobs.removeObsolete(); err != nil {
+		return err
+	}
+	if err := db.blobs.persist(); err != nil {
+		return err
+	}
+	db.blobs.close()
 
 	// TODO free FLOCK
 	// release file lock
@@ -203,23 +530,24 @@ func (db *DB) Close() error {
 	}
 	// close watchCh
 	if db.options.WatchQueueSize > 0 {
-		close(db.watchCh)
-	}
-	//  close cron scheduler
-	if db.cronScheduler != nil {
-		db.cronScheduler.Stop()
+		db.watcher.close()
 	}
+	// end the subscriptions
+	db.closeSubscriptions()
+	// wake up the change feeds waiting for new batches, they find the db closed.
+	close(db.changeNotify)
 	db.closed = true
 	return nil
 }
 
-// closeFiles close all data files and hint file
+// closeFiles close all data files and hint file.
+// The data files referenced by live snapshots are left open, they are closed when the snapshots are released.
+// The caller must hold db.mu.
 func (db *DB) closeFiles() error {
-	db.mu.Lock()
-	defer db.mu.Unlock()
-
-	if err := db.dataFiles.Close(); err != nil {
-		return err
+	if db.snapshots[db.dataFiles] == 0 {
+		if err := db.dataFiles.Close(); err != nil {
+			return err
+		}
 	}
 
 	if db.hintFile != nil {
@@ -247,10 +575,19 @@ func (db *DB) Stat() *Stat {
 	if err != nil {
 		panic(fmt.Sprintf("rosedb: get database directory size error: %v", err))
 	}
+	segments, err := db.segmentStats()
+	if err != nil {
+		panic(fmt.Sprintf("rosedb: get segment files stat error: %v", err))
+	}
 
 	return &Stat{
-		KeysNum:  db.index.Size(),
-		DiskSize: diskSize,
+		KeysNum:    db.index.Size(),
+		DiskSize:   diskSize,
+		Blobs:      db.blobs.stat(),
+		Segments:   segments,
+		Recovery:   db.recoveryStat,
+		Merge:      db.MergeProgress(),
+		Namespaces: db.namespaceStats(),
 	}
 }
 
@@ -372,6 +709,9 @@ func (db *DB) Persist(key []byte) error {
 	return batch.Commit()
 }
 
+// Watch returns the channel of the events of the writes, which is shared by all callers,
+// and drops the oldest events once WatchQueueSize are not received.
+// Subscribe gives each caller its own filtered channel instead.
 func (db *DB) Watch() (<-chan *Event, error) {
 	if db.options.WatchQueueSize <= 0 {
 		return nil, ErrWatchDisabled
@@ -389,7 +729,11 @@ func (db *DB) Ascend(handleFn func(k []byte, v []byte) (bool, error)) {
 		if err != nil {
 			return false, err
 		}
-		if value := db.checkValue(chunk); value != nil {
+		value, err := db.checkValue(chunk)
+		if err != nil {
+			return false, err
+		}
+		if value != nil {
 			return handleFn(key, value)
 		}
 		return true, nil
@@ -406,7 +750,11 @@ func (db *DB) AscendRange(startKey, endKey []byte, handleFn func(k []byte, v []b
 		if err != nil {
 			return false, nil
 		}
-		if value := db.checkValue(chunk); value != nil {
+		value, err := db.checkValue(chunk)
+		if err != nil {
+			return false, err
+		}
+		if value != nil {
 			return handleFn(key, value)
 		}
 		return true, nil
@@ -424,7 +772,11 @@ func (db *DB) AscendGreaterOrEqual(key []byte, handleFn func(k []byte, v []byte)
 		if err != nil {
 			return false, nil
 		}
-		if value := db.checkValue(chunk); value != nil {
+		value, err := db.checkValue(chunk)
+		if err != nil {
+			return false, err
+		}
+		if value != nil {
 			return handleFn(key, value)
 		}
 		return true, nil
@@ -452,7 +804,11 @@ func (db *DB) AscendKeys(pattern []byte, filterExpired bool, handleFn func(k []b
 				if err != nil {
 					return false, err
 				}
-				if value := db.checkValue(chunk); value == nil {
+				value, err := db.checkValue(chunk)
+				if err != nil {
+					return false, err
+				}
+				if value == nil {
 					invalid = true
 				}
 			}
@@ -475,7 +831,11 @@ func (db *DB) Descend(handleFn func(k []byte, v []byte) (bool, error)) {
 		if err != nil {
 			return false, nil
 		}
-		if value := db.checkValue(chunk); value != nil {
+		value, err := db.checkValue(chunk)
+		if err != nil {
+			return false, err
+		}
+		if value != nil {
 			return handleFn(key, value)
 		}
 		return true, nil
@@ -493,7 +853,11 @@ func (db *DB) DescendRange(startKey, endKey []byte, handleFn func(k []byte, v []
 		if err != nil {
 			return false, nil
 		}
-		if value := db.checkValue(chunk); value != nil {
+		value, err := db.checkValue(chunk)
+		if err != nil {
+			return false, err
+		}
+		if value != nil {
 			return handleFn(key, value)
 		}
 		return true, nil
@@ -510,7 +874,11 @@ func (db *DB) DescendLessOrEqual(key []byte, handleFn func(k []byte, v []byte) (
 		if err != nil {
 			return false, nil
 		}
-		if value := db.checkValue(chunk); value != nil {
+		value, err := db.checkValue(chunk)
+		if err != nil {
+			return false, err
+		}
+		if value != nil {
 			return handleFn(key, value)
 		}
 		return true, nil
@@ -538,7 +906,11 @@ func (db *DB) DescendKeys(pattern []byte, filterExpired bool, handleFn func(k []
 				if err != nil {
 					return false, err
 				}
-				if value := db.checkValue(chunk); value == nil {
+				value, err := db.checkValue(chunk)
+				if err != nil {
+					return false, err
+				}
+				if value == nil {
 					invalid = true
 				}
 			}
@@ -551,84 +923,20 @@ func (db *DB) DescendKeys(pattern []byte, filterExpired bool, handleFn func(k []
 	})
 }
 
-func (db *DB) checkValue(chunk []byte) []byte {
-	record := decodeLogRecord(chunk)
-	now := time.Now().UnixNano()
-	if record.Type != LogRecordDeleted && !record.IsExpired(now) {
-		return record.Value
-	}
-	return nil
-}
-
-// loadIndexFromWAL loads index from WAL.
-// It will iterate over all the WAL files and read data
-// from them to rebuild the index.
-func (db *DB) loadIndexFromWAL() error {
-	mergeFinSegmentId, err := getMergeFinSegmentId(db.options.DirPath)
+// checkValue returns the value of the record, or nil if the record is deleted or expired.
+func (db *DB) checkValue(chunk []byte) ([]byte, error) {
+	record, err := decodeLogRecord(chunk, db.cipher)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	indexRecords := make(map[uint64][]*IndexRecord)
 	now := time.Now().UnixNano()
-	// get a reader for WAL
-	reader := db.dataFiles.NewReader()
-	for {
-		// if the current segment id is less than the mergeFinSegmentId,
-		// we can skip this segment because it has been merged,
-		// and we can load index from the hint file directly.
-		if reader.CurrentSegmentId() <= mergeFinSegmentId {
-			reader.SkipCurrentSegment()
-			continue
-		}
-
-		chunk, position, err := reader.Next()
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			return err
-		}
-		// decode and get log record
-		record := decodeLogRecord(chunk)
-
-		// if we get the end of a batch,
-		// all records in this batch are ready to be indexed.
-		if record.Type == LogRecordBatchFinished {
-			batchId, err := snowflake.ParseBytes(record.Key)
-			if err != nil {
-				return err
-			}
-			for _, idxRecord := range indexRecords[uint64(batchId)] {
-				if idxRecord.recordType == LogRecordNormal {
-					db.index.Put(idxRecord.key, idxRecord.position)
-				}
-				if idxRecord.recordType == LogRecordDeleted {
-					db.index.Delete(idxRecord.key)
-				}
-			}
-			// delete indexRecords according to batchId after indexing
-			delete(indexRecords, uint64(batchId))
-		} else if record.Type == LogRecordNormal && record.BatchId == mergeFinishedBatchID {
-			// if the record is a normal record and the batch id is 0,
-			// it means that the record is involved in the merge operation.
-			// so put the record into index directly.
-			db.index.Put(record.Key, position)
-		} else {
-			// expired records should not be indexed
-			if record.IsExpired(now) {
-				db.index.Delete(record.Key)
-				continue
-			}
-			// put the record into the temporary indexRecords
-			indexRecords[record.BatchId] = append(indexRecords[record.BatchId],
-				&IndexRecord{
-					key:        record.Key,
-					recordType: record.Type,
-					position:   position,
-				})
+	if record.Type != LogRecordDeleted && !record.IsExpired(now) {
+		if err := db.loadValue(record); err != nil {
+			return nil, err
 		}
+		return record.Value, nil
 	}
-	return nil
+	return nil, nil
 }
 
 // DeleteExpiredKeys scan the entire index in ascending order to delete expired keys.
@@ -671,9 +979,14 @@ func (db *DB) DeleteExpiredKeys(timeout time.Duration) error {
 					done <- struct{}{}
 					return
 				}
-				record := decodeLogRecord(chunk)
+				record, err := decodeLogRecord(chunk, db.cipher)
+				if err != nil {
+					innerErr = err
+					done <- struct{}{}
+					return
+				}
 				if record.IsExpired(now) {
-					db.index.Delete(record.Key)
+					db.discardExpired(record.Key, record)
 				}
 				db.expiredCursorKey = record.Key
 			}
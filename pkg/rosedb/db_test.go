@@ -5,16 +5,18 @@
 package rosedb
 
 import (
+	"bytes"
 	"errors"
 	"io"
 	"math/rand"
 	"os"
+	"path/filepath"
 	"sync"
 	"testing"
 	"time"
 
-	"github.com/JoyZF/wal"
-
+	"github.com/JoyZF/zoom/pkg/rosedb/index"
+	"github.com/JoyZF/zoom/pkg/wal"
 	"github.com/JoyZF/zoom/utils"
 
 	"github.com/stretchr/testify/assert"
@@ -816,3 +818,182 @@ func TestDB_Auto_Merge(t *testing.T) {
 		destroyDB(db)
 	}
 }
+
+// crashDB releases the db without persisting anything, as if the process crashed.
+func crashDB(db *DB) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.closeIndex()
+	_ = db.closeFiles()
+	db.blobs.close()
+	_ = db.fileLock.Unlock()
+	db.closed = true
+}
+
+func TestDB_MemoryIndexTypes(t *testing.T) {
+	for _, indexType := range []index.IndexerType{index.ART, index.SkipList, index.Hash} {
+		options := DefaultOptions
+		options.IndexType = indexType
+		options.SegmentSize = 64 * wal.KB
+		db, err := Open(options)
+		assert.Nil(t, err)
+
+		values := make(map[string][]byte)
+		for i := 0; i < 1000; i++ {
+			key, value := utils.GetTestKey(i), utils.RandomValue(64)
+			values[string(key)] = value
+			assert.Nil(t, db.Put(key, value))
+		}
+		for i := 0; i < 100; i++ {
+			assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+			delete(values, string(utils.GetTestKey(i)))
+		}
+		snap, err := db.Snapshot()
+		assert.Nil(t, err)
+		assert.Nil(t, db.Put(utils.GetTestKey(500), []byte("after-snapshot")))
+		val, err := snap.Get(utils.GetTestKey(500))
+		assert.Nil(t, err)
+		assert.Equal(t, values[string(utils.GetTestKey(500))], val)
+		snap.Release()
+		values[string(utils.GetTestKey(500))] = []byte("after-snapshot")
+
+		// the iterators walk the keys in order whatever the index keeps them in
+		iter, err := db.NewIterator(IteratorOptions{Reverse: true})
+		assert.Nil(t, err)
+		var count int
+		var last []byte
+		for iter.Rewind(); iter.Valid(); iter.Next() {
+			assert.True(t, last == nil || bytes.Compare(iter.Key(), last) < 0)
+			assert.Equal(t, values[string(iter.Key())], iter.Value())
+			last = append(last[:0], iter.Key()...)
+			count++
+		}
+		iter.Close()
+		assert.Equal(t, len(values), count)
+
+		// merge and the index snapshot rebuild the index of the same type
+		assert.Nil(t, db.Merge(true))
+		assert.Nil(t, db.SaveIndexSnapshot())
+		ns, err := db.Namespace("ns")
+		assert.Nil(t, err)
+		assert.Nil(t, ns.Put([]byte("k"), []byte("v")))
+		assert.Nil(t, db.Close())
+		db, err = Open(options)
+		assert.Nil(t, err)
+		assert.Equal(t, len(values), db.Stat().KeysNum)
+		for key, value := range values {
+			val, err := db.Get([]byte(key))
+			assert.Nil(t, err)
+			assert.Equal(t, value, val)
+		}
+		ns, err = db.Namespace("ns")
+		assert.Nil(t, err)
+		val, err = ns.Get([]byte("k"))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("v"), val)
+		destroyDB(db)
+	}
+
+	options := DefaultOptions
+	options.IndexBTreeDegree = 1
+	_, err := Open(options)
+	assert.NotNil(t, err)
+	options = DefaultOptions
+	options.IndexType = index.Hash + 1
+	_, err = Open(options)
+	assert.NotNil(t, err)
+
+	// only the knobs of the index type are checked, and zero means the default.
+	options = DefaultOptions
+	options.IndexType = index.Hash
+	options.IndexBTreeDegree = 1
+	options.IndexHashShards = 0
+	db, err := Open(options)
+	assert.Nil(t, err)
+	destroyDB(db)
+	db, err = Open(Options{DirPath: DefaultOptions.DirPath, SegmentSize: 64 * wal.KB})
+	assert.Nil(t, err)
+	destroyDB(db)
+}
+
+func TestDB_DiskIndex(t *testing.T) {
+	options := DefaultOptions
+	options.IndexType = index.BPTree
+	options.SegmentSize = 64 * wal.KB
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer func() {
+		destroyDB(db)
+	}()
+
+	values := make(map[string][]byte)
+	put := func(from, to int) {
+		for i := from; i < to; i++ {
+			key, value := utils.GetTestKey(i), utils.RandomValue(128)
+			values[string(key)] = value
+			assert.Nil(t, db.Put(key, value))
+		}
+	}
+	check := func() {
+		assert.Equal(t, len(values), db.Stat().KeysNum)
+		for key, value := range values {
+			val, err := db.Get([]byte(key))
+			assert.Nil(t, err)
+			assert.Equal(t, value, val)
+		}
+	}
+	reopen := func() {
+		assert.Nil(t, db.Close())
+		db, err = Open(options)
+		assert.Nil(t, err)
+	}
+
+	put(0, 2000)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+		delete(values, string(utils.GetTestKey(i)))
+	}
+	reopen()
+	check()
+
+	// after a crash, only the WAL written after the index was persisted is replayed,
+	// so the first segment is not read even if it is corrupted.
+	put(2000, 3000)
+	crashDB(db)
+	segment := wal.SegmentFileName(options.DirPath, dataFileNameSuffix, 1)
+	original, err := os.ReadFile(segment)
+	assert.Nil(t, err)
+	assert.Nil(t, os.WriteFile(segment, make([]byte, len(original)), 0644))
+	db, err = Open(options)
+	assert.Nil(t, err)
+	assert.Equal(t, len(values), db.Stat().KeysNum)
+	for i := 2000; i < 3000; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, values[string(utils.GetTestKey(i))], val)
+	}
+	assert.Nil(t, db.Close())
+	assert.Nil(t, os.WriteFile(segment, original, 0644))
+
+	// an index which can not be used is rebuilt from the WAL
+	assert.Nil(t, os.Remove(filepath.Join(options.DirPath, "INDEXMETA")))
+	db, err = Open(options)
+	assert.Nil(t, err)
+	check()
+
+	// merge rebuilds the index, and the snapshots keep reading the old one
+	snap, err := db.Snapshot()
+	assert.Nil(t, err)
+	for i := 100; i < 1000; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+		delete(values, string(utils.GetTestKey(i)))
+	}
+	assert.Nil(t, db.Merge(true))
+	check()
+	val, err := snap.Get(utils.GetTestKey(500))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+	snap.Release()
+	reopen()
+	check()
+}
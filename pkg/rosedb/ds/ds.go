@@ -0,0 +1,359 @@
+// Copyright 2024 Joy <joyssss94@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+// Package ds provides the Redis-style compound data types, hash, list, set and sorted set, on rosedb.
+//
+// The structures are kept in their own namespace of the db, see rosedb.DB.Namespace.
+// A structure is a meta key, holding its type, version and size, and an element key for
+// each of its fields, members or items, which starts with the meta key and the version:
+//
+//	meta key:    uvarint(len(key)) | key
+//	element key: meta key | version (8 bytes) | suffix
+//
+// So the keys of a structure are next to each other in the ordered index, and a structure
+// is read with a single iterator, which gives a point-in-time view of it.
+// The writes read and update the meta key in a single Batch, so they are atomic.
+//
+// A ttl applies to the whole structure, it is the ttl of the meta key. Once the meta key expires or
+// is deleted, the elements of its version are left behind, they are removed when the key is written again.
+package ds
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/JoyZF/zoom/pkg/rosedb"
+)
+
+// DefaultNamespace is the namespace of the structures.
+const DefaultNamespace = "ds"
+
+var (
+	ErrWrongType    = errors.New("the key holds a value of another type")
+	ErrInvalidMeta  = errors.New("the meta of the key is invalid")
+	ErrMemberIsNil  = errors.New("the field or member is nil")
+	ErrValuesIsNone = errors.New("no value is given")
+	ErrScoreIsNaN   = errors.New("the score is not a number")
+)
+
+// DataType is the type of a structure.
+type DataType = byte
+
+const (
+	Hash DataType = iota + 1
+	List
+	Set
+	ZSet
+)
+
+// DS is the compound data types on a db.
+type DS struct {
+	db *rosedb.DB
+	ns *rosedb.Namespace
+}
+
+// New returns the compound data types on the db, stored in DefaultNamespace.
+func New(db *rosedb.DB) (*DS, error) {
+	ns, err := db.Namespace(DefaultNamespace)
+	if err != nil {
+		return nil, err
+	}
+	return &DS{db: db, ns: ns}, nil
+}
+
+// the first item of a new list, the list grows in both directions from it.
+const listInitialSeq = 1 << 62
+
+// meta is the value of the meta key of a structure.
+//
+// +--------+-----------+-----------+------------+------------+
+// |  type  |  version  |   count   |    head    |    tail    |
+// +--------+-----------+-----------+------------+------------+
+//
+//	1 byte    uvarint     uvarint    uvarint, only for the lists
+type meta struct {
+	dataType DataType
+	version  uint64
+	count    uint64
+	// the items of a list are at [head, tail).
+	head, tail uint64
+}
+
+func newMeta(dataType DataType) *meta {
+	m := &meta{dataType: dataType, version: uint64(time.Now().UnixNano())}
+	if dataType == List {
+		m.head, m.tail = listInitialSeq, listInitialSeq
+	}
+	return m
+}
+
+func (m *meta) encode() []byte {
+	buf := binary.AppendUvarint([]byte{m.dataType}, m.version)
+	buf = binary.AppendUvarint(buf, m.count)
+	if m.dataType == List {
+		buf = binary.AppendUvarint(buf, m.head)
+		buf = binary.AppendUvarint(buf, m.tail)
+	}
+	return buf
+}
+
+func decodeMeta(buf []byte) (*meta, error) {
+	if len(buf) == 0 {
+		return nil, ErrInvalidMeta
+	}
+	m := &meta{dataType: buf[0]}
+	fields := []*uint64{&m.version, &m.count}
+	if m.dataType == List {
+		fields = append(fields, &m.head, &m.tail)
+	}
+	index := 1
+	for _, field := range fields {
+		var n int
+		if *field, n = binary.Uvarint(buf[index:]); n <= 0 {
+			return nil, ErrInvalidMeta
+		}
+		index += n
+	}
+	return m, nil
+}
+
+func encodeMetaKey(key []byte) []byte {
+	return append(binary.AppendUvarint(nil, uint64(len(key))), key...)
+}
+
+// elementPrefix returns the prefix of the element keys of the version of the structure.
+func elementPrefix(metaKey []byte, version uint64) []byte {
+	return binary.BigEndian.AppendUint64(append([]byte(nil), metaKey...), version)
+}
+
+func elementKey(prefix []byte, suffix ...[]byte) []byte {
+	key := append([]byte(nil), prefix...)
+	for _, s := range suffix {
+		key = append(key, s...)
+	}
+	return key
+}
+
+// readMeta returns the meta of the structure of the key in the batch, and its ttl, -1 if it has none.
+// It returns rosedb.ErrKeyNotFound if the structure does not exist.
+func readMeta(batch *rosedb.NamespaceBatch, metaKey []byte, dataType DataType) (*meta, time.Duration, error) {
+	value, err := batch.Get(metaKey)
+	if err != nil {
+		return nil, -1, err
+	}
+	m, err := decodeMeta(value)
+	if err != nil {
+		return nil, -1, err
+	}
+	if m.dataType != dataType {
+		return nil, -1, ErrWrongType
+	}
+	ttl, err := batch.TTL(metaKey)
+	if err != nil {
+		return nil, -1, err
+	}
+	return m, ttl, nil
+}
+
+// update runs fn with the meta of the structure of the key in a batch,
+// a new structure is created if it does not exist. The meta is written back after fn,
+// keeping its ttl, and the structure is deleted once it is empty.
+func (d *DS) update(key []byte, dataType DataType, fn func(batch *rosedb.NamespaceBatch, m *meta, prefix []byte) error) error {
+	if len(key) == 0 {
+		return rosedb.ErrKeyIsEmpty
+	}
+	metaKey := encodeMetaKey(key)
+	batch := d.db.NewBatch(rosedb.DefaultBatchOptions)
+	nsBatch := batch.Namespace(d.ns)
+	m, ttl, err := readMeta(nsBatch, metaKey, dataType)
+	created := err == rosedb.ErrKeyNotFound
+	if created {
+		m, err = newMeta(dataType), nil
+	}
+	if err == nil {
+		err = fn(nsBatch, m, elementPrefix(metaKey, m.version))
+	}
+	if err == nil {
+		switch {
+		case m.count == 0 && !created:
+			err = nsBatch.Delete(metaKey)
+		case m.count == 0:
+		case ttl > 0:
+			err = nsBatch.PutWithTTL(metaKey, m.encode(), ttl)
+		default:
+			err = nsBatch.Put(metaKey, m.encode())
+		}
+	}
+	if err != nil {
+		_ = batch.Rollback()
+		return err
+	}
+	if err = batch.Commit(); err != nil {
+		return err
+	}
+	if created && m.count > 0 {
+		// the structure may have expired, leaving its elements behind.
+		return d.removeStale(metaKey)
+	}
+	return nil
+}
+
+// get runs fn with the meta of the structure of the key in a read only batch.
+// It returns rosedb.ErrKeyNotFound if the structure does not exist.
+func (d *DS) get(key []byte, dataType DataType, fn func(batch *rosedb.NamespaceBatch, m *meta, prefix []byte) error) error {
+	if len(key) == 0 {
+		return rosedb.ErrKeyIsEmpty
+	}
+	metaKey := encodeMetaKey(key)
+	batch := d.db.NewBatch(rosedb.BatchOptions{ReadOnly: true})
+	defer func() {
+		_ = batch.Commit()
+	}()
+	nsBatch := batch.Namespace(d.ns)
+	m, _, err := readMeta(nsBatch, metaKey, dataType)
+	if err != nil {
+		return err
+	}
+	return fn(nsBatch, m, elementPrefix(metaKey, m.version))
+}
+
+// scan runs fn with the meta of the structure of the key, and an iterator positioned at its first element.
+// The iterator is a point-in-time view of the structure, fn must stop at the end of prefix.
+// It returns rosedb.ErrKeyNotFound if the structure does not exist.
+func (d *DS) scan(key []byte, dataType DataType, fn func(iter *rosedb.Iterator, m *meta, prefix []byte) error) error {
+	if len(key) == 0 {
+		return rosedb.ErrKeyIsEmpty
+	}
+	metaKey := encodeMetaKey(key)
+	iter, err := d.ns.NewIterator(rosedb.IteratorOptions{Prefix: metaKey})
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+	// the meta key is the first key with the prefix.
+	if !iter.Valid() || !bytes.Equal(iter.Key(), metaKey) {
+		if err = iter.Err(); err != nil {
+			return err
+		}
+		return rosedb.ErrKeyNotFound
+	}
+	m, err := decodeMeta(iter.Value())
+	if err != nil {
+		return err
+	}
+	if m.dataType != dataType {
+		return ErrWrongType
+	}
+	prefix := elementPrefix(metaKey, m.version)
+	iter.Seek(prefix)
+	if err = fn(iter, m, prefix); err != nil {
+		return err
+	}
+	return iter.Err()
+}
+
+// size returns the number of the elements of the structure of the key, 0 if it does not exist.
+func (d *DS) size(key []byte, dataType DataType) (int, error) {
+	var count uint64
+	err := d.get(key, dataType, func(_ *rosedb.NamespaceBatch, m *meta, _ []byte) error {
+		count = m.count
+		return nil
+	})
+	if err == rosedb.ErrKeyNotFound {
+		return 0, nil
+	}
+	return int(count), err
+}
+
+// removeStale deletes the elements of the former versions of the structure of the meta key.
+func (d *DS) removeStale(metaKey []byte) error {
+	iter, err := d.ns.NewIterator(rosedb.IteratorOptions{Prefix: metaKey})
+	if err != nil {
+		return err
+	}
+	var version uint64
+	var stale [][]byte
+	for ; iter.Valid(); iter.Next() {
+		key := iter.Key()
+		if bytes.Equal(key, metaKey) {
+			m, err := decodeMeta(iter.Value())
+			if err != nil {
+				iter.Close()
+				return err
+			}
+			version = m.version
+			continue
+		}
+		if len(key) >= len(metaKey)+8 && binary.BigEndian.Uint64(key[len(metaKey):]) != version {
+			stale = append(stale, key)
+		}
+	}
+	err = iter.Err()
+	iter.Close()
+	if err != nil || len(stale) == 0 {
+		return err
+	}
+
+	batch := d.db.NewBatch(rosedb.DefaultBatchOptions)
+	nsBatch := batch.Namespace(d.ns)
+	// the structure may have been written again since, its elements are kept then.
+	value, err := nsBatch.Get(metaKey)
+	if err == nil {
+		var m *meta
+		if m, err = decodeMeta(value); err == nil && m.version != version {
+			return batch.Rollback()
+		}
+	}
+	if err != nil && err != rosedb.ErrKeyNotFound {
+		_ = batch.Rollback()
+		return err
+	}
+	for _, key := range stale {
+		if err = nsBatch.Delete(key); err != nil {
+			_ = batch.Rollback()
+			return err
+		}
+	}
+	return batch.Commit()
+}
+
+// Type returns the type of the structure of the key.
+// It returns rosedb.ErrKeyNotFound if the structure does not exist.
+func (d *DS) Type(key []byte) (DataType, error) {
+	value, err := d.ns.Get(encodeMetaKey(key))
+	if err != nil {
+		return 0, err
+	}
+	m, err := decodeMeta(value)
+	if err != nil {
+		return 0, err
+	}
+	return m.dataType, nil
+}
+
+// Expire sets the ttl of the whole structure of the key.
+func (d *DS) Expire(key []byte, ttl time.Duration) error {
+	return d.ns.Expire(encodeMetaKey(key), ttl)
+}
+
+// TTL returns the ttl of the whole structure of the key, -1 if it has none.
+func (d *DS) TTL(key []byte) (time.Duration, error) {
+	return d.ns.TTL(encodeMetaKey(key))
+}
+
+// Persist removes the ttl of the structure of the key.
+func (d *DS) Persist(key []byte) error {
+	return d.ns.Persist(encodeMetaKey(key))
+}
+
+// Delete removes the structure of the key, whatever its type.
+func (d *DS) Delete(key []byte) error {
+	metaKey := encodeMetaKey(key)
+	if err := d.ns.Delete(metaKey); err != nil {
+		return err
+	}
+	return d.removeStale(metaKey)
+}
@@ -0,0 +1,129 @@
+// Copyright 2024 Joy <joyssss94@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package ds
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/JoyZF/zoom/pkg/rosedb"
+)
+
+func openDS(t *testing.T) *DS {
+	options := rosedb.DefaultOptions
+	options.DirPath = t.TempDir()
+	db, err := rosedb.Open(options)
+	assert.Nil(t, err)
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	d, err := New(db)
+	assert.Nil(t, err)
+	return d
+}
+
+func TestMeta(t *testing.T) {
+	m := newMeta(List)
+	m.count, m.head = 3, listInitialSeq-3
+	decoded, err := decodeMeta(m.encode())
+	assert.Nil(t, err)
+	assert.Equal(t, m, decoded)
+
+	m = newMeta(Hash)
+	m.count = 10
+	decoded, err = decodeMeta(m.encode())
+	assert.Nil(t, err)
+	assert.Equal(t, m, decoded)
+
+	_, err = decodeMeta(nil)
+	assert.Equal(t, ErrInvalidMeta, err)
+	_, err = decodeMeta([]byte{List, 1, 1})
+	assert.Equal(t, ErrInvalidMeta, err)
+}
+
+func TestDS_WrongType(t *testing.T) {
+	d := openDS(t)
+	key := []byte("key")
+	_, err := d.HSet(key, []byte("f"), []byte("v"))
+	assert.Nil(t, err)
+
+	_, err = d.LPush(key, []byte("v"))
+	assert.Equal(t, ErrWrongType, err)
+	_, err = d.SMembers(key)
+	assert.Equal(t, ErrWrongType, err)
+	_, err = d.ZRank(key, []byte("m"))
+	assert.Equal(t, ErrWrongType, err)
+	dataType, err := d.Type(key)
+	assert.Nil(t, err)
+	assert.Equal(t, Hash, dataType)
+
+	// the key is free again once deleted
+	assert.Nil(t, d.Delete(key))
+	_, err = d.Type(key)
+	assert.Equal(t, rosedb.ErrKeyNotFound, err)
+	_, err = d.SAdd(key, []byte("m"))
+	assert.Nil(t, err)
+}
+
+func TestDS_TTL(t *testing.T) {
+	d := openDS(t)
+	key := []byte("key")
+	_, err := d.SAdd(key, []byte("a"), []byte("b"))
+	assert.Nil(t, err)
+	ttl, err := d.TTL(key)
+	assert.Nil(t, err)
+	assert.Equal(t, time.Duration(-1), ttl)
+
+	assert.Nil(t, d.Expire(key, time.Hour))
+	// the ttl is kept by the writes
+	_, err = d.SAdd(key, []byte("c"))
+	assert.Nil(t, err)
+	ttl, err = d.TTL(key)
+	assert.Nil(t, err)
+	assert.True(t, ttl > 59*time.Minute)
+	assert.Nil(t, d.Persist(key))
+	ttl, err = d.TTL(key)
+	assert.Nil(t, err)
+	assert.Equal(t, time.Duration(-1), ttl)
+
+	// the whole set expires
+	assert.Nil(t, d.Expire(key, 100*time.Millisecond))
+	time.Sleep(200 * time.Millisecond)
+	members, err := d.SMembers(key)
+	assert.Nil(t, err)
+	assert.Empty(t, members)
+	ok, err := d.SIsMember(key, []byte("a"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	// a new set does not see the members of the expired one, which are removed
+	_, err = d.SAdd(key, []byte("d"))
+	assert.Nil(t, err)
+	members, err = d.SMembers(key)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("d")}, members)
+	stat, err := d.ns.Stat()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, stat.KeysNum)
+}
+
+func TestDS_Delete(t *testing.T) {
+	d := openDS(t)
+	key := []byte("key")
+	_, err := d.HSet(key, []byte("f1"), []byte("v1"))
+	assert.Nil(t, err)
+	_, err = d.HSet(key, []byte("f2"), []byte("v2"))
+	assert.Nil(t, err)
+
+	assert.Nil(t, d.Delete(key))
+	fields, err := d.HGetAll(key)
+	assert.Nil(t, err)
+	assert.Empty(t, fields)
+	stat, err := d.ns.Stat()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, stat.KeysNum)
+}
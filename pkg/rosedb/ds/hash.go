@@ -0,0 +1,106 @@
+// Copyright 2024 Joy <joyssss94@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package ds
+
+import (
+	"bytes"
+
+	"github.com/JoyZF/zoom/pkg/rosedb"
+)
+
+// FieldValue is a field of a hash and its value.
+type FieldValue struct {
+	Field []byte
+	Value []byte
+}
+
+// HSet sets the field of the hash of the key to the value, the hash is created if it does not exist.
+// It returns true if the field is new.
+func (d *DS) HSet(key, field, value []byte) (bool, error) {
+	if len(field) == 0 {
+		return false, ErrMemberIsNil
+	}
+	var added bool
+	err := d.update(key, Hash, func(batch *rosedb.NamespaceBatch, m *meta, prefix []byte) error {
+		fieldKey := elementKey(prefix, field)
+		exist, err := batch.Exist(fieldKey)
+		if err != nil {
+			return err
+		}
+		if !exist {
+			added = true
+			m.count++
+		}
+		return batch.Put(fieldKey, value)
+	})
+	return added, err
+}
+
+// HGet returns the value of the field of the hash of the key.
+// It returns rosedb.ErrKeyNotFound if the hash or the field does not exist.
+func (d *DS) HGet(key, field []byte) ([]byte, error) {
+	if len(field) == 0 {
+		return nil, ErrMemberIsNil
+	}
+	var value []byte
+	err := d.get(key, Hash, func(batch *rosedb.NamespaceBatch, m *meta, prefix []byte) error {
+		var err error
+		value, err = batch.Get(elementKey(prefix, field))
+		return err
+	})
+	return value, err
+}
+
+// HGetAll returns all the fields of the hash of the key and their values, ordered by field.
+func (d *DS) HGetAll(key []byte) ([]FieldValue, error) {
+	var fields []FieldValue
+	err := d.scan(key, Hash, func(iter *rosedb.Iterator, m *meta, prefix []byte) error {
+		fields = make([]FieldValue, 0, m.count)
+		for ; iter.Valid() && bytes.HasPrefix(iter.Key(), prefix); iter.Next() {
+			fields = append(fields, FieldValue{
+				Field: bytes.Clone(iter.Key()[len(prefix):]),
+				Value: iter.Value(),
+			})
+		}
+		return nil
+	})
+	if err == rosedb.ErrKeyNotFound {
+		return nil, nil
+	}
+	return fields, err
+}
+
+// HDel removes the fields from the hash of the key, the hash is deleted once it is empty.
+// It returns the number of the fields removed.
+func (d *DS) HDel(key []byte, fields ...[]byte) (int, error) {
+	var removed int
+	err := d.update(key, Hash, func(batch *rosedb.NamespaceBatch, m *meta, prefix []byte) error {
+		for _, field := range fields {
+			if len(field) == 0 {
+				return ErrMemberIsNil
+			}
+			fieldKey := elementKey(prefix, field)
+			exist, err := batch.Exist(fieldKey)
+			if err != nil {
+				return err
+			}
+			if !exist {
+				continue
+			}
+			if err = batch.Delete(fieldKey); err != nil {
+				return err
+			}
+			removed++
+			m.count--
+		}
+		return nil
+	})
+	return removed, err
+}
+
+// HLen returns the number of the fields of the hash of the key.
+func (d *DS) HLen(key []byte) (int, error) {
+	return d.size(key, Hash)
+}
@@ -0,0 +1,71 @@
+// Copyright 2024 Joy <joyssss94@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package ds
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/JoyZF/zoom/pkg/rosedb"
+)
+
+func TestDS_Hash(t *testing.T) {
+	d := openDS(t)
+	key := []byte("user")
+
+	added, err := d.HSet(key, []byte("name"), []byte("joy"))
+	assert.Nil(t, err)
+	assert.True(t, added)
+	added, err = d.HSet(key, []byte("age"), []byte("18"))
+	assert.Nil(t, err)
+	assert.True(t, added)
+	added, err = d.HSet(key, []byte("age"), []byte("19"))
+	assert.Nil(t, err)
+	assert.False(t, added)
+	_, err = d.HSet(key, nil, []byte("v"))
+	assert.Equal(t, ErrMemberIsNil, err)
+
+	value, err := d.HGet(key, []byte("age"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("19"), value)
+	_, err = d.HGet(key, []byte("email"))
+	assert.Equal(t, rosedb.ErrKeyNotFound, err)
+	_, err = d.HGet([]byte("missing"), []byte("age"))
+	assert.Equal(t, rosedb.ErrKeyNotFound, err)
+	length, err := d.HLen(key)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, length)
+
+	fields, err := d.HGetAll(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []FieldValue{
+		{Field: []byte("age"), Value: []byte("19")},
+		{Field: []byte("name"), Value: []byte("joy")},
+	}, fields)
+
+	// a key which is a prefix of another one does not see its fields
+	_, err = d.HSet([]byte("use"), []byte("x"), []byte("y"))
+	assert.Nil(t, err)
+	fields, err = d.HGetAll([]byte("use"))
+	assert.Nil(t, err)
+	assert.Len(t, fields, 1)
+
+	removed, err := d.HDel(key, []byte("age"), []byte("email"))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, removed)
+	removed, err = d.HDel(key, []byte("name"))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, removed)
+	// the hash is deleted once it is empty
+	_, err = d.Type(key)
+	assert.Equal(t, rosedb.ErrKeyNotFound, err)
+	fields, err = d.HGetAll(key)
+	assert.Nil(t, err)
+	assert.Empty(t, fields)
+	removed, err = d.HDel(key, []byte("name"))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, removed)
+}
@@ -0,0 +1,97 @@
+// Copyright 2024 Joy <joyssss94@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package ds
+
+import (
+	"encoding/binary"
+
+	"github.com/JoyZF/zoom/pkg/rosedb"
+)
+
+// the key of the item of a list at the sequence, which keeps the items in order.
+func listItemKey(prefix []byte, seq uint64) []byte {
+	return binary.BigEndian.AppendUint64(elementKey(prefix), seq)
+}
+
+// LPush inserts the values at the head of the list of the key, one after another,
+// so the last value is the first item. The list is created if it does not exist.
+// It returns the length of the list.
+func (d *DS) LPush(key []byte, values ...[]byte) (int, error) {
+	if len(values) == 0 {
+		return 0, ErrValuesIsNone
+	}
+	var length uint64
+	err := d.update(key, List, func(batch *rosedb.NamespaceBatch, m *meta, prefix []byte) error {
+		for _, value := range values {
+			m.head--
+			if err := batch.Put(listItemKey(prefix, m.head), value); err != nil {
+				return err
+			}
+		}
+		m.count += uint64(len(values))
+		length = m.count
+		return nil
+	})
+	return int(length), err
+}
+
+// RPop removes and returns the last item of the list of the key, the list is deleted once it is empty.
+// It returns rosedb.ErrKeyNotFound if the list does not exist.
+func (d *DS) RPop(key []byte) ([]byte, error) {
+	var value []byte
+	err := d.update(key, List, func(batch *rosedb.NamespaceBatch, m *meta, prefix []byte) error {
+		if m.count == 0 {
+			return rosedb.ErrKeyNotFound
+		}
+		itemKey := listItemKey(prefix, m.tail-1)
+		var err error
+		if value, err = batch.Get(itemKey); err != nil {
+			return err
+		}
+		if err = batch.Delete(itemKey); err != nil {
+			return err
+		}
+		m.tail--
+		m.count--
+		return nil
+	})
+	return value, err
+}
+
+// LRange returns the items of the list of the key from start to stop, both inclusive.
+// Like Redis, negative indexes count from the end of the list, -1 is the last item.
+func (d *DS) LRange(key []byte, start, stop int) ([][]byte, error) {
+	var values [][]byte
+	err := d.scan(key, List, func(iter *rosedb.Iterator, m *meta, prefix []byte) error {
+		length := int(m.count)
+		if start < 0 {
+			start = max(length+start, 0)
+		}
+		if stop < 0 {
+			stop = length + stop
+		}
+		stop = min(stop, length-1)
+		if start > stop {
+			return nil
+		}
+		values = make([][]byte, 0, stop-start+1)
+		// the items are contiguous, so the range starts at the key of its first item.
+		iter.Seek(listItemKey(prefix, m.head+uint64(start)))
+		for i := start; i <= stop && iter.Valid(); i++ {
+			values = append(values, iter.Value())
+			iter.Next()
+		}
+		return nil
+	})
+	if err == rosedb.ErrKeyNotFound {
+		return nil, nil
+	}
+	return values, err
+}
+
+// LLen returns the length of the list of the key.
+func (d *DS) LLen(key []byte) (int, error) {
+	return d.size(key, List)
+}
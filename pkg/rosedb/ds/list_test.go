@@ -0,0 +1,60 @@
+// Copyright 2024 Joy <joyssss94@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package ds
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/JoyZF/zoom/pkg/rosedb"
+)
+
+func TestDS_List(t *testing.T) {
+	d := openDS(t)
+	key := []byte("queue")
+
+	length, err := d.LPush(key, []byte("a"), []byte("b"))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, length)
+	length, err = d.LPush(key, []byte("c"))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, length)
+	_, err = d.LPush(key)
+	assert.Equal(t, ErrValuesIsNone, err)
+
+	values, err := d.LRange(key, 0, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("c"), []byte("b"), []byte("a")}, values)
+	values, err = d.LRange(key, 1, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("b")}, values)
+	values, err = d.LRange(key, -2, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("b"), []byte("a")}, values)
+	values, err = d.LRange(key, 2, 1)
+	assert.Nil(t, err)
+	assert.Empty(t, values)
+	values, err = d.LRange([]byte("missing"), 0, -1)
+	assert.Nil(t, err)
+	assert.Empty(t, values)
+
+	value, err := d.RPop(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("a"), value)
+	length, err = d.LLen(key)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, length)
+	value, err = d.RPop(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("b"), value)
+	value, err = d.RPop(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("c"), value)
+	_, err = d.RPop(key)
+	assert.Equal(t, rosedb.ErrKeyNotFound, err)
+	_, err = d.Type(key)
+	assert.Equal(t, rosedb.ErrKeyNotFound, err)
+}
@@ -0,0 +1,80 @@
+// Copyright 2024 Joy <joyssss94@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package ds
+
+import (
+	"bytes"
+
+	"github.com/JoyZF/zoom/pkg/rosedb"
+)
+
+// SAdd adds the members to the set of the key, the set is created if it does not exist.
+// It returns the number of the members added, not counting the ones already in the set.
+func (d *DS) SAdd(key []byte, members ...[]byte) (int, error) {
+	if len(members) == 0 {
+		return 0, ErrValuesIsNone
+	}
+	var added int
+	err := d.update(key, Set, func(batch *rosedb.NamespaceBatch, m *meta, prefix []byte) error {
+		for _, member := range members {
+			if len(member) == 0 {
+				return ErrMemberIsNil
+			}
+			memberKey := elementKey(prefix, member)
+			exist, err := batch.Exist(memberKey)
+			if err != nil {
+				return err
+			}
+			if exist {
+				continue
+			}
+			if err = batch.Put(memberKey, nil); err != nil {
+				return err
+			}
+			added++
+			m.count++
+		}
+		return nil
+	})
+	return added, err
+}
+
+// SIsMember reports whether the member is in the set of the key.
+func (d *DS) SIsMember(key, member []byte) (bool, error) {
+	if len(member) == 0 {
+		return false, ErrMemberIsNil
+	}
+	var exist bool
+	err := d.get(key, Set, func(batch *rosedb.NamespaceBatch, m *meta, prefix []byte) error {
+		var err error
+		exist, err = batch.Exist(elementKey(prefix, member))
+		return err
+	})
+	if err == rosedb.ErrKeyNotFound {
+		return false, nil
+	}
+	return exist, err
+}
+
+// SMembers returns the members of the set of the key in byte order.
+func (d *DS) SMembers(key []byte) ([][]byte, error) {
+	var members [][]byte
+	err := d.scan(key, Set, func(iter *rosedb.Iterator, m *meta, prefix []byte) error {
+		members = make([][]byte, 0, m.count)
+		for ; iter.Valid() && bytes.HasPrefix(iter.Key(), prefix); iter.Next() {
+			members = append(members, bytes.Clone(iter.Key()[len(prefix):]))
+		}
+		return nil
+	})
+	if err == rosedb.ErrKeyNotFound {
+		return nil, nil
+	}
+	return members, err
+}
+
+// SCard returns the number of the members of the set of the key.
+func (d *DS) SCard(key []byte) (int, error) {
+	return d.size(key, Set)
+}
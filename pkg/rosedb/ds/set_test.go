@@ -0,0 +1,39 @@
+// Copyright 2024 Joy <joyssss94@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package ds
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDS_Set(t *testing.T) {
+	d := openDS(t)
+	key := []byte("tags")
+
+	added, err := d.SAdd(key, []byte("go"), []byte("db"), []byte("go"))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, added)
+	added, err = d.SAdd(key, []byte("db"), []byte("kv"))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, added)
+	_, err = d.SAdd(key, []byte("a"), nil)
+	assert.Equal(t, ErrMemberIsNil, err)
+	size, err := d.SCard(key)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, size)
+
+	ok, err := d.SIsMember(key, []byte("kv"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = d.SIsMember(key, []byte("a"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	members, err := d.SMembers(key)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("db"), []byte("go"), []byte("kv")}, members)
+}
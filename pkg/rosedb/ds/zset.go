@@ -0,0 +1,159 @@
+// Copyright 2024 Joy <joyssss94@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package ds
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+
+	"github.com/JoyZF/zoom/pkg/rosedb"
+)
+
+// A member of a sorted set has two element keys:
+//
+//	'm' | member         -> score, to look up the score of a member
+//	's' | score | member -> nil, to walk the members in the order of the score
+//
+// The score is encoded so that its bytes sort in the same order as the floats.
+const (
+	zsetMemberTag = 'm'
+	zsetScoreTag  = 's'
+)
+
+// ScoreMember is a member of a sorted set and its score.
+type ScoreMember struct {
+	Score  float64
+	Member []byte
+}
+
+func encodeScore(score float64) []byte {
+	bits := math.Float64bits(score)
+	// flip the sign bit of the positive numbers, and all the bits of the negative ones.
+	if bits&(1<<63) != 0 {
+		bits = ^bits
+	} else {
+		bits |= 1 << 63
+	}
+	return binary.BigEndian.AppendUint64(nil, bits)
+}
+
+func decodeScore(buf []byte) float64 {
+	bits := binary.BigEndian.Uint64(buf)
+	if bits&(1<<63) != 0 {
+		bits &^= 1 << 63
+	} else {
+		bits = ^bits
+	}
+	return math.Float64frombits(bits)
+}
+
+// ZAdd adds the member with the score to the sorted set of the key, or updates its score if it is already in.
+// The sorted set is created if it does not exist. It returns true if the member is new.
+func (d *DS) ZAdd(key []byte, score float64, member []byte) (bool, error) {
+	if len(member) == 0 {
+		return false, ErrMemberIsNil
+	}
+	if math.IsNaN(score) {
+		return false, ErrScoreIsNaN
+	}
+	var added bool
+	err := d.update(key, ZSet, func(batch *rosedb.NamespaceBatch, m *meta, prefix []byte) error {
+		memberKey := elementKey(prefix, []byte{zsetMemberTag}, member)
+		oldScore, err := batch.Get(memberKey)
+		switch {
+		case err == rosedb.ErrKeyNotFound:
+			added = true
+			m.count++
+		case err != nil:
+			return err
+		case bytes.Equal(oldScore, encodeScore(score)):
+			return nil
+		default:
+			if err = batch.Delete(elementKey(prefix, []byte{zsetScoreTag}, oldScore, member)); err != nil {
+				return err
+			}
+		}
+		encoded := encodeScore(score)
+		if err = batch.Put(memberKey, encoded); err != nil {
+			return err
+		}
+		return batch.Put(elementKey(prefix, []byte{zsetScoreTag}, encoded, member), nil)
+	})
+	return added, err
+}
+
+// ZScore returns the score of the member of the sorted set of the key.
+// It returns rosedb.ErrKeyNotFound if the sorted set or the member does not exist.
+func (d *DS) ZScore(key, member []byte) (float64, error) {
+	if len(member) == 0 {
+		return 0, ErrMemberIsNil
+	}
+	var score float64
+	err := d.get(key, ZSet, func(batch *rosedb.NamespaceBatch, m *meta, prefix []byte) error {
+		value, err := batch.Get(elementKey(prefix, []byte{zsetMemberTag}, member))
+		if err != nil {
+			return err
+		}
+		score = decodeScore(value)
+		return nil
+	})
+	return score, err
+}
+
+// ZRangeByScore returns the members of the sorted set of the key with a score between min and max,
+// both inclusive, ordered by score, and by member for the same score.
+func (d *DS) ZRangeByScore(key []byte, min, max float64) ([]ScoreMember, error) {
+	var members []ScoreMember
+	err := d.scan(key, ZSet, func(iter *rosedb.Iterator, m *meta, prefix []byte) error {
+		scorePrefix := elementKey(prefix, []byte{zsetScoreTag})
+		iter.Seek(elementKey(scorePrefix, encodeScore(min)))
+		for ; iter.Valid() && bytes.HasPrefix(iter.Key(), scorePrefix); iter.Next() {
+			suffix := iter.Key()[len(scorePrefix):]
+			score := decodeScore(suffix)
+			if score > max {
+				break
+			}
+			members = append(members, ScoreMember{Score: score, Member: bytes.Clone(suffix[8:])})
+		}
+		return nil
+	})
+	if err == rosedb.ErrKeyNotFound {
+		return nil, nil
+	}
+	return members, err
+}
+
+// ZRank returns the rank of the member in the sorted set of the key, the member with the lowest score is 0.
+// It returns rosedb.ErrKeyNotFound if the sorted set or the member does not exist.
+func (d *DS) ZRank(key, member []byte) (int, error) {
+	if len(member) == 0 {
+		return 0, ErrMemberIsNil
+	}
+	var rank int
+	err := d.scan(key, ZSet, func(iter *rosedb.Iterator, m *meta, prefix []byte) error {
+		memberKey := elementKey(prefix, []byte{zsetMemberTag}, member)
+		iter.Seek(memberKey)
+		if !iter.Valid() || !bytes.Equal(iter.Key(), memberKey) {
+			if err := iter.Err(); err != nil {
+				return err
+			}
+			return rosedb.ErrKeyNotFound
+		}
+		scoreKey := elementKey(prefix, []byte{zsetScoreTag}, iter.Value(), member)
+		// the members before it in the order of the score.
+		iter.Seek(elementKey(prefix, []byte{zsetScoreTag}))
+		for ; iter.Valid() && bytes.Compare(iter.Key(), scoreKey) < 0; iter.Next() {
+			rank++
+		}
+		return nil
+	})
+	return rank, err
+}
+
+// ZCard returns the number of the members of the sorted set of the key.
+func (d *DS) ZCard(key []byte) (int, error) {
+	return d.size(key, ZSet)
+}
@@ -0,0 +1,76 @@
+// Copyright 2024 Joy <joyssss94@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package ds
+
+import (
+	"bytes"
+	"math"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/JoyZF/zoom/pkg/rosedb"
+)
+
+func TestEncodeScore(t *testing.T) {
+	scores := []float64{math.Inf(1), 3.5, -0.5, 0, -100, 1e-9, math.Inf(-1), 42}
+	encoded := make([][]byte, len(scores))
+	for i, score := range scores {
+		encoded[i] = encodeScore(score)
+		assert.Equal(t, score, decodeScore(encoded[i]))
+	}
+	sort.Float64s(scores)
+	sort.Slice(encoded, func(i, j int) bool {
+		return bytes.Compare(encoded[i], encoded[j]) < 0
+	})
+	for i, score := range scores {
+		assert.Equal(t, score, decodeScore(encoded[i]))
+	}
+}
+
+func TestDS_ZSet(t *testing.T) {
+	d := openDS(t)
+	key := []byte("rank")
+
+	for member, score := range map[string]float64{"a": 3, "b": -1, "c": 10, "d": 3} {
+		added, err := d.ZAdd(key, score, []byte(member))
+		assert.Nil(t, err)
+		assert.True(t, added)
+	}
+	added, err := d.ZAdd(key, 5, []byte("c"))
+	assert.Nil(t, err)
+	assert.False(t, added)
+	size, err := d.ZCard(key)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, size)
+	score, err := d.ZScore(key, []byte("c"))
+	assert.Nil(t, err)
+	assert.Equal(t, float64(5), score)
+
+	members, err := d.ZRangeByScore(key, 0, 5)
+	assert.Nil(t, err)
+	assert.Equal(t, []ScoreMember{
+		{Score: 3, Member: []byte("a")},
+		{Score: 3, Member: []byte("d")},
+		{Score: 5, Member: []byte("c")},
+	}, members)
+	members, err = d.ZRangeByScore(key, math.Inf(-1), math.Inf(1))
+	assert.Nil(t, err)
+	assert.Len(t, members, 4)
+	members, err = d.ZRangeByScore(key, 6, 100)
+	assert.Nil(t, err)
+	assert.Empty(t, members)
+
+	for member, expected := range map[string]int{"b": 0, "a": 1, "d": 2, "c": 3} {
+		rank, err := d.ZRank(key, []byte(member))
+		assert.Nil(t, err)
+		assert.Equal(t, expected, rank)
+	}
+	_, err = d.ZAdd(key, math.NaN(), []byte("e"))
+	assert.Equal(t, ErrScoreIsNaN, err)
+	_, err = d.ZRank(key, []byte("e"))
+	assert.Equal(t, rosedb.ErrKeyNotFound, err)
+}
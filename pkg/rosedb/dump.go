@@ -0,0 +1,424 @@
+// Copyright 2024 Joy <joyssss94@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package rosedb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+// DumpFormat is the format of the dump written by Export and read by Import.
+type DumpFormat byte
+
+const (
+	// DumpFormatNDJSON writes one JSON object per line.
+	DumpFormatNDJSON DumpFormat = iota
+	// DumpFormatCSV writes a header row followed by one row per record.
+	DumpFormatCSV
+)
+
+// DumpEncodingBase64 is the encoding of the key and value of a DumpRecord
+// when any of them is not valid UTF-8.
+const DumpEncodingBase64 = "base64"
+
+// dumpCSVHeader is the header row of the csv dump, in the order of the columns.
+var dumpCSVHeader = []string{"key", "value", "encoding", "ttl", "expire_at", "batch_id"}
+
+// DumpRecord is a record in the dump.
+type DumpRecord struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	// Encoding is DumpEncodingBase64 if the key and value are base64 encoded,
+	// or empty if they are written as they are.
+	Encoding string `json:"encoding,omitempty"`
+	// TTL is the remaining time to live in milliseconds at the time of the export, 0 means no expiry.
+	TTL int64 `json:"ttl,omitempty"`
+	// ExpireAt is the absolute expiry in unix milliseconds, 0 means no expiry.
+	ExpireAt int64 `json:"expire_at,omitempty"`
+	// BatchId is the id of the batch which wrote the record, it is informational only.
+	BatchId uint64 `json:"batch_id,omitempty"`
+}
+
+// ExportOptions is the options for Export.
+type ExportOptions struct {
+	// Format is the format of the dump.
+	Format DumpFormat
+
+	// Prefix, Start and End filter the keys to export, the same as IteratorOptions.
+	Prefix []byte
+	Start  []byte
+	End    []byte
+
+	// AbsoluteExpiry writes the absolute expiry of the records in ExpireAt,
+	// otherwise the remaining time to live is written in TTL.
+	AbsoluteExpiry bool
+
+	// WithBatchId writes the batch id of the records.
+	WithBatchId bool
+}
+
+// ImportOptions is the options for Import.
+type ImportOptions struct {
+	// Format is the format of the dump.
+	Format DumpFormat
+
+	// Prefix, Start and End filter the keys to import, the same as IteratorOptions.
+	Prefix []byte
+	Start  []byte
+	End    []byte
+
+	// BatchSize is the number of records written in a batch.
+	BatchSize int
+
+	// Sync has the same semantics as BatchOptions.Sync.
+	Sync bool
+}
+
+var DefaultExportOptions = ExportOptions{
+	Format:         DumpFormatNDJSON,
+	AbsoluteExpiry: false,
+	WithBatchId:    false,
+}
+
+var DefaultImportOptions = ImportOptions{
+	Format:    DumpFormatNDJSON,
+	BatchSize: 10000,
+	Sync:      true,
+}
+
+// Export writes the keys of the db to w, and returns the number of records written.
+//
+// The dump is streamed from a snapshot of the db, so it is consistent at the time Export is called,
+// and the writes done during the export are neither blocked nor included.
+func (db *DB) Export(w io.Writer, options ExportOptions) (int, error) {
+	snapshot, err := db.Snapshot()
+	if err != nil {
+		return 0, err
+	}
+	defer snapshot.Release()
+	return snapshot.Export(w, options)
+}
+
+// Export writes the keys of the snapshot to w, and returns the number of records written.
+func (s *Snapshot) Export(w io.Writer, options ExportOptions) (int, error) {
+	iter, err := s.NewIterator(IteratorOptions{
+		Prefix: options.Prefix,
+		Start:  options.Start,
+		End:    options.End,
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer iter.Close()
+
+	writer, err := newDumpWriter(w, options.Format)
+	if err != nil {
+		return 0, err
+	}
+	var count int
+	for ; iter.Valid(); iter.Next() {
+		if err = writer.write(newDumpRecord(iter.record, s.ts, options)); err != nil {
+			return count, err
+		}
+		count++
+	}
+	if err = iter.Err(); err != nil {
+		return count, err
+	}
+	return count, writer.flush()
+}
+
+// Import loads the dump written by Export from r, and returns the number of records imported.
+//
+// The records are written in batches of BatchSize, the batches committed before an error
+// are kept. Records which have expired are skipped, and the batch ids in the dump are not kept.
+func (db *DB) Import(r io.Reader, options ImportOptions) (int, error) {
+	return ReadDump(r, options, func(changes []*Change) error {
+		return db.ApplyChanges(changes, options.Sync)
+	})
+}
+
+// ReadDump reads the dump written by Export from r, and calls apply with the puts of
+// each BatchSize records, in the order of the dump. It returns the number of records applied.
+// Records which have expired or are out of the bounds of the options are skipped.
+func ReadDump(r io.Reader, options ImportOptions, apply func(changes []*Change) error) (int, error) {
+	if options.BatchSize <= 0 {
+		options.BatchSize = DefaultImportOptions.BatchSize
+	}
+	reader, err := newDumpReader(r, options.Format)
+	if err != nil {
+		return 0, err
+	}
+	lowerBound, upperBound := keyBounds(options.Prefix, options.Start, options.End)
+
+	var count int
+	changes := make([]*Change, 0, options.BatchSize)
+	for {
+		dumpRecord, err := reader.read()
+		if err != nil && err != io.EOF {
+			return count, err
+		}
+		if dumpRecord != nil {
+			record, err := dumpRecord.logRecord()
+			if err != nil {
+				return count, err
+			}
+			if keyInBounds(record.Key, lowerBound, upperBound) && !record.IsExpired(time.Now().UnixNano()) {
+				changes = append(changes, &Change{
+					Action: WatchActionPut,
+					Key:    record.Key,
+					Value:  record.Value,
+					Expire: record.Expire,
+				})
+			}
+		}
+
+		if len(changes) == options.BatchSize || (err == io.EOF && len(changes) > 0) {
+			if err := apply(changes); err != nil {
+				return count, err
+			}
+			count += len(changes)
+			changes = make([]*Change, 0, options.BatchSize)
+		}
+		if err == io.EOF {
+			return count, nil
+		}
+	}
+}
+
+func newDumpRecord(record *LogRecord, now int64, options ExportOptions) *DumpRecord {
+	dumpRecord := &DumpRecord{}
+	if utf8.Valid(record.Key) && utf8.Valid(record.Value) {
+		dumpRecord.Key, dumpRecord.Value = string(record.Key), string(record.Value)
+	} else {
+		dumpRecord.Encoding = DumpEncodingBase64
+		dumpRecord.Key = base64.StdEncoding.EncodeToString(record.Key)
+		dumpRecord.Value = base64.StdEncoding.EncodeToString(record.Value)
+	}
+	if record.Expire > 0 {
+		if options.AbsoluteExpiry {
+			dumpRecord.ExpireAt = time.Unix(0, record.Expire).UnixMilli()
+		} else {
+			// round up, so a record which is about to expire does not become persistent.
+			dumpRecord.TTL = (record.Expire - now + int64(time.Millisecond) - 1) / int64(time.Millisecond)
+		}
+	}
+	if options.WithBatchId {
+		dumpRecord.BatchId = record.BatchId
+	}
+	return dumpRecord
+}
+
+// logRecord converts the dump record to a log record with an absolute expiry.
+func (r *DumpRecord) logRecord() (*LogRecord, error) {
+	record := &LogRecord{Type: LogRecordNormal, BatchId: r.BatchId}
+	switch r.Encoding {
+	case "":
+		record.Key, record.Value = []byte(r.Key), []byte(r.Value)
+	case DumpEncodingBase64:
+		var err error
+		if record.Key, err = base64.StdEncoding.DecodeString(r.Key); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidDump, err)
+		}
+		if record.Value, err = base64.StdEncoding.DecodeString(r.Value); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidDump, err)
+		}
+	default:
+		return nil, fmt.Errorf("%w: unknown encoding %q", ErrInvalidDump, r.Encoding)
+	}
+	if len(record.Key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+	if r.ExpireAt > 0 {
+		record.Expire = time.UnixMilli(r.ExpireAt).UnixNano()
+	} else if r.TTL > 0 {
+		record.Expire = time.Now().Add(time.Duration(r.TTL) * time.Millisecond).UnixNano()
+	}
+	return record, nil
+}
+
+type dumpWriter struct {
+	format DumpFormat
+	buf    *bufio.Writer
+	csv    *csv.Writer
+	row    []string
+}
+
+func newDumpWriter(w io.Writer, format DumpFormat) (*dumpWriter, error) {
+	writer := &dumpWriter{format: format, buf: bufio.NewWriterSize(w, 64*1024)}
+	switch format {
+	case DumpFormatNDJSON:
+	case DumpFormatCSV:
+		writer.csv = csv.NewWriter(writer.buf)
+		writer.row = make([]string, len(dumpCSVHeader))
+		if err := writer.csv.Write(dumpCSVHeader); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, ErrInvalidDumpFormat
+	}
+	return writer, nil
+}
+
+func (w *dumpWriter) write(record *DumpRecord) error {
+	if w.format == DumpFormatCSV {
+		w.row[0], w.row[1], w.row[2] = record.Key, record.Value, record.Encoding
+		w.row[3], w.row[4], w.row[5] = "", "", ""
+		if record.TTL > 0 {
+			w.row[3] = strconv.FormatInt(record.TTL, 10)
+		}
+		if record.ExpireAt > 0 {
+			w.row[4] = strconv.FormatInt(record.ExpireAt, 10)
+		}
+		if record.BatchId > 0 {
+			w.row[5] = strconv.FormatUint(record.BatchId, 10)
+		}
+		return w.csv.Write(w.row)
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if _, err = w.buf.Write(data); err != nil {
+		return err
+	}
+	return w.buf.WriteByte('\n')
+}
+
+func (w *dumpWriter) flush() error {
+	if w.csv != nil {
+		w.csv.Flush()
+		if err := w.csv.Error(); err != nil {
+			return err
+		}
+	}
+	return w.buf.Flush()
+}
+
+type dumpReader struct {
+	format  DumpFormat
+	scanner *bufio.Scanner
+	csv     *csv.Reader
+	columns map[string]int
+}
+
+func newDumpReader(r io.Reader, format DumpFormat) (*dumpReader, error) {
+	reader := &dumpReader{format: format}
+	switch format {
+	case DumpFormatNDJSON:
+		reader.scanner = bufio.NewScanner(r)
+		// a line holds a whole record, so it can be as large as the value.
+		reader.scanner.Buffer(make([]byte, 64*1024), 1<<31-1)
+	case DumpFormatCSV:
+		reader.csv = csv.NewReader(r)
+		reader.csv.FieldsPerRecord = -1
+		header, err := reader.csv.Read()
+		if err == io.EOF {
+			return reader, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidDump, err)
+		}
+		reader.columns = make(map[string]int)
+		for i, column := range header {
+			reader.columns[column] = i
+		}
+		if _, ok := reader.columns["key"]; !ok {
+			return nil, fmt.Errorf("%w: no key column", ErrInvalidDump)
+		}
+	default:
+		return nil, ErrInvalidDumpFormat
+	}
+	return reader, nil
+}
+
+// read returns the next record in the dump, or io.EOF if there is no more record.
+func (r *dumpReader) read() (*DumpRecord, error) {
+	if r.format == DumpFormatCSV {
+		if r.columns == nil {
+			return nil, io.EOF
+		}
+		row, err := r.csv.Read()
+		if err != nil {
+			if err == io.EOF {
+				return nil, err
+			}
+			return nil, fmt.Errorf("%w: %v", ErrInvalidDump, err)
+		}
+		return r.csvRecord(row)
+	}
+
+	for r.scanner.Scan() {
+		line := bytes.TrimSpace(r.scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		record := &DumpRecord{}
+		if err := json.Unmarshal(line, record); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidDump, err)
+		}
+		return record, nil
+	}
+	if err := r.scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+func (r *dumpReader) csvRecord(row []string) (*DumpRecord, error) {
+	column := func(name string) string {
+		if i, ok := r.columns[name]; ok && i < len(row) {
+			return row[i]
+		}
+		return ""
+	}
+	number := func(name string) (int64, error) {
+		value := column(name)
+		if value == "" {
+			return 0, nil
+		}
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("%w: invalid %s %q", ErrInvalidDump, name, value)
+		}
+		return n, nil
+	}
+
+	record := &DumpRecord{Key: column("key"), Value: column("value"), Encoding: column("encoding")}
+	var err error
+	if record.TTL, err = number("ttl"); err != nil {
+		return nil, err
+	}
+	if record.ExpireAt, err = number("expire_at"); err != nil {
+		return nil, err
+	}
+	batchId, err := number("batch_id")
+	if err != nil {
+		return nil, err
+	}
+	record.BatchId = uint64(batchId)
+	return record, nil
+}
+
+// ParseDumpFormat returns the DumpFormat of the given name, "ndjson" or "csv".
+func ParseDumpFormat(name string) (DumpFormat, error) {
+	switch name {
+	case "", "ndjson", "json":
+		return DumpFormatNDJSON, nil
+	case "csv":
+		return DumpFormatCSV, nil
+	default:
+		return 0, ErrInvalidDumpFormat
+	}
+}
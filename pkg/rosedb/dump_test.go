@@ -0,0 +1,171 @@
+// Copyright 2024 Joy <joyssss94@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package rosedb
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JoyZF/zoom/utils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func openImportTestDB(t *testing.T) *DB {
+	options := DefaultOptions
+	options.DirPath = "./data-import"
+	db, err := Open(options)
+	assert.Nil(t, err)
+	return db
+}
+
+func TestDB_Export_Import(t *testing.T) {
+	db, err := Open(DefaultOptions)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	binaryKey, binaryValue := []byte{0xff, 0x00, 'b'}, []byte{0xfe, 0xfd}
+	assert.Nil(t, db.Put(binaryKey, binaryValue))
+	assert.Nil(t, db.PutWithTTL([]byte("ttl"), []byte("value"), time.Hour))
+	assert.Nil(t, db.PutWithTTL([]byte("expired"), []byte("value"), time.Millisecond))
+	time.Sleep(10 * time.Millisecond)
+
+	for _, format := range []DumpFormat{DumpFormatNDJSON, DumpFormatCSV} {
+		for _, absolute := range []bool{false, true} {
+			var buf bytes.Buffer
+			options := DefaultExportOptions
+			options.Format, options.AbsoluteExpiry, options.WithBatchId = format, absolute, true
+			count, err := db.Export(&buf, options)
+			assert.Nil(t, err)
+			assert.Equal(t, 102, count)
+
+			importDB := openImportTestDB(t)
+			importOptions := DefaultImportOptions
+			importOptions.Format, importOptions.BatchSize = format, 30
+			count, err = importDB.Import(&buf, importOptions)
+			assert.Nil(t, err)
+			assert.Equal(t, 102, count)
+			assert.Equal(t, 102, importDB.Stat().KeysNum)
+
+			for i := 0; i < 100; i++ {
+				expected, err := db.Get(utils.GetTestKey(i))
+				assert.Nil(t, err)
+				val, err := importDB.Get(utils.GetTestKey(i))
+				assert.Nil(t, err)
+				assert.Equal(t, expected, val)
+			}
+			val, err := importDB.Get(binaryKey)
+			assert.Nil(t, err)
+			assert.Equal(t, binaryValue, val)
+			ttl, err := importDB.TTL([]byte("ttl"))
+			assert.Nil(t, err)
+			assert.True(t, ttl > 59*time.Minute && ttl <= time.Hour, ttl)
+			_, err = importDB.Get([]byte("expired"))
+			assert.Equal(t, ErrKeyNotFound, err)
+			destroyDB(importDB)
+		}
+	}
+}
+
+func TestDB_Export_Format(t *testing.T) {
+	db, err := Open(DefaultOptions)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	assert.Nil(t, db.Put([]byte("a"), []byte("1")))
+	assert.Nil(t, db.Put([]byte{0xff}, []byte("2")))
+	assert.Nil(t, db.PutWithTTL([]byte("c"), []byte("3"), time.Minute))
+
+	var buf bytes.Buffer
+	_, err = db.Export(&buf, DefaultExportOptions)
+	assert.Nil(t, err)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Equal(t, 3, len(lines))
+	assert.Equal(t, `{"key":"a","value":"1"}`, lines[0])
+	record := DumpRecord{}
+	assert.Nil(t, json.Unmarshal([]byte(lines[1]), &record))
+	assert.True(t, record.TTL > 59*1000 && record.TTL <= 60*1000)
+	record = DumpRecord{}
+	assert.Nil(t, json.Unmarshal([]byte(lines[2]), &record))
+	assert.Equal(t, DumpRecord{Key: "/w==", Value: "Mg==", Encoding: DumpEncodingBase64}, record)
+
+	buf.Reset()
+	options := DefaultExportOptions
+	options.Format = DumpFormatCSV
+	_, err = db.Export(&buf, options)
+	assert.Nil(t, err)
+	lines = strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Equal(t, "key,value,encoding,ttl,expire_at,batch_id", lines[0])
+	assert.Equal(t, "a,1,,,,", lines[1])
+
+	_, err = db.Export(&buf, ExportOptions{Format: DumpFormatCSV + 1})
+	assert.Equal(t, ErrInvalidDumpFormat, err)
+}
+
+func TestDB_Export_Import_Filter(t *testing.T) {
+	db, err := Open(DefaultOptions)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+	putIteratorTestData(t, db)
+
+	var buf bytes.Buffer
+	count, err := db.Export(&buf, ExportOptions{Prefix: []byte("b")})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, count)
+	assert.Equal(t, "{\"key\":\"banana\",\"value\":\"value-banana\"}\n", buf.String())
+
+	buf.Reset()
+	count, err = db.Export(&buf, ExportOptions{Start: []byte("c"), End: []byte("grape")})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, count)
+
+	buf.Reset()
+	count, err = db.Export(&buf, ExportOptions{})
+	assert.Nil(t, err)
+	importDB := openImportTestDB(t)
+	defer destroyDB(importDB)
+	imported, err := importDB.Import(bytes.NewReader(buf.Bytes()), ImportOptions{Start: []byte("b"), End: []byte("d")})
+	assert.Nil(t, err)
+	assert.True(t, imported > 0 && imported < count)
+	iter, err := importDB.NewIterator(IteratorOptions{})
+	assert.Nil(t, err)
+	defer iter.Close()
+	for ; iter.Valid(); iter.Next() {
+		assert.True(t, string(iter.Key()) >= "b" && string(iter.Key()) < "d", string(iter.Key()))
+	}
+}
+
+func TestDB_Import_Invalid(t *testing.T) {
+	db := openImportTestDB(t)
+	defer destroyDB(db)
+
+	dump := "{\"key\":\"a\",\"value\":\"1\"}\n\n{\"key\":\"b\",\"value\":\"2\",\"ttl\":60000}\nnot json\n"
+	count, err := db.Import(strings.NewReader(dump), ImportOptions{BatchSize: 1})
+	assert.True(t, errors.Is(err, ErrInvalidDump))
+	// the batches before the error are kept
+	assert.Equal(t, 2, count)
+
+	_, err = db.Import(strings.NewReader("value\n1\n"), ImportOptions{Format: DumpFormatCSV})
+	assert.True(t, errors.Is(err, ErrInvalidDump))
+	_, err = db.Import(strings.NewReader("key,ttl\na,x\n"), ImportOptions{Format: DumpFormatCSV})
+	assert.True(t, errors.Is(err, ErrInvalidDump))
+	_, err = db.Import(strings.NewReader(`{"key":"a","value":"!","encoding":"base64"}`), ImportOptions{})
+	assert.True(t, errors.Is(err, ErrInvalidDump))
+
+	// only the columns in the header are required
+	count, err = db.Import(strings.NewReader("value,key\n3,c\n"), ImportOptions{Format: DumpFormatCSV})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, count)
+	val, err := db.Get([]byte("c"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("3"), val)
+}
@@ -0,0 +1,260 @@
+// Copyright 2024 Joy <joyssss94@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package rosedb
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// KeyProvider provides the AES keys used to encrypt the data files and the hint file.
+// Every key has an id which is recorded in the encrypted records,
+// so the keys can be rotated, and the records encrypted with an old key can still be read
+// as long as the provider returns the old key for its id.
+type KeyProvider interface {
+	// CurrentKeyID returns the id of the key used to encrypt new records.
+	CurrentKeyID() (uint32, error)
+
+	// Key returns the key of the given id, it must be 16, 24 or 32 bytes long.
+	Key(id uint32) ([]byte, error)
+}
+
+// StaticKeyProvider is a KeyProvider with a fixed set of keys.
+type StaticKeyProvider struct {
+	current uint32
+	keys    map[uint32][]byte
+}
+
+// NewStaticKeyProvider returns a KeyProvider with the given keys,
+// new records are encrypted with the key of the current id.
+func NewStaticKeyProvider(current uint32, keys map[uint32][]byte) (*StaticKeyProvider, error) {
+	if _, ok := keys[current]; !ok {
+		return nil, fmt.Errorf("%w: %d", ErrEncryptionKeyNotFound, current)
+	}
+	return &StaticKeyProvider{current: current, keys: keys}, nil
+}
+
+func (p *StaticKeyProvider) CurrentKeyID() (uint32, error) {
+	return p.current, nil
+}
+
+func (p *StaticKeyProvider) Key(id uint32) ([]byte, error) {
+	key, ok := p.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrEncryptionKeyNotFound, id)
+	}
+	return key, nil
+}
+
+// NewKeyFileProvider returns a KeyProvider with the keys in the given file.
+// Each line of the file is a key in the form of "id=hex encoded key",
+// empty lines and lines starting with '#' are ignored.
+// The key with the largest id is used to encrypt new records.
+func NewKeyFileProvider(path string) (*StaticKeyProvider, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	var entries []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		entries = append(entries, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return parseKeys(entries)
+}
+
+// NewEnvKeyProvider returns a KeyProvider with the keys in the given environment variable.
+// The keys are separated by commas, each in the same form as NewKeyFileProvider.
+func NewEnvKeyProvider(name string) (*StaticKeyProvider, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return nil, fmt.Errorf("environment variable %s is not set", name)
+	}
+	return parseKeys(strings.Split(value, ","))
+}
+
+func parseKeys(entries []string) (*StaticKeyProvider, error) {
+	keys := make(map[uint32][]byte)
+	var current uint32
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		idStr, keyStr, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid encryption key entry %q", entry)
+		}
+		id, err := strconv.ParseUint(strings.TrimSpace(idStr), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid encryption key id %q: %v", idStr, err)
+		}
+		key, err := hex.DecodeString(strings.TrimSpace(keyStr))
+		if err != nil {
+			return nil, fmt.Errorf("invalid encryption key %d: %v", id, err)
+		}
+		if len(keys) == 0 || uint32(id) > current {
+			current = uint32(id)
+		}
+		keys[uint32(id)] = key
+	}
+	if len(keys) == 0 {
+		return nil, ErrEncryptionKeyNotFound
+	}
+	return &StaticKeyProvider{current: current, keys: keys}, nil
+}
+
+// FuncKeyProvider is a KeyProvider which gets the keys from a callback,
+// e.g. a KMS client.
+type FuncKeyProvider struct {
+	Current uint32
+	KeyFunc func(id uint32) ([]byte, error)
+}
+
+func (p *FuncKeyProvider) CurrentKeyID() (uint32, error) {
+	return p.Current, nil
+}
+
+func (p *FuncKeyProvider) Key(id uint32) ([]byte, error) {
+	return p.KeyFunc(id)
+}
+
+// recordCipher encrypts and decrypts the payloads with AES-GCM,
+// the AEADs are cached by key id.
+type recordCipher struct {
+	provider KeyProvider
+	mu       sync.RWMutex
+	aeads    map[uint32]cipher.AEAD
+}
+
+func newRecordCipher(provider KeyProvider) *recordCipher {
+	if provider == nil {
+		return nil
+	}
+	return &recordCipher{provider: provider, aeads: make(map[uint32]cipher.AEAD)}
+}
+
+func (c *recordCipher) aead(id uint32) (cipher.AEAD, error) {
+	c.mu.RLock()
+	aead, ok := c.aeads[id]
+	c.mu.RUnlock()
+	if ok {
+		return aead, nil
+	}
+
+	key, err := c.provider.Key(id)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if aead, err = cipher.NewGCM(block); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.aeads[id] = aead
+	c.mu.Unlock()
+	return aead, nil
+}
+
+// currentKeyID returns the id of the key used to encrypt new records,
+// and makes sure the key is valid.
+func (c *recordCipher) currentKeyID() (uint32, error) {
+	id, err := c.provider.CurrentKeyID()
+	if err != nil {
+		return 0, err
+	}
+	if _, err = c.aead(id); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// seal appends nonce and the encrypted plaintext to dst,
+// additionalData is authenticated but not encrypted.
+func (c *recordCipher) seal(dst []byte, id uint32, plaintext, additionalData []byte) ([]byte, error) {
+	aead, err := c.aead(id)
+	if err != nil {
+		return nil, err
+	}
+	nonceStart := len(dst)
+	dst = append(dst, make([]byte, aead.NonceSize())...)
+	if _, err := io.ReadFull(rand.Reader, dst[nonceStart:]); err != nil {
+		return nil, err
+	}
+	return aead.Seal(dst, dst[nonceStart:], plaintext, additionalData), nil
+}
+
+// open decrypts the sealed payload which is nonce followed by the ciphertext.
+func (c *recordCipher) open(id uint32, sealed, additionalData []byte) ([]byte, error) {
+	aead, err := c.aead(id)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrDecryptFailed
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, additionalData)
+	if err != nil {
+		return nil, fmt.Errorf("%w: key id %d", ErrDecryptFailed, id)
+	}
+	return plaintext, nil
+}
+
+// sealHintRecord encrypts the hint record with the current key.
+//
+//	+--------+------+--------+-------+-----------------------+
+//	| escape | kind | key id | nonce | encrypted hint record |
+//	+--------+------+--------+-------+-----------------------+
+//
+// The escape and the kind tell it from the other hint records, see hintEscape.
+func (c *recordCipher) sealHintRecord(hint []byte) ([]byte, error) {
+	if c == nil {
+		return hint, nil
+	}
+	id, err := c.currentKeyID()
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, 2+binary.MaxVarintLen32)
+	header[0], header[1] = hintEscape, hintKindEncrypted
+	n := 2 + binary.PutUvarint(header[2:], uint64(id))
+	return c.seal(header[:n], id, hint, header[:n])
+}
+
+// openHintRecord decrypts the hint record if it is encrypted.
+func (c *recordCipher) openHintRecord(chunk []byte) ([]byte, error) {
+	if len(chunk) < 2 || chunk[0] != hintEscape || chunk[1] != hintKindEncrypted {
+		return chunk, nil
+	}
+	if c == nil {
+		return nil, ErrEncryptionKeyRequired
+	}
+	id, n := binary.Uvarint(chunk[2:])
+	if n <= 0 {
+		return nil, ErrInvalidLogRecord
+	}
+	return c.open(uint32(id), chunk[2+n:], chunk[:2+n])
+}
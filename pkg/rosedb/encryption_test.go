@@ -0,0 +1,203 @@
+// Copyright 2024 Joy <joyssss94@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package rosedb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/JoyZF/zoom/utils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestKeyProvider(t *testing.T, current uint32, ids ...uint32) *StaticKeyProvider {
+	keys := make(map[uint32][]byte)
+	for _, id := range ids {
+		keys[id] = bytes.Repeat([]byte{byte(id)}, 32)
+	}
+	provider, err := NewStaticKeyProvider(current, keys)
+	assert.Nil(t, err)
+	return provider
+}
+
+// recordKeyID returns the key id in the header of the record at the position of the key.
+func recordKeyID(t *testing.T, db *DB, key []byte) uint32 {
+	chunk, err := db.dataFiles.Read(db.index.Get(key))
+	assert.Nil(t, err)
+	assert.NotZero(t, chunk[0]&recordEncryptedFlag)
+	index := 1
+	_, n := binary.Uvarint(chunk[index:])
+	index += n
+	for i := 0; i < 3; i++ {
+		_, n = binary.Varint(chunk[index:])
+		index += n
+	}
+	id, _ := binary.Uvarint(chunk[index:])
+	return uint32(id)
+}
+
+func TestDB_Encryption_Normal(t *testing.T) {
+	options := DefaultOptions
+	options.KeyProvider = newTestKeyProvider(t, 1, 1)
+	options.Compression = CompressionSnappy
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	secret := bytes.Repeat([]byte("top-secret-value"), 16)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), secret))
+	}
+	assert.Nil(t, db.Delete(utils.GetTestKey(0)))
+
+	// neither the keys nor the values are written in plaintext
+	segments, err := filepath.Glob(filepath.Join(options.DirPath, "*"+dataFileNameSuffix))
+	assert.Nil(t, err)
+	assert.NotEmpty(t, segments)
+	for _, segment := range segments {
+		data, err := os.ReadFile(segment)
+		assert.Nil(t, err)
+		assert.False(t, bytes.Contains(data, []byte("top-secret")))
+		assert.False(t, bytes.Contains(data, utils.GetTestKey(1)))
+	}
+
+	// reopen and load the index from the encrypted records
+	assert.Nil(t, db.Close())
+	db, err = Open(options)
+	assert.Nil(t, err)
+	_, err = db.Get(utils.GetTestKey(0))
+	assert.Equal(t, ErrKeyNotFound, err)
+	for i := 1; i < 100; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, secret, val)
+	}
+}
+
+func TestDB_Encryption_Wrong_Key(t *testing.T) {
+	options := DefaultOptions
+	options.KeyProvider = newTestKeyProvider(t, 1, 1)
+	db, err := Open(options)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte("key"), []byte("value")))
+	assert.Nil(t, db.Close())
+
+	// a different key with the same id
+	options.KeyProvider, err = NewStaticKeyProvider(1, map[uint32][]byte{1: bytes.Repeat([]byte{9}, 32)})
+	assert.Nil(t, err)
+	_, err = Open(options)
+	assert.True(t, errors.Is(err, ErrDecryptFailed))
+
+	// the key of the id is missing
+	options.KeyProvider = newTestKeyProvider(t, 2, 2)
+	_, err = Open(options)
+	assert.True(t, errors.Is(err, ErrEncryptionKeyNotFound))
+
+	// no key provider at all
+	options.KeyProvider = nil
+	_, err = Open(options)
+	assert.Equal(t, ErrEncryptionKeyRequired, err)
+
+	// and the right key still works
+	options.KeyProvider = newTestKeyProvider(t, 1, 1)
+	db, err = Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+	val, err := db.Get([]byte("key"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), val)
+}
+
+func TestDB_Encryption_Rotation_Merge(t *testing.T) {
+	options := DefaultOptions
+	options.KeyProvider = newTestKeyProvider(t, 1, 1)
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	values := make(map[string][]byte)
+	for i := 0; i < 100; i++ {
+		key, value := utils.GetTestKey(i), utils.RandomValue(128)
+		values[string(key)] = value
+		assert.Nil(t, db.Put(key, value))
+	}
+	assert.Equal(t, uint32(1), recordKeyID(t, db, utils.GetTestKey(1)))
+
+	// rotate the key, the old records are still readable with the old key
+	assert.Nil(t, db.Close())
+	options.KeyProvider = newTestKeyProvider(t, 2, 1, 2)
+	db, err = Open(options)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put(utils.GetTestKey(0), values[string(utils.GetTestKey(0))]))
+	assert.Equal(t, uint32(2), recordKeyID(t, db, utils.GetTestKey(0)))
+	assert.Equal(t, uint32(1), recordKeyID(t, db, utils.GetTestKey(1)))
+
+	// merge re-encrypts all the records with the current key
+	assert.Nil(t, db.Merge(true))
+	for key, value := range values {
+		assert.Equal(t, uint32(2), recordKeyID(t, db, []byte(key)))
+		val, err := db.Get([]byte(key))
+		assert.Nil(t, err)
+		assert.Equal(t, value, val)
+	}
+
+	// the old key is no longer needed, and the index is loaded from the encrypted hint file
+	assert.Nil(t, db.Close())
+	hint, err := os.ReadFile(filepath.Join(options.DirPath, "000000001"+hintFileNameSuffix))
+	assert.Nil(t, err)
+	assert.False(t, bytes.Contains(hint, utils.GetTestKey(1)))
+
+	options.KeyProvider = newTestKeyProvider(t, 2, 2)
+	db, err = Open(options)
+	assert.Nil(t, err)
+	for key, value := range values {
+		val, err := db.Get([]byte(key))
+		assert.Nil(t, err)
+		assert.Equal(t, value, val)
+	}
+}
+
+func TestKeyProviders(t *testing.T) {
+	key1, key2 := bytes.Repeat([]byte{1}, 16), bytes.Repeat([]byte{2}, 32)
+	content := "# rosedb keys\n1=01010101010101010101010101010101\n\n" +
+		"2=0202020202020202020202020202020202020202020202020202020202020202\n"
+	path := filepath.Join(os.TempDir(), "rosedb-keys")
+	assert.Nil(t, os.WriteFile(path, []byte(content), 0600))
+	defer func() {
+		_ = os.Remove(path)
+	}()
+
+	fileProvider, err := NewKeyFileProvider(path)
+	assert.Nil(t, err)
+	t.Setenv("ROSEDB_TEST_KEYS", "1=01010101010101010101010101010101, 2=0202020202020202020202020202020202020202020202020202020202020202")
+	envProvider, err := NewEnvKeyProvider("ROSEDB_TEST_KEYS")
+	assert.Nil(t, err)
+
+	for _, provider := range []KeyProvider{fileProvider, envProvider} {
+		current, err := provider.CurrentKeyID()
+		assert.Nil(t, err)
+		assert.Equal(t, uint32(2), current)
+		key, err := provider.Key(1)
+		assert.Nil(t, err)
+		assert.Equal(t, key1, key)
+		key, err = provider.Key(2)
+		assert.Nil(t, err)
+		assert.Equal(t, key2, key)
+		_, err = provider.Key(3)
+		assert.True(t, errors.Is(err, ErrEncryptionKeyNotFound))
+	}
+
+	_, err = NewEnvKeyProvider("ROSEDB_TEST_KEYS_NOT_SET")
+	assert.NotNil(t, err)
+	_, err = parseKeys([]string{"1=not-hex"})
+	assert.NotNil(t, err)
+	_, err = parseKeys([]string{"# nothing"})
+	assert.Equal(t, ErrEncryptionKeyNotFound, err)
+}
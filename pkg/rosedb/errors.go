@@ -7,13 +7,37 @@ package rosedb
 import "errors"
 
 var (
-	ErrKeyIsEmpty      = errors.New("the key is empty")
-	ErrKeyNotFound     = errors.New("key not found in database")
-	ErrDatabaseIsUsing = errors.New("the database directory is used by another process")
-	ErrReadOnlyBatch   = errors.New("the batch is read only")
-	ErrBatchCommitted  = errors.New("the batch is committed")
-	ErrBatchRolledBack = errors.New("the batch is rolled back")
-	ErrDBClosed        = errors.New("the database is closed")
-	ErrMergeRunning    = errors.New("the merge operation is running")
-	ErrWatchDisabled   = errors.New("the watch is disabled")
+	ErrKeyIsEmpty             = errors.New("the key is empty")
+	ErrKeyNotFound            = errors.New("key not found in database")
+	ErrDatabaseIsUsing        = errors.New("the database directory is used by another process")
+	ErrReadOnlyBatch          = errors.New("the batch is read only")
+	ErrBatchCommitted         = errors.New("the batch is committed")
+	ErrBatchRolledBack        = errors.New("the batch is rolled back")
+	ErrDBClosed               = errors.New("the database is closed")
+	ErrMergeRunning           = errors.New("the merge operation is running")
+	ErrWatchDisabled          = errors.New("the watch is disabled")
+	ErrSubscriptionOverflow   = errors.New("the subscription is disconnected as its buffer is full")
+	ErrChangeFeedBehind       = errors.New("the changes at the change feed position are no longer retained")
+	ErrChangeConsumerNotFound = errors.New("the change feed consumer is not found")
+	ErrSnapshotReleased       = errors.New("the snapshot is released")
+	ErrTxnConflict            = errors.New("the transaction conflicts with a concurrent write")
+	ErrTxnCommitted           = errors.New("the transaction is committed")
+	ErrTxnRolledBack          = errors.New("the transaction is rolled back")
+	ErrInvalidCompression     = errors.New("the compression type is invalid")
+	ErrInvalidLogRecord       = errors.New("the log record is invalid")
+	ErrDecryptFailed          = errors.New("failed to decrypt the record, the encryption key may be wrong")
+	ErrEncryptionKeyNotFound  = errors.New("the encryption key is not found")
+	ErrEncryptionKeyRequired  = errors.New("the data is encrypted but no key provider is set")
+	ErrBackupDirNotEmpty      = errors.New("the backup directory is not empty")
+	ErrInvalidBackup          = errors.New("the backup is invalid")
+	ErrInvalidDump            = errors.New("the dump is invalid")
+	ErrInvalidDumpFormat      = errors.New("the dump format is invalid")
+	ErrBlobGCRunning          = errors.New("the blob gc operation is running")
+	ErrNamespaceNameEmpty     = errors.New("the namespace name is empty")
+	ErrNamespaceNotFound      = errors.New("the namespace is not found")
+	ErrPreconditionFailed     = errors.New("the precondition of the write is not met")
+	ErrValueNotInteger        = errors.New("the value is not an integer")
+	ErrValueNotFloat          = errors.New("the value is not a float")
+	ErrIncrOverflow           = errors.New("the increment would overflow")
+	ErrMultiLengthMismatch    = errors.New("the numbers of the keys and the values do not match")
 )
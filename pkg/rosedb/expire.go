@@ -0,0 +1,234 @@
+// Copyright 2024 Joy <joyssss94@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package rosedb
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+
+	"github.com/JoyZF/zoom/pkg/wal"
+)
+
+// expireBatchSize is the number of keys handled each time the db lock is held by the expirer.
+const expireBatchSize = 64
+
+type expireKey struct {
+	namespace uint32
+	key       string
+}
+
+type expireEntry struct {
+	expireKey
+	expire int64
+	index  int // position of the entry in the heap
+}
+
+// expireHeap is a min-heap of the keys by their expiration time.
+type expireHeap []*expireEntry
+
+func (h expireHeap) Len() int           { return len(h) }
+func (h expireHeap) Less(i, j int) bool { return h[i].expire < h[j].expire }
+
+func (h expireHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index, h[j].index = i, j
+}
+
+func (h *expireHeap) Push(x any) {
+	entry := x.(*expireEntry)
+	entry.index = len(*h)
+	*h = append(*h, entry)
+}
+
+func (h *expireHeap) Pop() any {
+	old := *h
+	entry := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return entry
+}
+
+// expirer removes the expired keys from the index in the background, see Options.ActiveExpireInterval.
+//
+// The heap holds one entry with the expiration time of every key written with a ttl, found by entries.
+// The entry is updated when the key is written again, and removed when it is written without a ttl
+// or deleted. The record in the index is still checked before the key is removed,
+// because the keys removed from the index by the reads are left in the heap.
+// The keys loaded into the index without their records, from the hint file, the index snapshot
+// or the disk index, are found by scanning the index in small steps after it is loaded,
+// followed by the index of each namespace.
+// The heap and the scan are protected by db.mu.
+type expirer struct {
+	heap          expireHeap
+	entries       map[expireKey]*expireEntry // the entry of each key in the heap
+	cursor        []byte                     // next key of the index scan
+	scanNamespace uint32                     // namespace of the index scanned
+	scanning      bool                       // whether the index scan is still going on
+
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func newExpirer() *expirer {
+	return &expirer{
+		entries: make(map[expireKey]*expireEntry),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+// add records the expiration time of the key in the namespace, replacing the one recorded before.
+func (e *expirer) add(namespace uint32, key []byte, expire int64) {
+	k := expireKey{namespace: namespace, key: string(key)}
+	if entry, ok := e.entries[k]; ok {
+		entry.expire = expire
+		heap.Fix(&e.heap, entry.index)
+		return
+	}
+	entry := &expireEntry{expireKey: k, expire: expire}
+	heap.Push(&e.heap, entry)
+	e.entries[k] = entry
+}
+
+// remove forgets the expiration time of the key in the namespace, if any.
+func (e *expirer) remove(namespace uint32, key []byte) {
+	k := expireKey{namespace: namespace, key: string(key)}
+	if entry, ok := e.entries[k]; ok {
+		heap.Remove(&e.heap, entry.index)
+		delete(e.entries, k)
+	}
+}
+
+// reset forgets all keys and scans the index again, it is called whenever the index is loaded.
+func (e *expirer) reset() {
+	e.heap = e.heap[:0]
+	e.entries = make(map[expireKey]*expireEntry)
+	e.cursor = nil
+	e.scanNamespace = defaultNamespaceID
+	e.scanning = true
+}
+
+// runExpirer removes the expired keys every interval, holding the db lock for about step each time.
+func (db *DB) runExpirer(interval, step time.Duration) {
+	defer close(db.expirer.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			// a failed step is retried at the next tick.
+			_ = db.expireStep(step)
+		case <-db.expirer.stop:
+			return
+		}
+	}
+}
+
+// stopExpirer stops runExpirer and waits for the step running.
+func (db *DB) stopExpirer() {
+	if db.expirer == nil {
+		return
+	}
+	db.expirer.stopOnce.Do(func() {
+		close(db.expirer.stop)
+		<-db.expirer.done
+	})
+}
+
+// expireStep removes the expired keys for about the given time.
+// The db lock is released after every expireBatchSize keys, so the other operations are not blocked for long.
+func (db *DB) expireStep(step time.Duration) error {
+	deadline := time.Now().Add(step)
+	for {
+		db.mu.Lock()
+		if db.closed {
+			db.mu.Unlock()
+			return ErrDBClosed
+		}
+		more, err := db.expireBatch(time.Now().UnixNano())
+		db.mu.Unlock()
+		if err != nil || !more || !time.Now().Before(deadline) {
+			return err
+		}
+	}
+}
+
+// expireBatch removes up to expireBatchSize expired keys, and continues the index scan with the rest of the batch.
+// It reports whether there is more to do now.
+// The caller must hold db.mu.
+func (db *DB) expireBatch(now int64) (bool, error) {
+	e := db.expirer
+	n := 0
+	for ; n < expireBatchSize && len(e.heap) > 0 && e.heap[0].expire <= now; n++ {
+		entry := heap.Pop(&e.heap).(*expireEntry)
+		delete(e.entries, entry.expireKey)
+		key := []byte(entry.key)
+		// the index is nil if the namespace has been dropped since.
+		idx := db.namespaceIndex(entry.namespace)
+		if idx == nil {
+			continue
+		}
+		position := idx.Get(key)
+		if position == nil {
+			continue
+		}
+		record, err := db.readExpireRecord(position)
+		if err != nil {
+			return false, err
+		}
+		if record.IsExpired(now) {
+			db.discardExpired(key, record)
+		}
+	}
+	if !e.scanning || n >= expireBatchSize {
+		return n >= expireBatchSize, nil
+	}
+
+	var keys [][]byte
+	var positions []*wal.ChunkPosition
+	if idx := db.namespaceIndex(e.scanNamespace); idx != nil {
+		idx.AscendGreaterOrEqual(e.cursor, func(key []byte, pos *wal.ChunkPosition) (bool, error) {
+			keys = append(keys, append([]byte(nil), key...))
+			positions = append(positions, pos)
+			return len(keys) < expireBatchSize-n, nil
+		})
+	}
+	if len(keys) == 0 {
+		// the scan goes on with the next namespace, if any.
+		next, ok := db.nextNamespaceID(e.scanNamespace)
+		if !ok {
+			e.scanning, e.cursor, e.scanNamespace = false, nil, defaultNamespaceID
+			return false, nil
+		}
+		e.scanNamespace, e.cursor = next, nil
+		return true, nil
+	}
+	for i, key := range keys {
+		record, err := db.readExpireRecord(positions[i])
+		if err != nil {
+			return false, err
+		}
+		if record.IsExpired(now) {
+			db.discardExpired(key, record)
+		} else if record.Expire > 0 {
+			e.add(e.scanNamespace, key, record.Expire)
+		}
+	}
+	// the scan continues after the last key.
+	e.cursor = append(keys[len(keys)-1], 0)
+	return true, nil
+}
+
+// readExpireRecord reads the record at the position, the value is not loaded from the blob files.
+// The caller must hold db.mu.
+func (db *DB) readExpireRecord(position *wal.ChunkPosition) (*LogRecord, error) {
+	chunk, err := db.dataFiles.Read(position)
+	if err != nil {
+		return nil, err
+	}
+	return decodeLogRecord(chunk, db.cipher)
+}
@@ -0,0 +1,114 @@
+// Copyright 2024 Joy <joyssss94@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package rosedb
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/JoyZF/zoom/utils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_ActiveExpire(t *testing.T) {
+	options := DefaultOptions
+	options.ActiveExpireInterval = 10 * time.Millisecond
+	options.WatchQueueSize = 10000
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	var expired int64
+	watchCh, err := db.Watch()
+	assert.Nil(t, err)
+	go func() {
+		for event := range watchCh {
+			if event.Action == WatchActionExpire {
+				atomic.AddInt64(&expired, 1)
+			}
+		}
+	}()
+
+	for i := 0; i < 1000; i++ {
+		if i%2 == 0 {
+			assert.Nil(t, db.PutWithTTL(utils.GetTestKey(i), utils.RandomValue(128), 50*time.Millisecond))
+		} else {
+			assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+		}
+	}
+	// the keys written again or persisted are not removed
+	assert.Nil(t, db.Put(utils.GetTestKey(0), utils.RandomValue(128)))
+	assert.Nil(t, db.Persist(utils.GetTestKey(2)))
+	assert.Nil(t, db.Expire(utils.GetTestKey(4), time.Hour))
+	assert.Equal(t, 1000, db.Stat().KeysNum)
+
+	// the expired keys are removed without being read
+	time.Sleep(300 * time.Millisecond)
+	assert.Equal(t, 503, db.Stat().KeysNum)
+	for _, i := range []int{0, 2, 4} {
+		_, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt64(&expired) == 497
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestDB_ActiveExpire_Reopen(t *testing.T) {
+	options := DefaultOptions
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer func() {
+		destroyDB(db)
+	}()
+
+	for i := 0; i < 300; i++ {
+		assert.Nil(t, db.PutWithTTL(utils.GetTestKey(i), utils.RandomValue(128), 200*time.Millisecond))
+		assert.Nil(t, db.Put(utils.GetTestKey(i+300), utils.RandomValue(128)))
+	}
+	assert.Nil(t, db.Close())
+
+	// the keys with a ttl are found by scanning the index after it is loaded
+	options.ActiveExpireInterval = 10 * time.Millisecond
+	db, err = Open(options)
+	assert.Nil(t, err)
+	assert.Equal(t, 600, db.Stat().KeysNum)
+	time.Sleep(400 * time.Millisecond)
+	assert.Equal(t, 300, db.Stat().KeysNum)
+}
+
+func TestDB_ActiveExpire_Overwrite(t *testing.T) {
+	options := DefaultOptions
+	options.ActiveExpireInterval = 10 * time.Millisecond
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+	heapSize := func() int {
+		db.mu.RLock()
+		defer db.mu.RUnlock()
+		assert.Equal(t, len(db.expirer.heap), len(db.expirer.entries))
+		return len(db.expirer.heap)
+	}
+
+	// a key written again with a ttl keeps a single entry
+	for round := 0; round < 3; round++ {
+		for i := 0; i < 100; i++ {
+			assert.Nil(t, db.PutWithTTL(utils.GetTestKey(i), utils.RandomValue(128), time.Hour))
+		}
+	}
+	assert.Equal(t, 100, heapSize())
+
+	// the entry is dropped when the key is written without a ttl or deleted
+	for i := 0; i < 50; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+	assert.Nil(t, db.Persist(utils.GetTestKey(50)))
+	for i := 51; i < 75; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	assert.Equal(t, 25, heapSize())
+}
@@ -0,0 +1,661 @@
+// Copyright 2024 Joy <joyssss94@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"bytes"
+	"sync"
+
+	"github.com/JoyZF/zoom/pkg/wal"
+)
+
+// MemoryART is a memory based adaptive radix tree implementation of the Indexer interface.
+//
+// A node branches on one byte of the key, and grows from 4 up to 256 children as they are added,
+// so it takes little memory when it is sparse. The bytes shared by all keys below a node are stored
+// in the node as its prefix, so a lookup takes at most one step per byte of the key.
+//
+// The tree is persistent: Put and Delete copy the nodes on the path to the key instead of changing them,
+// so Clone and Iterator only have to share the root.
+type MemoryART struct {
+	root *artNode
+	size int
+	lock *sync.RWMutex
+}
+
+type artKind uint8
+
+const (
+	artNode4 artKind = iota
+	artNode16
+	artNode48
+	artNode256
+)
+
+// artCapacity is the maximum number of children of each kind of node.
+var artCapacity = [...]int{artNode4: 4, artNode16: 16, artNode48: 48, artNode256: 256}
+
+// artShrink is the number of children at which a node is shrunk to the smaller kind,
+// lower than the capacity of that kind, so a node does not flip between two kinds.
+var artShrink = [...]int{artNode16: 3, artNode48: 12, artNode256: 36}
+
+// artNode is a node of the tree. The key of a node is made of the edges from the root to it
+// and the prefixes of the nodes on the way, including its own.
+// A node shared by other trees is never changed, it is copied first.
+type artNode struct {
+	prefix []byte
+	leaf   *item // the item whose key is the key of the node
+	kind   artKind
+	size   int // the number of children
+	// keys are the sorted edges of the children of artNode4 and artNode16.
+	keys []byte
+	// children are in the order of keys for artNode4 and artNode16,
+	// in the slots referred to by index for artNode48, and indexed by the edge for artNode256.
+	children []*artNode
+	// index maps an edge to the slot of the child plus one for artNode48, 0 means no child.
+	index []byte
+}
+
+func (t *MemoryART) Put(key []byte, position *wal.ChunkPosition) *wal.ChunkPosition {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	var old *item
+	t.root, old = artInsert(t.root, key, 0, &item{key: key, pos: position})
+	if old != nil {
+		return old.pos
+	}
+	t.size++
+	return nil
+}
+
+func (t *MemoryART) Get(key []byte) *wal.ChunkPosition {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	depth := 0
+	for n := t.root; n != nil; depth++ {
+		if !bytes.HasPrefix(key[depth:], n.prefix) {
+			return nil
+		}
+		depth += len(n.prefix)
+		if depth == len(key) {
+			if n.leaf == nil {
+				return nil
+			}
+			return n.leaf.pos
+		}
+		n = n.child(key[depth])
+	}
+	return nil
+}
+
+func (t *MemoryART) Delete(key []byte) (*wal.ChunkPosition, bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	var old *item
+	t.root, old = artDelete(t.root, key, 0)
+	if old == nil {
+		return nil, false
+	}
+	t.size--
+	return old.pos, true
+}
+
+func (t *MemoryART) Size() int {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	return t.size
+}
+
+func (t *MemoryART) Ascend(handleFn func(key []byte, position *wal.ChunkPosition) (bool, error)) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	if t.root != nil {
+		t.root.ascend(itemVisitor(handleFn))
+	}
+}
+
+func (t *MemoryART) AscendRange(
+	startKey, endKey []byte,
+	handleFn func(key []byte, position *wal.ChunkPosition) (bool, error),
+) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	if t.root == nil {
+		return
+	}
+	visit := itemVisitor(handleFn)
+	t.root.ascendFrom(startKey, 0, func(i *item) bool {
+		return bytes.Compare(i.key, endKey) < 0 && visit(i)
+	})
+}
+
+func (t *MemoryART) AscendGreaterOrEqual(
+	key []byte,
+	handleFn func(key []byte, position *wal.ChunkPosition) (bool, error),
+) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	if t.root != nil {
+		t.root.ascendFrom(key, 0, itemVisitor(handleFn))
+	}
+}
+
+func (t *MemoryART) Descend(handleFn func(key []byte, pos *wal.ChunkPosition) (bool, error)) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	if t.root != nil {
+		t.root.descend(itemVisitor(handleFn))
+	}
+}
+
+func (t *MemoryART) DescendRange(
+	startKey, endKey []byte,
+	handleFn func(key []byte, position *wal.ChunkPosition) (bool, error),
+) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	if t.root == nil {
+		return
+	}
+	visit := itemVisitor(handleFn)
+	t.root.descendFrom(startKey, 0, func(i *item) bool {
+		return bytes.Compare(i.key, endKey) > 0 && visit(i)
+	})
+}
+
+func (t *MemoryART) DescendLessOrEqual(
+	key []byte,
+	handleFn func(key []byte, position *wal.ChunkPosition) (bool, error),
+) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	if t.root != nil {
+		t.root.descendFrom(key, 0, itemVisitor(handleFn))
+	}
+}
+
+func (t *MemoryART) Clone() Indexer {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	return &MemoryART{root: t.root, size: t.size, lock: new(sync.RWMutex)}
+}
+
+func (t *MemoryART) Iterator(reverse bool) IndexIterator {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	return &memoryARTIterator{root: t.root, reverse: reverse}
+}
+
+// memoryARTIterator walks the root of the tree at the time it was created,
+// which is never changed, so it does not hold the index lock.
+type memoryARTIterator struct {
+	root    *artNode
+	reverse bool
+	current *item
+}
+
+func (it *memoryARTIterator) Rewind() {
+	it.current = nil
+	if it.root == nil {
+		return
+	}
+	first := func(i *item) bool {
+		it.current = i
+		return false
+	}
+	if it.reverse {
+		it.root.descend(first)
+	} else {
+		it.root.ascend(first)
+	}
+}
+
+func (it *memoryARTIterator) Seek(key []byte) {
+	it.current = nil
+	if it.root == nil {
+		return
+	}
+	first := func(i *item) bool {
+		it.current = i
+		return false
+	}
+	if it.reverse {
+		it.root.descendFrom(key, 0, first)
+	} else {
+		it.root.ascendFrom(key, 0, first)
+	}
+}
+
+func (it *memoryARTIterator) Next() {
+	it.step(it.reverse)
+}
+
+func (it *memoryARTIterator) Prev() {
+	it.step(!it.reverse)
+}
+
+// step moves the cursor to the neighbour of the current item,
+// towards smaller keys if descend is true.
+func (it *memoryARTIterator) step(descend bool) {
+	cur := it.current
+	if cur == nil || it.root == nil {
+		return
+	}
+	it.current = nil
+	neighbour := func(i *item) bool {
+		if bytes.Equal(i.key, cur.key) {
+			return true
+		}
+		it.current = i
+		return false
+	}
+	if descend {
+		it.root.descendFrom(cur.key, 0, neighbour)
+	} else {
+		it.root.ascendFrom(cur.key, 0, neighbour)
+	}
+}
+
+func (it *memoryARTIterator) Valid() bool {
+	return it.current != nil
+}
+
+func (it *memoryARTIterator) Key() []byte {
+	if it.current == nil {
+		return nil
+	}
+	return it.current.key
+}
+
+func (it *memoryARTIterator) Value() *wal.ChunkPosition {
+	if it.current == nil {
+		return nil
+	}
+	return it.current.pos
+}
+
+func (it *memoryARTIterator) Close() {
+	it.root, it.current = nil, nil
+}
+
+// artInsert returns a copy of the node with the item of the key, and the item it replaced.
+// depth is the number of bytes of the key above the node.
+func artInsert(n *artNode, key []byte, depth int, it *item) (*artNode, *item) {
+	if n == nil {
+		return &artNode{prefix: key[depth:], leaf: it}, nil
+	}
+
+	p := commonPrefixLen(n.prefix, key[depth:])
+	if p < len(n.prefix) {
+		// the key leaves the prefix, split it at the first byte that differs.
+		parent := &artNode{prefix: n.prefix[:p]}
+		child := *n
+		child.prefix = n.prefix[p+1:]
+		parent.setChild(n.prefix[p], &child)
+		if depth+p == len(key) {
+			parent.leaf = it
+		} else {
+			parent.setChild(key[depth+p], &artNode{prefix: key[depth+p+1:], leaf: it})
+		}
+		return parent, nil
+	}
+
+	depth += p
+	c := n.copy()
+	if depth == len(key) {
+		c.leaf = it
+		return c, n.leaf
+	}
+	child, old := artInsert(n.child(key[depth]), key, depth+1, it)
+	c.setChild(key[depth], child)
+	return c, old
+}
+
+// artDelete returns a copy of the node without the key, and the item removed.
+// The node itself is returned if the key is not found.
+func artDelete(n *artNode, key []byte, depth int) (*artNode, *item) {
+	if n == nil || !bytes.HasPrefix(key[depth:], n.prefix) {
+		return n, nil
+	}
+
+	depth += len(n.prefix)
+	if depth == len(key) {
+		if n.leaf == nil {
+			return n, nil
+		}
+		c := n.copy()
+		c.leaf = nil
+		return c.compact(), n.leaf
+	}
+	edge := key[depth]
+	child, old := artDelete(n.child(edge), key, depth+1)
+	if old == nil {
+		return n, nil
+	}
+	c := n.copy()
+	if child == nil {
+		c.removeChild(edge)
+	} else {
+		c.setChild(edge, child)
+	}
+	return c.compact(), old
+}
+
+// copy returns a copy of the node which can be changed.
+func (n *artNode) copy() *artNode {
+	c := *n
+	c.keys = append([]byte(nil), n.keys...)
+	c.children = append([]*artNode(nil), n.children...)
+	c.index = append([]byte(nil), n.index...)
+	return &c
+}
+
+// compact removes the node if it has neither an item nor children,
+// and merges it into its only child if it has no item.
+func (n *artNode) compact() *artNode {
+	if n.leaf != nil || n.size > 1 {
+		return n
+	}
+	if n.size == 0 {
+		return nil
+	}
+	edge, child := n.next(0)
+	merged := *child
+	merged.prefix = make([]byte, 0, len(n.prefix)+1+len(child.prefix))
+	merged.prefix = append(append(append(merged.prefix, n.prefix...), edge), child.prefix...)
+	return &merged
+}
+
+// child returns the child of the edge, nil if there is none.
+func (n *artNode) child(edge byte) *artNode {
+	switch n.kind {
+	case artNode48:
+		if slot := n.index[edge]; slot != 0 {
+			return n.children[slot-1]
+		}
+		return nil
+	case artNode256:
+		return n.children[edge]
+	default:
+		if i, ok := n.search(edge); ok {
+			return n.children[i]
+		}
+		return nil
+	}
+}
+
+// search returns the position of the edge in the keys of artNode4 and artNode16,
+// or the position it would be inserted at.
+func (n *artNode) search(edge byte) (int, bool) {
+	for i, k := range n.keys {
+		if k >= edge {
+			return i, k == edge
+		}
+	}
+	return len(n.keys), false
+}
+
+// setChild adds or replaces the child of the edge, the node grows if it is full.
+func (n *artNode) setChild(edge byte, child *artNode) {
+	if n.kind != artNode256 && n.size == artCapacity[n.kind] && n.child(edge) == nil {
+		n.convert(n.kind + 1)
+	}
+	switch n.kind {
+	case artNode48:
+		if slot := n.index[edge]; slot != 0 {
+			n.children[slot-1] = child
+			return
+		}
+		slot := 0
+		for n.children[slot] != nil {
+			slot++
+		}
+		n.children[slot], n.index[edge] = child, byte(slot+1)
+	case artNode256:
+		if n.children[edge] != nil {
+			n.children[edge] = child
+			return
+		}
+		n.children[edge] = child
+	default:
+		i, ok := n.search(edge)
+		if ok {
+			n.children[i] = child
+			return
+		}
+		n.keys = append(n.keys, 0)
+		copy(n.keys[i+1:], n.keys[i:])
+		n.keys[i] = edge
+		n.children = append(n.children, nil)
+		copy(n.children[i+1:], n.children[i:])
+		n.children[i] = child
+	}
+	n.size++
+}
+
+// removeChild removes the child of the edge, the node shrinks if it has few children left.
+func (n *artNode) removeChild(edge byte) {
+	switch n.kind {
+	case artNode48:
+		slot := n.index[edge]
+		if slot == 0 {
+			return
+		}
+		n.children[slot-1], n.index[edge] = nil, 0
+	case artNode256:
+		if n.children[edge] == nil {
+			return
+		}
+		n.children[edge] = nil
+	default:
+		i, ok := n.search(edge)
+		if !ok {
+			return
+		}
+		last := len(n.keys) - 1
+		copy(n.keys[i:], n.keys[i+1:])
+		copy(n.children[i:], n.children[i+1:])
+		n.keys, n.children[last] = n.keys[:last], nil
+		n.children = n.children[:last]
+	}
+	n.size--
+	if n.kind != artNode4 && n.size <= artShrink[n.kind] {
+		n.convert(n.kind - 1)
+	}
+}
+
+// convert changes the node to the kind, keeping its children.
+func (n *artNode) convert(kind artKind) {
+	edges := make([]byte, 0, artCapacity[kind])
+	children := make([]*artNode, 0, artCapacity[kind])
+	for edge, child := n.next(0); child != nil; edge, child = n.next(int(edge) + 1) {
+		edges = append(edges, edge)
+		children = append(children, child)
+	}
+
+	n.kind, n.keys, n.children, n.index = kind, nil, nil, nil
+	switch kind {
+	case artNode48:
+		n.index = make([]byte, 256)
+		n.children = make([]*artNode, artCapacity[kind])
+		for i, edge := range edges {
+			n.index[edge], n.children[i] = byte(i+1), children[i]
+		}
+	case artNode256:
+		n.children = make([]*artNode, 256)
+		for i, edge := range edges {
+			n.children[edge] = children[i]
+		}
+	default:
+		n.keys, n.children = edges, children
+	}
+}
+
+// next returns the child of the smallest edge >= from, the child is nil if there is none.
+func (n *artNode) next(from int) (byte, *artNode) {
+	switch n.kind {
+	case artNode48:
+		for c := from; c < 256; c++ {
+			if slot := n.index[c]; slot != 0 {
+				return byte(c), n.children[slot-1]
+			}
+		}
+	case artNode256:
+		for c := from; c < 256; c++ {
+			if child := n.children[c]; child != nil {
+				return byte(c), child
+			}
+		}
+	default:
+		for i, k := range n.keys {
+			if int(k) >= from {
+				return k, n.children[i]
+			}
+		}
+	}
+	return 0, nil
+}
+
+// prev returns the child of the largest edge <= from, the child is nil if there is none.
+func (n *artNode) prev(from int) (byte, *artNode) {
+	switch n.kind {
+	case artNode48:
+		for c := from; c >= 0; c-- {
+			if slot := n.index[c]; slot != 0 {
+				return byte(c), n.children[slot-1]
+			}
+		}
+	case artNode256:
+		for c := from; c >= 0; c-- {
+			if child := n.children[c]; child != nil {
+				return byte(c), child
+			}
+		}
+	default:
+		for i := len(n.keys) - 1; i >= 0; i-- {
+			if int(n.keys[i]) <= from {
+				return n.keys[i], n.children[i]
+			}
+		}
+	}
+	return 0, nil
+}
+
+// ascend calls visit for the items below the node in ascending order,
+// it returns false if visit stopped the iteration.
+func (n *artNode) ascend(visit func(i *item) bool) bool {
+	if n.leaf != nil && !visit(n.leaf) {
+		return false
+	}
+	for edge, child := n.next(0); child != nil; edge, child = n.next(int(edge) + 1) {
+		if !child.ascend(visit) {
+			return false
+		}
+	}
+	return true
+}
+
+// descend calls visit for the items below the node in descending order,
+// it returns false if visit stopped the iteration.
+func (n *artNode) descend(visit func(i *item) bool) bool {
+	for edge, child := n.prev(255); child != nil; edge, child = n.prev(int(edge) - 1) {
+		if !child.descend(visit) {
+			return false
+		}
+	}
+	return n.leaf == nil || visit(n.leaf)
+}
+
+// ascendFrom calls visit for the items below the node with keys >= key in ascending order,
+// depth is the number of bytes of the key above the node.
+func (n *artNode) ascendFrom(key []byte, depth int, visit func(i *item) bool) bool {
+	rest := key[depth:]
+	switch comparePrefix(n.prefix, rest) {
+	case 1:
+		return n.ascend(visit)
+	case -1:
+		return true
+	}
+	// the keys below the node all start with the key.
+	if len(rest) <= len(n.prefix) {
+		return n.ascend(visit)
+	}
+
+	depth += len(n.prefix)
+	edge := key[depth]
+	if child := n.child(edge); child != nil && !child.ascendFrom(key, depth+1, visit) {
+		return false
+	}
+	for c, child := n.next(int(edge) + 1); child != nil; c, child = n.next(int(c) + 1) {
+		if !child.ascend(visit) {
+			return false
+		}
+	}
+	return true
+}
+
+// descendFrom calls visit for the items below the node with keys <= key in descending order,
+// depth is the number of bytes of the key above the node.
+func (n *artNode) descendFrom(key []byte, depth int, visit func(i *item) bool) bool {
+	rest := key[depth:]
+	switch comparePrefix(n.prefix, rest) {
+	case 1:
+		return true
+	case -1:
+		return n.descend(visit)
+	}
+	// the keys below the node are all longer than the key.
+	if len(rest) < len(n.prefix) {
+		return true
+	}
+
+	depth += len(n.prefix)
+	if depth == len(key) {
+		return n.leaf == nil || visit(n.leaf)
+	}
+	edge := key[depth]
+	if child := n.child(edge); child != nil && !child.descendFrom(key, depth+1, visit) {
+		return false
+	}
+	for c, child := n.prev(int(edge) - 1); child != nil; c, child = n.prev(int(c) - 1) {
+		if !child.descend(visit) {
+			return false
+		}
+	}
+	return n.leaf == nil || visit(n.leaf)
+}
+
+// commonPrefixLen returns the length of the common prefix of a and b.
+func commonPrefixLen(a, b []byte) int {
+	n := min(len(a), len(b))
+	for i := 0; i < n; i++ {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	return n
+}
+
+// comparePrefix compares the prefix with the key on their common length.
+func comparePrefix(prefix, key []byte) int {
+	n := min(len(prefix), len(key))
+	return bytes.Compare(prefix[:n], key[:n])
+}
+
+func newART() *MemoryART {
+	return &MemoryART{lock: new(sync.RWMutex)}
+}
@@ -0,0 +1,1203 @@
+// Copyright 2024 Joy <joyssss94@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"bytes"
+	"container/list"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/JoyZF/zoom/pkg/wal"
+)
+
+const (
+	bptreeFileNameSuffix = ".INDEX"
+	bptreeMetaFileName   = "INDEXMETA"
+	// maximum number of keys in a node, a node is split when it gets more.
+	bptreeMaxKeys = 128
+	// crc32 and payload length of a node in the index file
+	bptreeRecordHeaderSize = 8
+	// estimated memory used by an entry of a node, besides the key.
+	bptreeEntryOverhead = 64
+	// the nodes are buffered up to this size before being written to the index file.
+	bptreeWriteBufferSize = 1 << 20
+	// the index file is compacted when more than half of it is garbage, and it is larger than this.
+	bptreeMinCompactSize = 16 << 20
+)
+
+var (
+	ErrIndexCorrupted    = errors.New("the index file is corrupted")
+	ErrIndexNotFlushable = errors.New("the index is a clone and can not be flushed")
+)
+
+// DiskIndexer is an Indexer persisted in the database directory,
+// so it does not have to be rebuilt from the WAL every time the database is opened.
+//
+// The changes are kept in memory until Flush is called, along with a checkpoint
+// recording how much of the WAL the persisted index covers.
+type DiskIndexer interface {
+	Indexer
+
+	// Checkpoint returns the checkpoint given to the last Flush,
+	// or nil if the index has never been flushed.
+	Checkpoint() []byte
+
+	// Flush persists the changes made to the index since the last Flush, along with the checkpoint.
+	Flush(checkpoint []byte) error
+
+	// DirtySize returns the estimated memory size of the changes not flushed yet.
+	DirtySize() int64
+
+	// Reset discards all keys in the index, the clones made before are not affected.
+	Reset() error
+
+	// Close releases the files of the index, the changes not flushed are discarded.
+	Close() error
+}
+
+// BPTreeOptions is the options of the disk based B+tree.
+type BPTreeOptions struct {
+	// DirPath is the directory of the index files.
+	DirPath string
+
+	// CacheSize is the maximum memory size in bytes of the nodes read from the index file
+	// and cached in memory.
+	CacheSize int64
+}
+
+// DiskBPTree is a disk based B+tree implementation of the Indexer interface.
+//
+// The tree is copy-on-write and append-only: a changed node is copied in memory,
+// and written to the end of the index file by Flush, then the INDEXMETA file is replaced
+// to point to the new root. So the index file is never modified in place,
+// a crash during Flush leaves the previous version intact,
+// and Clone only has to share the root of the tree.
+//
+// Only the nodes changed since the last Flush are held in memory,
+// the others are read from the index file on demand and kept in a bounded cache.
+//
+// The Indexer interface does not return errors, so a failure to read the index file panics.
+type DiskBPTree struct {
+	lock  *sync.RWMutex
+	store *bptreeStore
+	file  *bptreeFile // the file the offsets in the tree refer to
+	root  bptreeRef
+	size  int
+	owner *bptreeOwner // the nodes owned by the tree can be changed in place
+
+	// the fields below are only used by the tree returned by OpenBPTree, the clones are never flushed.
+	persistent bool
+	meta       *bptreeMeta
+	dirtySize  int64
+	garbage    int64 // size of the nodes in the index file replaced since the last Flush
+	compactAt  int64
+}
+
+// bptreeOwner identifies the tree which may change a node in place, see DiskBPTree.mutable.
+type bptreeOwner struct {
+	_ int // a zero-sized type may share its address with other values
+}
+
+type bptreeNode struct {
+	owner     *bptreeOwner // nil if the node has been written to the index file
+	leaf      bool
+	keys      [][]byte
+	positions []*wal.ChunkPosition // leaf only
+	// internal only, len(children) == len(keys)+1,
+	// the keys of children[i] are >= keys[i-1] and < keys[i].
+	children []bptreeRef
+	diskSize int // size of the node in the index file, 0 if it has not been written
+}
+
+// bptreeRef refers to a node, either in memory or in the index file.
+type bptreeRef struct {
+	offset int64       // offset of the node in the index file, valid if node is nil
+	node   *bptreeNode // the node not written yet
+}
+
+// bptreeMeta is the content of the INDEXMETA file.
+type bptreeMeta struct {
+	FileId     uint32 `json:"file_id"`
+	Root       int64  `json:"root"`
+	Size       int    `json:"size"`
+	Length     int64  `json:"length"`  // the size of the index file when the meta was written
+	Garbage    int64  `json:"garbage"` // size of the nodes in the index file no longer referenced
+	Checkpoint []byte `json:"checkpoint"`
+}
+
+type bptreeFile struct {
+	id   uint32
+	fd   *os.File
+	size int64
+}
+
+// bptreeStore holds the index files and the node cache shared by a tree and its clones.
+type bptreeStore struct {
+	dirPath string
+	cache   *bptreeCache
+	mu      sync.Mutex
+	// all files opened, the files replaced by Reset or compaction are kept open
+	// until Close, because the clones may still read them.
+	files  []*bptreeFile
+	closed bool
+}
+
+// OpenBPTree opens the disk based B+tree in options.DirPath, or creates an empty one.
+// An index whose files are missing or corrupted is opened empty, with a nil checkpoint,
+// so the caller rebuilds it.
+func OpenBPTree(options BPTreeOptions) (*DiskBPTree, error) {
+	store := &bptreeStore{dirPath: options.DirPath, cache: newBPTreeCache(options.CacheSize)}
+	tree := &DiskBPTree{
+		lock:       new(sync.RWMutex),
+		store:      store,
+		owner:      new(bptreeOwner),
+		persistent: true,
+		compactAt:  bptreeMinCompactSize,
+	}
+
+	meta, file, err := store.openMeta()
+	if err != nil {
+		return nil, err
+	}
+	if file != nil {
+		tree.file, tree.meta = file, meta
+		tree.root, tree.size = bptreeRef{offset: meta.Root}, meta.Size
+		// make sure the root can be read, the other nodes are checked when they are read.
+		if _, err = tree.readNode(tree.root); err == nil {
+			return tree, store.removeFiles(file.id)
+		}
+		_ = store.close()
+		store = &bptreeStore{dirPath: options.DirPath, cache: newBPTreeCache(options.CacheSize)}
+		tree.store = store
+	}
+
+	// start with an empty tree
+	if tree.file, err = store.createFile(1); err != nil {
+		return nil, err
+	}
+	tree.meta = &bptreeMeta{FileId: tree.file.id}
+	tree.root, tree.size = bptreeRef{node: tree.newNode(true)}, 0
+	return tree, store.removeFiles(tree.file.id)
+}
+
+// openMeta reads the INDEXMETA file and opens the index file it points to,
+// the returned file is nil if the index does not exist or can not be used.
+func (s *bptreeStore) openMeta() (*bptreeMeta, *bptreeFile, error) {
+	data, err := os.ReadFile(filepath.Join(s.dirPath, bptreeMetaFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+	meta := &bptreeMeta{}
+	if err = json.Unmarshal(data, meta); err != nil {
+		return nil, nil, nil
+	}
+	fd, err := os.OpenFile(bptreeFileName(s.dirPath, meta.FileId), os.O_RDWR, 0644)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+	info, err := fd.Stat()
+	if err != nil {
+		_ = fd.Close()
+		return nil, nil, err
+	}
+	if info.Size() < meta.Length {
+		_ = fd.Close()
+		return nil, nil, nil
+	}
+	// the nodes written after the meta by an interrupted Flush are discarded.
+	file := &bptreeFile{id: meta.FileId, fd: fd, size: meta.Length}
+	s.files = append(s.files, file)
+	return meta, file, nil
+}
+
+func bptreeFileName(dirPath string, id uint32) string {
+	return filepath.Join(dirPath, fmt.Sprintf("%09d"+bptreeFileNameSuffix, id))
+}
+
+// createFile creates an empty index file.
+func (s *bptreeStore) createFile(id uint32) (*bptreeFile, error) {
+	fd, err := os.OpenFile(bptreeFileName(s.dirPath, id), os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	file := &bptreeFile{id: id, fd: fd}
+	s.mu.Lock()
+	s.files = append(s.files, file)
+	s.mu.Unlock()
+	return file, nil
+}
+
+// removeFiles removes the index files other than the current one.
+// The files still open are removed from the directory only, so the clones can keep reading them.
+func (s *bptreeStore) removeFiles(current uint32) error {
+	entries, err := os.ReadDir(s.dirPath)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		var id uint32
+		if entry.IsDir() || filepath.Ext(entry.Name()) != bptreeFileNameSuffix {
+			continue
+		}
+		if _, err = fmt.Sscanf(entry.Name(), "%d"+bptreeFileNameSuffix, &id); err != nil || id == current {
+			continue
+		}
+		if err = os.Remove(filepath.Join(s.dirPath, entry.Name())); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *bptreeStore) writeMeta(meta *bptreeMeta) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(s.dirPath, bptreeMetaFileName)
+	fd, err := os.OpenFile(path+".tmp", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = fd.Write(data); err == nil {
+		err = fd.Sync()
+	}
+	if closeErr := fd.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func (s *bptreeStore) close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	var err error
+	for _, file := range s.files {
+		if closeErr := file.fd.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	s.files = nil
+	return err
+}
+
+// readNode returns the node the ref refers to.
+func (t *DiskBPTree) readNode(ref bptreeRef) (*bptreeNode, error) {
+	if ref.node != nil {
+		return ref.node, nil
+	}
+	key := bptreeCacheKey{file: t.file, offset: ref.offset}
+	if node := t.store.cache.get(key); node != nil {
+		return node, nil
+	}
+
+	header := make([]byte, bptreeRecordHeaderSize)
+	if _, err := t.file.fd.ReadAt(header, ref.offset); err != nil {
+		return nil, err
+	}
+	length := binary.LittleEndian.Uint32(header[4:])
+	if ref.offset+bptreeRecordHeaderSize+int64(length) > t.file.size {
+		return nil, ErrIndexCorrupted
+	}
+	payload := make([]byte, length)
+	if _, err := t.file.fd.ReadAt(payload, ref.offset+bptreeRecordHeaderSize); err != nil {
+		return nil, err
+	}
+	if binary.LittleEndian.Uint32(header) != crc32.ChecksumIEEE(payload) {
+		return nil, ErrIndexCorrupted
+	}
+	node, err := decodeBPTreeNode(payload)
+	if err != nil {
+		return nil, err
+	}
+	node.diskSize = bptreeRecordHeaderSize + len(payload)
+	t.store.cache.put(key, node)
+	return node, nil
+}
+
+// node returns the node the ref refers to, it panics if the node can not be read.
+func (t *DiskBPTree) node(ref bptreeRef) *bptreeNode {
+	node, err := t.readNode(ref)
+	if err != nil {
+		panic(fmt.Sprintf("index: failed to read the index file: %v", err))
+	}
+	return node
+}
+
+func (t *DiskBPTree) newNode(leaf bool) *bptreeNode {
+	return &bptreeNode{owner: t.owner, leaf: leaf}
+}
+
+// mutable returns the node the ref refers to if it is owned by the tree,
+// or a copy of it owned by the tree, which the caller must link into the tree.
+func (t *DiskBPTree) mutable(ref bptreeRef) *bptreeNode {
+	node := t.node(ref)
+	if node.owner == t.owner {
+		return node
+	}
+	copied := &bptreeNode{
+		owner:     t.owner,
+		leaf:      node.leaf,
+		keys:      append(make([][]byte, 0, len(node.keys)+1), node.keys...),
+		positions: append([]*wal.ChunkPosition(nil), node.positions...),
+		children:  append([]bptreeRef(nil), node.children...),
+	}
+	t.dirtySize += copied.memSize()
+	if ref.node == nil {
+		t.garbage += int64(node.diskSize)
+	}
+	return copied
+}
+
+func (n *bptreeNode) memSize() int64 {
+	size := int64(bptreeEntryOverhead * (len(n.keys) + 1))
+	for _, key := range n.keys {
+		size += int64(len(key))
+	}
+	return size
+}
+
+// search returns the index of the first key >= key, and whether it equals key.
+func (n *bptreeNode) search(key []byte) (int, bool) {
+	i := sort.Search(len(n.keys), func(i int) bool {
+		return bytes.Compare(n.keys[i], key) >= 0
+	})
+	return i, i < len(n.keys) && bytes.Equal(n.keys[i], key)
+}
+
+// childIndex returns the index of the child of an internal node which may contain key.
+func (n *bptreeNode) childIndex(key []byte) int {
+	return sort.Search(len(n.keys), func(i int) bool {
+		return bytes.Compare(n.keys[i], key) > 0
+	})
+}
+
+func (t *DiskBPTree) Put(key []byte, position *wal.ChunkPosition) *wal.ChunkPosition {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	root := t.mutable(t.root)
+	old, right, separator := t.insert(root, key, position)
+	if right != nil {
+		newRoot := t.newNode(false)
+		newRoot.keys = [][]byte{separator}
+		newRoot.children = []bptreeRef{{node: root}, {node: right}}
+		t.dirtySize += newRoot.memSize()
+		root = newRoot
+	}
+	t.root = bptreeRef{node: root}
+	if old == nil {
+		t.size++
+	}
+	return old
+}
+
+// insert puts the key into the subtree of the node, which must be owned by the tree.
+// It returns the old position of the key, and the new right sibling and its first key
+// if the node is split.
+func (t *DiskBPTree) insert(node *bptreeNode, key []byte,
+	position *wal.ChunkPosition) (*wal.ChunkPosition, *bptreeNode, []byte) {
+	var old *wal.ChunkPosition
+	if node.leaf {
+		i, found := node.search(key)
+		if found {
+			old, node.positions[i] = node.positions[i], position
+			return old, nil, nil
+		}
+		node.keys = append(node.keys, nil)
+		copy(node.keys[i+1:], node.keys[i:])
+		node.keys[i] = key
+		node.positions = append(node.positions, nil)
+		copy(node.positions[i+1:], node.positions[i:])
+		node.positions[i] = position
+	} else {
+		i := node.childIndex(key)
+		child := t.mutable(node.children[i])
+		var right *bptreeNode
+		var separator []byte
+		old, right, separator = t.insert(child, key, position)
+		node.children[i] = bptreeRef{node: child}
+		if right == nil {
+			return old, nil, nil
+		}
+		node.keys = append(node.keys, nil)
+		copy(node.keys[i+1:], node.keys[i:])
+		node.keys[i] = separator
+		node.children = append(node.children, bptreeRef{})
+		copy(node.children[i+2:], node.children[i+1:])
+		node.children[i+1] = bptreeRef{node: right}
+	}
+
+	if len(node.keys) <= bptreeMaxKeys {
+		return old, nil, nil
+	}
+	right, separator := t.split(node)
+	return old, right, separator
+}
+
+// split moves the upper half of the node to a new right sibling.
+func (t *DiskBPTree) split(node *bptreeNode) (*bptreeNode, []byte) {
+	mid := len(node.keys) / 2
+	right := t.newNode(node.leaf)
+	var separator []byte
+	if node.leaf {
+		right.keys = append([][]byte(nil), node.keys[mid:]...)
+		right.positions = append([]*wal.ChunkPosition(nil), node.positions[mid:]...)
+		node.keys, node.positions = node.keys[:mid], node.positions[:mid]
+		separator = right.keys[0]
+	} else {
+		separator = node.keys[mid]
+		right.keys = append([][]byte(nil), node.keys[mid+1:]...)
+		right.children = append([]bptreeRef(nil), node.children[mid+1:]...)
+		node.keys, node.children = node.keys[:mid], node.children[:mid+1]
+	}
+	t.dirtySize += right.memSize()
+	return right, separator
+}
+
+func (t *DiskBPTree) Get(key []byte) *wal.ChunkPosition {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	return t.get(key)
+}
+
+func (t *DiskBPTree) get(key []byte) *wal.ChunkPosition {
+	node := t.node(t.root)
+	for !node.leaf {
+		node = t.node(node.children[node.childIndex(key)])
+	}
+	if i, found := node.search(key); found {
+		return node.positions[i]
+	}
+	return nil
+}
+
+// Delete removes the key from its leaf. The nodes are not rebalanced,
+// only the ones left empty are removed from the tree.
+func (t *DiskBPTree) Delete(key []byte) (*wal.ChunkPosition, bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	// do not copy the path to the leaf if the key does not exist
+	if t.get(key) == nil {
+		return nil, false
+	}
+	root := t.mutable(t.root)
+	old := t.remove(root, key)
+	t.root = bptreeRef{node: root}
+	if !root.leaf {
+		switch len(root.children) {
+		case 0:
+			t.root = bptreeRef{node: t.newNode(true)}
+		case 1:
+			t.root = root.children[0]
+		}
+	}
+	t.size--
+	return old, true
+}
+
+// remove deletes the key from the subtree of the node, which must be owned by the tree.
+func (t *DiskBPTree) remove(node *bptreeNode, key []byte) *wal.ChunkPosition {
+	if node.leaf {
+		i, found := node.search(key)
+		if !found {
+			return nil
+		}
+		old := node.positions[i]
+		node.keys = append(node.keys[:i], node.keys[i+1:]...)
+		node.positions = append(node.positions[:i], node.positions[i+1:]...)
+		return old
+	}
+
+	i := node.childIndex(key)
+	child := t.mutable(node.children[i])
+	old := t.remove(child, key)
+	if len(child.keys) > 0 || (!child.leaf && len(child.children) > 0) {
+		node.children[i] = bptreeRef{node: child}
+		return old
+	}
+	// the child is empty, remove it and one of the keys around it.
+	node.children = append(node.children[:i], node.children[i+1:]...)
+	if len(node.keys) > 0 {
+		k := i - 1
+		if k < 0 {
+			k = 0
+		}
+		node.keys = append(node.keys[:k], node.keys[k+1:]...)
+	}
+	return old
+}
+
+func (t *DiskBPTree) Size() int {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	return t.size
+}
+
+func (t *DiskBPTree) Ascend(handleFn func(key []byte, position *wal.ChunkPosition) (bool, error)) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	c := &bptreeCursor{tree: t}
+	for c.first(); c.valid(); c.next() {
+		if cont, err := handleFn(c.key(), c.value()); err != nil || !cont {
+			return
+		}
+	}
+}
+
+func (t *DiskBPTree) AscendRange(
+	startKey, endKey []byte,
+	handleFn func(key []byte, position *wal.ChunkPosition) (bool, error),
+) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	c := &bptreeCursor{tree: t}
+	for c.seekGE(startKey); c.valid() && bytes.Compare(c.key(), endKey) < 0; c.next() {
+		if cont, err := handleFn(c.key(), c.value()); err != nil || !cont {
+			return
+		}
+	}
+}
+
+func (t *DiskBPTree) AscendGreaterOrEqual(
+	key []byte,
+	handleFn func(key []byte, position *wal.ChunkPosition) (bool, error),
+) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	c := &bptreeCursor{tree: t}
+	for c.seekGE(key); c.valid(); c.next() {
+		if cont, err := handleFn(c.key(), c.value()); err != nil || !cont {
+			return
+		}
+	}
+}
+
+func (t *DiskBPTree) Descend(handleFn func(key []byte, pos *wal.ChunkPosition) (bool, error)) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	c := &bptreeCursor{tree: t}
+	for c.last(); c.valid(); c.prev() {
+		if cont, err := handleFn(c.key(), c.value()); err != nil || !cont {
+			return
+		}
+	}
+}
+
+func (t *DiskBPTree) DescendRange(
+	startKey, endKey []byte,
+	handleFn func(key []byte, position *wal.ChunkPosition) (bool, error),
+) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	c := &bptreeCursor{tree: t}
+	for c.seekLE(startKey); c.valid() && bytes.Compare(c.key(), endKey) > 0; c.prev() {
+		if cont, err := handleFn(c.key(), c.value()); err != nil || !cont {
+			return
+		}
+	}
+}
+
+func (t *DiskBPTree) DescendLessOrEqual(
+	key []byte,
+	handleFn func(key []byte, position *wal.ChunkPosition) (bool, error),
+) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	c := &bptreeCursor{tree: t}
+	for c.seekLE(key); c.valid(); c.prev() {
+		if cont, err := handleFn(c.key(), c.value()); err != nil || !cont {
+			return
+		}
+	}
+}
+
+// Clone shares the root with the clone, and both trees copy the nodes before changing them.
+// The clone is held in memory only, it can not be flushed.
+func (t *DiskBPTree) Clone() Indexer {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.clone()
+}
+
+func (t *DiskBPTree) clone() *DiskBPTree {
+	// the nodes owned by the tree so far are shared now, so neither tree can change them in place.
+	t.owner = new(bptreeOwner)
+	return &DiskBPTree{
+		lock:      new(sync.RWMutex),
+		store:     t.store,
+		file:      t.file,
+		root:      t.root,
+		size:      t.size,
+		owner:     new(bptreeOwner),
+		compactAt: t.compactAt,
+	}
+}
+
+func (t *DiskBPTree) Iterator(reverse bool) IndexIterator {
+	// the iterator walks its own clone, see Clone.
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	return &diskBPTreeIterator{cursor: &bptreeCursor{tree: t.clone()}, reverse: reverse}
+}
+
+func (t *DiskBPTree) Checkpoint() []byte {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	if t.meta == nil {
+		return nil
+	}
+	return t.meta.Checkpoint
+}
+
+func (t *DiskBPTree) DirtySize() int64 {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	return t.dirtySize
+}
+
+// Flush writes the nodes changed since the last Flush to the end of the index file,
+// and replaces the INDEXMETA file to point to the new root.
+// The index file is compacted when more than half of it is no longer referenced.
+func (t *DiskBPTree) Flush(checkpoint []byte) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if !t.persistent {
+		return ErrIndexNotFlushable
+	}
+	writer := &bptreeWriter{tree: t, file: t.file, offset: t.file.size}
+	root, err := writer.writeRef(t.root)
+	if err == nil {
+		err = writer.flush()
+	}
+	if err == nil {
+		err = t.file.fd.Sync()
+	}
+	if err != nil {
+		return err
+	}
+	meta := &bptreeMeta{
+		FileId:     t.file.id,
+		Root:       root,
+		Size:       t.size,
+		Length:     writer.offset,
+		Garbage:    t.meta.Garbage + t.garbage,
+		Checkpoint: append([]byte(nil), checkpoint...),
+	}
+	if err = t.store.writeMeta(meta); err != nil {
+		return err
+	}
+	t.file.size = writer.offset
+	t.root, t.meta = bptreeRef{offset: root}, meta
+	t.dirtySize, t.garbage = 0, 0
+
+	if meta.Length > t.compactAt && meta.Garbage*2 > meta.Length {
+		return t.compact()
+	}
+	return nil
+}
+
+// compact writes the nodes referenced by the tree to a new index file.
+// The caller must hold the lock, and the tree must have been flushed.
+func (t *DiskBPTree) compact() error {
+	file, err := t.store.createFile(t.file.id + 1)
+	if err != nil {
+		return err
+	}
+	writer := &bptreeWriter{tree: t, file: file, copyAll: true}
+	root, err := writer.writeRef(t.root)
+	if err == nil {
+		err = writer.flush()
+	}
+	if err == nil {
+		err = file.fd.Sync()
+	}
+	if err != nil {
+		return err
+	}
+	meta := *t.meta
+	meta.FileId, meta.Root, meta.Length, meta.Garbage = file.id, root, writer.offset, 0
+	if err = t.store.writeMeta(&meta); err != nil {
+		return err
+	}
+	file.size = writer.offset
+	t.file, t.root, t.meta = file, bptreeRef{offset: root}, &meta
+	return t.store.removeFiles(file.id)
+}
+
+// Reset discards all keys in the index, the index file is replaced by an empty one.
+// The INDEXMETA file is updated by the next Flush.
+func (t *DiskBPTree) Reset() error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if !t.persistent {
+		t.root, t.size = bptreeRef{node: t.newNode(true)}, 0
+		return nil
+	}
+	file, err := t.store.createFile(t.file.id + 1)
+	if err != nil {
+		return err
+	}
+	t.file, t.root, t.size = file, bptreeRef{node: t.newNode(true)}, 0
+	t.meta = &bptreeMeta{FileId: file.id}
+	t.dirtySize, t.garbage = 0, 0
+	return t.store.removeFiles(file.id)
+}
+
+func (t *DiskBPTree) Close() error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if !t.persistent {
+		return nil
+	}
+	return t.store.close()
+}
+
+// bptreeWriter appends the nodes to an index file.
+type bptreeWriter struct {
+	tree    *DiskBPTree
+	file    *bptreeFile
+	offset  int64 // offset of the next node
+	start   int64 // offset of the buffered nodes
+	buf     []byte
+	copyAll bool // write the nodes already in the index file as well, used by compaction
+}
+
+// writeRef writes the node and its descendants not written yet, and returns its offset.
+func (w *bptreeWriter) writeRef(ref bptreeRef) (int64, error) {
+	if ref.node == nil && !w.copyAll {
+		return ref.offset, nil
+	}
+	node, err := w.tree.readNode(ref)
+	if err != nil {
+		return 0, err
+	}
+	var children []int64
+	for _, child := range node.children {
+		offset, err := w.writeRef(child)
+		if err != nil {
+			return 0, err
+		}
+		children = append(children, offset)
+	}
+
+	payload := encodeBPTreeNode(node, children)
+	if len(w.buf) == 0 {
+		w.start = w.offset
+	}
+	offset := w.offset
+	w.buf = binary.LittleEndian.AppendUint32(w.buf, crc32.ChecksumIEEE(payload))
+	w.buf = binary.LittleEndian.AppendUint32(w.buf, uint32(len(payload)))
+	w.buf = append(w.buf, payload...)
+	w.offset += int64(bptreeRecordHeaderSize + len(payload))
+	if len(w.buf) >= bptreeWriteBufferSize {
+		if err = w.flush(); err != nil {
+			return 0, err
+		}
+	}
+
+	// the written node is cached, so it is not read back right after the flush.
+	written := &bptreeNode{
+		leaf:      node.leaf,
+		keys:      node.keys,
+		positions: node.positions,
+		diskSize:  bptreeRecordHeaderSize + len(payload),
+	}
+	for _, child := range children {
+		written.children = append(written.children, bptreeRef{offset: child})
+	}
+	w.tree.store.cache.put(bptreeCacheKey{file: w.file, offset: offset}, written)
+	return offset, nil
+}
+
+func (w *bptreeWriter) flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	if _, err := w.file.fd.WriteAt(w.buf, w.start); err != nil {
+		return err
+	}
+	w.buf = w.buf[:0]
+	return nil
+}
+
+// +-------+---------+------------------------------------------+---------------------------+
+// | leaf  |  count  |  key size + key (+ position if leaf) ... | child offsets if internal |
+// +-------+---------+------------------------------------------+---------------------------+
+//
+//	1 byte  uvarint               uvarint ...                          uvarint ...
+func encodeBPTreeNode(node *bptreeNode, children []int64) []byte {
+	buf := make([]byte, 0, node.memSize())
+	if node.leaf {
+		buf = append(buf, 1)
+	} else {
+		buf = append(buf, 0)
+	}
+	buf = binary.AppendUvarint(buf, uint64(len(node.keys)))
+	for i, key := range node.keys {
+		buf = binary.AppendUvarint(buf, uint64(len(key)))
+		buf = append(buf, key...)
+		if node.leaf {
+			pos := node.positions[i]
+			buf = binary.AppendUvarint(buf, uint64(pos.SegmentId))
+			buf = binary.AppendUvarint(buf, uint64(pos.BlockNumber))
+			buf = binary.AppendUvarint(buf, uint64(pos.ChunkOffset))
+			buf = binary.AppendUvarint(buf, uint64(pos.ChunkSize))
+		}
+	}
+	for _, child := range children {
+		buf = binary.AppendUvarint(buf, uint64(child))
+	}
+	return buf
+}
+
+func decodeBPTreeNode(buf []byte) (*bptreeNode, error) {
+	if len(buf) == 0 {
+		return nil, ErrIndexCorrupted
+	}
+	node := &bptreeNode{leaf: buf[0] == 1}
+	index := 1
+	next := func() (uint64, error) {
+		value, n := binary.Uvarint(buf[index:])
+		if n <= 0 {
+			return 0, ErrIndexCorrupted
+		}
+		index += n
+		return value, nil
+	}
+
+	count, err := next()
+	if err != nil || count > uint64(len(buf)) {
+		return nil, ErrIndexCorrupted
+	}
+	node.keys = make([][]byte, 0, count)
+	for i := uint64(0); i < count; i++ {
+		size, err := next()
+		if err != nil || size > uint64(len(buf)-index) {
+			return nil, ErrIndexCorrupted
+		}
+		node.keys = append(node.keys, buf[index:index+int(size)])
+		index += int(size)
+		if node.leaf {
+			var fields [4]uint64
+			for j := range fields {
+				if fields[j], err = next(); err != nil {
+					return nil, err
+				}
+			}
+			node.positions = append(node.positions, &wal.ChunkPosition{
+				SegmentId:   wal.SegmentID(fields[0]),
+				BlockNumber: uint32(fields[1]),
+				ChunkOffset: int64(fields[2]),
+				ChunkSize:   uint32(fields[3]),
+			})
+		}
+	}
+	if !node.leaf {
+		node.children = make([]bptreeRef, 0, count+1)
+		for i := uint64(0); i <= count; i++ {
+			offset, err := next()
+			if err != nil {
+				return nil, err
+			}
+			node.children = append(node.children, bptreeRef{offset: int64(offset)})
+		}
+	}
+	return node, nil
+}
+
+type bptreeCacheKey struct {
+	file   *bptreeFile
+	offset int64
+}
+
+type bptreeCacheEntry struct {
+	key  bptreeCacheKey
+	node *bptreeNode
+	size int64
+}
+
+// bptreeCache is a LRU cache of the nodes read from the index files, bounded by their memory size.
+type bptreeCache struct {
+	mu       sync.Mutex
+	capacity int64
+	size     int64
+	list     *list.List
+	entries  map[bptreeCacheKey]*list.Element
+}
+
+func newBPTreeCache(capacity int64) *bptreeCache {
+	return &bptreeCache{
+		capacity: capacity,
+		list:     list.New(),
+		entries:  make(map[bptreeCacheKey]*list.Element),
+	}
+}
+
+func (c *bptreeCache) get(key bptreeCacheKey) *bptreeNode {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		c.list.MoveToFront(elem)
+		return elem.Value.(*bptreeCacheEntry).node
+	}
+	return nil
+}
+
+func (c *bptreeCache) put(key bptreeCacheKey, node *bptreeNode) {
+	size := node.memSize()
+	if size > c.capacity {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		c.list.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.list.PushFront(&bptreeCacheEntry{key: key, node: node, size: size})
+	c.size += size
+	for c.size > c.capacity {
+		elem := c.list.Back()
+		entry := elem.Value.(*bptreeCacheEntry)
+		c.list.Remove(elem)
+		delete(c.entries, entry.key)
+		c.size -= entry.size
+	}
+}
+
+type bptreeFrame struct {
+	node  *bptreeNode
+	index int
+}
+
+// bptreeCursor walks the tree with the path from the root to the current leaf.
+type bptreeCursor struct {
+	tree  *DiskBPTree
+	stack []bptreeFrame
+}
+
+// descend pushes the path from the node to its first or last leaf.
+func (c *bptreeCursor) descend(node *bptreeNode, last bool) {
+	for {
+		index := 0
+		if last {
+			if node.leaf {
+				index = len(node.keys) - 1
+			} else {
+				index = len(node.children) - 1
+			}
+		}
+		c.stack = append(c.stack, bptreeFrame{node: node, index: index})
+		if node.leaf {
+			return
+		}
+		node = c.tree.node(node.children[index])
+	}
+}
+
+func (c *bptreeCursor) first() {
+	c.stack = c.stack[:0]
+	c.descend(c.tree.node(c.tree.root), false)
+	c.settle(false)
+}
+
+func (c *bptreeCursor) last() {
+	c.stack = c.stack[:0]
+	c.descend(c.tree.node(c.tree.root), true)
+	c.settle(true)
+}
+
+// seekGE moves the cursor to the first key >= key.
+func (c *bptreeCursor) seekGE(key []byte) {
+	c.seek(key)
+	leaf := &c.stack[len(c.stack)-1]
+	leaf.index, _ = leaf.node.search(key)
+	c.settle(false)
+}
+
+// seekLE moves the cursor to the last key <= key.
+func (c *bptreeCursor) seekLE(key []byte) {
+	c.seek(key)
+	leaf := &c.stack[len(c.stack)-1]
+	i, found := leaf.node.search(key)
+	if !found {
+		i--
+	}
+	leaf.index = i
+	c.settle(true)
+}
+
+// seek pushes the path to the leaf which may contain key.
+func (c *bptreeCursor) seek(key []byte) {
+	c.stack = c.stack[:0]
+	node := c.tree.node(c.tree.root)
+	for !node.leaf {
+		index := node.childIndex(key)
+		c.stack = append(c.stack, bptreeFrame{node: node, index: index})
+		node = c.tree.node(node.children[index])
+	}
+	c.stack = append(c.stack, bptreeFrame{node: node})
+}
+
+// settle moves the cursor out of the end of a leaf to the neighbour leaf,
+// or clears the stack if there is none.
+func (c *bptreeCursor) settle(backward bool) {
+	for len(c.stack) > 0 {
+		leaf := c.stack[len(c.stack)-1]
+		if leaf.index >= 0 && leaf.index < len(leaf.node.keys) {
+			return
+		}
+		// go up to the first ancestor which has a neighbour child
+		c.stack = c.stack[:len(c.stack)-1]
+		for len(c.stack) > 0 {
+			parent := &c.stack[len(c.stack)-1]
+			if backward {
+				parent.index--
+			} else {
+				parent.index++
+			}
+			if parent.index >= 0 && parent.index < len(parent.node.children) {
+				c.descend(c.tree.node(parent.node.children[parent.index]), backward)
+				break
+			}
+			c.stack = c.stack[:len(c.stack)-1]
+		}
+	}
+}
+
+func (c *bptreeCursor) next() {
+	if !c.valid() {
+		return
+	}
+	c.stack[len(c.stack)-1].index++
+	c.settle(false)
+}
+
+func (c *bptreeCursor) prev() {
+	if !c.valid() {
+		return
+	}
+	c.stack[len(c.stack)-1].index--
+	c.settle(true)
+}
+
+func (c *bptreeCursor) valid() bool {
+	return len(c.stack) > 0
+}
+
+func (c *bptreeCursor) key() []byte {
+	leaf := c.stack[len(c.stack)-1]
+	return leaf.node.keys[leaf.index]
+}
+
+func (c *bptreeCursor) value() *wal.ChunkPosition {
+	leaf := c.stack[len(c.stack)-1]
+	return leaf.node.positions[leaf.index]
+}
+
+// diskBPTreeIterator walks a clone of the tree, so it never holds the index lock
+// between calls and is not affected by later writes.
+type diskBPTreeIterator struct {
+	cursor  *bptreeCursor
+	reverse bool
+}
+
+func (it *diskBPTreeIterator) Rewind() {
+	if it.cursor == nil {
+		return
+	}
+	if it.reverse {
+		it.cursor.last()
+	} else {
+		it.cursor.first()
+	}
+}
+
+func (it *diskBPTreeIterator) Seek(key []byte) {
+	if it.cursor == nil {
+		return
+	}
+	if it.reverse {
+		it.cursor.seekLE(key)
+	} else {
+		it.cursor.seekGE(key)
+	}
+}
+
+func (it *diskBPTreeIterator) Next() {
+	if it.cursor == nil {
+		return
+	}
+	if it.reverse {
+		it.cursor.prev()
+	} else {
+		it.cursor.next()
+	}
+}
+
+func (it *diskBPTreeIterator) Prev() {
+	if it.cursor == nil {
+		return
+	}
+	if it.reverse {
+		it.cursor.next()
+	} else {
+		it.cursor.prev()
+	}
+}
+
+func (it *diskBPTreeIterator) Valid() bool {
+	return it.cursor != nil && it.cursor.valid()
+}
+
+func (it *diskBPTreeIterator) Key() []byte {
+	if !it.Valid() {
+		return nil
+	}
+	return it.cursor.key()
+}
+
+func (it *diskBPTreeIterator) Value() *wal.ChunkPosition {
+	if !it.Valid() {
+		return nil
+	}
+	return it.cursor.value()
+}
+
+func (it *diskBPTreeIterator) Close() {
+	it.cursor = nil
+}
@@ -0,0 +1,344 @@
+// Copyright 2024 Joy <joyssss94@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"testing"
+
+	"github.com/JoyZF/zoom/pkg/wal"
+)
+
+func openTestBPTree(t *testing.T, dir string) *DiskBPTree {
+	tree, err := OpenBPTree(BPTreeOptions{DirPath: dir, CacheSize: 64 * 1024})
+	if err != nil {
+		t.Fatalf("open bptree: %v", err)
+	}
+	return tree
+}
+
+func bptreeTestDir(t *testing.T) string {
+	dir, err := os.MkdirTemp("", "rosedb-bptree")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = os.RemoveAll(dir)
+	})
+	return dir
+}
+
+func bptreeTestKey(i int) []byte {
+	return []byte(fmt.Sprintf("key-%09d", i))
+}
+
+func bptreeTestPosition(i int) *wal.ChunkPosition {
+	return &wal.ChunkPosition{SegmentId: wal.SegmentID(i%7 + 1), BlockNumber: uint32(i), ChunkOffset: int64(i * 3), ChunkSize: 10}
+}
+
+// checkBPTree compares the tree with the expected content, in both directions.
+func checkBPTree(t *testing.T, tree Indexer, expected map[string]*wal.ChunkPosition) {
+	if tree.Size() != len(expected) {
+		t.Fatalf("expected size %d, got %d", len(expected), tree.Size())
+	}
+	keys := make([]string, 0, len(expected))
+	for key, pos := range expected {
+		keys = append(keys, key)
+		if got := tree.Get([]byte(key)); got == nil || *got != *pos {
+			t.Fatalf("key %s: expected %+v, got %+v", key, pos, got)
+		}
+	}
+	sort.Strings(keys)
+
+	var ascended []string
+	tree.Ascend(func(key []byte, pos *wal.ChunkPosition) (bool, error) {
+		ascended = append(ascended, string(key))
+		return true, nil
+	})
+	if fmt.Sprint(ascended) != fmt.Sprint(keys) {
+		t.Fatalf("unexpected ascending keys")
+	}
+	var descended []string
+	tree.Descend(func(key []byte, pos *wal.ChunkPosition) (bool, error) {
+		descended = append(descended, string(key))
+		return true, nil
+	})
+	for i := range descended {
+		if descended[i] != keys[len(keys)-1-i] {
+			t.Fatalf("unexpected descending keys")
+		}
+	}
+}
+
+func TestDiskBPTree_Put_Get_Delete(t *testing.T) {
+	tree := openTestBPTree(t, bptreeTestDir(t))
+	defer tree.Close()
+
+	expected := make(map[string]*wal.ChunkPosition)
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 20000; i++ {
+		n := r.Intn(5000)
+		key := bptreeTestKey(n)
+		if r.Intn(4) == 0 {
+			old, ok := tree.Delete(key)
+			pos, exist := expected[string(key)]
+			if ok != exist || (ok && *old != *pos) {
+				t.Fatalf("delete %s: expected %+v, got %+v", key, pos, old)
+			}
+			delete(expected, string(key))
+			continue
+		}
+		pos := bptreeTestPosition(i)
+		old := tree.Put(key, pos)
+		if prev, ok := expected[string(key)]; ok != (old != nil) || (ok && *old != *prev) {
+			t.Fatalf("put %s: expected %+v, got %+v", key, prev, old)
+		}
+		expected[string(key)] = pos
+	}
+	checkBPTree(t, tree, expected)
+
+	// delete everything
+	for key := range expected {
+		if _, ok := tree.Delete([]byte(key)); !ok {
+			t.Fatalf("expected %s to be deleted", key)
+		}
+	}
+	checkBPTree(t, tree, map[string]*wal.ChunkPosition{})
+}
+
+func TestDiskBPTree_Flush_Reopen(t *testing.T) {
+	dir := bptreeTestDir(t)
+	tree := openTestBPTree(t, dir)
+	if tree.Checkpoint() != nil {
+		t.Fatal("expected nil checkpoint")
+	}
+
+	expected := make(map[string]*wal.ChunkPosition)
+	for i := 0; i < 10000; i++ {
+		pos := bptreeTestPosition(i)
+		tree.Put(bptreeTestKey(i), pos)
+		expected[string(bptreeTestKey(i))] = pos
+	}
+	if tree.DirtySize() == 0 {
+		t.Fatal("expected dirty nodes")
+	}
+	if err := tree.Flush([]byte("checkpoint-1")); err != nil {
+		t.Fatal(err)
+	}
+	if tree.DirtySize() != 0 {
+		t.Fatal("expected no dirty nodes")
+	}
+
+	// the changes after the last flush are lost
+	for i := 0; i < 100; i++ {
+		tree.Delete(bptreeTestKey(i))
+	}
+	tree.Put([]byte("not-flushed"), bptreeTestPosition(1))
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tree = openTestBPTree(t, dir)
+	if string(tree.Checkpoint()) != "checkpoint-1" {
+		t.Fatalf("unexpected checkpoint %s", tree.Checkpoint())
+	}
+	checkBPTree(t, tree, expected)
+
+	for i := 0; i < 5000; i++ {
+		tree.Delete(bptreeTestKey(i))
+		delete(expected, string(bptreeTestKey(i)))
+	}
+	if err := tree.Flush([]byte("checkpoint-2")); err != nil {
+		t.Fatal(err)
+	}
+	_ = tree.Close()
+	tree = openTestBPTree(t, dir)
+	defer tree.Close()
+	if string(tree.Checkpoint()) != "checkpoint-2" {
+		t.Fatalf("unexpected checkpoint %s", tree.Checkpoint())
+	}
+	checkBPTree(t, tree, expected)
+}
+
+func TestDiskBPTree_Corrupted(t *testing.T) {
+	dir := bptreeTestDir(t)
+	tree := openTestBPTree(t, dir)
+	for i := 0; i < 1000; i++ {
+		tree.Put(bptreeTestKey(i), bptreeTestPosition(i))
+	}
+	if err := tree.Flush([]byte("checkpoint")); err != nil {
+		t.Fatal(err)
+	}
+	_ = tree.Close()
+
+	// a corrupted index is opened empty, so it is rebuilt
+	name := bptreeFileName(dir, 1)
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-1] ^= 0xff
+	if err = os.WriteFile(name, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	tree = openTestBPTree(t, dir)
+	defer tree.Close()
+	if tree.Checkpoint() != nil || tree.Size() != 0 {
+		t.Fatalf("expected an empty index, got %d keys", tree.Size())
+	}
+}
+
+func TestDiskBPTree_Clone_Iterator(t *testing.T) {
+	tree := openTestBPTree(t, bptreeTestDir(t))
+	defer tree.Close()
+
+	expected := make(map[string]*wal.ChunkPosition)
+	for i := 0; i < 3000; i++ {
+		pos := bptreeTestPosition(i)
+		tree.Put(bptreeTestKey(i), pos)
+		expected[string(bptreeTestKey(i))] = pos
+	}
+	_ = tree.Flush(nil)
+	tree.Put(bptreeTestKey(3000), bptreeTestPosition(3000))
+	expected[string(bptreeTestKey(3000))] = bptreeTestPosition(3000)
+
+	clone := tree.Clone()
+	iter := tree.Iterator(false)
+	defer iter.Close()
+
+	// changes after the clone are not visible to it, and the other way round
+	for i := 0; i < 1000; i++ {
+		tree.Delete(bptreeTestKey(i))
+	}
+	tree.Put(bptreeTestKey(5000), bptreeTestPosition(5000))
+	clone.Put([]byte("clone-only"), bptreeTestPosition(1))
+	if err := tree.Flush(nil); err != nil {
+		t.Fatal(err)
+	}
+	if tree.Get([]byte("clone-only")) != nil {
+		t.Fatal("the clone changed the tree")
+	}
+	clone.Delete([]byte("clone-only"))
+	checkBPTree(t, clone, expected)
+
+	var count int
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		if *iter.Value() != *expected[string(iter.Key())] {
+			t.Fatalf("unexpected position of %s", iter.Key())
+		}
+		count++
+	}
+	if count != len(expected) {
+		t.Fatalf("expected %d keys, got %d", len(expected), count)
+	}
+
+	iter.Seek([]byte("key-000001500x"))
+	if !iter.Valid() || !bytes.Equal(iter.Key(), bptreeTestKey(1501)) {
+		t.Fatalf("expected %s, got %s", bptreeTestKey(1501), iter.Key())
+	}
+	iter.Prev()
+	if !iter.Valid() || !bytes.Equal(iter.Key(), bptreeTestKey(1500)) {
+		t.Fatalf("expected %s, got %s", bptreeTestKey(1500), iter.Key())
+	}
+
+	rIter := tree.Iterator(true)
+	defer rIter.Close()
+	rIter.Seek([]byte("key-000001500x"))
+	if !rIter.Valid() || !bytes.Equal(rIter.Key(), bptreeTestKey(1500)) {
+		t.Fatalf("expected %s, got %s", bptreeTestKey(1500), rIter.Key())
+	}
+	rIter.Seek(bptreeTestKey(999))
+	if rIter.Valid() {
+		t.Fatalf("expected no key, got %s", rIter.Key())
+	}
+}
+
+func TestDiskBPTree_Ranges(t *testing.T) {
+	tree := openTestBPTree(t, bptreeTestDir(t))
+	defer tree.Close()
+	for i := 0; i < 1000; i += 2 {
+		tree.Put(bptreeTestKey(i), bptreeTestPosition(i))
+	}
+
+	collect := func(iterate func(handleFn func(key []byte, pos *wal.ChunkPosition) (bool, error))) []string {
+		var keys []string
+		iterate(func(key []byte, pos *wal.ChunkPosition) (bool, error) {
+			keys = append(keys, string(key[len(key)-3:]))
+			return len(keys) < 3, nil
+		})
+		return keys
+	}
+	cases := []struct {
+		keys     []string
+		expected string
+	}{
+		{collect(func(fn func([]byte, *wal.ChunkPosition) (bool, error)) {
+			tree.AscendRange(bptreeTestKey(101), bptreeTestKey(105), fn)
+		}), "[102 104]"},
+		{collect(func(fn func([]byte, *wal.ChunkPosition) (bool, error)) {
+			tree.DescendRange(bptreeTestKey(105), bptreeTestKey(100), fn)
+		}), "[104 102]"},
+		{collect(func(fn func([]byte, *wal.ChunkPosition) (bool, error)) {
+			tree.AscendGreaterOrEqual(bptreeTestKey(301), fn)
+		}), "[302 304 306]"},
+		{collect(func(fn func([]byte, *wal.ChunkPosition) (bool, error)) {
+			tree.DescendLessOrEqual(bptreeTestKey(300), fn)
+		}), "[300 298 296]"},
+		{collect(func(fn func([]byte, *wal.ChunkPosition) (bool, error)) {
+			tree.AscendGreaterOrEqual(bptreeTestKey(999), fn)
+		}), "[]"},
+	}
+	for _, c := range cases {
+		if fmt.Sprint(c.keys) != c.expected {
+			t.Fatalf("expected %s, got %v", c.expected, c.keys)
+		}
+	}
+}
+
+func TestDiskBPTree_Compact_Reset(t *testing.T) {
+	dir := bptreeTestDir(t)
+	tree := openTestBPTree(t, dir)
+	tree.compactAt = 64 * 1024
+
+	expected := make(map[string]*wal.ChunkPosition)
+	for round := 0; round < 20; round++ {
+		for i := 0; i < 2000; i++ {
+			pos := bptreeTestPosition(round*2000 + i)
+			tree.Put(bptreeTestKey(i), pos)
+			expected[string(bptreeTestKey(i))] = pos
+		}
+		if err := tree.Flush(nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the index file has been compacted, and the old ones are removed
+	if tree.file.id == 1 {
+		t.Fatal("expected the index file to be compacted")
+	}
+	if _, err := os.Stat(bptreeFileName(dir, 1)); !os.IsNotExist(err) {
+		t.Fatal("expected the old index file to be removed")
+	}
+	checkBPTree(t, tree, expected)
+
+	// the clone keeps reading the old file after the reset
+	clone := tree.Clone()
+	if err := tree.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	checkBPTree(t, tree, map[string]*wal.ChunkPosition{})
+	checkBPTree(t, clone, expected)
+	if err := tree.Flush([]byte("reset")); err != nil {
+		t.Fatal(err)
+	}
+	_ = tree.Close()
+
+	tree = openTestBPTree(t, dir)
+	defer tree.Close()
+	checkBPTree(t, tree, map[string]*wal.ChunkPosition{})
+}
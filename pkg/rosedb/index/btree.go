@@ -8,8 +8,9 @@ import (
 	"bytes"
 	"sync"
 
-	"github.com/JoyZF/wal"
 	"github.com/google/btree"
+
+	"github.com/JoyZF/zoom/pkg/wal"
 )
 
 // MemoryBTree is a memory based btree implementation of the Index interface
@@ -77,7 +78,7 @@ func (mt *MemoryBTree) AscendRange(
 	mt.lock.RLock()
 	defer mt.lock.RUnlock()
 
-	mt.tree.Descend(func(i btree.Item) bool {
+	mt.tree.AscendRange(&item{key: startKey}, &item{key: endKey}, func(i btree.Item) bool {
 		cont, err := handleFn(i.(*item).key, i.(*item).pos)
 		if err != nil {
 			return false
@@ -147,6 +148,123 @@ func (mt *MemoryBTree) DescendLessOrEqual(
 	})
 }
 
+func (mt *MemoryBTree) Clone() Indexer {
+	// Clone is lazy copy-on-write, but it is not safe to run concurrently with writers.
+	mt.lock.Lock()
+	defer mt.lock.Unlock()
+
+	return &MemoryBTree{
+		tree: mt.tree.Clone(),
+		lock: new(sync.RWMutex),
+	}
+}
+
+func (mt *MemoryBTree) Iterator(reverse bool) IndexIterator {
+	// the iterator walks its own clone, see Clone.
+	mt.lock.Lock()
+	defer mt.lock.Unlock()
+
+	return &memoryBTreeIterator{
+		tree:    mt.tree.Clone(),
+		reverse: reverse,
+	}
+}
+
+// memoryBTreeIterator walks a clone of the btree, so it never holds the index lock
+// between calls and is not affected by later writes.
+type memoryBTreeIterator struct {
+	tree    *btree.BTree
+	reverse bool
+	current *item
+	valid   bool
+}
+
+func (it *memoryBTreeIterator) Rewind() {
+	it.valid = false
+	if it.tree == nil || it.tree.Len() == 0 {
+		return
+	}
+	if it.reverse {
+		it.current = it.tree.Max().(*item)
+	} else {
+		it.current = it.tree.Min().(*item)
+	}
+	it.valid = true
+}
+
+func (it *memoryBTreeIterator) Seek(key []byte) {
+	it.valid = false
+	if it.tree == nil {
+		return
+	}
+	found := func(i btree.Item) bool {
+		it.current, it.valid = i.(*item), true
+		return false
+	}
+	if it.reverse {
+		it.tree.DescendLessOrEqual(&item{key: key}, found)
+	} else {
+		it.tree.AscendGreaterOrEqual(&item{key: key}, found)
+	}
+}
+
+func (it *memoryBTreeIterator) Next() {
+	it.step(it.reverse)
+}
+
+func (it *memoryBTreeIterator) Prev() {
+	it.step(!it.reverse)
+}
+
+// step moves the cursor to the neighbour of the current item,
+// towards smaller keys if descend is true.
+func (it *memoryBTreeIterator) step(descend bool) {
+	if !it.valid || it.tree == nil {
+		return
+	}
+	it.valid = false
+	cur := it.current
+	if descend {
+		it.tree.DescendLessOrEqual(cur, func(i btree.Item) bool {
+			if !i.Less(cur) {
+				return true
+			}
+			it.current, it.valid = i.(*item), true
+			return false
+		})
+	} else {
+		it.tree.AscendGreaterOrEqual(cur, func(i btree.Item) bool {
+			if !cur.Less(i) {
+				return true
+			}
+			it.current, it.valid = i.(*item), true
+			return false
+		})
+	}
+}
+
+func (it *memoryBTreeIterator) Valid() bool {
+	return it.valid
+}
+
+func (it *memoryBTreeIterator) Key() []byte {
+	if !it.valid {
+		return nil
+	}
+	return it.current.key
+}
+
+func (it *memoryBTreeIterator) Value() *wal.ChunkPosition {
+	if !it.valid {
+		return nil
+	}
+	return it.current.pos
+}
+
+func (it *memoryBTreeIterator) Close() {
+	it.tree, it.current, it.valid = nil, nil, false
+}
+
 type item struct {
 	key []byte
 	pos *wal.ChunkPosition
@@ -156,9 +274,9 @@ func (it *item) Less(bi btree.Item) bool {
 	return bytes.Compare(it.key, bi.(*item).key) < 0
 }
 
-func newBTree() *MemoryBTree {
+func newBTree(degree int) *MemoryBTree {
 	return &MemoryBTree{
-		tree: btree.New(32), // 默认深度32
+		tree: btree.New(degree),
 		lock: new(sync.RWMutex),
 	}
 }
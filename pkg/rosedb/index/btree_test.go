@@ -9,11 +9,11 @@ import (
 	"fmt"
 	"testing"
 
-	"github.com/JoyZF/wal"
+	"github.com/JoyZF/zoom/pkg/wal"
 )
 
 func TestMemoryBTree_Put_Get(t *testing.T) {
-	mt := newBTree()
+	mt := newBTree(DefaultOptions.BTreeDegree)
 	w, _ := wal.Open(wal.DefaultOptions)
 
 	key := []byte("testKey")
@@ -33,7 +33,7 @@ func TestMemoryBTree_Put_Get(t *testing.T) {
 }
 
 func TestMemoryBTree_Delete(t *testing.T) {
-	mt := newBTree()
+	mt := newBTree(DefaultOptions.BTreeDegree)
 	w, _ := wal.Open(wal.DefaultOptions)
 
 	key := []byte("testKey")
@@ -57,7 +57,7 @@ func TestMemoryBTree_Delete(t *testing.T) {
 }
 
 func TestMemoryBTree_Size(t *testing.T) {
-	mt := newBTree()
+	mt := newBTree(DefaultOptions.BTreeDegree)
 
 	if mt.Size() != 0 {
 		t.Fatalf("expected size to be 0, got %d", mt.Size())
@@ -75,7 +75,7 @@ func TestMemoryBTree_Size(t *testing.T) {
 }
 
 func TestMemoryBTree_Ascend_Descend(t *testing.T) {
-	mt := newBTree()
+	mt := newBTree(DefaultOptions.BTreeDegree)
 	w, _ := wal.Open(wal.DefaultOptions)
 
 	data := map[string][]byte{
@@ -129,7 +129,7 @@ func TestMemoryBTree_Ascend_Descend(t *testing.T) {
 }
 
 func TestMemoryBTree_AscendRange_DescendRange(t *testing.T) {
-	mt := newBTree()
+	mt := newBTree(DefaultOptions.BTreeDegree)
 	w, _ := wal.Open(wal.DefaultOptions)
 
 	data := map[string][]byte{
@@ -164,7 +164,7 @@ func TestMemoryBTree_AscendRange_DescendRange(t *testing.T) {
 }
 
 func TestMemoryBTree_AscendGreaterOrEqual_DescendLessOrEqual(t *testing.T) {
-	mt := newBTree()
+	mt := newBTree(DefaultOptions.BTreeDegree)
 	w, _ := wal.Open(wal.DefaultOptions)
 
 	data := map[string][]byte{
@@ -197,3 +197,53 @@ func TestMemoryBTree_AscendGreaterOrEqual_DescendLessOrEqual(t *testing.T) {
 		return true, nil
 	})
 }
+
+func TestMemoryBTree_Iterator(t *testing.T) {
+	mt := newBTree(DefaultOptions.BTreeDegree)
+	w, _ := wal.Open(wal.DefaultOptions)
+
+	for _, k := range []string{"apple", "banana", "cherry", "date"} {
+		chunkPosition, _ := w.Write([]byte(k))
+		mt.Put([]byte(k), chunkPosition)
+	}
+
+	iter := mt.Iterator(false)
+	defer iter.Close()
+
+	// writes after creation are not visible to the iterator
+	chunkPosition, _ := w.Write([]byte("banana2"))
+	mt.Put([]byte("banana2"), chunkPosition)
+
+	var keys []string
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		keys = append(keys, string(iter.Key()))
+	}
+	if fmt.Sprint(keys) != "[apple banana cherry date]" {
+		t.Fatalf("unexpected ascending keys %v", keys)
+	}
+
+	iter.Seek([]byte("bz"))
+	if !iter.Valid() || !bytes.Equal(iter.Key(), []byte("cherry")) {
+		t.Fatalf("expected cherry, got %s", iter.Key())
+	}
+	iter.Prev()
+	if !iter.Valid() || !bytes.Equal(iter.Key(), []byte("banana")) {
+		t.Fatalf("expected banana, got %s", iter.Key())
+	}
+
+	rIter := mt.Iterator(true)
+	defer rIter.Close()
+	rIter.Seek([]byte("bz"))
+	if !rIter.Valid() || !bytes.Equal(rIter.Key(), []byte("banana2")) {
+		t.Fatalf("expected banana2, got %s", rIter.Key())
+	}
+	rIter.Next()
+	if !rIter.Valid() || !bytes.Equal(rIter.Key(), []byte("banana")) {
+		t.Fatalf("expected banana, got %s", rIter.Key())
+	}
+	rIter.Prev()
+	rIter.Prev()
+	if !rIter.Valid() || !bytes.Equal(rIter.Key(), []byte("cherry")) {
+		t.Fatalf("expected cherry, got %s", rIter.Key())
+	}
+}
@@ -0,0 +1,209 @@
+// Copyright 2024 Joy <joyssss94@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+
+	"github.com/JoyZF/zoom/pkg/wal"
+)
+
+// MemoryHash is a memory based sharded hash map implementation of the Indexer interface.
+//
+// The keys are spread over the shards by their FNV-1a hash, and each shard has its own lock,
+// so Put, Get and Delete only block the calls on the same shard, and take constant time.
+//
+// The keys are not kept in order: the iterations and Iterator sort a copy of them first,
+// and Clone copies them, in time and memory proportional to the size of the index.
+// So it suits the workloads of point reads and writes, which seldom iterate or take snapshots.
+type MemoryHash struct {
+	shards []*hashShard
+}
+
+type hashShard struct {
+	items map[string]*item
+	lock  *sync.RWMutex
+}
+
+func (h *MemoryHash) Put(key []byte, position *wal.ChunkPosition) *wal.ChunkPosition {
+	shard := h.shard(key)
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+
+	old := shard.items[string(key)]
+	shard.items[string(key)] = &item{key: key, pos: position}
+	if old != nil {
+		return old.pos
+	}
+	return nil
+}
+
+func (h *MemoryHash) Get(key []byte) *wal.ChunkPosition {
+	shard := h.shard(key)
+	shard.lock.RLock()
+	defer shard.lock.RUnlock()
+
+	if i := shard.items[string(key)]; i != nil {
+		return i.pos
+	}
+	return nil
+}
+
+func (h *MemoryHash) Delete(key []byte) (*wal.ChunkPosition, bool) {
+	shard := h.shard(key)
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+
+	i := shard.items[string(key)]
+	if i == nil {
+		return nil, false
+	}
+	delete(shard.items, string(key))
+	return i.pos, true
+}
+
+func (h *MemoryHash) Size() int {
+	size := 0
+	for _, shard := range h.shards {
+		shard.lock.RLock()
+		size += len(shard.items)
+		shard.lock.RUnlock()
+	}
+	return size
+}
+
+func (h *MemoryHash) Ascend(handleFn func(key []byte, position *wal.ChunkPosition) (bool, error)) {
+	ascendItems(h.sorted(), itemVisitor(handleFn))
+}
+
+func (h *MemoryHash) AscendRange(
+	startKey, endKey []byte,
+	handleFn func(key []byte, position *wal.ChunkPosition) (bool, error),
+) {
+	items := h.sorted()
+	visit := itemVisitor(handleFn)
+	ascendItems(items[searchItems(items, startKey, false):], func(i *item) bool {
+		return bytes.Compare(i.key, endKey) < 0 && visit(i)
+	})
+}
+
+func (h *MemoryHash) AscendGreaterOrEqual(
+	key []byte,
+	handleFn func(key []byte, position *wal.ChunkPosition) (bool, error),
+) {
+	items := h.sorted()
+	ascendItems(items[searchItems(items, key, false):], itemVisitor(handleFn))
+}
+
+func (h *MemoryHash) Descend(handleFn func(key []byte, pos *wal.ChunkPosition) (bool, error)) {
+	descendItems(h.sorted(), itemVisitor(handleFn))
+}
+
+func (h *MemoryHash) DescendRange(
+	startKey, endKey []byte,
+	handleFn func(key []byte, position *wal.ChunkPosition) (bool, error),
+) {
+	items := h.sorted()
+	visit := itemVisitor(handleFn)
+	descendItems(items[:searchItems(items, startKey, true)], func(i *item) bool {
+		return bytes.Compare(i.key, endKey) > 0 && visit(i)
+	})
+}
+
+func (h *MemoryHash) DescendLessOrEqual(
+	key []byte,
+	handleFn func(key []byte, position *wal.ChunkPosition) (bool, error),
+) {
+	items := h.sorted()
+	descendItems(items[:searchItems(items, key, true)], itemVisitor(handleFn))
+}
+
+func (h *MemoryHash) Clone() Indexer {
+	h.lockAll()
+	defer h.unlockAll()
+
+	clone := &MemoryHash{shards: make([]*hashShard, len(h.shards))}
+	for i, shard := range h.shards {
+		items := make(map[string]*item, len(shard.items))
+		for key, it := range shard.items {
+			items[key] = it
+		}
+		clone.shards[i] = &hashShard{items: items, lock: new(sync.RWMutex)}
+	}
+	return clone
+}
+
+func (h *MemoryHash) Iterator(reverse bool) IndexIterator {
+	return newSliceIterator(h.sorted(), reverse)
+}
+
+// shard returns the shard of the key.
+func (h *MemoryHash) shard(key []byte) *hashShard {
+	hash := uint32(2166136261)
+	for _, c := range key {
+		hash ^= uint32(c)
+		hash *= 16777619
+	}
+	return h.shards[hash%uint32(len(h.shards))]
+}
+
+// sorted returns the items of all shards at one point in time, sorted by the keys.
+// The handlers of the iterations are called on the copy, without holding the locks.
+func (h *MemoryHash) sorted() []*item {
+	h.lockAll()
+	items := make([]*item, 0)
+	for _, shard := range h.shards {
+		for _, it := range shard.items {
+			items = append(items, it)
+		}
+	}
+	h.unlockAll()
+
+	sort.Slice(items, func(i, j int) bool {
+		return bytes.Compare(items[i].key, items[j].key) < 0
+	})
+	return items
+}
+
+// lockAll takes the read locks of all shards in order.
+func (h *MemoryHash) lockAll() {
+	for _, shard := range h.shards {
+		shard.lock.RLock()
+	}
+}
+
+func (h *MemoryHash) unlockAll() {
+	for _, shard := range h.shards {
+		shard.lock.RUnlock()
+	}
+}
+
+// ascendItems visits the items in order until visit returns false.
+func ascendItems(items []*item, visit func(i *item) bool) {
+	for _, i := range items {
+		if !visit(i) {
+			return
+		}
+	}
+}
+
+// descendItems visits the items in reverse order until visit returns false.
+func descendItems(items []*item, visit func(i *item) bool) {
+	for i := len(items) - 1; i >= 0; i-- {
+		if !visit(items[i]) {
+			return
+		}
+	}
+}
+
+func newHash(shards int) *MemoryHash {
+	h := &MemoryHash{shards: make([]*hashShard, shards)}
+	for i := range h.shards {
+		h.shards[i] = &hashShard{items: make(map[string]*item), lock: new(sync.RWMutex)}
+	}
+	return h
+}
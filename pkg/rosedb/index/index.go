@@ -4,7 +4,7 @@
 
 package index
 
-import "github.com/JoyZF/wal"
+import "github.com/JoyZF/zoom/pkg/wal"
 
 // Indexer is an interface for indexing key and position.
 // It is used to store the key and the position of the data in the WAL.
@@ -46,22 +46,114 @@ type Indexer interface {
 	// DescendLessOrEqual iterates in descending order, starting from key <= given key,
 	// invoking handleFn. Stops if handleFn returns false.
 	DescendLessOrEqual(key []byte, handleFn func(key []byte, position *wal.ChunkPosition) (bool, error))
+
+	// Clone returns a point-in-time copy of the index.
+	// Changes made to either index after the call are not visible to the other.
+	Clone() Indexer
+
+	// Iterator returns a cursor over a point-in-time view of the index.
+	// Changes made to the index after the call are not visible to the cursor.
+	Iterator(reverse bool) IndexIterator
+}
+
+// IndexIterator is a cursor over the keys of an Indexer.
+// Next always moves in the direction the iterator was created with,
+// and Prev moves the other way.
+type IndexIterator interface {
+	// Rewind moves the cursor to the first key, or to the last key if the iterator is reversed.
+	Rewind()
+
+	// Seek moves the cursor to the first key >= the given key,
+	// or to the last key <= the given key if the iterator is reversed.
+	Seek(key []byte)
+
+	// Next moves the cursor to the next key in iteration order.
+	Next()
+
+	// Prev moves the cursor to the previous key in iteration order.
+	Prev()
+
+	// Valid reports whether the cursor points to a key.
+	Valid() bool
+
+	// Key returns the key at the cursor.
+	Key() []byte
+
+	// Value returns the position at the cursor.
+	Value() *wal.ChunkPosition
+
+	// Close releases the resources held by the cursor.
+	Close()
 }
 
 type IndexerType = byte
 
 const (
+	// BTree is the in-memory btree, rebuilt from the WAL every time the database is opened.
 	BTree IndexerType = iota
+	// BPTree is the disk based B+tree, see DiskBPTree.
+	BPTree
+	// ART is the in-memory adaptive radix tree, see MemoryART.
+	ART
+	// SkipList is the in-memory skiplist, see MemorySkipList.
+	SkipList
+	// Hash is the in-memory sharded hash map, see MemoryHash.
+	Hash
 )
 
-// Change the index type as you implement.
-var indexType = BTree
+// Options is the options of the in-memory indexes.
+type Options struct {
+	// Type is the type of the index, BPTree is opened by OpenBPTree instead.
+	Type IndexerType
 
-func NewIndexer() Indexer {
-	switch indexType {
+	// BTreeDegree is the degree of the nodes of BTree,
+	// a node holds at most 2*BTreeDegree-1 keys. It must be greater than 1.
+	BTreeDegree int
+
+	// SkipListMaxLevel is the maximum number of levels of SkipList,
+	// each level links about a quarter of the keys of the level below.
+	SkipListMaxLevel int
+
+	// HashShards is the number of shards of Hash, each of them has its own lock.
+	HashShards int
+}
+
+var DefaultOptions = Options{
+	Type:             BTree,
+	BTreeDegree:      32,
+	SkipListMaxLevel: 32,
+	HashShards:       32,
+}
+
+// IsMemory reports whether the index of the type is kept in memory and created by NewIndexer.
+func IsMemory(indexType IndexerType) bool {
+	return indexType == BTree || (indexType >= ART && indexType <= Hash)
+}
+
+// NewIndexer returns an empty in-memory index of the type in the options.
+func NewIndexer(options Options) Indexer {
+	switch options.Type {
 	case BTree:
-		return newBTree()
+		return newBTree(options.BTreeDegree)
+	case ART:
+		return newART()
+	case SkipList:
+		return newSkipList(options.SkipListMaxLevel)
+	case Hash:
+		return newHash(options.HashShards)
 	default:
 		panic("unexpected index type")
 	}
 }
+
+// itemVisitor adapts the handler of the iterations to a function over the items,
+// which returns false when the iteration stops, the handler returned false or an error.
+func itemVisitor(handleFn func(key []byte, position *wal.ChunkPosition) (bool, error)) func(i *item) bool {
+	return func(i *item) bool {
+		cont, err := handleFn(i.key, i.pos)
+		if err != nil {
+			return false
+		}
+		return cont
+	}
+}
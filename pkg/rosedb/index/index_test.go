@@ -0,0 +1,290 @@
+// Copyright 2024 Joy <joyssss94@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/JoyZF/zoom/pkg/wal"
+)
+
+// memoryIndexTypes are the in-memory indexes checked against each other.
+var memoryIndexTypes = map[string]IndexerType{
+	"BTree":    BTree,
+	"ART":      ART,
+	"SkipList": SkipList,
+	"Hash":     Hash,
+}
+
+func newTestIndexer(indexType IndexerType) Indexer {
+	options := DefaultOptions
+	options.Type = indexType
+	options.BTreeDegree, options.SkipListMaxLevel, options.HashShards = 4, 8, 4
+	return NewIndexer(options)
+}
+
+// memoryIndexTestKeys returns random keys which often share prefixes and are prefixes of each other.
+func memoryIndexTestKeys(r *rand.Rand, n int) [][]byte {
+	keys := make([][]byte, n)
+	for i := range keys {
+		key := make([]byte, 1+r.Intn(5))
+		for j := range key {
+			if r.Intn(2) == 0 {
+				key[j] = "ab"[r.Intn(2)]
+			} else {
+				key[j] = byte(r.Intn(256))
+			}
+		}
+		keys[i] = key
+	}
+	return keys
+}
+
+// collectKeys runs the iteration and returns the keys it visited.
+func collectKeys(iterate func(handleFn func(key []byte, pos *wal.ChunkPosition) (bool, error))) []string {
+	var keys []string
+	iterate(func(key []byte, pos *wal.ChunkPosition) (bool, error) {
+		keys = append(keys, string(key))
+		return true, nil
+	})
+	return keys
+}
+
+// filterKeys returns the sorted keys which match, in descending order if reverse is true.
+func filterKeys(sorted []string, reverse bool, match func(key string) bool) []string {
+	var keys []string
+	for _, key := range sorted {
+		if match(key) {
+			keys = append(keys, key)
+		}
+	}
+	if reverse {
+		for i, j := 0, len(keys)-1; i < j; i, j = i+1, j-1 {
+			keys[i], keys[j] = keys[j], keys[i]
+		}
+	}
+	return keys
+}
+
+// checkMemoryIndexer checks the index holds exactly the expected positions,
+// and iterates over them like a sorted list of the keys would.
+func checkMemoryIndexer(t *testing.T, idx Indexer, expected map[string]*wal.ChunkPosition, bounds [][]byte) {
+	if idx.Size() != len(expected) {
+		t.Fatalf("expected size %d, got %d", len(expected), idx.Size())
+	}
+	sorted := make([]string, 0, len(expected))
+	for key, pos := range expected {
+		sorted = append(sorted, key)
+		if got := idx.Get([]byte(key)); got == nil || *got != *pos {
+			t.Fatalf("key %q: expected %+v, got %+v", key, pos, got)
+		}
+	}
+	sort.Strings(sorted)
+
+	check := func(name string, got, want []string) {
+		if fmt.Sprintf("%q", got) != fmt.Sprintf("%q", want) {
+			t.Fatalf("%s: expected %q, got %q", name, want, got)
+		}
+	}
+	check("Ascend", collectKeys(idx.Ascend), sorted)
+	check("Descend", collectKeys(idx.Descend), filterKeys(sorted, true, func(string) bool { return true }))
+	for i := 0; i+1 < len(bounds); i += 2 {
+		start, end := string(bounds[i]), string(bounds[i+1])
+		check("AscendRange", collectKeys(func(fn func([]byte, *wal.ChunkPosition) (bool, error)) {
+			idx.AscendRange(bounds[i], bounds[i+1], fn)
+		}), filterKeys(sorted, false, func(key string) bool { return key >= start && key < end }))
+		check("DescendRange", collectKeys(func(fn func([]byte, *wal.ChunkPosition) (bool, error)) {
+			idx.DescendRange(bounds[i], bounds[i+1], fn)
+		}), filterKeys(sorted, true, func(key string) bool { return key <= start && key > end }))
+		check("AscendGreaterOrEqual", collectKeys(func(fn func([]byte, *wal.ChunkPosition) (bool, error)) {
+			idx.AscendGreaterOrEqual(bounds[i], fn)
+		}), filterKeys(sorted, false, func(key string) bool { return key >= start }))
+		check("DescendLessOrEqual", collectKeys(func(fn func([]byte, *wal.ChunkPosition) (bool, error)) {
+			idx.DescendLessOrEqual(bounds[i], fn)
+		}), filterKeys(sorted, true, func(key string) bool { return key <= start }))
+	}
+
+	for _, reverse := range []bool{false, true} {
+		iter := idx.Iterator(reverse)
+		var keys []string
+		for iter.Rewind(); iter.Valid(); iter.Next() {
+			keys = append(keys, string(iter.Key()))
+		}
+		check("Iterator", keys, filterKeys(sorted, reverse, func(string) bool { return true }))
+
+		for _, bound := range bounds {
+			// the keys from the sought one onwards, then one step back from the second of them.
+			want := filterKeys(sorted, reverse, func(key string) bool {
+				return (!reverse && key >= string(bound)) || (reverse && key <= string(bound))
+			})
+			iter.Seek(bound)
+			if len(want) == 0 {
+				if iter.Valid() {
+					t.Fatalf("Seek(%q): expected no key, got %q", bound, iter.Key())
+				}
+				continue
+			}
+			if !iter.Valid() || string(iter.Key()) != want[0] {
+				t.Fatalf("Seek(%q): expected %q, got %q", bound, want[0], iter.Key())
+			}
+			iter.Next()
+			if len(want) == 1 {
+				if iter.Valid() {
+					t.Fatalf("Next: expected no key, got %q", iter.Key())
+				}
+				continue
+			}
+			if !iter.Valid() || string(iter.Key()) != want[1] {
+				t.Fatalf("Next: expected %q, got %q", want[1], iter.Key())
+			}
+			iter.Prev()
+			if !iter.Valid() || string(iter.Key()) != want[0] {
+				t.Fatalf("Prev: expected %q, got %q", want[0], iter.Key())
+			}
+		}
+		iter.Close()
+	}
+}
+
+func TestMemoryIndexer_Random(t *testing.T) {
+	for name, indexType := range memoryIndexTypes {
+		t.Run(name, func(t *testing.T) {
+			r := rand.New(rand.NewSource(1))
+			keys := memoryIndexTestKeys(r, 2000)
+			idx := newTestIndexer(indexType)
+			expected := make(map[string]*wal.ChunkPosition)
+			for i := 0; i < 20000; i++ {
+				key := keys[r.Intn(len(keys))]
+				old, ok := expected[string(key)]
+				if r.Intn(3) == 0 {
+					pos, deleted := idx.Delete(key)
+					if deleted != ok || (ok && *pos != *old) {
+						t.Fatalf("Delete(%q): expected %+v %v, got %+v %v", key, old, ok, pos, deleted)
+					}
+					delete(expected, string(key))
+				} else {
+					pos := &wal.ChunkPosition{SegmentId: 1, ChunkOffset: int64(i)}
+					if got := idx.Put(key, pos); (got == nil) == ok || (ok && *got != *old) {
+						t.Fatalf("Put(%q): expected %+v, got %+v", key, old, got)
+					}
+					expected[string(key)] = pos
+				}
+				if i%5000 == 0 {
+					checkMemoryIndexer(t, idx, expected, memoryIndexTestKeys(r, 20))
+				}
+			}
+			checkMemoryIndexer(t, idx, expected, memoryIndexTestKeys(r, 100))
+
+			// the deletes of all keys leave an empty index.
+			for key := range expected {
+				idx.Delete([]byte(key))
+			}
+			checkMemoryIndexer(t, idx, nil, memoryIndexTestKeys(r, 10))
+		})
+	}
+}
+
+func TestMemoryIndexer_Clone_Iterator(t *testing.T) {
+	for name, indexType := range memoryIndexTypes {
+		t.Run(name, func(t *testing.T) {
+			idx := newTestIndexer(indexType)
+			expected := make(map[string]*wal.ChunkPosition)
+			for i := 0; i < 1000; i++ {
+				key, pos := []byte(fmt.Sprintf("key-%04d", i)), &wal.ChunkPosition{SegmentId: 1, ChunkOffset: int64(i)}
+				idx.Put(key, pos)
+				expected[string(key)] = pos
+			}
+			clone := idx.Clone()
+			iter := idx.Iterator(false)
+
+			// changes after the clone are not visible to it, and the other way round
+			for i := 0; i < 500; i++ {
+				idx.Delete([]byte(fmt.Sprintf("key-%04d", i)))
+			}
+			idx.Put([]byte("key-0999"), &wal.ChunkPosition{SegmentId: 2})
+			idx.Put([]byte("index-only"), &wal.ChunkPosition{SegmentId: 2})
+			clone.Put([]byte("clone-only"), &wal.ChunkPosition{SegmentId: 3})
+			if idx.Get([]byte("clone-only")) != nil {
+				t.Fatal("the clone changed the index")
+			}
+			clone.Delete([]byte("clone-only"))
+			bounds := [][]byte{[]byte("key-0500"), []byte("key-0100"), []byte("key-0999x"), []byte("a")}
+			checkMemoryIndexer(t, clone, expected, bounds)
+
+			var count int
+			for iter.Rewind(); iter.Valid(); iter.Next() {
+				if *iter.Value() != *expected[string(iter.Key())] {
+					t.Fatalf("unexpected position of %s", iter.Key())
+				}
+				count++
+			}
+			iter.Close()
+			if count != len(expected) {
+				t.Fatalf("expected %d keys, got %d", len(expected), count)
+			}
+		})
+	}
+}
+
+func TestMemoryIndexer_Stop(t *testing.T) {
+	for name, indexType := range memoryIndexTypes {
+		t.Run(name, func(t *testing.T) {
+			idx := newTestIndexer(indexType)
+			for i := 0; i < 10; i++ {
+				idx.Put([]byte(fmt.Sprintf("key-%d", i)), &wal.ChunkPosition{ChunkOffset: int64(i)})
+			}
+			// the iteration stops when the handler returns false or an error.
+			var keys []string
+			idx.Ascend(func(key []byte, pos *wal.ChunkPosition) (bool, error) {
+				keys = append(keys, string(key))
+				return len(keys) < 2, nil
+			})
+			idx.Descend(func(key []byte, pos *wal.ChunkPosition) (bool, error) {
+				keys = append(keys, string(key))
+				return true, errors.New("stop")
+			})
+			if fmt.Sprint(keys) != "[key-0 key-1 key-9]" {
+				t.Fatalf("unexpected keys %v", keys)
+			}
+		})
+	}
+}
+
+func TestMemoryART_NodeKinds(t *testing.T) {
+	tree := newART()
+	expected := make(map[string]*wal.ChunkPosition)
+	// the root grows through all kinds of nodes, then shrinks back.
+	for c := 0; c < 256; c++ {
+		key := []byte{'p', byte(c), 'x'}
+		pos := &wal.ChunkPosition{ChunkOffset: int64(c)}
+		tree.Put(key, pos)
+		expected[string(key)] = pos
+		if c == 3 || c == 15 || c == 47 {
+			checkMemoryIndexer(t, tree, expected, [][]byte{{'p', byte(c)}, {'p'}})
+		}
+	}
+	if tree.root.kind != artNode256 || string(tree.root.prefix) != "p" {
+		t.Fatalf("expected a node256 with prefix p, got kind %d prefix %q", tree.root.kind, tree.root.prefix)
+	}
+	checkMemoryIndexer(t, tree, expected, [][]byte{{'p', 100}, {'p', 50, 'y'}, {'p', 255, 'x'}, {'p'}})
+
+	for c := 255; c > 0; c-- {
+		key := []byte{'p', byte(c), 'x'}
+		tree.Delete(key)
+		delete(expected, string(key))
+		if c == 36 || c == 12 || c == 3 {
+			checkMemoryIndexer(t, tree, expected, [][]byte{{'p', byte(c)}, {'p'}})
+		}
+	}
+	// the only key left is merged back into a single node.
+	if tree.root.size != 0 || string(tree.root.prefix) != "p\x00x" || tree.root.leaf == nil {
+		t.Fatalf("expected a single leaf, got %+v", tree.root)
+	}
+	checkMemoryIndexer(t, tree, expected, [][]byte{{'p'}, {'q'}})
+}
@@ -0,0 +1,275 @@
+// Copyright 2024 Joy <joyssss94@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"bytes"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/JoyZF/zoom/pkg/wal"
+)
+
+// skipListP is the inverse of the probability that a key linked on a level is linked on the next one too.
+const skipListP = 4
+
+// MemorySkipList is a memory based skiplist implementation of the Indexer interface.
+//
+// The keys are linked in order on the lowest level, and each level above links a random
+// quarter of the keys of the level below, so a lookup skips most of the keys from the top level down.
+// The lowest level is also linked backwards for the descending iterations.
+//
+// Clone and Iterator copy the keys, in time and memory proportional to the size of the index,
+// so BTree and ART suit better if snapshots and iterators are used a lot.
+type MemorySkipList struct {
+	head     *skipListNode
+	level    int // the number of levels in use
+	maxLevel int
+	size     int
+	rand     *rand.Rand
+	lock     *sync.RWMutex
+}
+
+type skipListNode struct {
+	entry *item
+	next  []*skipListNode
+	prev  *skipListNode // the previous node on the lowest level, nil for the first one
+}
+
+func (sl *MemorySkipList) Put(key []byte, position *wal.ChunkPosition) *wal.ChunkPosition {
+	sl.lock.Lock()
+	defer sl.lock.Unlock()
+
+	update := make([]*skipListNode, sl.maxLevel)
+	node := sl.findLess(key, false, update).next[0]
+	if node != nil && bytes.Equal(node.entry.key, key) {
+		// the item is replaced rather than changed, the copies of the list may share it.
+		old := node.entry.pos
+		node.entry = &item{key: key, pos: position}
+		return old
+	}
+
+	level := sl.randomLevel()
+	for ; sl.level < level; sl.level++ {
+		update[sl.level] = sl.head
+	}
+	node = &skipListNode{entry: &item{key: key, pos: position}, next: make([]*skipListNode, level)}
+	for i := 0; i < level; i++ {
+		node.next[i], update[i].next[i] = update[i].next[i], node
+	}
+	if update[0] != sl.head {
+		node.prev = update[0]
+	}
+	if node.next[0] != nil {
+		node.next[0].prev = node
+	}
+	sl.size++
+	return nil
+}
+
+func (sl *MemorySkipList) Get(key []byte) *wal.ChunkPosition {
+	sl.lock.RLock()
+	defer sl.lock.RUnlock()
+
+	node := sl.findLess(key, false, nil).next[0]
+	if node != nil && bytes.Equal(node.entry.key, key) {
+		return node.entry.pos
+	}
+	return nil
+}
+
+func (sl *MemorySkipList) Delete(key []byte) (*wal.ChunkPosition, bool) {
+	sl.lock.Lock()
+	defer sl.lock.Unlock()
+
+	update := make([]*skipListNode, sl.maxLevel)
+	node := sl.findLess(key, false, update).next[0]
+	if node == nil || !bytes.Equal(node.entry.key, key) {
+		return nil, false
+	}
+	for i := range node.next {
+		update[i].next[i] = node.next[i]
+	}
+	if node.next[0] != nil {
+		node.next[0].prev = node.prev
+	}
+	for sl.level > 1 && sl.head.next[sl.level-1] == nil {
+		sl.level--
+	}
+	sl.size--
+	return node.entry.pos, true
+}
+
+func (sl *MemorySkipList) Size() int {
+	sl.lock.RLock()
+	defer sl.lock.RUnlock()
+
+	return sl.size
+}
+
+func (sl *MemorySkipList) Ascend(handleFn func(key []byte, position *wal.ChunkPosition) (bool, error)) {
+	sl.lock.RLock()
+	defer sl.lock.RUnlock()
+
+	sl.ascendFrom(sl.head.next[0], itemVisitor(handleFn))
+}
+
+func (sl *MemorySkipList) AscendRange(
+	startKey, endKey []byte,
+	handleFn func(key []byte, position *wal.ChunkPosition) (bool, error),
+) {
+	sl.lock.RLock()
+	defer sl.lock.RUnlock()
+
+	visit := itemVisitor(handleFn)
+	sl.ascendFrom(sl.findLess(startKey, false, nil).next[0], func(i *item) bool {
+		return bytes.Compare(i.key, endKey) < 0 && visit(i)
+	})
+}
+
+func (sl *MemorySkipList) AscendGreaterOrEqual(
+	key []byte,
+	handleFn func(key []byte, position *wal.ChunkPosition) (bool, error),
+) {
+	sl.lock.RLock()
+	defer sl.lock.RUnlock()
+
+	sl.ascendFrom(sl.findLess(key, false, nil).next[0], itemVisitor(handleFn))
+}
+
+func (sl *MemorySkipList) Descend(handleFn func(key []byte, pos *wal.ChunkPosition) (bool, error)) {
+	sl.lock.RLock()
+	defer sl.lock.RUnlock()
+
+	sl.descendFrom(sl.last(), itemVisitor(handleFn))
+}
+
+func (sl *MemorySkipList) DescendRange(
+	startKey, endKey []byte,
+	handleFn func(key []byte, position *wal.ChunkPosition) (bool, error),
+) {
+	sl.lock.RLock()
+	defer sl.lock.RUnlock()
+
+	visit := itemVisitor(handleFn)
+	sl.descendFrom(sl.findLess(startKey, true, nil), func(i *item) bool {
+		return bytes.Compare(i.key, endKey) > 0 && visit(i)
+	})
+}
+
+func (sl *MemorySkipList) DescendLessOrEqual(
+	key []byte,
+	handleFn func(key []byte, position *wal.ChunkPosition) (bool, error),
+) {
+	sl.lock.RLock()
+	defer sl.lock.RUnlock()
+
+	sl.descendFrom(sl.findLess(key, true, nil), itemVisitor(handleFn))
+}
+
+func (sl *MemorySkipList) Clone() Indexer {
+	sl.lock.RLock()
+	defer sl.lock.RUnlock()
+
+	// the nodes are copied with the same levels, appended to the tail of each level.
+	clone := newSkipList(sl.maxLevel)
+	tails := make([]*skipListNode, sl.maxLevel)
+	for i := range tails {
+		tails[i] = clone.head
+	}
+	var prev *skipListNode
+	for node := sl.head.next[0]; node != nil; node = node.next[0] {
+		copied := &skipListNode{entry: node.entry, next: make([]*skipListNode, len(node.next)), prev: prev}
+		for i := range copied.next {
+			tails[i].next[i], tails[i] = copied, copied
+		}
+		prev = copied
+	}
+	clone.level, clone.size = sl.level, sl.size
+	return clone
+}
+
+func (sl *MemorySkipList) Iterator(reverse bool) IndexIterator {
+	sl.lock.RLock()
+	defer sl.lock.RUnlock()
+
+	items := make([]*item, 0, sl.size)
+	for node := sl.head.next[0]; node != nil; node = node.next[0] {
+		items = append(items, node.entry)
+	}
+	return newSliceIterator(items, reverse)
+}
+
+// findLess returns the last node with a key < key, or <= key if orEqual, and the head if there is none.
+// If update is not nil, it is filled with the last such node on each level in use.
+func (sl *MemorySkipList) findLess(key []byte, orEqual bool, update []*skipListNode) *skipListNode {
+	node := sl.head
+	for level := sl.level - 1; level >= 0; level-- {
+		for next := node.next[level]; next != nil; next = node.next[level] {
+			cmp := bytes.Compare(next.entry.key, key)
+			if cmp > 0 || (cmp == 0 && !orEqual) {
+				break
+			}
+			node = next
+		}
+		if update != nil {
+			update[level] = node
+		}
+	}
+	return node
+}
+
+// last returns the last node, and the head if the list is empty.
+func (sl *MemorySkipList) last() *skipListNode {
+	node := sl.head
+	for level := sl.level - 1; level >= 0; level-- {
+		for node.next[level] != nil {
+			node = node.next[level]
+		}
+	}
+	return node
+}
+
+// ascendFrom visits the items from the node forwards until visit returns false.
+func (sl *MemorySkipList) ascendFrom(node *skipListNode, visit func(i *item) bool) {
+	for ; node != nil; node = node.next[0] {
+		if !visit(node.entry) {
+			return
+		}
+	}
+}
+
+// descendFrom visits the items from the node backwards until visit returns false,
+// the head means there is no item to visit.
+func (sl *MemorySkipList) descendFrom(node *skipListNode, visit func(i *item) bool) {
+	if node == sl.head {
+		return
+	}
+	for ; node != nil; node = node.prev {
+		if !visit(node.entry) {
+			return
+		}
+	}
+}
+
+// randomLevel returns the number of levels of a new node.
+func (sl *MemorySkipList) randomLevel() int {
+	level := 1
+	for level < sl.maxLevel && sl.rand.Intn(skipListP) == 0 {
+		level++
+	}
+	return level
+}
+
+func newSkipList(maxLevel int) *MemorySkipList {
+	return &MemorySkipList{
+		head:     &skipListNode{next: make([]*skipListNode, maxLevel)},
+		level:    1,
+		maxLevel: maxLevel,
+		rand:     rand.New(rand.NewSource(time.Now().UnixNano())),
+		lock:     new(sync.RWMutex),
+	}
+}
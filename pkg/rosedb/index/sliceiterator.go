@@ -0,0 +1,92 @@
+// Copyright 2024 Joy <joyssss94@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/JoyZF/zoom/pkg/wal"
+)
+
+// sliceIterator is a cursor over a sorted copy of the items of an index,
+// for the indexes which can not share their nodes with the cursor.
+type sliceIterator struct {
+	items   []*item
+	reverse bool
+	i       int // the position of the cursor, out of the range of items if it is not valid
+}
+
+func newSliceIterator(items []*item, reverse bool) *sliceIterator {
+	return &sliceIterator{items: items, reverse: reverse, i: -1}
+}
+
+func (it *sliceIterator) Rewind() {
+	if it.reverse {
+		it.i = len(it.items) - 1
+	} else {
+		it.i = 0
+	}
+}
+
+func (it *sliceIterator) Seek(key []byte) {
+	if it.reverse {
+		it.i = searchItems(it.items, key, true) - 1
+	} else {
+		it.i = searchItems(it.items, key, false)
+	}
+}
+
+func (it *sliceIterator) Next() {
+	it.step(it.reverse)
+}
+
+func (it *sliceIterator) Prev() {
+	it.step(!it.reverse)
+}
+
+// step moves the cursor to the neighbour of the current item,
+// towards smaller keys if descend is true.
+func (it *sliceIterator) step(descend bool) {
+	if !it.Valid() {
+		return
+	}
+	if descend {
+		it.i--
+	} else {
+		it.i++
+	}
+}
+
+func (it *sliceIterator) Valid() bool {
+	return it.i >= 0 && it.i < len(it.items)
+}
+
+func (it *sliceIterator) Key() []byte {
+	if !it.Valid() {
+		return nil
+	}
+	return it.items[it.i].key
+}
+
+func (it *sliceIterator) Value() *wal.ChunkPosition {
+	if !it.Valid() {
+		return nil
+	}
+	return it.items[it.i].pos
+}
+
+func (it *sliceIterator) Close() {
+	it.items, it.i = nil, -1
+}
+
+// searchItems returns the position of the first item with a key >= key in the sorted items,
+// or > key if after is true.
+func searchItems(items []*item, key []byte, after bool) int {
+	return sort.Search(len(items), func(i int) bool {
+		cmp := bytes.Compare(items[i].key, key)
+		return cmp > 0 || (cmp == 0 && !after)
+	})
+}
@@ -0,0 +1,305 @@
+// Copyright 2024 Joy <joyssss94@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package rosedb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/JoyZF/zoom/pkg/rosedb/index"
+	"github.com/JoyZF/zoom/pkg/wal"
+)
+
+const (
+	indexSnapshotFileName = "INDEXSNAP"
+	// the snapshot of the indexes of the namespaces, written along with the index.BPTree index.
+	namespaceSnapshotFileName = "NSINDEXSNAP"
+	// crc32 and length of the payload
+	indexSnapshotHeaderSize = 8
+)
+
+var errInvalidIndexSnapshot = errors.New("the index snapshot is invalid")
+
+// SaveIndexSnapshot writes all keys of the index and of the namespaces to the snapshot file,
+// with the position of the last record in the WAL, so the next Open loads the snapshot and only replays
+// the WAL written after it. It does nothing if the WAL has not changed since the last snapshot.
+//
+// The index.BPTree index is persisted instead, it is already kept on disk,
+// and the indexes of the namespaces are written to a snapshot file along with it.
+func (db *DB) SaveIndexSnapshot() error {
+	db.indexSnapshotMu.Lock()
+	defer db.indexSnapshotMu.Unlock()
+
+	db.mu.RLock()
+	if db.closed {
+		db.mu.RUnlock()
+		return ErrDBClosed
+	}
+	if _, ok := db.index.(index.DiskIndexer); ok {
+		db.mu.RUnlock()
+		db.mu.Lock()
+		defer db.mu.Unlock()
+		if db.closed {
+			return ErrDBClosed
+		}
+		return db.flushIndex()
+	}
+	// the indexes are copied, so the writes are not blocked while the snapshot is written.
+	indexes, checkpoint, err := db.indexSnapshot()
+	db.mu.RUnlock()
+	if err != nil || indexes == nil {
+		return err
+	}
+	return db.writeIndexSnapshot(indexes, checkpoint)
+}
+
+// indexSnapshot returns a copy of the indexes of all namespaces by id and their checkpoint,
+// or nil indexes if the snapshot file is up-to-date. The caller must hold db.mu and db.indexSnapshotMu.
+func (db *DB) indexSnapshot() (map[uint32]index.Indexer, []byte, error) {
+	mergeFinSegmentId, err := getMergeFinSegmentId(db.options.DirPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	checkpoint := encodeIndexCheckpoint(mergeFinSegmentId, db.walPosition)
+	if bytes.Equal(checkpoint, db.indexSnapshotCheckpoint) {
+		return nil, nil, nil
+	}
+	// the snapshot must not point to the records which may be lost in a crash.
+	if err = db.dataFiles.Sync(); err != nil {
+		return nil, nil, err
+	}
+	if err = db.saveSegmentGarbage(checkpoint); err != nil {
+		return nil, nil, err
+	}
+	indexes := map[uint32]index.Indexer{defaultNamespaceID: db.index.Clone()}
+	for id, ns := range db.namespaces {
+		indexes[id] = ns.index.Clone()
+	}
+	return indexes, checkpoint, nil
+}
+
+// writeIndexSnapshot writes the indexes to the snapshot file.
+// The caller must hold db.indexSnapshotMu.
+func (db *DB) writeIndexSnapshot(indexes map[uint32]index.Indexer, checkpoint []byte) error {
+	if err := db.writeSnapshotFile(indexSnapshotFileName, indexes, checkpoint); err != nil {
+		return err
+	}
+	db.indexSnapshotCheckpoint = checkpoint
+	return nil
+}
+
+// saveNamespaceSnapshot writes the indexes of the namespaces to the namespace snapshot file,
+// with the checkpoint of the index.BPTree index persisted along with them.
+// It is not written until there is a namespace. The caller must hold db.mu.
+func (db *DB) saveNamespaceSnapshot(checkpoint []byte) error {
+	path := filepath.Join(db.options.DirPath, namespaceSnapshotFileName)
+	if len(db.namespaces) == 0 {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return nil
+		}
+	}
+	indexes := make(map[uint32]index.Indexer, len(db.namespaces))
+	for id, ns := range db.namespaces {
+		indexes[id] = ns.index
+	}
+	return db.writeSnapshotFile(namespaceSnapshotFileName, indexes, checkpoint)
+}
+
+// writeSnapshotFile writes the indexes to a temporary file and renames it to the snapshot file,
+// so a crash in the middle leaves the previous snapshot intact.
+// The keys are written like the hint records, with the id of their namespace.
+//
+//	+-------+--------+---------------------------------------------------------+
+//	| crc32 | length |                        payload                          |
+//	+-------+--------+---------------------------------------------------------+
+//	                  | checkpoint length | checkpoint | keys | hint records ... |
+//	                  +-------------------+------------+------+------------------+
+//	                        uvarint                   uvarint  uvarint length + hint record
+//
+// The payload is encrypted like a hint record if encryption is enabled.
+func (db *DB) writeSnapshotFile(name string, indexes map[uint32]index.Indexer, checkpoint []byte) error {
+	ids := make([]uint32, 0, len(indexes))
+	keys := 0
+	for id, idx := range indexes {
+		ids = append(ids, id)
+		keys += idx.Size()
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+
+	payload := binary.AppendUvarint(nil, uint64(len(checkpoint)))
+	payload = append(payload, checkpoint...)
+	payload = binary.AppendUvarint(payload, uint64(keys))
+	for _, id := range ids {
+		indexes[id].Ascend(func(key []byte, position *wal.ChunkPosition) (bool, error) {
+			hint := encodeNamespaceHintRecord(id, key, position)
+			payload = binary.AppendUvarint(payload, uint64(len(hint)))
+			payload = append(payload, hint...)
+			return true, nil
+		})
+	}
+	payload, err := db.cipher.sealHintRecord(payload)
+	if err != nil {
+		return err
+	}
+
+	data := make([]byte, indexSnapshotHeaderSize+len(payload))
+	binary.LittleEndian.PutUint32(data, crc32.ChecksumIEEE(payload))
+	binary.LittleEndian.PutUint32(data[4:], uint32(len(payload)))
+	copy(data[indexSnapshotHeaderSize:], payload)
+
+	path := filepath.Join(db.options.DirPath, name)
+	if err = writeFileSync(path+backupTempSuffix, data); err != nil {
+		return err
+	}
+	return os.Rename(path+backupTempSuffix, path)
+}
+
+// loadIndexSnapshot loads the indexes from the snapshot file, and returns the position in the WAL
+// after which the records are not in the snapshot, and the positions of the keys of the namespaces
+// dropped since the snapshot, which are garbage. It returns false and leaves the indexes as they are
+// if there is no snapshot, or it is corrupted or stale.
+func (db *DB) loadIndexSnapshot() (*wal.ChunkPosition, []*wal.ChunkPosition, bool) {
+	data, err := os.ReadFile(filepath.Join(db.options.DirPath, indexSnapshotFileName))
+	if err != nil {
+		return nil, nil, false
+	}
+	indexes, checkpoint, err := db.decodeIndexSnapshot(data)
+	if err != nil {
+		return nil, nil, false
+	}
+	start, valid := db.checkpointPosition(checkpoint)
+	if !valid {
+		return nil, nil, false
+	}
+	db.indexSnapshotCheckpoint = checkpoint
+	return start, db.installIndexes(indexes), true
+}
+
+// loadNamespaceSnapshot loads the indexes of the namespaces from the namespace snapshot file,
+// if it was written with the index.BPTree index persisted at the checkpoint, and returns the positions
+// of the keys of the namespaces dropped since, which are garbage. It returns false and leaves the indexes
+// as they are if the namespaces can not be loaded. There is no file if there has never been a namespace.
+func (db *DB) loadNamespaceSnapshot(checkpoint []byte) ([]*wal.ChunkPosition, bool) {
+	data, err := os.ReadFile(filepath.Join(db.options.DirPath, namespaceSnapshotFileName))
+	if err != nil {
+		return nil, os.IsNotExist(err) && len(db.namespaces) == 0
+	}
+	indexes, snapshotCheckpoint, err := db.decodeIndexSnapshot(data)
+	if err != nil || !bytes.Equal(snapshotCheckpoint, checkpoint) {
+		return nil, false
+	}
+	// the keys of the default namespace are in the disk index.
+	delete(indexes, defaultNamespaceID)
+	return db.installIndexes(indexes), true
+}
+
+// installIndexes replaces the indexes of the namespaces with the ones loaded from a snapshot,
+// and returns the positions of the keys of the namespaces which have been dropped.
+func (db *DB) installIndexes(indexes map[uint32]index.Indexer) []*wal.ChunkPosition {
+	var dropped []*wal.ChunkPosition
+	for id, idx := range indexes {
+		if id == defaultNamespaceID {
+			db.index = idx
+		} else if ns, ok := db.namespaces[id]; ok {
+			ns.index = idx
+		} else {
+			idx.Ascend(func(_ []byte, position *wal.ChunkPosition) (bool, error) {
+				dropped = append(dropped, position)
+				return true, nil
+			})
+		}
+	}
+	return dropped
+}
+
+// decodeIndexSnapshot returns the indexes of the snapshot file by namespace id, the index of
+// the default namespace is always returned, and the checkpoint of the snapshot.
+func (db *DB) decodeIndexSnapshot(data []byte) (map[uint32]index.Indexer, []byte, error) {
+	if len(data) < indexSnapshotHeaderSize {
+		return nil, nil, errInvalidIndexSnapshot
+	}
+	checksum := binary.LittleEndian.Uint32(data)
+	payload := data[indexSnapshotHeaderSize:]
+	if int(binary.LittleEndian.Uint32(data[4:])) != len(payload) || crc32.ChecksumIEEE(payload) != checksum {
+		return nil, nil, errInvalidIndexSnapshot
+	}
+	payload, err := db.cipher.openHintRecord(payload)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// readBytes reads a uvarint length prefixed field from the payload.
+	readBytes := func() ([]byte, error) {
+		length, n := binary.Uvarint(payload)
+		if n <= 0 || uint64(len(payload)-n) < length {
+			return nil, errInvalidIndexSnapshot
+		}
+		field := payload[n : n+int(length)]
+		payload = payload[n+int(length):]
+		return field, nil
+	}
+	checkpoint, err := readBytes()
+	if err != nil {
+		return nil, nil, err
+	}
+	keys, n := binary.Uvarint(payload)
+	if n <= 0 {
+		return nil, nil, errInvalidIndexSnapshot
+	}
+	payload = payload[n:]
+
+	indexes := map[uint32]index.Indexer{defaultNamespaceID: index.NewIndexer(db.options.memoryIndexOptions())}
+	for i := uint64(0); i < keys; i++ {
+		hint, err := readBytes()
+		if err != nil {
+			return nil, nil, err
+		}
+		namespace, key, position := decodeNamespaceHintRecord(hint)
+		idx, ok := indexes[namespace]
+		if !ok {
+			idx = index.NewIndexer(db.options.memoryIndexOptions())
+			indexes[namespace] = idx
+		}
+		idx.Put(key, position)
+	}
+	if len(payload) != 0 {
+		return nil, nil, errInvalidIndexSnapshot
+	}
+	return indexes, checkpoint, nil
+}
+
+// runIndexSnapshots writes the index snapshot every interval until the db is closed.
+func (db *DB) runIndexSnapshots(interval time.Duration) {
+	defer close(db.indexSnapshotDone)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			// a failed snapshot only makes the next Open slower, it is retried at the next tick.
+			_ = db.SaveIndexSnapshot()
+		case <-db.indexSnapshotStop:
+			return
+		}
+	}
+}
+
+// stopIndexSnapshots stops runIndexSnapshots and waits for the snapshot being written.
+func (db *DB) stopIndexSnapshots() {
+	db.indexSnapshotStopOnce.Do(func() {
+		if db.indexSnapshotStop != nil {
+			close(db.indexSnapshotStop)
+			<-db.indexSnapshotDone
+		}
+	})
+}
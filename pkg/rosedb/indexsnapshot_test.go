@@ -0,0 +1,125 @@
+// Copyright 2024 Joy <joyssss94@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package rosedb
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/JoyZF/zoom/pkg/wal"
+	"github.com/JoyZF/zoom/utils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_IndexSnapshot(t *testing.T) {
+	for _, setup := range []func(options *Options){
+		func(options *Options) {},
+		func(options *Options) { options.KeyProvider = newTestKeyProvider(t, 1, 1) },
+	} {
+		options := DefaultOptions
+		options.SegmentSize = 64 * wal.KB
+		setup(&options)
+		db, err := Open(options)
+		assert.Nil(t, err)
+
+		values := make(map[string][]byte)
+		put := func(from, to int) {
+			for i := from; i < to; i++ {
+				key, value := utils.GetTestKey(i), utils.RandomValue(128)
+				values[string(key)] = value
+				assert.Nil(t, db.Put(key, value))
+			}
+		}
+		check := func() {
+			assert.Equal(t, len(values), db.Stat().KeysNum)
+			for key, value := range values {
+				val, err := db.Get([]byte(key))
+				assert.Nil(t, err)
+				assert.Equal(t, value, val)
+			}
+		}
+
+		put(0, 2000)
+		for i := 0; i < 100; i++ {
+			assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+			delete(values, string(utils.GetTestKey(i)))
+		}
+		assert.Nil(t, db.SaveIndexSnapshot())
+		put(2000, 3000)
+		crashDB(db)
+
+		// only the WAL written after the snapshot is replayed,
+		// so the first segment is not read even if it is corrupted.
+		segment := wal.SegmentFileName(options.DirPath, dataFileNameSuffix, 1)
+		original, err := os.ReadFile(segment)
+		assert.Nil(t, err)
+		assert.Nil(t, os.WriteFile(segment, make([]byte, len(original)), 0644))
+		db, err = Open(options)
+		assert.Nil(t, err)
+		assert.Equal(t, len(values), db.Stat().KeysNum)
+		for i := 2000; i < 3000; i++ {
+			val, err := db.Get(utils.GetTestKey(i))
+			assert.Nil(t, err)
+			assert.Equal(t, values[string(utils.GetTestKey(i))], val)
+		}
+		crashDB(db)
+		assert.Nil(t, os.WriteFile(segment, original, 0644))
+
+		// a corrupted snapshot falls back to a full replay
+		path := filepath.Join(options.DirPath, indexSnapshotFileName)
+		snapshot, err := os.ReadFile(path)
+		assert.Nil(t, err)
+		snapshot[len(snapshot)/2] ^= 0xff
+		assert.Nil(t, os.WriteFile(path, snapshot, 0644))
+		db, err = Open(options)
+		assert.Nil(t, err)
+		check()
+
+		// a snapshot taken before the merge is stale after it
+		assert.Nil(t, db.SaveIndexSnapshot())
+		for i := 100; i < 1000; i++ {
+			assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+			delete(values, string(utils.GetTestKey(i)))
+		}
+		assert.Nil(t, db.Merge(false))
+		crashDB(db)
+		db, err = Open(options)
+		assert.Nil(t, err)
+		check()
+		destroyDB(db)
+	}
+}
+
+func TestDB_IndexSnapshot_Interval(t *testing.T) {
+	options := DefaultOptions
+	options.IndexSnapshotInterval = 10 * time.Millisecond
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer func() {
+		destroyDB(db)
+	}()
+
+	path := filepath.Join(options.DirPath, indexSnapshotFileName)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+	assert.Eventually(t, func() bool {
+		_, err := os.Stat(path)
+		return err == nil
+	}, time.Second, 5*time.Millisecond)
+
+	// the snapshot is also written when the db is closed
+	assert.Nil(t, db.Put(utils.GetTestKey(100), utils.RandomValue(128)))
+	assert.Nil(t, db.Close())
+	db, err = Open(options)
+	assert.Nil(t, err)
+	start, _, loaded := db.loadIndexSnapshot()
+	assert.True(t, loaded)
+	assert.Equal(t, db.walPosition, start)
+	assert.Equal(t, 101, db.Stat().KeysNum)
+}
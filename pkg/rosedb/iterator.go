@@ -0,0 +1,334 @@
+// Copyright 2024 Joy <joyssss94@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package rosedb
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/JoyZF/zoom/pkg/rosedb/index"
+	"github.com/JoyZF/zoom/pkg/wal"
+)
+
+// IteratorOptions is the options for the iterator.
+type IteratorOptions struct {
+	// Prefix filters the keys by prefix.
+	Prefix []byte
+
+	// Reverse indicates whether the iterator is reversed,
+	// false is forward, true is backward.
+	Reverse bool
+
+	// Start is the inclusive lower bound of the keys, nil means no lower bound.
+	Start []byte
+
+	// End is the exclusive upper bound of the keys, nil means no upper bound.
+	End []byte
+
+	// ContinueOnError skips the records that can not be read from the data files,
+	// otherwise the iterator stops at the first error, which is returned by Err.
+	ContinueOnError bool
+}
+
+// Iterator is a pull style cursor over the keys of the db.
+//
+// It walks a point-in-time view of the index, so it does not hold the db lock
+// between calls and writes done after NewIterator are not visible to it.
+// Values are read from the data files only when the cursor lands on a key,
+// deleted and expired records are skipped.
+type Iterator struct {
+	indexIter  index.IndexIterator
+	readRecord func(pos *wal.ChunkPosition) (*LogRecord, error)
+	release    func() // called by Close, nil if there is nothing to release
+	options    IteratorOptions
+	lowerBound []byte
+	upperBound []byte
+	record     *LogRecord
+	valid      bool
+	err        error
+	closed     bool
+}
+
+// NewIterator returns a new iterator positioned at the first key.
+// The data files it reads are kept open until it is closed, even if Merge replaces them,
+// so the iterator must be closed after use.
+func (db *DB) NewIterator(options IteratorOptions) (*Iterator, error) {
+	return db.newPinnedIterator(func() (index.Indexer, error) {
+		return db.index, nil
+	}, options)
+}
+
+// newPinnedIterator returns an iterator over a point-in-time view of the index returned by indexFn,
+// which is called with db.mu held, and reads the current data files. Merge reuses the segment ids
+// of the data files it replaces, so they are referenced like the ones of a snapshot
+// until the iterator is closed, otherwise the positions in the view could point to other records.
+func (db *DB) newPinnedIterator(indexFn func() (index.Indexer, error), options IteratorOptions) (*Iterator, error) {
+	db.mu.Lock()
+	if db.closed {
+		db.mu.Unlock()
+		return nil, ErrDBClosed
+	}
+	idx, err := indexFn()
+	if err != nil {
+		db.mu.Unlock()
+		return nil, err
+	}
+	dataFiles := db.dataFiles
+	db.snapshots[dataFiles]++
+	indexIter := idx.Iterator(options.Reverse)
+	db.mu.Unlock()
+
+	// the iterator reads the first record as it is created, without db.mu.
+	iter := newIterator(indexIter, func(pos *wal.ChunkPosition) (*LogRecord, error) {
+		return db.readRecord(dataFiles, pos)
+	}, options)
+	iter.release = func() {
+		db.mu.Lock()
+		defer db.mu.Unlock()
+		if !db.closed {
+			db.releaseDataFiles(dataFiles)
+		}
+	}
+	return iter, nil
+}
+
+func newIterator(
+	indexIter index.IndexIterator,
+	readRecord func(pos *wal.ChunkPosition) (*LogRecord, error),
+	options IteratorOptions,
+) *Iterator {
+	iter := &Iterator{
+		indexIter:  indexIter,
+		readRecord: readRecord,
+		options:    options,
+	}
+	iter.lowerBound, iter.upperBound = keyBounds(options.Prefix, options.Start, options.End)
+	iter.rewind()
+	return iter
+}
+
+// keyBounds returns the inclusive lower bound and the exclusive upper bound
+// of the keys with the given prefix in the range [start, end), nil means unbounded.
+func keyBounds(prefix, start, end []byte) ([]byte, []byte) {
+	lowerBound, upperBound := start, end
+	if len(prefix) > 0 {
+		if bytes.Compare(prefix, lowerBound) > 0 {
+			lowerBound = prefix
+		}
+		if prefixEnd := prefixEnd(prefix); prefixEnd != nil &&
+			(upperBound == nil || bytes.Compare(prefixEnd, upperBound) < 0) {
+			upperBound = prefixEnd
+		}
+	}
+	return lowerBound, upperBound
+}
+
+// keyInBounds reports whether the key is in the bounds returned by keyBounds.
+func keyInBounds(key, lowerBound, upperBound []byte) bool {
+	if lowerBound != nil && bytes.Compare(key, lowerBound) < 0 {
+		return false
+	}
+	if upperBound != nil && bytes.Compare(key, upperBound) >= 0 {
+		return false
+	}
+	return true
+}
+
+// Rewind moves the cursor to the first key in iteration order.
+func (it *Iterator) Rewind() {
+	if it.closed {
+		return
+	}
+	it.err = nil
+	it.rewind()
+}
+
+func (it *Iterator) rewind() {
+	if it.options.Reverse {
+		it.seekReverse(it.upperBound)
+	} else {
+		it.seekForward(it.lowerBound)
+	}
+}
+
+// Seek moves the cursor to the first key >= the given key,
+// or to the last key <= the given key if the iterator is reversed.
+func (it *Iterator) Seek(key []byte) {
+	if it.closed {
+		return
+	}
+	it.err = nil
+	if it.options.Reverse {
+		if it.upperBound != nil && bytes.Compare(key, it.upperBound) >= 0 {
+			it.seekReverse(it.upperBound)
+			return
+		}
+		it.indexIter.Seek(key)
+		it.settle(true)
+	} else {
+		if bytes.Compare(key, it.lowerBound) < 0 {
+			key = it.lowerBound
+		}
+		it.seekForward(key)
+	}
+}
+
+// seekForward moves the cursor to the first valid key >= key.
+func (it *Iterator) seekForward(key []byte) {
+	if key == nil {
+		it.indexIter.Rewind()
+	} else {
+		it.indexIter.Seek(key)
+	}
+	it.settle(true)
+}
+
+// seekReverse moves the cursor to the last valid key < key,
+// nil key means the last key of the index.
+func (it *Iterator) seekReverse(key []byte) {
+	if key == nil {
+		it.indexIter.Rewind()
+	} else {
+		it.indexIter.Seek(key)
+		if it.indexIter.Valid() && bytes.Equal(it.indexIter.Key(), key) {
+			it.indexIter.Next()
+		}
+	}
+	it.settle(true)
+}
+
+// Next moves the cursor to the next key in iteration order.
+func (it *Iterator) Next() {
+	if !it.Valid() {
+		return
+	}
+	it.indexIter.Next()
+	it.settle(true)
+}
+
+// Prev moves the cursor to the previous key in iteration order.
+func (it *Iterator) Prev() {
+	if !it.Valid() {
+		return
+	}
+	it.indexIter.Prev()
+	it.settle(false)
+}
+
+// settle moves the index cursor until it points to a live record within the bounds,
+// forward is the direction of Next.
+func (it *Iterator) settle(forward bool) {
+	it.record, it.valid = nil, false
+	for it.indexIter.Valid() {
+		// keys are sorted, so nothing behind an out of bounds key is in bounds either.
+		if !keyInBounds(it.indexIter.Key(), it.lowerBound, it.upperBound) {
+			return
+		}
+
+		record, err := it.readRecord(it.indexIter.Value())
+		if err != nil && !it.options.ContinueOnError {
+			it.err = err
+			return
+		}
+		if record != nil {
+			it.record, it.valid = record, true
+			return
+		}
+
+		if forward {
+			it.indexIter.Next()
+		} else {
+			it.indexIter.Prev()
+		}
+	}
+}
+
+// Valid reports whether the cursor points to a key.
+func (it *Iterator) Valid() bool {
+	return !it.closed && it.valid
+}
+
+// Key returns the key at the cursor, or nil if the cursor is not valid.
+func (it *Iterator) Key() []byte {
+	if !it.Valid() {
+		return nil
+	}
+	return it.indexIter.Key()
+}
+
+// Value returns the value at the cursor, or nil if the cursor is not valid.
+func (it *Iterator) Value() []byte {
+	if !it.Valid() {
+		return nil
+	}
+	return it.record.Value
+}
+
+// Expire returns the expiration time of the key at the cursor in unix nanoseconds,
+// 0 if the key has no ttl or the cursor is not valid.
+func (it *Iterator) Expire() int64 {
+	if !it.Valid() {
+		return 0
+	}
+	return it.record.Expire
+}
+
+// Err returns the error that stopped the iteration, if any.
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+// Close releases the resources held by the iterator.
+func (it *Iterator) Close() {
+	if it.closed {
+		return
+	}
+	it.indexIter.Close()
+	it.record, it.valid = nil, false
+	it.closed = true
+	if it.release != nil {
+		it.release()
+	}
+}
+
+// readRecord reads the record at the given position from the data files and decompresses its value,
+// it returns nil if the record is deleted or expired.
+func (db *DB) readRecord(dataFiles *wal.WAL, pos *wal.ChunkPosition) (*LogRecord, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.closed {
+		return nil, ErrDBClosed
+	}
+	chunk, err := dataFiles.Read(pos)
+	if err != nil {
+		return nil, err
+	}
+	record, err := decodeLogRecord(chunk, db.cipher)
+	if err != nil {
+		return nil, err
+	}
+	if record.Type == LogRecordDeleted || record.IsExpired(time.Now().UnixNano()) {
+		return nil, nil
+	}
+	if err = db.loadValue(record); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// prefixEnd returns the smallest key that is greater than all keys with the given prefix,
+// or nil if there is no such key.
+func prefixEnd(prefix []byte) []byte {
+	end := make([]byte, len(prefix))
+	copy(end, prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
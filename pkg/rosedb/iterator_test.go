@@ -0,0 +1,189 @@
+// Copyright 2024 Joy <joyssss94@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package rosedb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/JoyZF/zoom/utils"
+)
+
+func putIteratorTestData(t *testing.T, db *DB) {
+	for _, k := range []string{"apple", "banana", "cherry", "date", "grape", "kiwi"} {
+		err := db.Put([]byte(k), []byte("value-"+k))
+		assert.Nil(t, err)
+	}
+}
+
+func collectKeys(iter *Iterator) []string {
+	var keys []string
+	for ; iter.Valid(); iter.Next() {
+		keys = append(keys, string(iter.Key()))
+	}
+	return keys
+}
+
+func TestDB_Iterator_Normal(t *testing.T) {
+	db, err := Open(DefaultOptions)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	// empty db
+	iter, err := db.NewIterator(IteratorOptions{})
+	assert.Nil(t, err)
+	assert.False(t, iter.Valid())
+	iter.Close()
+
+	putIteratorTestData(t, db)
+
+	iter, err = db.NewIterator(IteratorOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, "apple", string(iter.Key()))
+	assert.Equal(t, "value-apple", string(iter.Value()))
+	assert.Equal(t, []string{"apple", "banana", "cherry", "date", "grape", "kiwi"}, collectKeys(iter))
+	assert.Nil(t, iter.Err())
+
+	iter.Seek([]byte("coconut"))
+	assert.Equal(t, "date", string(iter.Key()))
+	iter.Prev()
+	assert.Equal(t, "cherry", string(iter.Key()))
+	iter.Rewind()
+	assert.Equal(t, "apple", string(iter.Key()))
+	iter.Close()
+	assert.False(t, iter.Valid())
+
+	iter, err = db.NewIterator(IteratorOptions{Reverse: true})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"kiwi", "grape", "date", "cherry", "banana", "apple"}, collectKeys(iter))
+	iter.Seek([]byte("coconut"))
+	assert.Equal(t, "cherry", string(iter.Key()))
+	iter.Prev()
+	assert.Equal(t, "date", string(iter.Key()))
+	iter.Close()
+}
+
+func TestDB_Iterator_Range_Prefix(t *testing.T) {
+	db, err := Open(DefaultOptions)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	putIteratorTestData(t, db)
+	for _, k := range []string{"user:1", "user:2", "user:3"} {
+		assert.Nil(t, db.Put([]byte(k), []byte(k)))
+	}
+
+	iter, err := db.NewIterator(IteratorOptions{Start: []byte("banana"), End: []byte("grape")})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"banana", "cherry", "date"}, collectKeys(iter))
+	iter.Close()
+
+	iter, err = db.NewIterator(IteratorOptions{Start: []byte("banana"), End: []byte("grape"), Reverse: true})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"date", "cherry", "banana"}, collectKeys(iter))
+	iter.Seek([]byte("zzz"))
+	assert.Equal(t, "date", string(iter.Key()))
+	iter.Close()
+
+	iter, err = db.NewIterator(IteratorOptions{Prefix: []byte("user:")})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"user:1", "user:2", "user:3"}, collectKeys(iter))
+	iter.Close()
+
+	iter, err = db.NewIterator(IteratorOptions{Prefix: []byte("user:"), Reverse: true})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"user:3", "user:2", "user:1"}, collectKeys(iter))
+	iter.Close()
+}
+
+func TestDB_Iterator_Skip_Deleted_Expired(t *testing.T) {
+	db, err := Open(DefaultOptions)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	putIteratorTestData(t, db)
+	assert.Nil(t, db.PutWithTTL([]byte("cherry"), []byte("value"), 100*time.Millisecond))
+	assert.Nil(t, db.Delete([]byte("date")))
+
+	iter, err := db.NewIterator(IteratorOptions{})
+	assert.Nil(t, err)
+	defer iter.Close()
+
+	// writes after the iterator is created are not visible to it.
+	assert.Nil(t, db.Put([]byte("banana2"), []byte("value")))
+
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, []string{"apple", "banana", "grape", "kiwi"}, collectKeys(iter))
+}
+
+func TestDB_Iterator_Expire(t *testing.T) {
+	db, err := Open(DefaultOptions)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+	assert.Nil(t, db.Put([]byte("apple"), []byte("value")))
+	assert.Nil(t, db.PutWithTTL([]byte("banana"), []byte("value"), time.Hour))
+
+	iter, err := db.NewIterator(IteratorOptions{})
+	assert.Nil(t, err)
+	defer iter.Close()
+	assert.Zero(t, iter.Expire())
+	iter.Next()
+	assert.Equal(t, []byte("banana"), iter.Key())
+	assert.InDelta(t, time.Now().Add(time.Hour).UnixNano(), iter.Expire(), float64(time.Minute))
+	iter.Next()
+	assert.Zero(t, iter.Expire())
+}
+
+func TestDB_Iterator_Closed_DB(t *testing.T) {
+	db, err := Open(DefaultOptions)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+	putIteratorTestData(t, db)
+
+	iter, err := db.NewIterator(IteratorOptions{})
+	assert.Nil(t, err)
+	defer iter.Close()
+
+	assert.Nil(t, db.Close())
+	iter.Next()
+	assert.False(t, iter.Valid())
+	assert.Equal(t, ErrDBClosed, iter.Err())
+
+	_, err = db.NewIterator(IteratorOptions{})
+	assert.Equal(t, ErrDBClosed, err)
+}
+
+func TestDB_Iterator_Merge(t *testing.T) {
+	db, err := Open(DefaultOptions)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	values := make(map[string][]byte)
+	for i := 0; i < 1000; i++ {
+		key, value := utils.GetTestKey(i), utils.RandomValue(64)
+		assert.Nil(t, db.Put(key, value))
+		if i%2 == 0 {
+			assert.Nil(t, db.Delete(key))
+		} else {
+			values[string(key)] = value
+		}
+	}
+
+	iter, err := db.NewIterator(IteratorOptions{})
+	assert.Nil(t, err)
+	// merge rewrites the segments with the same ids, the iterator keeps reading the old ones.
+	assert.Nil(t, db.Merge(true))
+	var count int
+	for ; iter.Valid(); iter.Next() {
+		assert.Equal(t, values[string(iter.Key())], iter.Value(), string(iter.Key()))
+		count++
+	}
+	assert.Nil(t, iter.Err())
+	assert.Equal(t, len(values), count)
+	iter.Close()
+	assert.Empty(t, db.snapshots)
+}
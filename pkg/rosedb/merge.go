@@ -5,6 +5,7 @@
 package rosedb
 
 import (
+	"context"
 	"encoding/binary"
 	"fmt"
 	"io"
@@ -14,9 +15,8 @@ import (
 	"sync/atomic"
 	"time"
 
-	"github.com/JoyZF/wal"
-
 	"github.com/JoyZF/zoom/pkg/rosedb/index"
+	"github.com/JoyZF/zoom/pkg/wal"
 
 	"github.com/valyala/bytebufferpool"
 )
@@ -26,27 +26,95 @@ const (
 	mergeFinishedBatchID = 0
 )
 
+// MergeOptions specifies the options of MergeWithOptions.
+type MergeOptions struct {
+	// ReopenAfterDone specifies whether the original files are replaced by the merged ones,
+	// and the index is rebuilt after the merge completes.
+	ReopenAfterDone bool
+
+	// BytesPerSecond limits the bytes read from and written to the disk per second by the merge,
+	// so it does not hurt the latency of the other operations. 0 means no limit.
+	BytesPerSecond int64
+
+	// Progress is called after each segment file is merged.
+	Progress func(progress MergeProgress)
+}
+
+// MergeProgress is the progress of the running merge, or of the last one if none is running.
+type MergeProgress struct {
+	Running       bool
+	SegmentsDone  int
+	SegmentsTotal int
+	BytesRead     int64 // bytes of the records read from the data files
+	BytesWritten  int64 // bytes of the records written to the merged data files and the hint file
+}
+
+// mergeThrottle limits the IO rate of a merge, see MergeOptions.BytesPerSecond.
+type mergeThrottle struct {
+	bytesPerSecond int64
+	start          time.Time
+	bytes          int64
+}
+
+// the shortest pause of a throttled merge, the shorter ones are put off until they add up.
+const minMergeThrottleDelay = 10 * time.Millisecond
+
+// wait blocks until the n bytes just read or written are within the rate limit.
+// It returns the error of ctx if it is done.
+func (t *mergeThrottle) wait(ctx context.Context, n int64) error {
+	t.bytes += n
+	if t.bytesPerSecond <= 0 {
+		return ctx.Err()
+	}
+	delay := time.Duration(float64(t.bytes)/float64(t.bytesPerSecond)*float64(time.Second)) - time.Since(t.start)
+	if delay < minMergeThrottleDelay {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
 // Merge merges all the data files in the database.
 // It will iterate all the data files, find the valid data,
 // and rewrite the data to the new data file.
 //
 // Merge operation maybe a very time-consuming operation when the database is large.
-// So it is recommended to perform this operation when the database is idle.
+// So it is recommended to perform this operation when the database is idle,
+// or to limit its IO rate with Options.MergeBytesPerSecond.
 //
 // If reopenAfterDone is true, the original file will be replaced by the merge file,
 // and db's index will be rebuilt after the merge completes.
 func (db *DB) Merge(reopenAfterDone bool) error {
-	if err := db.doMerge(); err != nil {
+	return db.MergeWithOptions(context.Background(), MergeOptions{
+		ReopenAfterDone: reopenAfterDone,
+		BytesPerSecond:  db.options.MergeBytesPerSecond,
+	})
+}
+
+// MergeWithOptions is like Merge, with the IO rate limit and progress callback in options.
+//
+// The merge stops and returns the error of ctx once it is done. The merge directory is removed then,
+// so the db is left as if the merge never ran.
+func (db *DB) MergeWithOptions(ctx context.Context, options MergeOptions) error {
+	if err := db.doMerge(ctx, options); err != nil {
 		return err
 	}
-	if !reopenAfterDone {
+	if !options.ReopenAfterDone {
 		return nil
 	}
 
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
-	// close current files
+	// close current files.
+	// If live snapshots still reference the data files, they stay open, so the snapshots
+	// can keep reading the old segments after the merged ones replace them.
 	_ = db.closeFiles()
 
 	// replace original file
@@ -61,7 +129,9 @@ func (db *DB) Merge(reopenAfterDone bool) error {
 	}
 
 	// discard the old index first.
-	db.index = index.NewIndexer()
+	if err = db.resetIndex(); err != nil {
+		return err
+	}
 	// rebuild index
 	if err = db.loadIndex(); err != nil {
 		return err
@@ -70,7 +140,24 @@ func (db *DB) Merge(reopenAfterDone bool) error {
 	return nil
 }
 
-func (db *DB) doMerge() error {
+// MergeProgress returns the progress of the running merge, or of the last one if none is running.
+func (db *DB) MergeProgress() MergeProgress {
+	db.mergeProgressMu.Lock()
+	defer db.mergeProgressMu.Unlock()
+	return db.mergeProgress
+}
+
+// setMergeProgress records the progress of the running merge, and reports it to the callback in options.
+func (db *DB) setMergeProgress(progress MergeProgress, options MergeOptions) {
+	db.mergeProgressMu.Lock()
+	db.mergeProgress = progress
+	db.mergeProgressMu.Unlock()
+	if options.Progress != nil {
+		options.Progress(progress)
+	}
+}
+
+func (db *DB) doMerge(ctx context.Context, options MergeOptions) (err error) {
 	db.mu.Lock()
 	// check if the database is closed
 	if db.closed {
@@ -93,9 +180,33 @@ func (db *DB) doMerge() error {
 	defer atomic.StoreUint32(&db.mergeRunning, 0)
 
 	prevActiveSegId := db.dataFiles.ActiveSegmentID()
-	// rotate the write-ahead log, create a new active segment file.
-	// so all the older segment files will be merged.
-	if err := db.dataFiles.OpenNewActiveSegment(); err != nil {
+	// the segment files holding the changes not acknowledged by the change feed consumers are kept.
+	retention, retained, err := db.changeRetention()
+	if err != nil {
+		db.mu.Unlock()
+		return err
+	}
+	if retained && retention < prevActiveSegId {
+		mergeFinSegmentId, err := getMergeFinSegmentId(db.options.DirPath)
+		if err != nil {
+			db.mu.Unlock()
+			return err
+		}
+		// nothing can be merged since the last merge.
+		if retention <= mergeFinSegmentId {
+			db.mu.Unlock()
+			return nil
+		}
+		// the segments after the retained one are left as they are, so there is no need to rotate.
+		prevActiveSegId = retention
+	} else if err := db.dataFiles.OpenNewActiveSegment(); err != nil {
+		// rotate the write-ahead log, create a new active segment file.
+		// so all the older segment files will be merged.
+		db.mu.Unlock()
+		return err
+	}
+	segmentIds, err := db.walSegmentIds()
+	if err != nil {
 		db.mu.Unlock()
 		return err
 	}
@@ -105,6 +216,29 @@ func (db *DB) doMerge() error {
 	// Our Merge operation will only read from the older segment files.
 	db.mu.Unlock()
 
+	progress := MergeProgress{Running: true}
+	for _, id := range segmentIds {
+		if id <= prevActiveSegId {
+			progress.SegmentsTotal++
+		}
+	}
+	db.setMergeProgress(progress, options)
+	defer func() {
+		progress.Running = false
+		if err == nil {
+			progress.SegmentsDone = progress.SegmentsTotal
+		}
+		db.setMergeProgress(progress, options)
+	}()
+
+	// a merge which is not finished, because it is cancelled or failed, is removed,
+	// so loadMergeFiles never sees it. It runs after the mergeDB is closed.
+	defer func() {
+		if err != nil {
+			_ = os.RemoveAll(mergeDirPath(db.options.DirPath))
+		}
+	}()
+
 	// open a merge db to write the data to the new data file.
 	// delete the merge directory if it exists and create a new one.
 	mergeDB, err := db.openMergeDB()
@@ -118,9 +252,11 @@ func (db *DB) doMerge() error {
 	buf := bytebufferpool.Get()
 	now := time.Now().UnixNano()
 	defer bytebufferpool.Put(buf)
+	throttle := &mergeThrottle{bytesPerSecond: options.BytesPerSecond, start: time.Now()}
 
 	// iterate all the data files, and write the valid data to the new data file.
 	reader := db.dataFiles.NewReaderWithMax(prevActiveSegId)
+	var currentSegId wal.SegmentID
 	for {
 		buf.Reset()
 		chunk, position, err := reader.Next()
@@ -130,30 +266,77 @@ func (db *DB) doMerge() error {
 			}
 			return err
 		}
-		record := decodeLogRecord(chunk)
+		if currentSegId != 0 && position.SegmentId != currentSegId {
+			progress.SegmentsDone++
+			db.setMergeProgress(progress, options)
+		}
+		currentSegId = position.SegmentId
+		progress.BytesRead += int64(position.ChunkSize)
+		if err = throttle.wait(ctx, int64(position.ChunkSize)); err != nil {
+			return err
+		}
+
+		record, err := decodeLogRecord(chunk, db.cipher)
+		if err != nil {
+			return err
+		}
 		// Only handle the normal log record, LogRecordDeleted and LogRecordBatchFinished
 		// will be ignored, because they are not valid data.
+		if record.Type == LogRecordNormal && record.blob && record.Expire > 0 && record.Expire <= now {
+			// the expired value is dropped by the merge, so it becomes garbage in the blob files.
+			db.mu.RLock()
+			indexPos := db.namespacePosition(record.namespace, record.Key)
+			db.mu.RUnlock()
+			if indexPos != nil && positionEquals(indexPos, position) {
+				db.discardBlob(record)
+			}
+		}
 		if record.Type == LogRecordNormal && (record.Expire == 0 || record.Expire > now) {
 			db.mu.RLock()
-			indexPos := db.index.Get(record.Key)
+			indexPos := db.namespacePosition(record.namespace, record.Key)
 			db.mu.RUnlock()
 			if indexPos != nil && positionEquals(indexPos, position) {
 				// clear the batch id of the record,
 				// all data after merge will be valid data, so the batch id should be 0.
 				record.BatchId = mergeFinishedBatchID
+				// rewrite the value with the codec in the current options,
+				// so the records written with an older codec are recompressed.
+				if record.codec != db.options.Compression {
+					if err := record.decompress(); err != nil {
+						return err
+					}
+				}
+				if record, err = db.compressRecord(record); err != nil {
+					return err
+				}
+				// the record is re-encrypted with the current key, if encryption is enabled.
+				encRecord, err := encodeLogRecord(record, mergeDB.encodeHeader, buf, mergeDB.cipher)
+				if err != nil {
+					return err
+				}
 				// Since the mergeDB will never be used for any read or write operations,
 				// it is not necessary to update the index.
-				newPosition, err := mergeDB.dataFiles.Write(encodeLogRecord(record, mergeDB.encodeHeader, buf))
+				newPosition, err := mergeDB.dataFiles.Write(encRecord)
 				if err != nil {
 					return err
 				}
+				progress.BytesWritten += int64(newPosition.ChunkSize)
 				// And now we should write the new position to the write-ahead log,
 				// which is so-called HINT FILE in bitcask paper.
 				// The HINT FILE will be used to rebuild the index quickly when the database is restarted.
-				_, err = mergeDB.hintFile.Write(encodeHintRecord(record.Key, newPosition))
+				hintRecord, err := mergeDB.cipher.sealHintRecord(
+					encodeNamespaceHintRecord(record.namespace, record.Key, newPosition))
+				if err != nil {
+					return err
+				}
+				hintPosition, err := mergeDB.hintFile.Write(hintRecord)
 				if err != nil {
 					return err
 				}
+				progress.BytesWritten += int64(hintPosition.ChunkSize)
+				if err = throttle.wait(ctx, int64(newPosition.ChunkSize+hintPosition.ChunkSize)); err != nil {
+					return err
+				}
 			}
 		}
 	}
@@ -188,6 +371,14 @@ func (db *DB) openMergeDB() (*DB, error) {
 	// we don't need to use the original sync policy,
 	// because we can sync the data file manually after the merge operation is completed.
 	options.Sync, options.BytesPerSync = false, 0
+	// the records pointing to the blob files are copied as they are, the values are not moved.
+	options.BlobThreshold = 0
+	// the index of the mergeDB is never used.
+	options.IndexType, options.IndexSnapshotInterval = index.BTree, 0
+	// the mergeDB is only written by the merge, it is never merged automatically nor watched,
+	// and the expired keys are skipped by the merge instead.
+	options.MergePolicy, options.AutoMergeCronExpr = nil, ""
+	options.WatchQueueSize, options.ActiveExpireInterval = 0, 0
 	options.DirPath = mergePath
 	mergeDB, err := Open(options)
 	if err != nil {
@@ -282,6 +473,42 @@ func decodeHintRecord(buf []byte) ([]byte, *wal.ChunkPosition) {
 	}
 }
 
+// A plain hint record starts with the segment id, which is never 0,
+// so the other kinds of hint records start with hintEscape, followed by their kind.
+const (
+	hintEscape = 0x00
+	// hintKindEncrypted is the kind of the hint records encrypted by sealHintRecord.
+	hintKindEncrypted = 0x01
+	// hintKindNamespace is the kind of the hint records of the namespaces other than the default one.
+	hintKindNamespace = 0x02
+)
+
+// +----------+--------+-------------+-------------+
+// |  escape  |  kind  |  namespace  | hint record |
+// +----------+--------+-------------+-------------+
+//
+//	1 byte     1 byte     uvarint
+//
+// The hint records of the default namespace are written as they are by encodeHintRecord.
+func encodeNamespaceHintRecord(namespace uint32, key []byte, pos *wal.ChunkPosition) []byte {
+	hint := encodeHintRecord(key, pos)
+	if namespace == defaultNamespaceID {
+		return hint
+	}
+	buf := binary.AppendUvarint([]byte{hintEscape, hintKindNamespace}, uint64(namespace))
+	return append(buf, hint...)
+}
+
+func decodeNamespaceHintRecord(buf []byte) (uint32, []byte, *wal.ChunkPosition) {
+	if len(buf) < 2 || buf[0] != hintEscape || buf[1] != hintKindNamespace {
+		key, pos := decodeHintRecord(buf)
+		return defaultNamespaceID, key, pos
+	}
+	namespace, n := binary.Uvarint(buf[2:])
+	key, pos := decodeHintRecord(buf[2+n:])
+	return uint32(namespace), key, pos
+}
+
 func encodeMergeFinRecord(segmentId wal.SegmentID) []byte {
 	buf := make([]byte, 4)
 	binary.LittleEndian.PutUint32(buf, uint32(segmentId))
@@ -327,6 +554,10 @@ func loadMergeFiles(dirPath string) error {
 	if err != nil {
 		return err
 	}
+	// the merge is not finished, the files in the merge directory are discarded.
+	if mergeFinSegmentId == 0 {
+		return nil
+	}
 	// now we get the merge finished segment id, so all the segment id less than the merge finished segment id
 	// should be moved to the original data directory, and the original data files should be deleted.
 	for fileId := wal.SegmentID(1); fileId <= mergeFinSegmentId; fileId++ {
@@ -407,10 +638,18 @@ func (db *DB) loadIndexFromHintFile() error {
 			return err
 		}
 
-		key, position := decodeHintRecord(chunk)
+		if chunk, err = db.cipher.openHintRecord(chunk); err != nil {
+			return err
+		}
+		namespace, key, position := decodeNamespaceHintRecord(chunk)
 		// All the hint records are valid because it is generated by the merge operation.
-		// So just put them into the index without checking.
-		db.index.Put(key, position)
+		// So just put them into the index without checking,
+		// unless the namespace has been dropped since.
+		if idx := db.namespaceIndex(namespace); idx != nil {
+			idx.Put(key, position)
+		} else {
+			db.garbage.add(position)
+		}
 	}
 	return nil
 }
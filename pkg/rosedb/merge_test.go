@@ -5,11 +5,14 @@
 package rosedb
 
 import (
+	"context"
 	"math/rand"
 	"os"
 	"sync"
 	"testing"
+	"time"
 
+	"github.com/JoyZF/zoom/pkg/wal"
 	"github.com/JoyZF/zoom/utils"
 
 	"github.com/stretchr/testify/assert"
@@ -308,3 +311,106 @@ func TestDB_Merge_Concurrent_Put(t *testing.T) {
 	assert.Equal(t, count, db.index.Size())
 
 }
+
+func TestDB_Merge_Throttle_Progress(t *testing.T) {
+	options := DefaultOptions
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	for i := 0; i < 10000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+
+	var progress []MergeProgress
+	start := time.Now()
+	err = db.MergeWithOptions(context.Background(), MergeOptions{
+		ReopenAfterDone: true,
+		BytesPerSecond:  4 * wal.MB,
+		Progress: func(p MergeProgress) {
+			progress = append(progress, p)
+		},
+	})
+	assert.Nil(t, err)
+
+	last := progress[len(progress)-1]
+	assert.False(t, last.Running)
+	assert.Equal(t, last.SegmentsTotal, last.SegmentsDone)
+	assert.True(t, last.BytesRead > 10000*128)
+	assert.True(t, last.BytesWritten > 10000*128)
+	// the records are read and written at no more than 4MB per second
+	expected := time.Duration(float64(last.BytesRead+last.BytesWritten) / (4 * wal.MB) * float64(time.Second))
+	assert.True(t, time.Since(start) >= expected-minMergeThrottleDelay, "%v %v", time.Since(start), expected)
+	assert.Equal(t, last, db.Stat().Merge)
+
+	for i := 0; i < 10000; i++ {
+		_, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+}
+
+func TestDB_Merge_Cancel(t *testing.T) {
+	options := DefaultOptions
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	for i := 0; i < 10000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	err = db.MergeWithOptions(ctx, MergeOptions{
+		ReopenAfterDone: true,
+		BytesPerSecond:  wal.MB,
+		Progress: func(p MergeProgress) {
+			if p.SegmentsDone == 0 && p.BytesRead == 0 {
+				cancel()
+			}
+		},
+	})
+	assert.Equal(t, context.Canceled, err)
+	assert.False(t, db.Stat().Merge.Running)
+
+	// the unfinished merge leaves nothing behind
+	_, err = os.Stat(mergeDirPath(options.DirPath))
+	assert.True(t, os.IsNotExist(err))
+	assert.Nil(t, db.Close())
+	db, err = Open(options)
+	assert.Nil(t, err)
+	assert.Equal(t, 10000, db.Stat().KeysNum)
+}
+
+func TestLoadMergeFiles_Unfinished(t *testing.T) {
+	options := DefaultOptions
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, db.Close())
+
+	// a merge directory without the merge finished file, like one left by a crash
+	mergeDB, err := db.openMergeDB()
+	assert.Nil(t, err)
+	hintRecord := encodeHintRecord([]byte("unfinished"), &wal.ChunkPosition{SegmentId: 1, BlockNumber: 100})
+	_, err = mergeDB.hintFile.Write(hintRecord)
+	assert.Nil(t, err)
+	assert.Nil(t, mergeDB.Close())
+
+	db, err = Open(options)
+	assert.Nil(t, err)
+	_, err = os.Stat(mergeDirPath(options.DirPath))
+	assert.True(t, os.IsNotExist(err))
+	// the hint file of the unfinished merge is not loaded
+	assert.Equal(t, 1000, db.Stat().KeysNum)
+	for i := 0; i < 1000; i++ {
+		_, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+}
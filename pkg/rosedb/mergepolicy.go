@@ -0,0 +1,259 @@
+// Copyright 2024 Joy <joyssss94@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package rosedb
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/robfig/cron/v3"
+
+	"github.com/JoyZF/zoom/utils"
+)
+
+// MergePolicy decides when the db is merged automatically, see Options.MergePolicy.
+type MergePolicy interface {
+	// ShouldMerge reports whether the db should be merged now.
+	// It is called every Options.MergePolicyInterval from a single goroutine, and not while a merge is running.
+	ShouldMerge(state MergeState) bool
+}
+
+// MergePolicyFunc is a function used as a MergePolicy.
+type MergePolicyFunc func(state MergeState) bool
+
+func (f MergePolicyFunc) ShouldMerge(state MergeState) bool {
+	return f(state)
+}
+
+// MergeState is the state of the db a MergePolicy decides on.
+type MergeState struct {
+	Now               time.Time
+	LastCheck         time.Time // when the policy was last evaluated, or the db was opened
+	LastMerge         time.Time // when the last auto merge finished, zero if none since the db was opened
+	DiskSize          int64     // size of the db directory
+	SealedSegments    int       // number of the segment files before the active one
+	SealedSize        int64     // size of the sealed segment files
+	ReclaimableBytes  int64     // garbage in the sealed segment files, which a merge reclaims
+	RequestsPerSecond float64   // reads and writes per second since the policy was last evaluated
+}
+
+// GarbageRatio returns the ratio of the reclaimable bytes to the size of the sealed segment files.
+func (s MergeState) GarbageRatio() float64 {
+	if s.SealedSize == 0 {
+		return 0
+	}
+	return float64(s.ReclaimableBytes) / float64(s.SealedSize)
+}
+
+// ThresholdMergePolicy merges the db once any of its thresholds is reached.
+// A zero threshold is disabled.
+type ThresholdMergePolicy struct {
+	// GarbageRatio is the ratio of the reclaimable bytes to the size of the sealed segment files.
+	GarbageRatio float64
+	// DiskSize is the size in bytes of the db directory.
+	// It only triggers the merge if the reclaimable bytes bring the size back below it,
+	// so a db whose live data is larger is not rewritten again at every evaluation.
+	DiskSize int64
+	// SealedSegments is the number of the sealed segment files.
+	SealedSegments int
+}
+
+func (p ThresholdMergePolicy) ShouldMerge(state MergeState) bool {
+	if p.GarbageRatio > 0 && state.ReclaimableBytes > 0 && state.GarbageRatio() >= p.GarbageRatio {
+		return true
+	}
+	if p.DiskSize > 0 && state.DiskSize >= p.DiskSize && state.DiskSize-state.ReclaimableBytes < p.DiskSize {
+		return true
+	}
+	return p.SealedSegments > 0 && state.SealedSegments >= p.SealedSegments
+}
+
+// CronMergePolicy merges the db at the times of a cron expression,
+// which is what Options.AutoMergeCronExpr does.
+// A time missed because the merge was deferred triggers the merge as soon as it is allowed.
+type CronMergePolicy struct {
+	mu       sync.Mutex
+	schedule cron.Schedule
+	next     time.Time
+}
+
+// NewCronMergePolicy returns a CronMergePolicy of the given cron expression,
+// in the form of Options.AutoMergeCronExpr.
+func NewCronMergePolicy(expr string) (*CronMergePolicy, error) {
+	schedule, err := cron.NewParser(cron.SecondOptional | cron.Minute | cron.Hour |
+		cron.Dom | cron.Month | cron.Dow | cron.Descriptor).Parse(expr)
+	if err != nil {
+		return nil, fmt.Errorf("databse auto merge cron expression is invalid, err: %s", err)
+	}
+	return &CronMergePolicy{schedule: schedule}, nil
+}
+
+func (p *CronMergePolicy) ShouldMerge(state MergeState) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.next.IsZero() {
+		p.next = p.schedule.Next(state.LastCheck)
+	}
+	if state.Now.Before(p.next) {
+		return false
+	}
+	p.next = p.schedule.Next(state.Now)
+	return true
+}
+
+// MergeWindow is a time of day range [Start, End) in the local time zone,
+// Start and End are the durations since midnight.
+// A window whose End is not after its Start spans midnight.
+type MergeWindow struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+// ParseMergeWindow parses a window in the form of "22:00-06:30".
+func ParseMergeWindow(s string) (MergeWindow, error) {
+	var startHour, startMin, endHour, endMin int
+	if _, err := fmt.Sscanf(s, "%d:%d-%d:%d", &startHour, &startMin, &endHour, &endMin); err != nil {
+		return MergeWindow{}, fmt.Errorf("invalid merge window %q: %v", s, err)
+	}
+	for _, v := range []struct{ hour, min int }{{startHour, startMin}, {endHour, endMin}} {
+		if v.hour < 0 || v.hour > 24 || v.min < 0 || v.min > 59 || (v.hour == 24 && v.min > 0) {
+			return MergeWindow{}, fmt.Errorf("invalid merge window %q", s)
+		}
+	}
+	return MergeWindow{
+		Start: time.Duration(startHour)*time.Hour + time.Duration(startMin)*time.Minute,
+		End:   time.Duration(endHour)*time.Hour + time.Duration(endMin)*time.Minute,
+	}, nil
+}
+
+// Contains reports whether the time of day of t is in the window.
+func (w MergeWindow) Contains(t time.Time) bool {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	offset := t.Sub(midnight)
+	if w.Start < w.End {
+		return offset >= w.Start && offset < w.End
+	}
+	return offset >= w.Start || offset < w.End
+}
+
+// ConstrainedMergePolicy restricts when the merges triggered by Policy may run.
+// Policy is only asked once the constraints allow a merge, so a trigger is deferred rather than lost.
+type ConstrainedMergePolicy struct {
+	Policy MergePolicy
+	// QuietHours are the windows the db may be merged in, empty means any time.
+	QuietHours []MergeWindow
+	// MinInterval is the minimum time between the end of an auto merge and the start of the next one.
+	MinInterval time.Duration
+	// MaxRequestsPerSecond defers the merge while the db serves more requests per second,
+	// 0 means no limit.
+	MaxRequestsPerSecond float64
+}
+
+func (p ConstrainedMergePolicy) ShouldMerge(state MergeState) bool {
+	if len(p.QuietHours) > 0 {
+		quiet := false
+		for _, window := range p.QuietHours {
+			if window.Contains(state.Now) {
+				quiet = true
+				break
+			}
+		}
+		if !quiet {
+			return false
+		}
+	}
+	if p.MinInterval > 0 && !state.LastMerge.IsZero() && state.Now.Sub(state.LastMerge) < p.MinInterval {
+		return false
+	}
+	if p.MaxRequestsPerSecond > 0 && state.RequestsPerSecond > p.MaxRequestsPerSecond {
+		return false
+	}
+	return p.Policy.ShouldMerge(state)
+}
+
+// mergePolicy returns the auto merge policy in the options, nil if auto merge is disabled.
+func (o Options) mergePolicy() (MergePolicy, error) {
+	if o.MergePolicy != nil {
+		return o.MergePolicy, nil
+	}
+	if len(o.AutoMergeCronExpr) > 0 {
+		return NewCronMergePolicy(o.AutoMergeCronExpr)
+	}
+	return nil, nil
+}
+
+// runMergePolicy evaluates the policy every interval and merges the db when it says so,
+// until ctx is canceled.
+func (db *DB) runMergePolicy(ctx context.Context, policy MergePolicy, interval time.Duration) {
+	defer close(db.autoMergeDone)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var lastMerge time.Time
+	lastRequests, lastCheck := atomic.LoadUint64(&db.requests), time.Now()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		state, err := db.mergeState()
+		if err != nil {
+			// the state is read again at the next tick.
+			continue
+		}
+		state.LastCheck, state.LastMerge = lastCheck, lastMerge
+		requests := atomic.LoadUint64(&db.requests)
+		if elapsed := state.Now.Sub(lastCheck).Seconds(); elapsed > 0 {
+			state.RequestsPerSecond = float64(requests-lastRequests) / elapsed
+		}
+		lastRequests, lastCheck = requests, state.Now
+
+		if atomic.LoadUint32(&db.mergeRunning) == 1 || !policy.ShouldMerge(state) {
+			continue
+		}
+		// a background task can't return its error, a failed merge is retried when the policy says so.
+		// after auto merge, we should close and reopen the db.
+		err = db.MergeWithOptions(ctx, MergeOptions{
+			ReopenAfterDone: true,
+			BytesPerSecond:  db.options.MergeBytesPerSecond,
+		})
+		if err != ErrMergeRunning {
+			lastMerge = time.Now()
+		}
+	}
+}
+
+// mergeState returns the state of the db for the merge policy.
+func (db *DB) mergeState() (MergeState, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return MergeState{}, ErrDBClosed
+	}
+
+	state := MergeState{Now: time.Now()}
+	var err error
+	if state.DiskSize, err = utils.DirSize(db.options.DirPath); err != nil {
+		return MergeState{}, err
+	}
+	segments, err := db.segmentStats()
+	if err != nil {
+		return MergeState{}, err
+	}
+	activeSegmentId := db.dataFiles.ActiveSegmentID()
+	for _, segment := range segments {
+		if segment.Id >= activeSegmentId {
+			continue
+		}
+		state.SealedSegments++
+		state.SealedSize += segment.Size
+		state.ReclaimableBytes += segment.Garbage
+	}
+	return state, nil
+}
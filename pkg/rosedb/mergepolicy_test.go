@@ -0,0 +1,232 @@
+// Copyright 2024 Joy <joyssss94@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package rosedb
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/JoyZF/zoom/utils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThresholdMergePolicy(t *testing.T) {
+	state := MergeState{DiskSize: 1000, SealedSegments: 3, SealedSize: 800, ReclaimableBytes: 400}
+	assert.Equal(t, 0.5, state.GarbageRatio())
+
+	assert.False(t, ThresholdMergePolicy{}.ShouldMerge(state))
+	assert.True(t, ThresholdMergePolicy{GarbageRatio: 0.5}.ShouldMerge(state))
+	assert.False(t, ThresholdMergePolicy{GarbageRatio: 0.6}.ShouldMerge(state))
+	assert.True(t, ThresholdMergePolicy{DiskSize: 1000}.ShouldMerge(state))
+	assert.False(t, ThresholdMergePolicy{DiskSize: 1001}.ShouldMerge(state))
+	// the live data is larger than the threshold, a merge would not bring the size below it
+	assert.False(t, ThresholdMergePolicy{DiskSize: 600}.ShouldMerge(state))
+	assert.True(t, ThresholdMergePolicy{DiskSize: 601}.ShouldMerge(state))
+	assert.False(t, ThresholdMergePolicy{DiskSize: 100}.ShouldMerge(MergeState{DiskSize: 1000}))
+	assert.True(t, ThresholdMergePolicy{SealedSegments: 3}.ShouldMerge(state))
+	assert.False(t, ThresholdMergePolicy{SealedSegments: 4}.ShouldMerge(state))
+	// any threshold reached triggers the merge
+	assert.True(t, ThresholdMergePolicy{GarbageRatio: 0.9, SealedSegments: 2}.ShouldMerge(state))
+	// nothing to reclaim in an empty db
+	assert.False(t, ThresholdMergePolicy{GarbageRatio: 0.1}.ShouldMerge(MergeState{}))
+}
+
+func TestCronMergePolicy(t *testing.T) {
+	_, err := NewCronMergePolicy("*/1 * * * * * *")
+	assert.NotNil(t, err)
+
+	policy, err := NewCronMergePolicy("0 * * * *") // every hour
+	assert.Nil(t, err)
+	start := time.Date(2024, 1, 1, 10, 30, 0, 0, time.Local)
+	check := func(now time.Time) bool {
+		merge := policy.ShouldMerge(MergeState{Now: now, LastCheck: start})
+		start = now
+		return merge
+	}
+	assert.False(t, check(start.Add(time.Minute)))
+	assert.True(t, check(start.Add(30*time.Minute)))
+	assert.False(t, check(start.Add(time.Minute)))
+	// a missed time triggers the merge once
+	assert.True(t, check(start.Add(3*time.Hour)))
+	assert.False(t, check(start.Add(time.Minute)))
+}
+
+func TestMergeWindow(t *testing.T) {
+	for _, s := range []string{"", "1:00", "25:00-01:00", "01:60-02:00", "24:01-01:00"} {
+		_, err := ParseMergeWindow(s)
+		assert.NotNil(t, err, s)
+	}
+
+	at := func(hour, min int) time.Time {
+		return time.Date(2024, 1, 1, hour, min, 0, 0, time.Local)
+	}
+	window, err := ParseMergeWindow("01:30-05:00")
+	assert.Nil(t, err)
+	assert.Equal(t, MergeWindow{Start: 90 * time.Minute, End: 5 * time.Hour}, window)
+	assert.False(t, window.Contains(at(1, 29)))
+	assert.True(t, window.Contains(at(1, 30)))
+	assert.True(t, window.Contains(at(4, 59)))
+	assert.False(t, window.Contains(at(5, 0)))
+
+	// a window spanning midnight
+	window, err = ParseMergeWindow("22:00-02:00")
+	assert.Nil(t, err)
+	assert.True(t, window.Contains(at(23, 0)))
+	assert.True(t, window.Contains(at(0, 0)))
+	assert.True(t, window.Contains(at(1, 59)))
+	assert.False(t, window.Contains(at(2, 0)))
+	assert.False(t, window.Contains(at(12, 0)))
+}
+
+func TestConstrainedMergePolicy(t *testing.T) {
+	night, err := ParseMergeWindow("00:00-06:00")
+	assert.Nil(t, err)
+	policy := ConstrainedMergePolicy{
+		Policy:               MergePolicyFunc(func(MergeState) bool { return true }),
+		QuietHours:           []MergeWindow{night},
+		MinInterval:          time.Hour,
+		MaxRequestsPerSecond: 100,
+	}
+	now := time.Date(2024, 1, 1, 3, 0, 0, 0, time.Local)
+	assert.True(t, policy.ShouldMerge(MergeState{Now: now}))
+	// out of the quiet hours
+	assert.False(t, policy.ShouldMerge(MergeState{Now: now.Add(4 * time.Hour)}))
+	// too soon after the last merge
+	assert.False(t, policy.ShouldMerge(MergeState{Now: now, LastMerge: now.Add(-time.Minute)}))
+	assert.True(t, policy.ShouldMerge(MergeState{Now: now, LastMerge: now.Add(-time.Hour)}))
+	// too busy
+	assert.False(t, policy.ShouldMerge(MergeState{Now: now, RequestsPerSecond: 101}))
+	assert.True(t, policy.ShouldMerge(MergeState{Now: now, RequestsPerSecond: 100}))
+
+	// the policy is not asked while a merge is not allowed
+	var asked int
+	policy.Policy = MergePolicyFunc(func(MergeState) bool {
+		asked++
+		return false
+	})
+	policy.ShouldMerge(MergeState{Now: now.Add(4 * time.Hour)})
+	assert.Zero(t, asked)
+	policy.ShouldMerge(MergeState{Now: now})
+	assert.Equal(t, 1, asked)
+}
+
+func TestDB_MergePolicy_State(t *testing.T) {
+	var mu sync.Mutex
+	var states []MergeState
+	options := DefaultOptions
+	options.MergePolicyInterval = 50 * time.Millisecond
+	options.MergePolicy = MergePolicyFunc(func(state MergeState) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		states = append(states, state)
+		return false
+	})
+	lastState := func() MergeState {
+		time.Sleep(3 * options.MergePolicyInterval)
+		mu.Lock()
+		defer mu.Unlock()
+		assert.NotEmpty(t, states)
+		return states[len(states)-1]
+	}
+
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer func() {
+		destroyDB(db)
+	}()
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+	for i := 0; i < 50; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	// nothing is reclaimable in the active segment
+	state := lastState()
+	assert.Zero(t, state.SealedSegments)
+	assert.Zero(t, state.ReclaimableBytes)
+	assert.True(t, state.DiskSize > 0)
+	assert.True(t, state.Now.After(state.LastCheck))
+	assert.True(t, state.LastMerge.IsZero())
+
+	db = sealActiveSegment(t, db)
+	state = lastState()
+	stats := db.Stat().Segments
+	assert.Equal(t, 1, state.SealedSegments)
+	assert.Equal(t, stats[0].Size, state.SealedSize)
+	assert.Equal(t, stats[0].Garbage, state.ReclaimableBytes)
+	assert.True(t, state.GarbageRatio() > 0.3)
+
+	// the requests are counted as the load
+	stop := time.Now().Add(3 * options.MergePolicyInterval)
+	for time.Now().Before(stop) {
+		_, _ = db.Get(utils.GetTestKey(99))
+	}
+	lastState()
+	mu.Lock()
+	var busiest float64
+	for _, s := range states {
+		if s.RequestsPerSecond > busiest {
+			busiest = s.RequestsPerSecond
+		}
+	}
+	mu.Unlock()
+	assert.True(t, busiest > 1000)
+
+	// the policy is no longer evaluated once the db is closed
+	assert.Nil(t, db.Close())
+	mu.Lock()
+	evaluated := len(states)
+	mu.Unlock()
+	time.Sleep(3 * options.MergePolicyInterval)
+	mu.Lock()
+	assert.Equal(t, evaluated, len(states))
+	mu.Unlock()
+}
+
+func TestDB_MergePolicy_Options(t *testing.T) {
+	options := DefaultOptions
+	options.MergePolicy = ThresholdMergePolicy{GarbageRatio: 0.5}
+	options.AutoMergeCronExpr = "@hourly"
+	_, err := Open(options)
+	assert.NotNil(t, err)
+
+	options.AutoMergeCronExpr = ""
+	options.MergePolicyInterval = 0
+	_, err = Open(options)
+	assert.NotNil(t, err)
+}
+
+func TestDB_MergePolicy_MergeDB(t *testing.T) {
+	// the db does not evaluate its policy while merging, so any evaluation then is the merge db's.
+	var merging, evaluatedWhileMerging atomic.Bool
+	options := DefaultOptions
+	options.MergePolicyInterval = 10 * time.Millisecond
+	options.MergeBytesPerSecond = 512 * 1024
+	options.MergePolicy = MergePolicyFunc(func(state MergeState) bool {
+		if !merging.Load() {
+			return false
+		}
+		// the merge db fires, and would merge itself.
+		evaluatedWhileMerging.Store(true)
+		return true
+	})
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer func() {
+		destroyDB(db)
+	}()
+	for i := 0; i < 200; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(1024)))
+	}
+
+	merging.Store(true)
+	assert.Nil(t, db.Merge(false))
+	merging.Store(false)
+	assert.False(t, evaluatedWhileMerging.Load())
+}
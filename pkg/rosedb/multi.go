@@ -0,0 +1,95 @@
+// Copyright 2024 Joy <joyssss94@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package rosedb
+
+import (
+	"sort"
+	"time"
+
+	"github.com/JoyZF/zoom/pkg/wal"
+)
+
+// MultiGet returns the values of the keys, in the order of the keys,
+// the value of a key which does not exist is nil.
+//
+// The keys are read under a single read lock, so the values are a point-in-time view of the db,
+// and the records are read in the order of their positions in the WAL rather than of the keys.
+func (db *DB) MultiGet(keys [][]byte) ([][]byte, error) {
+	for _, key := range keys {
+		if len(key) == 0 {
+			return nil, ErrKeyIsEmpty
+		}
+	}
+	values := make([][]byte, len(keys))
+	err := db.view(func(batch *Batch) error {
+		if db.closed {
+			return ErrDBClosed
+		}
+		type read struct {
+			i        int
+			position *wal.ChunkPosition
+		}
+		idx := db.namespaceIndex(defaultNamespaceID)
+		reads := make([]read, 0, len(keys))
+		for i, key := range keys {
+			if position := idx.Get(key); position != nil {
+				reads = append(reads, read{i: i, position: position})
+			}
+		}
+		sort.Slice(reads, func(i, j int) bool {
+			return positionAfter(reads[j].position, reads[i].position)
+		})
+
+		now := time.Now().UnixNano()
+		for _, r := range reads {
+			value, err := db.readValue(r.position, now)
+			if err == ErrKeyNotFound {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if value == nil {
+				// tell an empty value from a key which does not exist.
+				value = []byte{}
+			}
+			values[r.i] = value
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// MultiPut puts the key/value pairs atomically, in a single batch.
+// The keys and the values are paired by their index, a later pair of the same key wins.
+func (db *DB) MultiPut(keys, values [][]byte) error {
+	if len(keys) != len(values) {
+		return ErrMultiLengthMismatch
+	}
+	return db.update(func(batch *Batch) error {
+		for i, key := range keys {
+			if err := batch.Put(key, values[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// MultiDelete deletes the keys atomically, in a single batch.
+// The keys which do not exist are skipped.
+func (db *DB) MultiDelete(keys [][]byte) error {
+	return db.update(func(batch *Batch) error {
+		for _, key := range keys {
+			if err := batch.Delete(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,93 @@
+// Copyright 2024 Joy <joyssss94@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package rosedb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/JoyZF/zoom/utils"
+)
+
+func TestDB_MultiGet(t *testing.T) {
+	db, err := Open(DefaultOptions)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	// written in the reverse order of the keys, so the reads are reordered,
+	// and over several blocks of the segment, which are ordered before the offsets in them.
+	written := make(map[int][]byte)
+	for i := 99; i >= 0; i-- {
+		written[i] = utils.RandomValue(1024)
+		assert.Nil(t, db.Put(utils.GetTestKey(i), written[i]))
+	}
+	assert.Nil(t, db.Put([]byte("empty"), []byte{}))
+	assert.Nil(t, db.PutWithTTL([]byte("expired"), []byte("v"), time.Millisecond))
+	assert.Nil(t, db.Delete(utils.GetTestKey(1)))
+	time.Sleep(5 * time.Millisecond)
+
+	keys := [][]byte{utils.GetTestKey(0), utils.GetTestKey(1), []byte("missing"), []byte("empty"), []byte("expired"), utils.GetTestKey(0)}
+	for i := 2; i < 100; i++ {
+		keys = append(keys, utils.GetTestKey(i))
+	}
+	values, err := db.MultiGet(keys)
+	assert.Nil(t, err)
+	assert.Len(t, values, len(keys))
+	assert.Equal(t, written[0], values[0])
+	assert.Nil(t, values[1])
+	assert.Nil(t, values[2])
+	assert.Equal(t, []byte{}, values[3])
+	assert.Nil(t, values[4])
+	assert.Equal(t, written[0], values[5])
+	for i := 2; i < 100; i++ {
+		assert.Equal(t, written[i], values[i+4])
+	}
+
+	values, err = db.MultiGet(nil)
+	assert.Nil(t, err)
+	assert.Empty(t, values)
+	_, err = db.MultiGet([][]byte{[]byte("k"), nil})
+	assert.Equal(t, ErrKeyIsEmpty, err)
+}
+
+func TestDB_MultiPut(t *testing.T) {
+	db, err := Open(DefaultOptions)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	keys := [][]byte{[]byte("k1"), []byte("k2"), []byte("k1")}
+	assert.Nil(t, db.MultiPut(keys, [][]byte{[]byte("v1"), []byte("v2"), []byte("v3")}))
+	values, err := db.MultiGet(keys)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("v3"), []byte("v2"), []byte("v3")}, values)
+
+	// nothing is written if a pair is invalid
+	err = db.MultiPut([][]byte{[]byte("k3"), nil}, [][]byte{[]byte("v"), []byte("v")})
+	assert.Equal(t, ErrKeyIsEmpty, err)
+	exist, err := db.Exist([]byte("k3"))
+	assert.Nil(t, err)
+	assert.False(t, exist)
+	assert.Equal(t, ErrMultiLengthMismatch, db.MultiPut(keys, nil))
+}
+
+func TestDB_MultiDelete(t *testing.T) {
+	db, err := Open(DefaultOptions)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	assert.Nil(t, db.MultiDelete([][]byte{utils.GetTestKey(0), utils.GetTestKey(5), []byte("missing")}))
+	assert.Equal(t, 8, db.Stat().KeysNum)
+	_, err = db.Get(utils.GetTestKey(5))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// nothing is deleted if a key is invalid
+	assert.Equal(t, ErrKeyIsEmpty, db.MultiDelete([][]byte{utils.GetTestKey(1), nil}))
+	assert.Equal(t, 8, db.Stat().KeysNum)
+}
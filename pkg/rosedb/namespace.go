@@ -0,0 +1,419 @@
+// Copyright 2024 Joy <joyssss94@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package rosedb
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/JoyZF/zoom/pkg/rosedb/index"
+	"github.com/JoyZF/zoom/pkg/wal"
+)
+
+const (
+	namespaceFileName = "NAMESPACES"
+	// the id of the default namespace, which holds the keys written by the methods of DB and Batch.
+	defaultNamespaceID = 0
+)
+
+// namespaceState is a live namespace, see DB.Namespace.
+type namespaceState struct {
+	name  string
+	index index.Indexer
+}
+
+// namespaceFile is the content of the NAMESPACES file.
+type namespaceFile struct {
+	NextId     uint32            `json:"next_id"`
+	Namespaces map[string]uint32 `json:"namespaces"`
+}
+
+// Namespace is a keyspace of the db with its own index, see DB.Namespace.
+type Namespace struct {
+	db   *DB
+	id   uint32
+	name string
+}
+
+// NamespaceStat is the statistics of a namespace.
+type NamespaceStat struct {
+	Name    string
+	KeysNum int
+}
+
+// Namespace returns the namespace of the name, it is created if it does not exist.
+//
+// A namespace has its own index, so its keys are independent of the keys of the db and of the other
+// namespaces, it is iterated on its own and has its own statistics. All namespaces share the WAL,
+// the id of the namespace is written in the header of each record, and a Batch writes to several
+// namespaces atomically with Batch.Namespace.
+//
+// The keys written by the methods of DB, Batch, Txn, Snapshot and Export are in the default namespace,
+// which has no name. The values of the other namespaces are never separated to the blob files,
+// and their indexes are kept in memory, they are saved with the index snapshot,
+// or along with the index.BPTree index when it is persisted.
+func (db *DB) Namespace(name string) (*Namespace, error) {
+	if name == "" {
+		return nil, ErrNamespaceNameEmpty
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return nil, ErrDBClosed
+	}
+
+	if id, ok := db.namespaceID(name); ok {
+		return &Namespace{db: db, id: id, name: name}, nil
+	}
+	id := db.nextNamespaceId
+	db.namespaces[id] = &namespaceState{name: name, index: index.NewIndexer(db.options.memoryIndexOptions())}
+	db.nextNamespaceId++
+	if err := db.saveNamespaces(); err != nil {
+		delete(db.namespaces, id)
+		db.nextNamespaceId--
+		return nil, err
+	}
+	return &Namespace{db: db, id: id, name: name}, nil
+}
+
+// Namespaces returns the names of the namespaces in ascending order.
+func (db *DB) Namespaces() []string {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	names := make([]string, 0, len(db.namespaces))
+	for _, ns := range db.namespaces {
+		names = append(names, ns.name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DropNamespace removes the namespace and all its keys.
+//
+// It only removes the namespace from the NAMESPACES file and drops its index, the records of its keys
+// become garbage, which is reclaimed by Merge and CompactSegments later.
+// The Namespace values of the namespace return ErrNamespaceNotFound afterwards,
+// even if a namespace of the same name is created again.
+func (db *DB) DropNamespace(name string) error {
+	db.indexSnapshotMu.Lock()
+	defer db.indexSnapshotMu.Unlock()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return ErrDBClosed
+	}
+
+	id, ok := db.namespaceID(name)
+	if !ok {
+		return ErrNamespaceNotFound
+	}
+	// the index snapshot must not hold the keys of the namespace, their records are counted as garbage
+	// from now on, so it is written again by the next snapshot even if the WAL has not changed.
+	if err := os.Remove(filepath.Join(db.options.DirPath, indexSnapshotFileName)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	db.indexSnapshotCheckpoint = nil
+	ns := db.namespaces[id]
+	delete(db.namespaces, id)
+	if err := db.saveNamespaces(); err != nil {
+		db.namespaces[id] = ns
+		return err
+	}
+	ns.index.Ascend(func(_ []byte, position *wal.ChunkPosition) (bool, error) {
+		db.garbage.add(position)
+		return true, nil
+	})
+	return nil
+}
+
+// loadNamespaces reads the namespaces from the NAMESPACES file.
+func (db *DB) loadNamespaces() error {
+	db.namespaces = make(map[uint32]*namespaceState)
+	db.nextNamespaceId = defaultNamespaceID + 1
+	data, err := os.ReadFile(filepath.Join(db.options.DirPath, namespaceFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	file := &namespaceFile{}
+	if err = json.Unmarshal(data, file); err != nil {
+		return err
+	}
+	for name, id := range file.Namespaces {
+		db.namespaces[id] = &namespaceState{name: name, index: index.NewIndexer(db.options.memoryIndexOptions())}
+	}
+	if file.NextId > db.nextNamespaceId {
+		db.nextNamespaceId = file.NextId
+	}
+	return nil
+}
+
+// saveNamespaces writes the namespaces to the NAMESPACES file.
+// The caller must hold db.mu.
+func (db *DB) saveNamespaces() error {
+	file := &namespaceFile{NextId: db.nextNamespaceId, Namespaces: make(map[string]uint32, len(db.namespaces))}
+	for id, ns := range db.namespaces {
+		file.Namespaces[ns.name] = id
+	}
+	data, err := json.Marshal(file)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(db.options.DirPath, namespaceFileName)
+	if err = writeFileSync(path+backupTempSuffix, data); err != nil {
+		return err
+	}
+	return os.Rename(path+backupTempSuffix, path)
+}
+
+// resetNamespaceIndexes discards the keys of all namespaces, it is called whenever the index is loaded.
+func (db *DB) resetNamespaceIndexes() {
+	for _, ns := range db.namespaces {
+		ns.index = index.NewIndexer(db.options.memoryIndexOptions())
+	}
+}
+
+// addDroppedGarbage counts the records of the keys of the namespaces dropped since a snapshot was loaded
+// as garbage.
+func (db *DB) addDroppedGarbage(positions []*wal.ChunkPosition) {
+	for _, position := range positions {
+		db.garbage.add(position)
+	}
+}
+
+// namespaceID returns the id of the live namespace of the name.
+// The caller must hold db.mu.
+func (db *DB) namespaceID(name string) (uint32, bool) {
+	for id, ns := range db.namespaces {
+		if ns.name == name {
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+// nextNamespaceID returns the smallest id of the live namespaces greater than id.
+// The caller must hold db.mu.
+func (db *DB) nextNamespaceID(id uint32) (uint32, bool) {
+	next, found := uint32(0), false
+	for nsId := range db.namespaces {
+		if nsId > id && (!found || nsId < next) {
+			next, found = nsId, true
+		}
+	}
+	return next, found
+}
+
+// namespaceIndex returns the index of the namespace, nil if it has been dropped.
+// The caller must hold db.mu.
+func (db *DB) namespaceIndex(id uint32) index.Indexer {
+	if id == defaultNamespaceID {
+		return db.index
+	}
+	if ns, ok := db.namespaces[id]; ok {
+		return ns.index
+	}
+	return nil
+}
+
+// namespaceName returns the name of the namespace, empty for the default namespace.
+// The caller must hold db.mu.
+func (db *DB) namespaceName(id uint32) string {
+	if ns, ok := db.namespaces[id]; ok {
+		return ns.name
+	}
+	return ""
+}
+
+// namespacePosition returns the position of the key in the index of the namespace,
+// nil if the key or the namespace does not exist.
+// The caller must hold db.mu.
+func (db *DB) namespacePosition(id uint32, key []byte) *wal.ChunkPosition {
+	if idx := db.namespaceIndex(id); idx != nil {
+		return idx.Get(key)
+	}
+	return nil
+}
+
+// namespaceStats returns the statistics of the namespaces in ascending order of name.
+// The caller must hold db.mu.
+func (db *DB) namespaceStats() []NamespaceStat {
+	stats := make([]NamespaceStat, 0, len(db.namespaces))
+	for _, ns := range db.namespaces {
+		stats = append(stats, NamespaceStat{Name: ns.name, KeysNum: ns.index.Size()})
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Name < stats[j].Name
+	})
+	return stats
+}
+
+// update runs fn in a batch, which is committed if fn succeeds, like the single writes of DB.
+func (db *DB) update(fn func(batch *Batch) error) error {
+	batch := db.batchPool.Get().(*Batch)
+	defer func() {
+		batch.reset()
+		db.batchPool.Put(batch)
+	}()
+	batch.init(false, false, db)
+	if err := fn(batch); err != nil {
+		_ = batch.Rollback()
+		return err
+	}
+	return batch.Commit()
+}
+
+// view runs fn in a read only batch, like the single reads of DB.
+func (db *DB) view(fn func(batch *Batch) error) error {
+	batch := db.batchPool.Get().(*Batch)
+	batch.init(true, false, db)
+	defer func() {
+		_ = batch.Commit()
+		batch.reset()
+		db.batchPool.Put(batch)
+	}()
+	return fn(batch)
+}
+
+// Name returns the name of the namespace.
+func (ns *Namespace) Name() string {
+	return ns.name
+}
+
+func (ns *Namespace) Put(key []byte, value []byte) error {
+	return ns.db.update(func(batch *Batch) error {
+		return batch.put(ns.id, key, value)
+	})
+}
+
+func (ns *Namespace) PutWithTTL(key []byte, value []byte, ttl time.Duration) error {
+	return ns.db.update(func(batch *Batch) error {
+		return batch.putWithTTL(ns.id, key, value, ttl)
+	})
+}
+
+func (ns *Namespace) Get(key []byte) ([]byte, error) {
+	var value []byte
+	err := ns.db.view(func(batch *Batch) error {
+		var err error
+		value, err = batch.get(ns.id, key)
+		return err
+	})
+	return value, err
+}
+
+func (ns *Namespace) Delete(key []byte) error {
+	return ns.db.update(func(batch *Batch) error {
+		return batch.delete(ns.id, key)
+	})
+}
+
+func (ns *Namespace) Exist(key []byte) (bool, error) {
+	var exist bool
+	err := ns.db.view(func(batch *Batch) error {
+		var err error
+		exist, err = batch.exist(ns.id, key)
+		return err
+	})
+	return exist, err
+}
+
+func (ns *Namespace) Expire(key []byte, ttl time.Duration) error {
+	return ns.db.update(func(batch *Batch) error {
+		return batch.expire(ns.id, key, ttl)
+	})
+}
+
+func (ns *Namespace) TTL(key []byte) (time.Duration, error) {
+	ttl := time.Duration(-1)
+	err := ns.db.view(func(batch *Batch) error {
+		var err error
+		ttl, err = batch.ttl(ns.id, key)
+		return err
+	})
+	return ttl, err
+}
+
+func (ns *Namespace) Persist(key []byte) error {
+	return ns.db.update(func(batch *Batch) error {
+		return batch.persist(ns.id, key)
+	})
+}
+
+// NewIterator returns a new iterator over the keys of the namespace, positioned at the first key.
+// The iterator must be closed after use, see DB.NewIterator.
+func (ns *Namespace) NewIterator(options IteratorOptions) (*Iterator, error) {
+	return ns.db.newPinnedIterator(func() (index.Indexer, error) {
+		idx := ns.db.namespaceIndex(ns.id)
+		if idx == nil {
+			return nil, ErrNamespaceNotFound
+		}
+		return idx, nil
+	}, options)
+}
+
+// Stat returns the statistics of the namespace.
+func (ns *Namespace) Stat() (*NamespaceStat, error) {
+	ns.db.mu.RLock()
+	defer ns.db.mu.RUnlock()
+
+	if ns.db.closed {
+		return nil, ErrDBClosed
+	}
+	idx := ns.db.namespaceIndex(ns.id)
+	if idx == nil {
+		return nil, ErrNamespaceNotFound
+	}
+	return &NamespaceStat{Name: ns.name, KeysNum: idx.Size()}, nil
+}
+
+// NamespaceBatch writes to a namespace within a Batch, see Batch.Namespace.
+type NamespaceBatch struct {
+	batch *Batch
+	id    uint32
+}
+
+// Namespace returns the view of the batch on the namespace, which must be a namespace of the db of the batch.
+// The writes to the namespace are committed atomically along with the other writes of the batch.
+func (b *Batch) Namespace(ns *Namespace) *NamespaceBatch {
+	return &NamespaceBatch{batch: b, id: ns.id}
+}
+
+func (b *NamespaceBatch) Put(key []byte, value []byte) error {
+	return b.batch.put(b.id, key, value)
+}
+
+func (b *NamespaceBatch) PutWithTTL(key []byte, value []byte, ttl time.Duration) error {
+	return b.batch.putWithTTL(b.id, key, value, ttl)
+}
+
+func (b *NamespaceBatch) Get(key []byte) ([]byte, error) {
+	return b.batch.get(b.id, key)
+}
+
+func (b *NamespaceBatch) Delete(key []byte) error {
+	return b.batch.delete(b.id, key)
+}
+
+func (b *NamespaceBatch) Exist(key []byte) (bool, error) {
+	return b.batch.exist(b.id, key)
+}
+
+func (b *NamespaceBatch) Expire(key []byte, ttl time.Duration) error {
+	return b.batch.expire(b.id, key, ttl)
+}
+
+func (b *NamespaceBatch) TTL(key []byte) (time.Duration, error) {
+	return b.batch.ttl(b.id, key)
+}
+
+func (b *NamespaceBatch) Persist(key []byte) error {
+	return b.batch.persist(b.id, key)
+}
@@ -0,0 +1,429 @@
+// Copyright 2024 Joy <joyssss94@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package rosedb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/JoyZF/zoom/pkg/rosedb/index"
+	"github.com/JoyZF/zoom/pkg/wal"
+	"github.com/JoyZF/zoom/utils"
+)
+
+func TestDB_Namespace(t *testing.T) {
+	db, err := Open(DefaultOptions)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	_, err = db.Namespace("")
+	assert.Equal(t, ErrNamespaceNameEmpty, err)
+	sessions, err := db.Namespace("sessions")
+	assert.Nil(t, err)
+	users, err := db.Namespace("users")
+	assert.Nil(t, err)
+	assert.Equal(t, "sessions", sessions.Name())
+	assert.Equal(t, []string{"sessions", "users"}, db.Namespaces())
+
+	// the same key is independent in each namespace
+	key := []byte("key")
+	assert.Nil(t, db.Put(key, []byte("default")))
+	assert.Nil(t, sessions.Put(key, []byte("sessions")))
+	value, err := db.Get(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("default"), value)
+	value, err = sessions.Get(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("sessions"), value)
+	_, err = users.Get(key)
+	assert.Equal(t, ErrKeyNotFound, err)
+	exist, err := users.Exist(key)
+	assert.Nil(t, err)
+	assert.False(t, exist)
+
+	assert.Nil(t, sessions.Delete(key))
+	_, err = sessions.Get(key)
+	assert.Equal(t, ErrKeyNotFound, err)
+	value, err = db.Get(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("default"), value)
+
+	// ttl
+	assert.Nil(t, users.PutWithTTL(key, []byte("v"), time.Hour))
+	ttl, err := users.TTL(key)
+	assert.Nil(t, err)
+	assert.True(t, ttl > 59*time.Minute)
+	assert.Nil(t, users.Persist(key))
+	ttl, err = users.TTL(key)
+	assert.Nil(t, err)
+	assert.Equal(t, time.Duration(-1), ttl)
+	assert.Nil(t, users.Expire(key, time.Millisecond))
+	time.Sleep(5 * time.Millisecond)
+	_, err = users.Get(key)
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// iteration and stats only cover the keys of the namespace
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, sessions.Put(utils.GetTestKey(i), []byte("v")))
+	}
+	iter, err := sessions.NewIterator(IteratorOptions{Reverse: true})
+	assert.Nil(t, err)
+	var keys [][]byte
+	for ; iter.Valid(); iter.Next() {
+		keys = append(keys, iter.Key())
+	}
+	iter.Close()
+	assert.Equal(t, 10, len(keys))
+	assert.Equal(t, utils.GetTestKey(9), keys[0])
+	stat, err := sessions.Stat()
+	assert.Nil(t, err)
+	assert.Equal(t, &NamespaceStat{Name: "sessions", KeysNum: 10}, stat)
+	dbStat := db.Stat()
+	assert.Equal(t, 1, dbStat.KeysNum)
+	assert.Equal(t, []NamespaceStat{{Name: "sessions", KeysNum: 10}, {Name: "users", KeysNum: 0}}, dbStat.Namespaces)
+
+	// an existing namespace is returned by name
+	again, err := db.Namespace("sessions")
+	assert.Nil(t, err)
+	value, err = again.Get(utils.GetTestKey(0))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v"), value)
+}
+
+func TestDB_Namespace_Batch(t *testing.T) {
+	db, err := Open(DefaultOptions)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	sessions, err := db.Namespace("sessions")
+	assert.Nil(t, err)
+	key := []byte("key")
+
+	batch := db.NewBatch(DefaultBatchOptions)
+	nsBatch := batch.Namespace(sessions)
+	assert.Nil(t, batch.Put(key, []byte("default")))
+	assert.Nil(t, nsBatch.Put(key, []byte("sessions")))
+	// the pending writes are looked up by namespace
+	value, err := nsBatch.Get(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("sessions"), value)
+	assert.Nil(t, batch.Delete(key))
+	exist, err := nsBatch.Exist(key)
+	assert.Nil(t, err)
+	assert.True(t, exist)
+	assert.Nil(t, batch.Rollback())
+	_, err = sessions.Get(key)
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	batch = db.NewBatch(DefaultBatchOptions)
+	assert.Nil(t, batch.Put(key, []byte("default")))
+	assert.Nil(t, batch.Namespace(sessions).PutWithTTL(key, []byte("sessions"), time.Hour))
+	assert.Nil(t, batch.Commit())
+	value, err = db.Get(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("default"), value)
+	value, err = sessions.Get(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("sessions"), value)
+}
+
+func TestDB_DropNamespace(t *testing.T) {
+	testDropNamespace(t, DefaultOptions)
+	// the dropped keys are neither in the index snapshot nor in the snapshot of the namespaces
+	options := DefaultOptions
+	options.IndexSnapshotInterval = time.Hour
+	testDropNamespace(t, options)
+	options = DefaultOptions
+	options.IndexType = index.BPTree
+	testDropNamespace(t, options)
+}
+
+func testDropNamespace(t *testing.T, options Options) {
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer func() {
+		destroyDB(db)
+	}()
+
+	sessions, err := db.Namespace("sessions")
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, sessions.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+	garbage := totalGarbage(db)
+
+	assert.Nil(t, db.DropNamespace("sessions"))
+	assert.Equal(t, ErrNamespaceNotFound, db.DropNamespace("sessions"))
+	assert.Empty(t, db.Namespaces())
+	// the records of the namespace are garbage
+	assert.True(t, totalGarbage(db) > garbage+100*128)
+	_, err = sessions.Get(utils.GetTestKey(0))
+	assert.Equal(t, ErrNamespaceNotFound, err)
+	assert.Equal(t, ErrNamespaceNotFound, sessions.Put(utils.GetTestKey(0), []byte("v")))
+	_, err = sessions.NewIterator(IteratorOptions{})
+	assert.Equal(t, ErrNamespaceNotFound, err)
+
+	// a namespace created again with the same name is empty
+	recreated, err := db.Namespace("sessions")
+	assert.Nil(t, err)
+	_, err = recreated.Get(utils.GetTestKey(0))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = sessions.Get(utils.GetTestKey(0))
+	assert.Equal(t, ErrNamespaceNotFound, err)
+
+	// the dropped keys do not come back after a restart, and stay garbage
+	garbage = totalGarbage(db)
+	assert.Nil(t, db.Close())
+	db, err = Open(options)
+	assert.Nil(t, err)
+	assert.Equal(t, garbage, totalGarbage(db))
+	recreated, err = db.Namespace("sessions")
+	assert.Nil(t, err)
+	stat, err := recreated.Stat()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, stat.KeysNum)
+}
+
+func TestDB_DropNamespace_Crash(t *testing.T) {
+	options := DefaultOptions
+	options.IndexType = index.BPTree
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer func() {
+		destroyDB(db)
+	}()
+
+	sessions, err := db.Namespace("sessions")
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, sessions.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+	assert.Nil(t, db.SaveIndexSnapshot())
+	assert.Nil(t, db.DropNamespace("sessions"))
+	garbage := totalGarbage(db)
+
+	// the keys of the namespace dropped after the disk index was persisted are garbage
+	crashDB(db)
+	db, err = Open(options)
+	assert.Nil(t, err)
+	assert.Zero(t, db.Stat().Recovery.Records)
+	assert.Empty(t, db.Namespaces())
+	assert.Equal(t, garbage, totalGarbage(db))
+}
+
+func TestDB_Namespace_Reopen(t *testing.T) {
+	// the namespaces are saved with the index snapshot, or along with the disk index
+	options := DefaultOptions
+	options.IndexSnapshotInterval = time.Hour
+	testNamespaceReopen(t, options)
+	options = DefaultOptions
+	options.IndexType = index.BPTree
+	testNamespaceReopen(t, options)
+}
+
+func testNamespaceReopen(t *testing.T, options Options) {
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer func() {
+		destroyDB(db)
+	}()
+
+	sessions, err := db.Namespace("sessions")
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), []byte("default")))
+		assert.Nil(t, sessions.Put(utils.GetTestKey(i), []byte("sessions")))
+	}
+	assert.Nil(t, sessions.Delete(utils.GetTestKey(0)))
+	assert.Nil(t, sessions.PutWithTTL(utils.GetTestKey(1), []byte("sessions"), time.Millisecond))
+	time.Sleep(5 * time.Millisecond)
+
+	assert.Nil(t, db.Close())
+	db, err = Open(options)
+	assert.Nil(t, err)
+	// the indexes are loaded without replaying the WAL
+	assert.Zero(t, db.Stat().Recovery.Records)
+	assert.Equal(t, []string{"sessions"}, db.Namespaces())
+	sessions, err = db.Namespace("sessions")
+	assert.Nil(t, err)
+	// the expired key is in the snapshot until it is read
+	_, err = sessions.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+	stat, err := sessions.Stat()
+	assert.Nil(t, err)
+	assert.Equal(t, 98, stat.KeysNum)
+	assert.Equal(t, 100, db.Stat().KeysNum)
+	_, err = sessions.Get(utils.GetTestKey(0))
+	assert.Equal(t, ErrKeyNotFound, err)
+	value, err := sessions.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("sessions"), value)
+	value, err = db.Get(utils.GetTestKey(0))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("default"), value)
+}
+
+func TestDB_Namespace_Merge(t *testing.T) {
+	// the hint records of the namespaces are told apart from the encrypted ones, key id 0 included
+	for _, setup := range []func(options *Options){
+		func(options *Options) {},
+		func(options *Options) { options.KeyProvider = newTestKeyProvider(t, 0, 0) },
+	} {
+		options := DefaultOptions
+		options.SegmentSize = 64 * wal.KB
+		setup(&options)
+		db, err := Open(options)
+		assert.Nil(t, err)
+
+		sessions, err := db.Namespace("sessions")
+		assert.Nil(t, err)
+		for i := 0; i < 1000; i++ {
+			assert.Nil(t, db.Put(utils.GetTestKey(i), []byte("default")))
+			assert.Nil(t, sessions.Put(utils.GetTestKey(i), []byte("sessions")))
+		}
+		for i := 0; i < 500; i++ {
+			assert.Nil(t, sessions.Delete(utils.GetTestKey(i)))
+		}
+		assert.Nil(t, db.Merge(true))
+
+		// the index is loaded from the hint file when the db is opened again
+		assert.Nil(t, db.Close())
+		db, err = Open(options)
+		assert.Nil(t, err)
+		sessions, err = db.Namespace("sessions")
+		assert.Nil(t, err)
+		stat, err := sessions.Stat()
+		assert.Nil(t, err)
+		assert.Equal(t, 500, stat.KeysNum)
+		assert.Equal(t, 1000, db.Stat().KeysNum)
+		_, err = sessions.Get(utils.GetTestKey(0))
+		assert.Equal(t, ErrKeyNotFound, err)
+		value, err := sessions.Get(utils.GetTestKey(500))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("sessions"), value)
+		value, err = db.Get(utils.GetTestKey(0))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("default"), value)
+		destroyDB(db)
+	}
+}
+
+func TestDB_Namespace_CompactSegments(t *testing.T) {
+	db, err := Open(DefaultOptions)
+	assert.Nil(t, err)
+	defer func() {
+		destroyDB(db)
+	}()
+
+	sessions, err := db.Namespace("sessions")
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, sessions.Put(utils.GetTestKey(i), []byte("old")))
+	}
+	for i := 0; i < 50; i++ {
+		assert.Nil(t, sessions.Put(utils.GetTestKey(i), []byte("new")))
+	}
+	db = sealActiveSegment(t, db)
+
+	removed, err := db.CompactSegments(0)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, removed)
+	sessions, err = db.Namespace("sessions")
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		value, err := sessions.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		if i < 50 {
+			assert.Equal(t, []byte("new"), value)
+		} else {
+			assert.Equal(t, []byte("old"), value)
+		}
+	}
+	_, err = db.Get(utils.GetTestKey(0))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestDB_Namespace_Watch(t *testing.T) {
+	options := DefaultOptions
+	options.WatchQueueSize = 10
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	sessions, err := db.Namespace("sessions")
+	assert.Nil(t, err)
+	w, err := db.Watch()
+	assert.Nil(t, err)
+
+	assert.Nil(t, sessions.Put([]byte("a"), []byte("1")))
+	assert.Nil(t, db.Put([]byte("a"), []byte("2")))
+	for _, namespace := range []string{"sessions", ""} {
+		select {
+		case event := <-w:
+			assert.Equal(t, WatchActionPut, event.Action)
+			assert.Equal(t, []byte("a"), event.Key)
+			assert.Equal(t, namespace, event.Namespace)
+		case <-time.After(time.Second):
+			t.Fatal("no event")
+		}
+	}
+}
+
+func TestDB_Namespace_ChangeFeed(t *testing.T) {
+	db, err := Open(DefaultOptions)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	sessions, err := db.Namespace("sessions")
+	assert.Nil(t, err)
+	feed, err := db.NewChangeFeed("")
+	assert.Nil(t, err)
+	batch := db.NewBatch(DefaultBatchOptions)
+	assert.Nil(t, batch.Put([]byte("a"), []byte("1")))
+	assert.Nil(t, batch.Namespace(sessions).Put([]byte("a"), []byte("2")))
+	assert.Nil(t, batch.Commit())
+	changes := nextChangeBatch(t, feed).Changes
+	assert.Equal(t, []*Change{
+		{Action: WatchActionPut, Key: []byte("a"), Value: []byte("1")},
+		{Action: WatchActionPut, Key: []byte("a"), Value: []byte("2"), Namespace: "sessions"},
+	}, changes)
+
+	// the replica creates the namespace
+	replicaOptions := DefaultOptions
+	replicaOptions.DirPath = DefaultOptions.DirPath + "-replica"
+	replica, err := Open(replicaOptions)
+	assert.Nil(t, err)
+	defer destroyDB(replica)
+	assert.Nil(t, replica.ApplyChanges(changes, false))
+	assert.Equal(t, []string{"sessions"}, replica.Namespaces())
+	replicaSessions, err := replica.Namespace("sessions")
+	assert.Nil(t, err)
+	value, err := replicaSessions.Get([]byte("a"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("2"), value)
+	value, err = replica.Get([]byte("a"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("1"), value)
+
+	// the changes of a dropped namespace are left out
+	assert.Nil(t, sessions.Put([]byte("b"), []byte("3")))
+	assert.Nil(t, db.DropNamespace("sessions"))
+	assert.Empty(t, nextChangeBatch(t, feed).Changes)
+}
+
+func TestNamespaceHintRecord(t *testing.T) {
+	position := &wal.ChunkPosition{SegmentId: 1, BlockNumber: 2, ChunkOffset: 3, ChunkSize: 4}
+	for _, namespace := range []uint32{defaultNamespaceID, 1, 300} {
+		hint := encodeNamespaceHintRecord(namespace, []byte("key"), position)
+		decodedNamespace, key, decodedPosition := decodeNamespaceHintRecord(hint)
+		assert.Equal(t, namespace, decodedNamespace)
+		assert.Equal(t, []byte("key"), key)
+		assert.Equal(t, position, decodedPosition)
+	}
+	// the hint records of the default namespace are unchanged
+	assert.Equal(t, encodeHintRecord([]byte("key"), position), encodeNamespaceHintRecord(defaultNamespaceID, []byte("key"), position))
+}
@@ -6,8 +6,11 @@ package rosedb
 
 import (
 	"os"
+	"runtime"
+	"time"
 
-	"github.com/JoyZF/wal"
+	"github.com/JoyZF/zoom/pkg/rosedb/index"
+	"github.com/JoyZF/zoom/pkg/wal"
 )
 
 type Options struct {
@@ -39,6 +42,16 @@ type Options struct {
 	// if the size greater than 0, which means enable the watch.
 	WatchQueueSize uint64
 
+	// ActiveExpireInterval specifies how often the expired keys are removed in the background.
+	// Otherwise an expired key stays in the index until it is read, or DeleteExpiredKeys is called.
+	// The keys are removed in the order of their expiration time, and a WatchActionExpire event
+	// is sent for each of them if watch is enabled. 0 means no active expiration.
+	ActiveExpireInterval time.Duration
+
+	// ActiveExpireStepTime specifies how long the active expiration runs every ActiveExpireInterval.
+	// The db lock is released every few keys in the meantime.
+	ActiveExpireStepTime time.Duration
+
 	// AutoMergeEnable enable the auto merge.
 	// auto merge will be triggered when cron expr is satisfied.
 	// cron expression follows the standard cron expression.
@@ -49,16 +62,118 @@ type Options struct {
 	// do not set this shecule too frequently, it will affect the performance.
 	// refer to https://en.wikipedia.org/wiki/Cron
 	AutoMergeCronExpr string
+
+	// MergePolicy decides when the db is merged automatically, and is the general form of AutoMergeCronExpr,
+	// which is the same as a CronMergePolicy. Only one of them can be set, nil means no auto merge.
+	// ThresholdMergePolicy merges on the reclaimable bytes, the disk size or the number of sealed segments,
+	// and ConstrainedMergePolicy restricts the merges to quiet hours, a minimum interval
+	// and a maximum request rate.
+	// As with AutoMergeCronExpr, the db is reopened after the auto merge is done.
+	MergePolicy MergePolicy
+
+	// MergePolicyInterval specifies how often the MergePolicy is evaluated.
+	MergePolicyInterval time.Duration
+
+	// MergeBytesPerSecond limits the bytes read from and written to the disk per second by Merge
+	// and the auto merge, so they do not cause latency spikes of the other operations.
+	// 0 means no limit.
+	MergeBytesPerSecond int64
+
+	// Compression specifies the codec used to compress the values written to the data files.
+	// The codec is recorded in each log record, so changing it does not affect the data already written,
+	// and Merge rewrites the old records with the current codec.
+	Compression CompressionType
+
+	// CompressionMinSize specifies the minimum size in bytes of a value to be compressed.
+	// Smaller values are stored as they are, because compressing them saves little or nothing.
+	CompressionMinSize int
+
+	// KeyProvider provides the keys to encrypt the data files and the hint file with AES-GCM.
+	// Nil means the records are written in plaintext.
+	// The key id is recorded in each log record, so the keys can be rotated,
+	// and Merge rewrites the old records with the current key.
+	KeyProvider KeyProvider
+
+	// BlobThreshold specifies the minimum size in bytes of a value to be written to the blob files.
+	// The log record of such a value only keeps a pointer to it, so Merge does not copy the value again,
+	// and the space of the values overwritten or deleted is reclaimed by BlobGC instead.
+	// 0 means all values are written to the data files.
+	BlobThreshold int
+
+	// BlobFileSize specifies the maximum size in bytes of a blob file.
+	BlobFileSize int64
+
+	// IndexType specifies the type of the index.
+	// index.BTree, index.ART, index.SkipList and index.Hash keep all keys in memory and rebuild
+	// the index every time the db is opened, from the latest index snapshot if there is one,
+	// see IndexSnapshotInterval. index.Hash is the fastest for the point reads and writes,
+	// but sorts the keys for every iteration, see index.MemoryHash.
+	// index.BPTree keeps the index in a B+tree file in the db directory, only the nodes changed recently
+	// and a cache of IndexCacheSize are held in memory, and only the WAL written after the index
+	// was last persisted is replayed when the db is opened.
+	IndexType index.IndexerType
+
+	// IndexBTreeDegree specifies the degree of the nodes of index.BTree, it must be greater than 1.
+	// It is also used by index.BPTree, whose namespaces and index snapshots are btrees.
+	// 0 means the default degree.
+	IndexBTreeDegree int
+
+	// IndexSkipListMaxLevel specifies the maximum number of levels of index.SkipList.
+	// 0 means the default maximum.
+	IndexSkipListMaxLevel int
+
+	// IndexHashShards specifies the number of shards of index.Hash, each of them has its own lock.
+	// 0 means the default number of shards.
+	IndexHashShards int
+
+	// IndexCacheSize specifies the maximum memory size in bytes of the nodes cached by index.BPTree.
+	// The changed nodes are persisted when they take more than half of it.
+	IndexCacheSize int64
+
+	// IndexSnapshotInterval specifies how often the in-memory index is written to a snapshot file,
+	// with the position of the last record in the WAL, so only the WAL written after it is replayed
+	// when the db is opened. The snapshot is also written when the db is closed.
+	// 0 means the snapshot is only written by SaveIndexSnapshot.
+	IndexSnapshotInterval time.Duration
+
+	// RecoveryWorkers specifies the number of goroutines reading and decoding the segment files
+	// in parallel, when the index is rebuilt from the WAL.
+	// 0 means runtime.GOMAXPROCS(0).
+	RecoveryWorkers int
+
+	// RecoveryProgress is called after each segment file is replayed when the index is rebuilt
+	// from the WAL, with the number of segments replayed so far and the total.
+	// The statistics of the whole rebuild are reported by Stat.
+	RecoveryProgress func(segment SegmentRecoveryStat, done, total int)
 }
 
 var DefaultOptions = Options{
-	DirPath:           "./data",
-	SegmentSize:       1 * wal.GB,
-	BlockCache:        0,
-	Sync:              false,
-	BytesPerSync:      0,
-	WatchQueueSize:    0,
-	AutoMergeCronExpr: "",
+	DirPath:            "./data",
+	SegmentSize:        1 * wal.GB,
+	BlockCache:         0,
+	Sync:               false,
+	BytesPerSync:       0,
+	WatchQueueSize:     0,
+	AutoMergeCronExpr:  "",
+	Compression:        CompressionNone,
+	CompressionMinSize: 128,
+	KeyProvider:        nil,
+	BlobThreshold:      0,
+	BlobFileSize:       256 * wal.MB,
+	IndexType:          index.BTree,
+	IndexCacheSize:     64 * wal.MB,
+
+	ActiveExpireInterval:  0,
+	ActiveExpireStepTime:  10 * time.Millisecond,
+	MergePolicy:           nil,
+	MergePolicyInterval:   time.Second,
+	MergeBytesPerSecond:   0,
+	IndexSnapshotInterval: 0,
+	IndexBTreeDegree:      index.DefaultOptions.BTreeDegree,
+	IndexSkipListMaxLevel: index.DefaultOptions.SkipListMaxLevel,
+	IndexHashShards:       index.DefaultOptions.HashShards,
+	RecoveryWorkers:       runtime.NumCPU(),
+	RecoveryProgress:      nil,
 }
 
 var DefaultBatchOptions = BatchOptions{
@@ -66,6 +181,44 @@ var DefaultBatchOptions = BatchOptions{
 	ReadOnly: false,
 }
 
+var DefaultTxnOptions = TxnOptions{
+	Sync: true,
+}
+
+// withDefaults returns the options with the zero index knobs and recovery workers replaced by their defaults,
+// so the options only setting a few fields, like DirPath and SegmentSize, are valid.
+func (o Options) withDefaults() Options {
+	if o.IndexBTreeDegree == 0 {
+		o.IndexBTreeDegree = index.DefaultOptions.BTreeDegree
+	}
+	if o.IndexSkipListMaxLevel == 0 {
+		o.IndexSkipListMaxLevel = index.DefaultOptions.SkipListMaxLevel
+	}
+	if o.IndexHashShards == 0 {
+		o.IndexHashShards = index.DefaultOptions.HashShards
+	}
+	if o.RecoveryWorkers == 0 {
+		o.RecoveryWorkers = runtime.GOMAXPROCS(0)
+	}
+	return o
+}
+
+// memoryIndexOptions returns the options of the in-memory indexes of the db.
+// The namespaces and the index snapshots are always kept in memory,
+// in a btree if the index of the db is on disk.
+func (o Options) memoryIndexOptions() index.Options {
+	indexType := o.IndexType
+	if !index.IsMemory(indexType) {
+		indexType = index.BTree
+	}
+	return index.Options{
+		Type:             indexType,
+		BTreeDegree:      o.IndexBTreeDegree,
+		SkipListMaxLevel: o.IndexSkipListMaxLevel,
+		HashShards:       o.IndexHashShards,
+	}
+}
+
 func tempDBDir() string {
 	dir, _ := os.MkdirTemp("", "rosedb-temp")
 	return dir
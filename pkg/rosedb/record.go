@@ -6,9 +6,11 @@ package rosedb
 
 import (
 	"encoding/binary"
+	"math"
 
-	"github.com/JoyZF/wal"
 	"github.com/valyala/bytebufferpool"
+
+	"github.com/JoyZF/zoom/pkg/wal"
 )
 
 // LogRecordType is the type of the log record.
@@ -23,20 +25,38 @@ const (
 	LogRecordBatchFinished
 )
 
-// type batchId keySize valueSize expire
+const (
+	// the low 3 bits of the first header byte is the record type,
+	// the high bits are flags, records written before the flags existed have them all zero.
+	recordTypeMask = 0x07
+	// bit 3 is set if the value is a pointer to the blob files, see Options.BlobThreshold.
+	recordBlobFlag = 0x08
+	// bit 4-5 is the compression codec of the value.
+	recordCodecMask  = 0x30
+	recordCodecShift = 4
+	// bit 6 is set if the key and value are encrypted.
+	recordEncryptedFlag = 0x40
+	// bit 7 is set if the record belongs to a namespace other than the default one, see DB.Namespace.
+	recordNamespaceFlag = 0x80
+)
+
+// type batchId keySize valueSize expire namespace keyId
 //
-//	1  +  10  +   5   +   5   +    10  = 31
-const maxLogRecordHeaderSize = binary.MaxVarintLen32*2 + binary.MaxVarintLen64*2 + 1
+//	1  +  10  +   5   +   5   +    10  +    5    +  5  = 41
+const maxLogRecordHeaderSize = binary.MaxVarintLen32*4 + binary.MaxVarintLen64*2 + 1
 
 // LogRecord is the log record of the key/value pair.
 // It contains the key, the value, the record type and the batch id
 // It will be encoded to byte slice and written to the wal.
 type LogRecord struct {
-	Key     []byte
-	Value   []byte
-	Type    LogRecordType
-	BatchId uint64
-	Expire  int64
+	Key       []byte
+	Value     []byte
+	Type      LogRecordType
+	BatchId   uint64
+	Expire    int64
+	codec     CompressionType // compression codec of Value, see decompress.
+	blob      bool            // Value is a blobPointer, see DB.loadValue.
+	namespace uint32          // id of the namespace of Key, 0 is the default namespace.
 }
 
 // IsExpired checks whether the log record is expired.
@@ -51,15 +71,50 @@ type IndexRecord struct {
 	key        []byte
 	recordType LogRecordType
 	position   *wal.ChunkPosition
+	batchId    uint64
+	expired    bool
+	namespace  uint32
 }
 
 // +-------------+-------------+-------------+--------------+---------------+---------+--------------+
-// |    type     |  batch id   |   key size  |   value size |     expire    |  key    |      value   |
+// | type+flags  |  batch id   |   key size  |   value size |     expire    |  key    |      value   |
 // +-------------+-------------+-------------+--------------+---------------+--------+--------------+
 //
 //	1 byte	      varint            varint       varint         varint        varint      varint
-func encodeLogRecord(logRecord *LogRecord, header []byte, buf *bytebufferpool.ByteBuffer) []byte {
-	header[0] = logRecord.Type
+//
+// The value is written as it is, so it must already be compressed with the codec of the record.
+//
+// The records of a namespace other than the default one have the id of the namespace after the expire:
+//
+// +-------------+-----+--------------+--------------+-------+
+// | type+flags  | ... |    expire    |  namespace   |  key  | ...
+// +-------------+-----+--------------+--------------+-------+
+//
+//	uvarint
+//
+// If c is not nil, the key and value are encrypted with the current key of c,
+// the id of the key is appended to the header, and the header is authenticated along with them:
+//
+// +-------------+-----+--------------+---------+-------+------------------------+
+// | type+flags  | ... |    expire    |  key id | nonce |  encrypted key + value |
+// +-------------+-----+--------------+---------+-------+------------------------+
+func encodeLogRecord(logRecord *LogRecord, header []byte, buf *bytebufferpool.ByteBuffer,
+	c *recordCipher) ([]byte, error) {
+	header[0] = logRecord.Type | logRecord.codec<<recordCodecShift
+	var keyId uint32
+	if c != nil {
+		var err error
+		if keyId, err = c.currentKeyID(); err != nil {
+			return nil, err
+		}
+		header[0] |= recordEncryptedFlag
+	}
+	if logRecord.blob {
+		header[0] |= recordBlobFlag
+	}
+	if logRecord.namespace != defaultNamespaceID {
+		header[0] |= recordNamespaceFlag
+	}
 	var index = 1
 
 	// batch id
@@ -70,47 +125,95 @@ func encodeLogRecord(logRecord *LogRecord, header []byte, buf *bytebufferpool.By
 	index += binary.PutVarint(header[index:], int64(len(logRecord.Value)))
 	// expire
 	index += binary.PutVarint(header[index:], logRecord.Expire)
-
-	// copy header
+	// namespace
+	if logRecord.namespace != defaultNamespaceID {
+		index += binary.PutUvarint(header[index:], uint64(logRecord.namespace))
+	}
+
+	if c == nil {
+		// copy header
+		_, _ = buf.Write(header[:index])
+		// copy key
+		_, _ = buf.Write(logRecord.Key)
+		// copy value
+		_, _ = buf.Write(logRecord.Value)
+		return buf.Bytes(), nil
+	}
+
+	// key id
+	index += binary.PutUvarint(header[index:], uint64(keyId))
 	_, _ = buf.Write(header[:index])
-	// copy key
-	_, _ = buf.Write(logRecord.Key)
-	// copy value
-	_, _ = buf.Write(logRecord.Value)
-
-	return buf.Bytes()
+	plaintext := make([]byte, 0, len(logRecord.Key)+len(logRecord.Value))
+	plaintext = append(append(plaintext, logRecord.Key...), logRecord.Value...)
+	sealed, err := c.seal(buf.B, keyId, plaintext, header[:index])
+	if err != nil {
+		return nil, err
+	}
+	buf.B = sealed
+	return buf.Bytes(), nil
 }
 
-// decodeLogRecord decodes the log record from the given byte slice.
-func decodeLogRecord(buf []byte) *LogRecord {
-	recordType := buf[0]
+// decodeLogRecord decodes the log record from the given byte slice,
+// c is used to decrypt the encrypted records, and can be nil if the records are not encrypted.
+// The value is not decompressed, call decompress on the record if the value is needed.
+func decodeLogRecord(buf []byte, c *recordCipher) (*LogRecord, error) {
+	if len(buf) == 0 {
+		return nil, ErrInvalidLogRecord
+	}
+	recordType := buf[0] & recordTypeMask
+	codec := (buf[0] & recordCodecMask) >> recordCodecShift
 
-	var index uint32 = 1
-	// batch id
-	batchId, n := binary.Uvarint(buf[index:])
-	index += uint32(n)
-
-	// key size
-	keySize, n := binary.Varint(buf[index:])
-	index += uint32(n)
-
-	// value size
-	valueSize, n := binary.Varint(buf[index:])
-	index += uint32(n)
-
-	// expire
-	expire, n := binary.Varint(buf[index:])
-	index += uint32(n)
+	var index = 1
+	var n int
+	// batch id, key size, value size, expire
+	var batchId uint64
+	var keySize, valueSize, expire int64
+	if batchId, n = binary.Uvarint(buf[index:]); n <= 0 {
+		return nil, ErrInvalidLogRecord
+	}
+	index += n
+	for _, field := range []*int64{&keySize, &valueSize, &expire} {
+		if *field, n = binary.Varint(buf[index:]); n <= 0 {
+			return nil, ErrInvalidLogRecord
+		}
+		index += n
+	}
+	var namespace uint64
+	if buf[0]&recordNamespaceFlag != 0 {
+		if namespace, n = binary.Uvarint(buf[index:]); n <= 0 || namespace > math.MaxUint32 {
+			return nil, ErrInvalidLogRecord
+		}
+		index += n
+	}
+
+	payload := buf[index:]
+	if buf[0]&recordEncryptedFlag != 0 {
+		if c == nil {
+			return nil, ErrEncryptionKeyRequired
+		}
+		keyId, n := binary.Uvarint(buf[index:])
+		if n <= 0 {
+			return nil, ErrInvalidLogRecord
+		}
+		index += n
+		plaintext, err := c.open(uint32(keyId), buf[index:], buf[:index])
+		if err != nil {
+			return nil, err
+		}
+		payload = plaintext
+	}
+	if keySize < 0 || valueSize < 0 || int64(len(payload)) < keySize+valueSize {
+		return nil, ErrInvalidLogRecord
+	}
 
 	// copy key
 	key := make([]byte, keySize)
-	copy(key[:], buf[index:index+uint32(keySize)])
-	index += uint32(keySize)
+	copy(key, payload[:keySize])
 
 	// copy value
 	value := make([]byte, valueSize)
-	copy(value[:], buf[index:index+uint32(valueSize)])
+	copy(value, payload[keySize:keySize+valueSize])
 
-	return &LogRecord{Key: key, Value: value, Expire: expire,
-		BatchId: batchId, Type: recordType}
+	return &LogRecord{Key: key, Value: value, Expire: expire, BatchId: batchId, Type: recordType,
+		codec: codec, blob: buf[0]&recordBlobFlag != 0, namespace: uint32(namespace)}, nil
 }
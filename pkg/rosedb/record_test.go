@@ -16,15 +16,21 @@ func Test_encodeLogRecord(t *testing.T) {
 	buf := bytebufferpool.ByteBuffer{
 		B: []byte{},
 	}
-	bytes := encodeLogRecord(&LogRecord{
+	bytes, err := encodeLogRecord(&LogRecord{
 		Key:     []byte("this is key"),
 		Value:   []byte("this is value"),
 		Type:    LogRecordDeleted,
 		BatchId: 1,
 		Expire:  0,
-	}, []byte("header"), &buf)
+	}, make([]byte, maxLogRecordHeaderSize), &buf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
 
-	logRecord := decodeLogRecord(bytes)
+	logRecord, err := decodeLogRecord(bytes, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
 	fmt.Println(string(logRecord.Key))
 	b, _ := json.Marshal(logRecord)
 	fmt.Println(string(b))
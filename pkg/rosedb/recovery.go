@@ -0,0 +1,275 @@
+// Copyright 2024 Joy <joyssss94@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package rosedb
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/snowflake"
+
+	"github.com/JoyZF/zoom/pkg/wal"
+)
+
+// RecoveryStat is the statistics of the index rebuilt from the WAL
+// when the db was opened or reloaded after a merge.
+type RecoveryStat struct {
+	// Segments are the segment files replayed, in the order they were applied to the index.
+	Segments []SegmentRecoveryStat
+	// Records is the number of records replayed.
+	Records int
+	// Duration is the time taken to replay the WAL.
+	Duration time.Duration
+}
+
+// SegmentRecoveryStat is the statistics of a segment file replayed to rebuild the index.
+type SegmentRecoveryStat struct {
+	SegmentId wal.SegmentID
+	// Records is the number of records read from the segment.
+	Records int
+	// Duration is the time taken to read and decode the segment.
+	Duration time.Duration
+}
+
+// segmentRecovery is a segment file read by a recovery worker.
+type segmentRecovery struct {
+	id      wal.SegmentID
+	records []*IndexRecord
+	stat    SegmentRecoveryStat
+	err     error
+	done    chan struct{}
+}
+
+// loadIndexFromWAL loads index from WAL.
+// It will iterate over all the WAL files and read data
+// from them to rebuild the index.
+// If start is not nil, only the records after it are loaded.
+//
+// The segments are read and decoded by Options.RecoveryWorkers goroutines,
+// and applied to the index one after another in the order they were written,
+// so the later records win, and the records of the unfinished batches are discarded.
+func (db *DB) loadIndexFromWAL(start *wal.ChunkPosition) error {
+	begin := time.Now()
+	mergeFinSegmentId, err := getMergeFinSegmentId(db.options.DirPath)
+	if err != nil {
+		return err
+	}
+	segmentIds, err := db.walSegmentIds()
+	if err != nil {
+		return err
+	}
+	var segments []*segmentRecovery
+	for _, id := range segmentIds {
+		// if the current segment id is less than the mergeFinSegmentId,
+		// we can skip this segment because it has been merged,
+		// and we can load index from the hint file directly.
+		// The segments before start are skipped as well.
+		if id <= mergeFinSegmentId || (start != nil && id < start.SegmentId) {
+			continue
+		}
+		segments = append(segments, &segmentRecovery{id: id, done: make(chan struct{})})
+	}
+
+	// the workers read ahead a limited number of segments, so the decoded records
+	// waiting to be applied do not take too much memory.
+	workers := db.options.RecoveryWorkers
+	if workers <= 0 {
+		workers = 1
+	}
+	jobs := make(chan *segmentRecovery)
+	readAhead := make(chan struct{}, workers*2)
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	defer func() {
+		close(stop)
+		wg.Wait()
+	}()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(jobs)
+		for _, segment := range segments {
+			select {
+			case readAhead <- struct{}{}:
+			case <-stop:
+				return
+			}
+			select {
+			case jobs <- segment:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	now := time.Now().UnixNano()
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for segment := range jobs {
+				db.readSegment(segment, start, now)
+			}
+		}()
+	}
+
+	indexRecords := make(map[uint64][]*IndexRecord)
+	stat := RecoveryStat{Segments: make([]SegmentRecoveryStat, 0, len(segments))}
+	db.walPosition = start
+	for i, segment := range segments {
+		<-segment.done
+		<-readAhead
+		if segment.err != nil {
+			return segment.err
+		}
+		db.applyIndexRecords(segment.records, indexRecords)
+		if len(segment.records) > 0 {
+			db.walPosition = segment.records[len(segment.records)-1].position
+		}
+		segment.records = nil
+
+		stat.Segments = append(stat.Segments, segment.stat)
+		stat.Records += segment.stat.Records
+		if db.options.RecoveryProgress != nil {
+			db.options.RecoveryProgress(segment.stat, i+1, len(segments))
+		}
+	}
+	// the records of the batches never finished are garbage.
+	for _, records := range indexRecords {
+		for _, record := range records {
+			db.garbage.add(record.position)
+		}
+	}
+	stat.Duration = time.Since(begin)
+	db.recoveryStat = stat
+	return nil
+}
+
+// walSegmentIds returns the ids of the segment files of the WAL in ascending order.
+func (db *DB) walSegmentIds() ([]wal.SegmentID, error) {
+	entries, err := os.ReadDir(db.options.DirPath)
+	if err != nil {
+		return nil, err
+	}
+	var ids []wal.SegmentID
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), dataFileNameSuffix) {
+			continue
+		}
+		var id wal.SegmentID
+		if _, err := fmt.Sscanf(entry.Name(), "%d"+dataFileNameSuffix, &id); err != nil {
+			continue
+		}
+		// the entries are sorted by file name, which is the zero padded id.
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// readSegment reads and decodes the records in the segment after start,
+// the expired records are marked with a deleted type, so they are removed from the index.
+func (db *DB) readSegment(segment *segmentRecovery, start *wal.ChunkPosition, now int64) {
+	defer close(segment.done)
+	begin := time.Now()
+
+	reader := db.dataFiles.NewReaderWithMax(segment.id)
+	for reader.CurrentSegmentId() < segment.id {
+		reader.SkipCurrentSegment()
+	}
+	for {
+		chunk, position, err := reader.Next()
+		if err != nil {
+			if err != io.EOF {
+				segment.err = err
+			}
+			break
+		}
+		if start != nil && !positionAfter(position, start) {
+			continue
+		}
+		// decode and get log record
+		record, err := decodeLogRecord(chunk, db.cipher)
+		if err != nil {
+			segment.err = err
+			break
+		}
+		indexRecord := &IndexRecord{
+			key:        record.Key,
+			recordType: record.Type,
+			position:   position,
+			batchId:    record.BatchId,
+			namespace:  record.namespace,
+		}
+		if record.Type == LogRecordBatchFinished {
+			batchId, err := snowflake.ParseBytes(record.Key)
+			if err != nil {
+				segment.err = err
+				break
+			}
+			indexRecord.batchId = uint64(batchId)
+		} else if record.Type == LogRecordNormal && record.IsExpired(now) {
+			indexRecord.expired = true
+		}
+		segment.records = append(segment.records, indexRecord)
+	}
+
+	segment.stat = SegmentRecoveryStat{
+		SegmentId: segment.id,
+		Records:   len(segment.records),
+		Duration:  time.Since(begin),
+	}
+}
+
+// applyIndexRecords applies the records of a segment to the index of their namespace,
+// indexRecords holds the records of the batches not finished yet.
+// The records of the dropped namespaces are garbage.
+func (db *DB) applyIndexRecords(records []*IndexRecord, indexRecords map[uint64][]*IndexRecord) {
+	for _, record := range records {
+		// the index is nil for the records of a dropped namespace.
+		idx := db.namespaceIndex(record.namespace)
+		// if we get the end of a batch,
+		// all records in this batch are ready to be indexed.
+		if record.recordType == LogRecordBatchFinished {
+			for _, idxRecord := range indexRecords[record.batchId] {
+				batchIdx := db.namespaceIndex(idxRecord.namespace)
+				if batchIdx == nil {
+					db.garbage.add(idxRecord.position)
+					continue
+				}
+				if idxRecord.recordType == LogRecordNormal {
+					db.garbage.add(batchIdx.Put(idxRecord.key, idxRecord.position))
+				}
+				if idxRecord.recordType == LogRecordDeleted {
+					oldPosition, _ := batchIdx.Delete(idxRecord.key)
+					db.garbage.add(oldPosition)
+					db.garbage.add(idxRecord.position)
+				}
+			}
+			db.garbage.add(record.position)
+			// delete indexRecords according to batchId after indexing
+			delete(indexRecords, record.batchId)
+		} else if record.recordType == LogRecordNormal && record.batchId == mergeFinishedBatchID {
+			// if the record is a normal record and the batch id is 0,
+			// it means that the record is involved in the merge operation.
+			// so put the record into index directly.
+			if idx == nil {
+				db.garbage.add(record.position)
+			} else {
+				db.garbage.add(idx.Put(record.key, record.position))
+			}
+		} else if record.expired && idx != nil {
+			// expired records should not be indexed
+			oldPosition, _ := idx.Delete(record.key)
+			db.garbage.add(oldPosition)
+			db.garbage.add(record.position)
+		} else {
+			// put the record into the temporary indexRecords
+			indexRecords[record.batchId] = append(indexRecords[record.batchId], record)
+		}
+	}
+}
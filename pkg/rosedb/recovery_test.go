@@ -0,0 +1,87 @@
+// Copyright 2024 Joy <joyssss94@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package rosedb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/valyala/bytebufferpool"
+
+	"github.com/JoyZF/zoom/pkg/wal"
+	"github.com/JoyZF/zoom/utils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_Recovery_Parallel(t *testing.T) {
+	options := DefaultOptions
+	options.SegmentSize = 64 * wal.KB
+	options.RecoveryWorkers = 1
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer func() {
+		destroyDB(db)
+	}()
+
+	values := make(map[string][]byte)
+	for round := 0; round < 3; round++ {
+		for i := 0; i < 1000; i++ {
+			key, value := utils.GetTestKey(i), utils.RandomValue(64)
+			values[string(key)] = value
+			assert.Nil(t, db.Put(key, value))
+		}
+		batch := db.NewBatch(DefaultBatchOptions)
+		for i := round * 100; i < round*100+100; i++ {
+			assert.Nil(t, batch.Delete(utils.GetTestKey(i)))
+			delete(values, string(utils.GetTestKey(i)))
+		}
+		assert.Nil(t, batch.Commit())
+	}
+	check := func() {
+		assert.Equal(t, len(values), db.Stat().KeysNum)
+		for key, value := range values {
+			val, err := db.Get([]byte(key))
+			assert.Nil(t, err)
+			assert.Equal(t, value, val)
+		}
+	}
+	check()
+	assert.Nil(t, db.PutWithTTL([]byte("expired"), []byte("value"), time.Millisecond))
+
+	// the records of a batch which is not finished are discarded
+	unfinished := &LogRecord{Key: utils.GetTestKey(999), Value: []byte("unfinished"), Type: LogRecordNormal, BatchId: 1}
+	chunk, err := encodeLogRecord(unfinished, db.encodeHeader, bytebufferpool.Get(), db.cipher)
+	assert.Nil(t, err)
+	_, err = db.dataFiles.Write(chunk)
+	assert.Nil(t, err)
+	time.Sleep(10 * time.Millisecond)
+
+	// the result does not depend on the number of workers
+	for _, workers := range []int{0, 1, 2, 8} {
+		assert.Nil(t, db.Close())
+		options.RecoveryWorkers = workers
+		var progress []SegmentRecoveryStat
+		options.RecoveryProgress = func(segment SegmentRecoveryStat, done, total int) {
+			progress = append(progress, segment)
+			assert.Equal(t, len(progress), done)
+			assert.Equal(t, int(db.dataFiles.ActiveSegmentID()), total)
+		}
+		db, err = Open(options)
+		assert.Nil(t, err)
+		check()
+
+		stat := db.Stat().Recovery
+		assert.Equal(t, progress, stat.Segments)
+		assert.True(t, len(stat.Segments) > 2)
+		var records int
+		for i, segment := range stat.Segments {
+			assert.Equal(t, wal.SegmentID(i+1), segment.SegmentId)
+			records += segment.Records
+		}
+		assert.Equal(t, records, stat.Records)
+		assert.True(t, stat.Duration > 0)
+	}
+}
@@ -0,0 +1,157 @@
+// Copyright 2024 Joy <joyssss94@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package rosedb
+
+import (
+	"time"
+
+	"github.com/JoyZF/zoom/pkg/rosedb/index"
+	"github.com/JoyZF/zoom/pkg/wal"
+)
+
+// Snapshot is a read-only view of the db at the time it was created.
+//
+// It holds a copy-on-write clone of the index, so later Put, Delete and Merge
+// operations are not visible to it. Records expire according to the creation time
+// of the snapshot, not the time they are read.
+//
+// The data files referenced by a snapshot are kept open until it is released,
+// even if Merge replaces them in the db directory.
+// So the snapshot must be released after use.
+type Snapshot struct {
+	db        *DB
+	index     index.Indexer
+	dataFiles *wal.WAL
+	ts        int64 // creation time of the snapshot in unix nano
+	released  bool
+}
+
+// Snapshot returns a read-only view of the current state of the db.
+func (db *DB) Snapshot() (*Snapshot, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed {
+		return nil, ErrDBClosed
+	}
+	return db.snapshotLocked(), nil
+}
+
+// snapshotLocked creates a snapshot of the current state of the db.
+// The caller must hold db.mu.
+func (db *DB) snapshotLocked() *Snapshot {
+	db.snapshots[db.dataFiles]++
+	return &Snapshot{
+		db:        db,
+		index:     db.index.Clone(),
+		dataFiles: db.dataFiles,
+		ts:        time.Now().UnixNano(),
+	}
+}
+
+// Get the value of the key as it was when the snapshot was created.
+func (s *Snapshot) Get(key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+	pos := s.index.Get(key)
+	if pos == nil {
+		return nil, ErrKeyNotFound
+	}
+	record, err := s.readRecord(pos)
+	if err != nil {
+		return nil, err
+	}
+	if record == nil {
+		return nil, ErrKeyNotFound
+	}
+	return record.Value, nil
+}
+
+// Exist reports whether the key existed when the snapshot was created.
+func (s *Snapshot) Exist(key []byte) (bool, error) {
+	_, err := s.Get(key)
+	if err == ErrKeyNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// NewIterator returns an iterator over the keys of the snapshot.
+// The iterator must be closed before the snapshot is released.
+func (s *Snapshot) NewIterator(options IteratorOptions) (*Iterator, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	if s.db.closed {
+		return nil, ErrDBClosed
+	}
+	if s.released {
+		return nil, ErrSnapshotReleased
+	}
+	return newIterator(s.index.Iterator(options.Reverse), s.readRecord, options), nil
+}
+
+// Release releases the snapshot,
+// the data files only referenced by it will be closed.
+func (s *Snapshot) Release() {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	if s.released {
+		return
+	}
+	s.released = true
+	if !s.db.closed {
+		s.db.releaseDataFiles(s.dataFiles)
+	}
+}
+
+// readRecord reads the record at the given position from the data files of the snapshot,
+// and returns nil if the record was deleted or expired when the snapshot was created.
+func (s *Snapshot) readRecord(pos *wal.ChunkPosition) (*LogRecord, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	if s.db.closed {
+		return nil, ErrDBClosed
+	}
+	if s.released {
+		return nil, ErrSnapshotReleased
+	}
+	chunk, err := s.dataFiles.Read(pos)
+	if err != nil {
+		return nil, err
+	}
+	record, err := decodeLogRecord(chunk, s.db.cipher)
+	if err != nil {
+		return nil, err
+	}
+	if record.Type == LogRecordDeleted || record.IsExpired(s.ts) {
+		return nil, nil
+	}
+	if err := s.db.loadValue(record); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// releaseDataFiles drops a snapshot reference to the data files,
+// and closes them if they have been replaced by Merge and are no longer referenced.
+// The caller must hold db.mu.
+func (db *DB) releaseDataFiles(dataFiles *wal.WAL) {
+	db.snapshots[dataFiles]--
+	if db.snapshots[dataFiles] > 0 {
+		return
+	}
+	delete(db.snapshots, dataFiles)
+	if dataFiles != db.dataFiles {
+		_ = dataFiles.Close()
+	}
+	// the blob files collected by BlobGC are removed once no snapshot may read them
+	if len(db.snapshots) == 0 {
+		_ = db.blobs.removeObsolete()
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright 2024 Joy <joyssss94@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package rosedb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/JoyZF/zoom/utils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshot_Get_Iterate(t *testing.T) {
+	db, err := Open(DefaultOptions)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	putIteratorTestData(t, db)
+	assert.Nil(t, db.PutWithTTL([]byte("kiwi"), []byte("value-kiwi"), 200*time.Millisecond))
+
+	snap, err := db.Snapshot()
+	assert.Nil(t, err)
+	defer snap.Release()
+
+	// changes after the snapshot is created
+	assert.Nil(t, db.Put([]byte("apple"), []byte("new-apple")))
+	assert.Nil(t, db.Delete([]byte("banana")))
+	assert.Nil(t, db.Put([]byte("fig"), []byte("value-fig")))
+	time.Sleep(300 * time.Millisecond)
+
+	val, err := snap.Get([]byte("apple"))
+	assert.Nil(t, err)
+	assert.Equal(t, "value-apple", string(val))
+	val, err = snap.Get([]byte("banana"))
+	assert.Nil(t, err)
+	assert.Equal(t, "value-banana", string(val))
+	_, err = snap.Get([]byte("fig"))
+	assert.Equal(t, ErrKeyNotFound, err)
+	// kiwi was alive when the snapshot was created
+	exist, err := snap.Exist([]byte("kiwi"))
+	assert.Nil(t, err)
+	assert.True(t, exist)
+
+	iter, err := snap.NewIterator(IteratorOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"apple", "banana", "cherry", "date", "grape", "kiwi"}, collectKeys(iter))
+	iter.Close()
+
+	val, err = db.Get([]byte("apple"))
+	assert.Nil(t, err)
+	assert.Equal(t, "new-apple", string(val))
+	_, err = db.Get([]byte("banana"))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestSnapshot_Merge(t *testing.T) {
+	options := DefaultOptions
+	options.SegmentSize = 32 * 1024 * 1024
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	kvs := make(map[int][]byte)
+	for i := 0; i < 100000; i++ {
+		kvs[i] = utils.RandomValue(128)
+		assert.Nil(t, db.Put(utils.GetTestKey(i), kvs[i]))
+	}
+	snap, err := db.Snapshot()
+	assert.Nil(t, err)
+
+	// overwrite and delete everything, so the merged segments contain nothing the snapshot needs
+	for i := 0; i < 100000; i++ {
+		if i%2 == 0 {
+			assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+		} else {
+			assert.Nil(t, db.Put(utils.GetTestKey(i), []byte("new")))
+		}
+	}
+	assert.Nil(t, db.Merge(true))
+
+	for i := 0; i < 100000; i += 1000 {
+		val, err := snap.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, kvs[i], val)
+	}
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, "new", string(val))
+
+	snap.Release()
+	_, err = snap.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrSnapshotReleased, err)
+	_, err = snap.NewIterator(IteratorOptions{})
+	assert.Equal(t, ErrSnapshotReleased, err)
+}
+
+func TestSnapshot_Closed_DB(t *testing.T) {
+	db, err := Open(DefaultOptions)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+	putIteratorTestData(t, db)
+
+	snap, err := db.Snapshot()
+	assert.Nil(t, err)
+	assert.Nil(t, db.Close())
+
+	_, err = snap.Get([]byte("apple"))
+	assert.Equal(t, ErrDBClosed, err)
+	snap.Release()
+
+	_, err = db.Snapshot()
+	assert.Equal(t, ErrDBClosed, err)
+}
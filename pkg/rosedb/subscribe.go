@@ -0,0 +1,259 @@
+// Copyright 2024 Joy <joyssss94@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package rosedb
+
+import (
+	"bytes"
+	"errors"
+	"path"
+	"sync"
+	"sync/atomic"
+)
+
+// OverflowPolicy specifies what happens when an event is published to a subscription whose buffer is full.
+type OverflowPolicy = byte
+
+const (
+	// OverflowDropOldest drops the oldest event in the buffer to make room for the new one.
+	// It is the default, so a slow subscriber never holds up the writes.
+	OverflowDropOldest OverflowPolicy = iota
+	// OverflowDisconnect drops the event and closes the subscription with ErrSubscriptionOverflow.
+	OverflowDisconnect
+	// OverflowBlock makes the write wait until the subscriber receives the event,
+	// so a subscriber that stops receiving blocks the writes to the db until it is closed.
+	// It is only meant for the subscribers that must not miss an event and always keep up,
+	// and has to be chosen explicitly.
+	OverflowBlock
+)
+
+// SubscribeOptions specifies the events received by a subscription and how they are buffered.
+type SubscribeOptions struct {
+	// Prefix only receives the events of the keys with the prefix, empty means all keys.
+	Prefix []byte
+
+	// Pattern only receives the events of the keys matching the pattern, in the syntax of path.Match.
+	// Empty means all keys.
+	Pattern string
+
+	// Actions only receives the events of the actions, empty means all actions.
+	Actions []WatchActionType
+
+	// BufferSize is the number of events buffered for the subscriber.
+	BufferSize int
+
+	// Overflow specifies what happens when the buffer is full.
+	// The zero value is OverflowDropOldest, OverflowBlock is opt-in.
+	Overflow OverflowPolicy
+}
+
+var DefaultSubscribeOptions = SubscribeOptions{
+	Prefix:     nil,
+	Pattern:    "",
+	Actions:    nil,
+	BufferSize: 1024,
+	Overflow:   OverflowDropOldest,
+}
+
+// Subscription receives the events of the writes to the db, see DB.Subscribe.
+// The events are shared by all subscriptions, so they must not be modified.
+type Subscription struct {
+	db      *DB
+	options SubscribeOptions
+	events  chan *Event
+	dropped uint64
+
+	mu       sync.Mutex
+	closed   bool
+	err      error
+	done     chan struct{} // closed to release the writer blocked by OverflowBlock
+	doneOnce sync.Once
+}
+
+// Subscribe returns a new subscription to the events of the writes to the db,
+// which is independent of the other subscriptions and of Watch.
+// The events are delivered to Subscription.Events as soon as they are committed.
+func (db *DB) Subscribe(options SubscribeOptions) (*Subscription, error) {
+	if options.Pattern != "" {
+		if _, err := path.Match(options.Pattern, ""); err != nil {
+			return nil, err
+		}
+	}
+	if options.BufferSize <= 0 {
+		return nil, errors.New("the subscription buffer size must be greater than 0")
+	}
+	if options.Overflow > OverflowBlock {
+		return nil, errors.New("the subscription overflow policy is invalid")
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return nil, ErrDBClosed
+	}
+
+	s := &Subscription{
+		db:      db,
+		options: options,
+		events:  make(chan *Event, options.BufferSize),
+		done:    make(chan struct{}),
+	}
+	db.subscriptionsMu.Lock()
+	defer db.subscriptionsMu.Unlock()
+	var subscriptions []*Subscription
+	if current := db.subscriptions.Load(); current != nil {
+		subscriptions = append(subscriptions, *current...)
+	}
+	subscriptions = append(subscriptions, s)
+	db.subscriptions.Store(&subscriptions)
+	return s, nil
+}
+
+// Events returns the channel of the events, it is closed when the subscription is closed.
+func (s *Subscription) Events() <-chan *Event {
+	return s.events
+}
+
+// Dropped returns the number of the events dropped because the buffer was full.
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Err returns why the subscription was closed, ErrSubscriptionOverflow or ErrDBClosed,
+// or nil if it is open or closed by Close.
+func (s *Subscription) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// Close ends the subscription and closes its channel.
+func (s *Subscription) Close() {
+	s.close(nil)
+}
+
+func (s *Subscription) close(err error) {
+	// release the writer blocked on the full buffer, which holds s.mu.
+	s.doneOnce.Do(func() {
+		close(s.done)
+	})
+	s.mu.Lock()
+	s.closeLocked(err)
+	s.mu.Unlock()
+}
+
+// closeLocked closes the channel and removes the subscription from the db.
+// The caller must hold s.mu.
+func (s *Subscription) closeLocked(err error) {
+	if s.closed {
+		return
+	}
+	s.closed, s.err = true, err
+	close(s.events)
+	s.db.removeSubscription(s)
+}
+
+func (s *Subscription) match(e *Event) bool {
+	if len(s.options.Actions) > 0 && bytes.IndexByte(s.options.Actions, e.Action) < 0 {
+		return false
+	}
+	if len(s.options.Prefix) > 0 && !bytes.HasPrefix(e.Key, s.options.Prefix) {
+		return false
+	}
+	if s.options.Pattern != "" {
+		if matched, _ := path.Match(s.options.Pattern, string(e.Key)); !matched {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Subscription) publish(e *Event) {
+	if !s.match(e) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+
+	switch s.options.Overflow {
+	case OverflowBlock:
+		select {
+		case s.events <- e:
+		case <-s.done:
+			atomic.AddUint64(&s.dropped, 1)
+		}
+	case OverflowDropOldest:
+		for {
+			select {
+			case s.events <- e:
+				return
+			default:
+			}
+			select {
+			case <-s.events:
+				atomic.AddUint64(&s.dropped, 1)
+			default:
+			}
+		}
+	case OverflowDisconnect:
+		select {
+		case s.events <- e:
+		default:
+			atomic.AddUint64(&s.dropped, 1)
+			s.doneOnce.Do(func() {
+				close(s.done)
+			})
+			s.closeLocked(ErrSubscriptionOverflow)
+		}
+	}
+}
+
+// watching reports whether the events of the writes are needed by Watch or a subscription.
+func (db *DB) watching() bool {
+	if db.options.WatchQueueSize > 0 {
+		return true
+	}
+	subscriptions := db.subscriptions.Load()
+	return subscriptions != nil && len(*subscriptions) > 0
+}
+
+// publish sends the event to Watch and to all subscriptions.
+func (db *DB) publish(e *Event) {
+	if db.options.WatchQueueSize > 0 {
+		db.watcher.putEvent(e)
+	}
+	if subscriptions := db.subscriptions.Load(); subscriptions != nil {
+		for _, s := range *subscriptions {
+			s.publish(e)
+		}
+	}
+}
+
+func (db *DB) removeSubscription(s *Subscription) {
+	db.subscriptionsMu.Lock()
+	defer db.subscriptionsMu.Unlock()
+	current := db.subscriptions.Load()
+	if current == nil {
+		return
+	}
+	subscriptions := make([]*Subscription, 0, len(*current))
+	for _, sub := range *current {
+		if sub != s {
+			subscriptions = append(subscriptions, sub)
+		}
+	}
+	db.subscriptions.Store(&subscriptions)
+}
+
+// closeSubscriptions closes all subscriptions with ErrDBClosed.
+func (db *DB) closeSubscriptions() {
+	if subscriptions := db.subscriptions.Load(); subscriptions != nil {
+		for _, s := range *subscriptions {
+			s.close(ErrDBClosed)
+		}
+	}
+}
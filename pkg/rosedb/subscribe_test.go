@@ -0,0 +1,189 @@
+// Copyright 2024 Joy <joyssss94@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package rosedb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// receive returns the events buffered in the subscription.
+func receive(s *Subscription) []*Event {
+	var events []*Event
+	for {
+		select {
+		case e, ok := <-s.Events():
+			if !ok {
+				return events
+			}
+			events = append(events, e)
+		case <-time.After(50 * time.Millisecond):
+			return events
+		}
+	}
+}
+
+func eventKeys(events []*Event) []string {
+	keys := make([]string, 0, len(events))
+	for _, e := range events {
+		keys = append(keys, string(e.Key))
+	}
+	return keys
+}
+
+func TestDB_Subscribe_Filter(t *testing.T) {
+	db, err := Open(DefaultOptions)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	all, err := db.Subscribe(DefaultSubscribeOptions)
+	assert.Nil(t, err)
+	options := DefaultSubscribeOptions
+	options.Prefix = []byte("user:")
+	users, err := db.Subscribe(options)
+	assert.Nil(t, err)
+	options = DefaultSubscribeOptions
+	options.Pattern = "*:1"
+	ones, err := db.Subscribe(options)
+	assert.Nil(t, err)
+	options = DefaultSubscribeOptions
+	options.Actions = []WatchActionType{WatchActionDelete}
+	deletes, err := db.Subscribe(options)
+	assert.Nil(t, err)
+
+	assert.Nil(t, db.Put([]byte("user:1"), []byte("a")))
+	assert.Nil(t, db.Put([]byte("user:2"), []byte("b")))
+	assert.Nil(t, db.Put([]byte("order:1"), []byte("c")))
+	assert.Nil(t, db.Delete([]byte("user:2")))
+
+	events := receive(all)
+	assert.Equal(t, []string{"user:1", "user:2", "order:1", "user:2"}, eventKeys(events))
+	assert.Equal(t, WatchActionPut, events[0].Action)
+	assert.Equal(t, []byte("a"), events[0].Value)
+	assert.Equal(t, WatchActionDelete, events[3].Action)
+	assert.Equal(t, []string{"user:1", "user:2", "user:2"}, eventKeys(receive(users)))
+	assert.Equal(t, []string{"user:1", "order:1"}, eventKeys(receive(ones)))
+	assert.Equal(t, []string{"user:2"}, eventKeys(receive(deletes)))
+
+	// a closed subscription no longer receives events
+	users.Close()
+	assert.Nil(t, db.Put([]byte("user:3"), []byte("d")))
+	assert.Empty(t, receive(users))
+	assert.Nil(t, users.Err())
+	assert.Equal(t, []string{"user:3"}, eventKeys(receive(all)))
+
+	options = DefaultSubscribeOptions
+	options.Pattern = "["
+	_, err = db.Subscribe(options)
+	assert.NotNil(t, err)
+	options = DefaultSubscribeOptions
+	options.BufferSize = 0
+	_, err = db.Subscribe(options)
+	assert.NotNil(t, err)
+}
+
+func TestDB_Subscribe_DefaultOverflow(t *testing.T) {
+	db, err := Open(DefaultOptions)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	// a subscriber that stops receiving does not block the writes by default
+	options := DefaultSubscribeOptions
+	options.BufferSize = 2
+	s, err := db.Subscribe(options)
+	assert.Nil(t, err)
+	written := make(chan struct{})
+	go func() {
+		for _, key := range []string{"k1", "k2", "k3", "k4"} {
+			assert.Nil(t, db.Put([]byte(key), []byte("v")))
+		}
+		close(written)
+	}()
+	select {
+	case <-written:
+	case <-time.After(time.Second):
+		t.Fatal("the write is blocked by the full subscription")
+	}
+	assert.Equal(t, []string{"k3", "k4"}, eventKeys(receive(s)))
+	assert.Equal(t, uint64(2), s.Dropped())
+}
+
+func TestDB_Subscribe_Overflow(t *testing.T) {
+	db, err := Open(DefaultOptions)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	options := DefaultSubscribeOptions
+	options.BufferSize = 2
+	options.Overflow = OverflowDropOldest
+	dropOldest, err := db.Subscribe(options)
+	assert.Nil(t, err)
+	options.Overflow = OverflowDisconnect
+	disconnect, err := db.Subscribe(options)
+	assert.Nil(t, err)
+	options.Overflow = OverflowBlock
+	block, err := db.Subscribe(options)
+	assert.Nil(t, err)
+
+	assert.Nil(t, db.Put([]byte("k1"), []byte("v")))
+	assert.Nil(t, db.Put([]byte("k2"), []byte("v")))
+
+	// the write waits for the blocking subscriber
+	written := make(chan struct{})
+	go func() {
+		for _, key := range []string{"k3", "k4", "k5"} {
+			assert.Nil(t, db.Put([]byte(key), []byte("v")))
+		}
+		close(written)
+	}()
+	select {
+	case <-written:
+		t.Fatal("the write is not blocked by the full subscription")
+	case <-time.After(50 * time.Millisecond):
+	}
+	assert.Equal(t, []string{"k1", "k2", "k3", "k4", "k5"}, eventKeys(receive(block)))
+	<-written
+	assert.Zero(t, block.Dropped())
+
+	assert.Equal(t, []string{"k4", "k5"}, eventKeys(receive(dropOldest)))
+	assert.Equal(t, uint64(3), dropOldest.Dropped())
+
+	assert.Equal(t, []string{"k1", "k2"}, eventKeys(receive(disconnect)))
+	assert.Equal(t, ErrSubscriptionOverflow, disconnect.Err())
+	assert.Equal(t, uint64(1), disconnect.Dropped())
+
+	// closing the subscription releases the blocked write
+	assert.Nil(t, db.Put([]byte("k6"), []byte("v")))
+	assert.Nil(t, db.Put([]byte("k7"), []byte("v")))
+	written = make(chan struct{})
+	go func() {
+		assert.Nil(t, db.Put([]byte("k8"), []byte("v")))
+		close(written)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	block.Close()
+	<-written
+	assert.Equal(t, uint64(1), block.Dropped())
+}
+
+func TestDB_Subscribe_Close(t *testing.T) {
+	db, err := Open(DefaultOptions)
+	assert.Nil(t, err)
+
+	s, err := db.Subscribe(DefaultSubscribeOptions)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte("k"), []byte("v")))
+	destroyDB(db)
+
+	assert.Equal(t, []string{"k"}, eventKeys(receive(s)))
+	_, ok := <-s.Events()
+	assert.False(t, ok)
+	assert.Equal(t, ErrDBClosed, s.Err())
+
+	_, err = db.Subscribe(DefaultSubscribeOptions)
+	assert.Equal(t, ErrDBClosed, err)
+}
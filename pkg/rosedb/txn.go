@@ -0,0 +1,221 @@
+// Copyright 2024 Joy <joyssss94@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package rosedb
+
+import (
+	"bytes"
+	"sync"
+	"time"
+
+	"github.com/JoyZF/zoom/pkg/wal"
+)
+
+// Txn is an optimistic transaction.
+//
+// Unlike Batch, it does not hold the db lock while it is open.
+// Reads go to the db directly and the position of every key read is recorded,
+// writes are buffered in memory.
+// At commit time the db lock is taken, and if any key read by the transaction
+// has been changed since, the commit fails with ErrTxnConflict and nothing is written.
+//
+// Merge with reopenAfterDone rebuilds the index, so transactions open across it will conflict.
+type Txn struct {
+	db            *DB
+	options       TxnOptions
+	reads         map[string]*wal.ChunkPosition // position of each key at its first read, nil if not found
+	pendingWrites []*LogRecord
+	mu            sync.Mutex
+	committed     bool
+	rollback      bool
+}
+
+type TxnOptions struct {
+	// Sync has the same semantics as BatchOptions.Sync.
+	Sync bool
+}
+
+// NewTxn creates a new optimistic transaction.
+func (db *DB) NewTxn(options TxnOptions) *Txn {
+	return &Txn{
+		db:      db,
+		options: options,
+		reads:   make(map[string]*wal.ChunkPosition),
+	}
+}
+
+func (t *Txn) checkState() error {
+	if t.committed {
+		return ErrTxnCommitted
+	}
+	if t.rollback {
+		return ErrTxnRolledBack
+	}
+	return nil
+}
+
+func (t *Txn) pendingRecord(key []byte) *LogRecord {
+	for i := len(t.pendingWrites) - 1; i >= 0; i-- {
+		if bytes.Equal(key, t.pendingWrites[i].Key) {
+			return t.pendingWrites[i]
+		}
+	}
+	return nil
+}
+
+// read gets the record of the key from the db and adds the key to the read set.
+func (t *Txn) read(key []byte) (*LogRecord, error) {
+	t.db.mu.RLock()
+	if t.db.closed {
+		t.db.mu.RUnlock()
+		return nil, ErrDBClosed
+	}
+	position := t.db.index.Get(key)
+	var chunk []byte
+	var err error
+	if position != nil {
+		chunk, err = t.db.dataFiles.Read(position)
+	}
+	t.db.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := t.reads[string(key)]; !ok {
+		t.reads[string(key)] = position
+	}
+	if chunk == nil {
+		return nil, nil
+	}
+	record, err := decodeLogRecord(chunk, t.db.cipher)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.db.loadValue(record); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// Get the value of the key, the key is added to the read set of the transaction.
+func (t *Txn) Get(key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.checkState(); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UnixNano()
+	record := t.pendingRecord(key)
+	if record == nil {
+		var err error
+		if record, err = t.read(key); err != nil {
+			return nil, err
+		}
+	}
+	if record == nil || record.Type == LogRecordDeleted || record.IsExpired(now) {
+		return nil, ErrKeyNotFound
+	}
+	return record.Value, nil
+}
+
+// Exist reports whether the key exists, the key is added to the read set of the transaction.
+func (t *Txn) Exist(key []byte) (bool, error) {
+	_, err := t.Get(key)
+	if err == ErrKeyNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Put a key/value pair into the transaction.
+func (t *Txn) Put(key []byte, value []byte) error {
+	return t.write(key, value, LogRecordNormal, 0)
+}
+
+// PutWithTTL puts a key/value pair with ttl into the transaction.
+func (t *Txn) PutWithTTL(key []byte, value []byte, ttl time.Duration) error {
+	return t.write(key, value, LogRecordNormal, time.Now().Add(ttl).UnixNano())
+}
+
+// Delete the key in the transaction.
+func (t *Txn) Delete(key []byte) error {
+	return t.write(key, nil, LogRecordDeleted, 0)
+}
+
+func (t *Txn) write(key []byte, value []byte, recordType LogRecordType, expire int64) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.checkState(); err != nil {
+		return err
+	}
+
+	record := t.pendingRecord(key)
+	if record == nil {
+		record = &LogRecord{Key: key}
+		t.pendingWrites = append(t.pendingWrites, record)
+	}
+	record.Value, record.Type, record.Expire = value, recordType, expire
+	return nil
+}
+
+// Commit writes the buffered writes to the db as one batch.
+// It returns ErrTxnConflict if any key read by the transaction has been changed since it was read.
+func (t *Txn) Commit() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.checkState(); err != nil {
+		return err
+	}
+	// a read only transaction has nothing to protect.
+	if len(t.pendingWrites) == 0 {
+		t.committed = true
+		return nil
+	}
+
+	// the batch holds the db lock until it is committed or rolled back,
+	// so no one can change the keys between the validation and the write.
+	batch := t.db.NewBatch(BatchOptions{Sync: t.options.Sync})
+	if t.db.closed {
+		_ = batch.Rollback()
+		return ErrDBClosed
+	}
+	for key, readPos := range t.reads {
+		position := t.db.index.Get([]byte(key))
+		if (position == nil) != (readPos == nil) ||
+			(position != nil && !positionEquals(position, readPos)) {
+			_ = batch.Rollback()
+			return ErrTxnConflict
+		}
+	}
+	batch.pendingWrites = append(batch.pendingWrites, t.pendingWrites...)
+	if err := batch.Commit(); err != nil {
+		return err
+	}
+
+	t.committed = true
+	return nil
+}
+
+// Rollback discards the transaction.
+func (t *Txn) Rollback() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.checkState(); err != nil {
+		return err
+	}
+
+	t.pendingWrites = nil
+	t.reads = nil
+	t.rollback = true
+	return nil
+}
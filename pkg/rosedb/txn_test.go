@@ -0,0 +1,121 @@
+// Copyright 2024 Joy <joyssss94@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package rosedb
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTxn_Commit_Normal(t *testing.T) {
+	db, err := Open(DefaultOptions)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	assert.Nil(t, db.Put([]byte("k1"), []byte("v1")))
+
+	txn := db.NewTxn(DefaultTxnOptions)
+	assert.Nil(t, txn.Put([]byte("k2"), []byte("v2")))
+	assert.Nil(t, txn.Delete([]byte("k1")))
+
+	// own writes are visible to the transaction only
+	val, err := txn.Get([]byte("k2"))
+	assert.Nil(t, err)
+	assert.Equal(t, "v2", string(val))
+	_, err = txn.Get([]byte("k1"))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db.Get([]byte("k2"))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	assert.Nil(t, txn.Commit())
+	assert.Equal(t, ErrTxnCommitted, txn.Commit())
+
+	val, err = db.Get([]byte("k2"))
+	assert.Nil(t, err)
+	assert.Equal(t, "v2", string(val))
+	_, err = db.Get([]byte("k1"))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestTxn_Conflict(t *testing.T) {
+	db, err := Open(DefaultOptions)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	assert.Nil(t, db.Put([]byte("counter"), []byte("1")))
+
+	txn := db.NewTxn(DefaultTxnOptions)
+	_, err = txn.Get([]byte("counter"))
+	assert.Nil(t, err)
+	// a missing key is part of the read set too
+	_, err = txn.Get([]byte("missing"))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Nil(t, txn.Put([]byte("counter"), []byte("2")))
+
+	assert.Nil(t, db.Put([]byte("counter"), []byte("5")))
+	assert.Equal(t, ErrTxnConflict, txn.Commit())
+	val, err := db.Get([]byte("counter"))
+	assert.Nil(t, err)
+	assert.Equal(t, "5", string(val))
+
+	txn = db.NewTxn(DefaultTxnOptions)
+	_, err = txn.Get([]byte("missing"))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Nil(t, txn.Put([]byte("other"), []byte("1")))
+	assert.Nil(t, db.Put([]byte("missing"), []byte("1")))
+	assert.Equal(t, ErrTxnConflict, txn.Commit())
+
+	// blind writes never conflict
+	txn = db.NewTxn(DefaultTxnOptions)
+	assert.Nil(t, txn.Put([]byte("counter"), []byte("6")))
+	assert.Nil(t, db.Put([]byte("counter"), []byte("7")))
+	assert.Nil(t, txn.Commit())
+
+	txn = db.NewTxn(DefaultTxnOptions)
+	assert.Nil(t, txn.Rollback())
+	assert.Equal(t, ErrTxnRolledBack, txn.Put([]byte("counter"), []byte("8")))
+}
+
+func TestTxn_Concurrent_Incr(t *testing.T) {
+	db, err := Open(DefaultOptions)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	key := []byte("counter")
+	assert.Nil(t, db.Put(key, []byte("0")))
+
+	incr := func() {
+		for {
+			txn := db.NewTxn(TxnOptions{})
+			val, err := txn.Get(key)
+			assert.Nil(t, err)
+			n, _ := strconv.Atoi(string(val))
+			assert.Nil(t, txn.Put(key, []byte(strconv.Itoa(n+1))))
+			if err := txn.Commit(); err != ErrTxnConflict {
+				assert.Nil(t, err)
+				return
+			}
+		}
+	}
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				incr()
+			}
+		}()
+	}
+	wg.Wait()
+
+	val, err := db.Get(key)
+	assert.Nil(t, err)
+	assert.Equal(t, "500", string(val))
+}
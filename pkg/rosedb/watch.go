@@ -6,7 +6,6 @@ package rosedb
 
 import (
 	"sync"
-	"time"
 )
 
 type WatchActionType = byte
@@ -14,6 +13,9 @@ type WatchActionType = byte
 const (
 	WatchActionPut WatchActionType = iota
 	WatchActionDelete
+	// WatchActionExpire is sent when an expired key is removed from the index,
+	// by the active expiration, DeleteExpiredKeys or a read of the key.
+	WatchActionExpire
 )
 
 type Event struct {
@@ -21,11 +23,16 @@ type Event struct {
 	Key     []byte
 	Value   []byte
 	BatchId uint64
+	// Namespace is the name of the namespace of Key, empty for the default namespace.
+	Namespace string
 }
 
 type Watcher struct {
-	queue eventQueue
-	mu    sync.RWMutex
+	queue    eventQueue
+	mu       sync.RWMutex
+	notify   chan struct{} // wakes sendEvent up when an event is put
+	stop     chan struct{}
+	stopOnce sync.Once
 }
 
 type eventQueue struct {
@@ -64,6 +71,8 @@ func NewWatcher(capacity uint64) *Watcher {
 			Events:   make([]*Event, capacity),
 			Capacity: capacity,
 		},
+		notify: make(chan struct{}, 1),
+		stop:   make(chan struct{}),
 	}
 }
 
@@ -74,26 +83,47 @@ func (w *Watcher) putEvent(e *Event) {
 		w.queue.frontTakeAStep()
 	}
 	w.mu.Unlock()
+	select {
+	case w.notify <- struct{}{}:
+	default:
+	}
 }
 
 // getEvent if queue is empty, it will return nil.
 func (w *Watcher) getEvent() *Event {
-	w.mu.RLock()
-	defer w.mu.RUnlock()
+	w.mu.Lock()
+	defer w.mu.Unlock()
 	if w.queue.isEmpty() {
 		return nil
 	}
 	return w.queue.pop()
 }
 
-// sendEvent send events to DB's watch
+// sendEvent send events to DB's watch as soon as they are put,
+// and closes the channel when the watcher is closed.
 func (w *Watcher) sendEvent(c chan *Event) {
+	defer close(c)
 	for {
 		event := w.getEvent()
 		if event == nil {
-			time.Sleep(100 * time.Millisecond)
-			continue
+			select {
+			case <-w.notify:
+				continue
+			case <-w.stop:
+				return
+			}
+		}
+		select {
+		case c <- event:
+		case <-w.stop:
+			return
 		}
-		c <- event
 	}
 }
+
+// close stops sendEvent.
+func (w *Watcher) close() {
+	w.stopOnce.Do(func() {
+		close(w.stop)
+	})
+}
@@ -5,10 +5,13 @@
 package store
 
 import (
+	"io"
 	"sync"
 	"time"
 
 	"github.com/JoyZF/errors"
+
+	"github.com/JoyZF/zoom/pkg/rosedb"
 )
 
 type DBer interface {
@@ -18,9 +21,24 @@ type DBer interface {
 	Put(key, value []byte) error
 	PutWithTTL(key []byte, value []byte, ttl time.Duration) error
 	Delete(key []byte) error
+	// MultiGet returns nil for the keys which do not exist, MultiPut and MultiDelete are atomic.
+	MultiGet(keys [][]byte) ([][]byte, error)
+	MultiPut(keys, values [][]byte) error
+	MultiDelete(keys [][]byte) error
 	TTL(key []byte) (time.Duration, error)
 	Exist(key []byte) (bool, error)
 	Expire(key []byte, ttl time.Duration) error
+	// the read-modify-write operations are atomic, see rosedb.DB.IncrBy and the others.
+	// A write whose precondition is not met returns rosedb.ErrPreconditionFailed.
+	Incr(key []byte) (int64, error)
+	IncrBy(key []byte, delta int64) (int64, error)
+	IncrByFloat(key []byte, delta float64) (float64, error)
+	CompareAndSwap(key, expected, value []byte) error
+	PutIfAbsent(key, value []byte) error
+	PutIfExists(key, value []byte) error
+	GetAndSet(key, value []byte) ([]byte, error)
+	Export(w io.Writer, options rosedb.ExportOptions) (int, error)
+	Import(r io.Reader, options rosedb.ImportOptions) (int, error)
 }
 
 var (
@@ -49,3 +67,9 @@ func DB(driver string) (err error) {
 func GetStore() DBer {
 	return db
 }
+
+// SetStore replaces the DBer returned by GetStore, e.g. by the store of a cluster node
+// which wraps the store opened by DB.
+func SetStore(store DBer) {
+	db = store
+}
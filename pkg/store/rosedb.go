@@ -5,6 +5,7 @@
 package store
 
 import (
+	"io"
 	"sync"
 	"time"
 
@@ -72,3 +73,51 @@ func (r *RoseDB) Exist(key []byte) (bool, error) {
 func (r *RoseDB) Expire(key []byte, ttl time.Duration) error {
 	return r.DB.Expire(key, ttl)
 }
+
+func (r *RoseDB) MultiGet(keys [][]byte) ([][]byte, error) {
+	return r.DB.MultiGet(keys)
+}
+
+func (r *RoseDB) MultiPut(keys, values [][]byte) error {
+	return r.DB.MultiPut(keys, values)
+}
+
+func (r *RoseDB) MultiDelete(keys [][]byte) error {
+	return r.DB.MultiDelete(keys)
+}
+
+func (r *RoseDB) Incr(key []byte) (int64, error) {
+	return r.DB.Incr(key)
+}
+
+func (r *RoseDB) IncrBy(key []byte, delta int64) (int64, error) {
+	return r.DB.IncrBy(key, delta)
+}
+
+func (r *RoseDB) IncrByFloat(key []byte, delta float64) (float64, error) {
+	return r.DB.IncrByFloat(key, delta)
+}
+
+func (r *RoseDB) CompareAndSwap(key, expected, value []byte) error {
+	return r.DB.CompareAndSwap(key, expected, value)
+}
+
+func (r *RoseDB) PutIfAbsent(key, value []byte) error {
+	return r.DB.PutIfAbsent(key, value)
+}
+
+func (r *RoseDB) PutIfExists(key, value []byte) error {
+	return r.DB.PutIfExists(key, value)
+}
+
+func (r *RoseDB) GetAndSet(key, value []byte) ([]byte, error) {
+	return r.DB.GetAndSet(key, value)
+}
+
+func (r *RoseDB) Export(w io.Writer, options rosedb.ExportOptions) (int, error) {
+	return r.DB.Export(w, options)
+}
+
+func (r *RoseDB) Import(reader io.Reader, options rosedb.ImportOptions) (int, error) {
+	return r.DB.Import(reader, options)
+}
@@ -5,10 +5,13 @@
 package store
 
 import (
+	"bytes"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+
+	"github.com/JoyZF/zoom/pkg/rosedb"
 )
 
 func init() {
@@ -52,3 +55,50 @@ func TestRoseDB_TTL(t *testing.T) {
 	ttl, err := db.TTL([]byte("test"))
 	assert.True(t, ttl.Seconds() > 0)
 }
+
+func TestRoseDB_Export_Import(t *testing.T) {
+	_ = DB("ROSEDB")
+	err := db.Put([]byte("test_export"), []byte("test"))
+	assert.Nil(t, err)
+	var buf bytes.Buffer
+	count, err := db.Export(&buf, rosedb.ExportOptions{Prefix: []byte("test_export")})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, count)
+
+	err = db.Delete([]byte("test_export"))
+	assert.Nil(t, err)
+	count, err = db.Import(&buf, rosedb.DefaultImportOptions)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, count)
+	val, err := db.Get([]byte("test_export"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("test"), val)
+}
+
+func TestRoseDB_IncrBy(t *testing.T) {
+	_ = DB("ROSEDB")
+	key := []byte("test_incr")
+	assert.Nil(t, db.Put(key, []byte("10")))
+	value, err := db.IncrBy(key, 5)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(15), value)
+	assert.Equal(t, rosedb.ErrPreconditionFailed, db.PutIfAbsent(key, []byte("1")))
+	assert.Nil(t, db.CompareAndSwap(key, []byte("15"), []byte("1")))
+	former, err := db.GetAndSet(key, []byte("2"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("1"), former)
+	assert.Nil(t, db.Delete(key))
+}
+
+func TestRoseDB_MultiGet(t *testing.T) {
+	_ = DB("ROSEDB")
+	keys := [][]byte{[]byte("test_multi_1"), []byte("test_multi_2")}
+	assert.Nil(t, db.MultiPut(keys, [][]byte{[]byte("v1"), []byte("v2")}))
+	values, err := db.MultiGet(append(keys, []byte("test_multi_missing")))
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("v1"), []byte("v2"), nil}, values)
+	assert.Nil(t, db.MultiDelete(keys))
+	values, err = db.MultiGet(keys)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{nil, nil}, values)
+}
@@ -0,0 +1,11 @@
+package wal
+
+const (
+	B = 1
+
+	KB = 1024 * B
+
+	MB = 1024 * KB
+
+	GB = 1024 * MB
+)
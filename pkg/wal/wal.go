@@ -0,0 +1,524 @@
+package wal
+
+import (
+	"errors"
+	"fmt"
+	lru "github.com/hashicorp/golang-lru/v2"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	initialSegmentFileId = 1
+)
+
+var (
+	ErrValueTooLarge        = errors.New("the data size can't larger than segment size")
+	ErrPendingSizeTooLarge  = errors.New("the upper bound of pendingWrites can't larger than segment size")
+	ErrSegmentFileExtFormat = errors.New("segment file extension must start with '.'")
+	ErrBlockCacheSize       = errors.New("BlockCache must be smaller than SegmentSize")
+	ErrStartPositionIsNull  = errors.New("start position is nil")
+)
+
+// WAL represents a Write-Ahead Log structure that provides durability
+// and fault-tolerance for incoming writes.
+// It consists of an activeSegment, which is the current segment file
+// used for new incoming writes, and olderSegments,
+// which is a map of segment files used for read operations.
+//
+// The options field stores various configuration options for the WAL.
+//
+// The mu sync.RWMutex is used for concurrent access to the WAL data structure,
+// ensuring safe access and modification.
+//
+// The blockCache is an LRU cache used to store recently accessed data blocks,
+// improving read performance by reducing disk I/O.
+// It is implemented using a lru.Cache structure with keys of type uint64 and values of type []byte.
+type WAL struct {
+	activeSegment     *segment
+	olderSegments     map[SegmentID]*segment
+	options           Options
+	mu                sync.RWMutex
+	blockCache        *lru.Cache[uint64, []byte]
+	bytesWrite        uint32
+	renameIds         []SegmentID
+	pendingWrites     [][]byte
+	pendingSize       int64
+	pendingWritesLock sync.Mutex
+}
+
+// Reader represents a reader for the WAL.
+// It consists of segmentReaders, which is a slice of segmentReader
+// structures sorted by segment id,
+// and currentReader, which is the index of the current segmentReader in the slice.
+//
+// The currentReader field is used to iterate over the segmentReaders slice.
+type Reader struct {
+	segmentReaders []*segmentReader
+	currentReader  int // segmentReaders 的index
+}
+
+// OpenNewActiveSegment opens a new segment file
+// and sets it as the active segment file.
+// It is used when even the active segment file is not full,
+// but the user wants to create a new segment file.
+//
+// It is now used by Merge operation of rosedb, not a common usage for most users.
+func (wal *WAL) OpenNewActiveSegment() error {
+	wal.mu.Lock()
+	defer wal.mu.Unlock()
+
+	// sync the active segment file.
+	if err := wal.activeSegment.Sync(); err != nil {
+		return err
+	}
+
+	// wal.mu is held, so the id is read from the active segment rather than by ActiveSegmentID.
+	segment, err := openSegmentFile(wal.options.DirPath, wal.options.SegmentFileExt, wal.activeSegment.id+1, wal.blockCache)
+	if err != nil {
+		return err
+	}
+	wal.olderSegments[wal.activeSegment.id] = wal.activeSegment
+	wal.activeSegment = segment
+	return nil
+}
+
+// ActiveSegmentID return activeSegment id
+func (wal *WAL) ActiveSegmentID() SegmentID {
+	wal.mu.Lock()
+	defer wal.mu.Unlock()
+	if wal.activeSegment == nil {
+		return 0
+	}
+	return wal.activeSegment.id
+}
+
+// IsEmpty returns whether the WAL is empty.
+// Only there is only one empty active segment file, which means the WAL is empty.
+func (wal *WAL) IsEmpty() bool {
+	wal.mu.RLock()
+	defer wal.mu.RUnlock()
+
+	return wal.activeSegment.currentBlockSize == 0 && len(wal.olderSegments) == 0
+}
+
+// NewReaderWithMax returns a new reader for the WAL,
+// and the reader will only read the data from the segment file
+// whose id is less than or equal to the given segId.
+//
+// It is now used by the Merge operation of rosedb, not a common usage for most users.
+func (wal *WAL) NewReaderWithMax(segId SegmentID) *Reader {
+	wal.mu.RLock()
+	defer wal.mu.RUnlock()
+	return wal.newReaderWithMax(segId)
+}
+
+// newReaderWithMax is NewReaderWithMax, the caller must hold wal.mu.
+func (wal *WAL) newReaderWithMax(segId SegmentID) *Reader {
+	// get all segment readers.
+	var segmentReaders []*segmentReader
+	for _, segment := range wal.olderSegments {
+		if segId == 0 || segment.id <= segId {
+			reader := segment.NewReader()
+			segmentReaders = append(segmentReaders, reader)
+		}
+	}
+
+	// Whether create active segment
+	if segId == 0 || wal.activeSegment.id <= segId {
+		reader := wal.activeSegment.NewReader()
+		segmentReaders = append(segmentReaders, reader)
+	}
+	// sort the segment readers by segment id.
+	sort.Slice(segmentReaders, func(i, j int) bool {
+		return segmentReaders[i].segment.id < segmentReaders[j].segment.id
+	})
+	return &Reader{
+		segmentReaders: segmentReaders,
+		currentReader:  0,
+	}
+}
+
+// NewReaderWithStart returns a new reader for the WAL,
+// and the reader will only read the data from the segment file
+// whose position is greater than or equal to the given position.
+func (wal *WAL) NewReaderWithStart(startPos *ChunkPosition) (*Reader, error) {
+	if startPos == nil {
+		return nil, ErrStartPositionIsNull
+	}
+	wal.mu.RLock()
+	defer wal.mu.RUnlock()
+
+	// a read lock must not be taken again while it is held, a waiting writer would block it.
+	reader := wal.newReaderWithMax(0)
+	for {
+		// skip the segment readers whose id is less than the given position's segment id.
+		if reader.CurrentSegmentId() < startPos.SegmentId {
+			reader.SkipCurrentSegment()
+			continue
+		}
+
+		// skip the chunk whose position is less than the given position.
+		currentPos := reader.CurrentChunkPosition()
+		if currentPos.BlockNumber >= startPos.BlockNumber &&
+			currentPos.ChunkOffset >= startPos.ChunkOffset {
+			break
+		}
+		// call Next to find again.
+		if _, _, err := reader.Next(); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+	}
+	return reader, nil
+}
+
+// NewReader returns a new reader for the WAL.
+// It will iterate all segment files and read all data from them.
+func (wal *WAL) NewReader() *Reader {
+	return wal.NewReaderWithMax(0)
+}
+
+// ClearPendingWrites clear pendingWrite and reset pendingSize
+func (wal *WAL) ClearPendingWrites() {
+	wal.pendingWritesLock.Lock()
+	defer wal.pendingWritesLock.Unlock()
+
+	wal.pendingSize = 0
+	wal.pendingWrites = wal.pendingWrites[:0]
+}
+
+// PendingWrites add data to wal.pendingWrites and wait for batch write.
+// If the data in pendingWrites exceeds the size of one segment,
+// it will return a 'ErrPendingSizeTooLarge' error and clear the pendingWrites.
+func (wal *WAL) PendingWrites(data []byte) {
+	wal.pendingWritesLock.Lock()
+	defer wal.pendingWritesLock.Unlock()
+
+	size := wal.maxDataWriteSize(int64(len(data)))
+	wal.pendingSize += size
+	wal.pendingWrites = append(wal.pendingWrites, data)
+}
+
+// rotateActiveSegment create a new segment file and replace the activeSegment.
+func (wal *WAL) rotateActiveSegment() error {
+	if err := wal.activeSegment.Sync(); err != nil {
+		return err
+	}
+
+	wal.bytesWrite = 0
+	segment, err := openSegmentFile(wal.options.DirPath, wal.options.SegmentFileExt,
+		wal.activeSegment.id+1, wal.blockCache)
+	if err != nil {
+		return err
+	}
+	wal.olderSegments[wal.activeSegment.id] = wal.activeSegment
+	wal.activeSegment = segment
+	return nil
+}
+
+// WriteAll write wal.pendingWrites to WAL and then clear pendingWrites,
+// it will not sync the segment file based on wal.options, you should call Sync() manually.
+func (wal *WAL) WriteAll() ([]*ChunkPosition, error) {
+	if len(wal.pendingWrites) == 0 {
+		return make([]*ChunkPosition, 0), nil
+	}
+
+	wal.mu.Lock()
+	defer func() {
+		wal.ClearPendingWrites()
+		wal.mu.Unlock()
+	}()
+
+	// if the pending size is still larger than segment size, return error
+	if wal.pendingSize > wal.options.SegmentSize {
+		return nil, ErrPendingSizeTooLarge
+	}
+
+	// if the active segment file is full, sync it and create a new one.
+	if wal.activeSegment.Size()+wal.pendingSize > wal.options.SegmentSize {
+		if err := wal.rotateActiveSegment(); err != nil {
+			return nil, err
+		}
+	}
+
+	// write all data to the active segment file.
+	positions, err := wal.activeSegment.writeAll(wal.pendingWrites)
+	if err != nil {
+		return nil, err
+	}
+
+	return positions, nil
+}
+
+// Write writes the data to the WAL.
+// Actually, it writes the data to the active segment file.
+// It returns the position of the data in the WAL, and an error if any.
+func (wal *WAL) Write(data []byte) (*ChunkPosition, error) {
+	wal.mu.Lock()
+	defer wal.mu.Unlock()
+
+	if int64(len(data))+chunkHeaderSize > wal.options.SegmentSize {
+		return nil, ErrValueTooLarge
+	}
+
+	// if the active segment file is full, sync it and create a new one.
+	if wal.isFull(int64(len(data))) {
+		if err := wal.rotateActiveSegment(); err != nil {
+			return nil, err
+		}
+	}
+
+	position, err := wal.activeSegment.Write(data)
+	if err != nil {
+		return nil, err
+	}
+
+	// update the bytesWrite field.
+	wal.bytesWrite += position.ChunkSize
+	// sync the active segment file if needed.
+	var needSync = wal.options.Sync
+	if !needSync && wal.options.BytesPerSync > 0 {
+		needSync = wal.bytesWrite >= wal.options.BytesPerSync
+	}
+	if needSync {
+		if err = wal.activeSegment.Sync(); err != nil {
+			return nil, err
+		}
+		wal.bytesWrite = 0
+	}
+	return position, nil
+}
+
+// Read reads the data from the WAL according to the given position.
+func (wal *WAL) Read(pos *ChunkPosition) ([]byte, error) {
+	wal.mu.Lock()
+	defer wal.mu.Unlock()
+
+	// find the segment file according to the position.
+	var segment *segment
+	if pos.SegmentId == wal.activeSegment.id {
+		segment = wal.activeSegment
+	} else {
+		segment = wal.olderSegments[pos.SegmentId]
+	}
+
+	if segment == nil {
+		return nil, fmt.Errorf("segment file %d%s not found", pos.SegmentId, wal.options.SegmentFileExt)
+	}
+	return segment.Read(pos.BlockNumber, pos.ChunkOffset)
+}
+
+// Close closes the WAL.
+func (wal *WAL) Close() error {
+	wal.mu.Lock()
+	defer wal.mu.Unlock()
+
+	// purge the block cache.
+	if wal.blockCache != nil {
+		wal.blockCache.Purge()
+	}
+
+	// close all segment files.
+	for _, segment := range wal.olderSegments {
+		if err := segment.Close(); err != nil {
+			return err
+		}
+		wal.renameIds = append(wal.renameIds, segment.id)
+	}
+
+	wal.olderSegments = nil
+
+	wal.renameIds = append(wal.renameIds, wal.activeSegment.id)
+	// close the active segment file.
+	return wal.activeSegment.Close()
+}
+
+// Delete deletes all segment files of the WAL.
+func (wal *WAL) Delete() error {
+	wal.mu.Lock()
+	defer wal.mu.Unlock()
+
+	// purge the block cache.
+	if wal.blockCache != nil {
+		wal.blockCache.Purge()
+	}
+
+	// delete all segment files.
+	for _, segment := range wal.olderSegments {
+		if err := segment.Remove(); err != nil {
+			return err
+		}
+	}
+	wal.olderSegments = nil
+
+	// delete the active segment file.
+	return wal.activeSegment.Remove()
+}
+
+// Sync syncs the active segment file to stable storage like disk.
+func (wal *WAL) Sync() error {
+	wal.mu.Lock()
+	defer wal.mu.Unlock()
+
+	return wal.activeSegment.Sync()
+}
+
+// RenameFileExt renames all segment files' extension name.
+func (wal *WAL) RenameFileExt(ext string) error {
+	if !strings.HasPrefix(ext, ".") {
+		return fmt.Errorf("segment file extension must start with '.'")
+	}
+	wal.mu.Lock()
+	defer wal.mu.Unlock()
+
+	f := func(id SegmentID) error {
+		oldName := SegmentFileName(wal.options.DirPath, wal.options.SegmentFileExt, id)
+		newName := SegmentFileName(wal.options.DirPath, ext, id)
+		return os.Rename(oldName, newName)
+	}
+	for _, id := range wal.renameIds {
+		if err := f(id); err != nil {
+			return err
+		}
+	}
+
+	wal.options.SegmentFileExt = ext
+	return nil
+}
+
+func (wal *WAL) isFull(delta int64) bool {
+	return wal.activeSegment.Size()+wal.maxDataWriteSize(delta) > wal.options.SegmentSize
+}
+
+// maxDataWriteSize calculate the possible maximum size.
+// the maximum size = max padding + (num_block + 1) * headerSize + dataSize
+func (wal *WAL) maxDataWriteSize(size int64) int64 {
+	return chunkHeaderSize + size + (size/blockSize+1)*chunkHeaderSize
+}
+
+func (r *Reader) Next() ([]byte, *ChunkPosition, error) {
+	if r.currentReader >= len(r.segmentReaders) {
+		return nil, nil, io.EOF
+	}
+
+	data, position, err := r.segmentReaders[r.currentReader].Next()
+	if err == io.EOF {
+		r.currentReader++
+		return r.Next()
+	}
+	return data, position, err
+}
+
+// SkipCurrentSegment skips the current segment file
+// when reading the WAL.
+func (r *Reader) SkipCurrentSegment() {
+	r.currentReader++
+}
+
+// CurrentSegmentId returns the id of the current segment file
+// when reading the WAL.
+func (r *Reader) CurrentSegmentId() SegmentID {
+	return r.segmentReaders[r.currentReader].segment.id
+}
+
+// CurrentChunkPosition returns the position of the current chunk data
+func (r *Reader) CurrentChunkPosition() *ChunkPosition {
+	reader := r.segmentReaders[r.currentReader]
+	return &ChunkPosition{
+		SegmentId:   reader.segment.id,
+		BlockNumber: reader.blockNumber,
+		ChunkOffset: reader.chunkOffset,
+	}
+}
+
+// SegmentFileName returns the file name of a segment file.
+func SegmentFileName(dirPath string, extName string, id SegmentID) string {
+	return filepath.Join(dirPath, fmt.Sprintf("%09d"+extName, id))
+}
+
+// Open opens a WAL with the given options.
+// It will create the directory if not exists, and open all segment files in the directory.
+// If there is no segment file in the directory, it will create a new one.
+func Open(options Options) (*WAL, error) {
+	var (
+		wal        = &WAL{}
+		segmentIds []int
+		err        error
+	)
+
+	if !strings.Contains(options.SegmentFileExt, ".") {
+		return nil, ErrSegmentFileExtFormat
+	}
+	if options.BlockCache > uint32(options.SegmentSize) {
+		return nil, ErrBlockCacheSize
+	}
+
+	wal.options = options
+	wal.olderSegments = make(map[SegmentID]*segment)
+	wal.pendingWrites = make([][]byte, 0)
+
+	if err := os.MkdirAll(wal.options.DirPath, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	// create the block cache if needed.
+	if options.BlockCache > 0 {
+		var lruSize = options.BlockCache / blockSize
+		// if options BlockCache cannot exact division by blockSize lruSize +1
+		if options.BlockCache%blockSize != 0 {
+			lruSize += 1
+		}
+		if wal.blockCache, err = lru.New[uint64, []byte](int(lruSize)); err != nil {
+			return nil, err
+		}
+	}
+
+	// iterate the dir and open all segment files.
+	entries, err := os.ReadDir(options.DirPath)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		// ignore folder
+		if entry.IsDir() {
+			continue
+		}
+		var id int
+		if _, err = fmt.Sscanf(entry.Name(), "%d"+options.SegmentFileExt, &id); err != nil {
+			continue
+		}
+		segmentIds = append(segmentIds, id)
+	}
+
+	// empty directory, just initialize a new segment file.
+	if len(segmentIds) == 0 {
+		if wal.activeSegment, err = openSegmentFile(options.DirPath, options.SegmentFileExt, initialSegmentFileId, wal.blockCache); err != nil {
+			return nil, err
+		}
+	} else {
+		// open the segment files in order, get the max one as the active segment file.
+		sort.Ints(segmentIds)
+
+		l := len(segmentIds)
+		for i := 0; i < l; i++ {
+			segment, err := openSegmentFile(options.DirPath, options.SegmentFileExt, SegmentID(segmentIds[i]), wal.blockCache)
+			if err != nil {
+				return nil, err
+			}
+			if i == l-1 {
+				wal.activeSegment = segment
+				continue
+			}
+			wal.olderSegments[segment.id] = segment
+		}
+	}
+	return wal, nil
+}
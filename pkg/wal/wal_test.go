@@ -0,0 +1,140 @@
+package wal
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func openTestWAL(t *testing.T, segmentSize int64) *WAL {
+	options := DefaultOptions
+	options.DirPath = t.TempDir()
+	options.SegmentSize = segmentSize
+	options.BlockCache = 0
+	wal, err := Open(options)
+	assert.Nil(t, err)
+	t.Cleanup(func() {
+		_ = wal.Close()
+	})
+	return wal
+}
+
+// readAll returns the data of the reader.
+func readAll(t *testing.T, reader *Reader) [][]byte {
+	var all [][]byte
+	for {
+		data, _, err := reader.Next()
+		if err == io.EOF {
+			return all
+		}
+		assert.Nil(t, err)
+		all = append(all, data)
+	}
+}
+
+// runWithin fails the test if fn does not return within a few seconds, the rotation used to deadlock.
+func runWithin(t *testing.T, fn func()) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fn()
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the operation did not return, it may be deadlocked")
+	}
+}
+
+func TestWAL_Write_Rotate(t *testing.T) {
+	wal := openTestWAL(t, 4*KB)
+	data := bytes.Repeat([]byte("a"), 512)
+	var positions []*ChunkPosition
+	runWithin(t, func() {
+		for i := 0; i < 20; i++ {
+			position, err := wal.Write(data)
+			assert.Nil(t, err)
+			positions = append(positions, position)
+		}
+	})
+	assert.True(t, wal.ActiveSegmentID() > initialSegmentFileId)
+	assert.Len(t, readAll(t, wal.NewReader()), 20)
+	for _, position := range positions {
+		read, err := wal.Read(position)
+		assert.Nil(t, err)
+		assert.Equal(t, data, read)
+	}
+}
+
+func TestWAL_WriteAll_Rotate(t *testing.T) {
+	wal := openTestWAL(t, 4*KB)
+	data := bytes.Repeat([]byte("a"), 512)
+	runWithin(t, func() {
+		for i := 0; i < 10; i++ {
+			wal.PendingWrites(data)
+			wal.PendingWrites(data)
+			positions, err := wal.WriteAll()
+			assert.Nil(t, err)
+			assert.Len(t, positions, 2)
+		}
+	})
+	assert.True(t, wal.ActiveSegmentID() > initialSegmentFileId)
+	assert.Len(t, readAll(t, wal.NewReader()), 20)
+}
+
+func TestWAL_OpenNewActiveSegment(t *testing.T) {
+	wal := openTestWAL(t, GB)
+	_, err := wal.Write([]byte("first"))
+	assert.Nil(t, err)
+	runWithin(t, func() {
+		assert.Nil(t, wal.OpenNewActiveSegment())
+	})
+	assert.Equal(t, SegmentID(initialSegmentFileId+1), wal.ActiveSegmentID())
+	position, err := wal.Write([]byte("second"))
+	assert.Nil(t, err)
+	assert.Equal(t, SegmentID(initialSegmentFileId+1), position.SegmentId)
+
+	// the segments written before are kept for the readers
+	assert.Equal(t, [][]byte{[]byte("first")}, readAll(t, wal.NewReaderWithMax(initialSegmentFileId)))
+	assert.Equal(t, [][]byte{[]byte("first"), []byte("second")}, readAll(t, wal.NewReader()))
+}
+
+func TestWAL_NewReaderWithStart_Concurrent(t *testing.T) {
+	wal := openTestWAL(t, 4*KB)
+	start, err := wal.Write([]byte("start"))
+	assert.Nil(t, err)
+
+	// the readers take the read lock while the writer keeps rotating the segments
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if _, err := wal.Write(bytes.Repeat([]byte("a"), 512)); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	runWithin(t, func() {
+		for i := 0; i < 200; i++ {
+			reader, err := wal.NewReaderWithStart(start)
+			assert.Nil(t, err)
+			data, _, err := reader.Next()
+			assert.Nil(t, err)
+			assert.Equal(t, []byte("start"), data)
+		}
+	})
+	close(stop)
+	wg.Wait()
+}